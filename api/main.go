@@ -2,14 +2,22 @@ package api
 
 import (
 	"database/sql"
+	"encoding/json"
+	"expvar"
 	"net/http"
+	"strings"
 
 	"github.com/cyverse-de/messaging/v12"
 	v1 "github.com/cyverse-de/notifications/api/v1"
 	v2 "github.com/cyverse-de/notifications/api/v2"
+	v3 "github.com/cyverse-de/notifications/api/v3"
 	"github.com/cyverse-de/notifications/common"
 	"github.com/cyverse-de/notifications/mailer"
+	"github.com/cyverse-de/notifications/membership"
 	"github.com/cyverse-de/notifications/model"
+	"github.com/cyverse-de/notifications/query"
+	"github.com/cyverse-de/notifications/retention"
+	"github.com/cyverse-de/notifications/stream"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
 )
@@ -21,15 +29,21 @@ const emailRequestBodyLimit = "16M"
 
 // API defines the REST API of the notifications service
 type API struct {
-	Echo         *echo.Echo
-	AMQPSettings *common.AMQPSettings
-	AMQPClient   *messaging.Client
-	DB           *sql.DB
-	UserSuffix   common.UserSuffix
-	Mailer       *mailer.EmailProcessor
-	Service      string
-	Title        string
-	Version      string
+	Echo           *echo.Echo
+	AMQPSettings   *common.AMQPSettings
+	AMQPClient     *messaging.Client
+	DB             *sql.DB
+	UserSuffix     common.UserSuffix
+	Mailer         *mailer.EmailProcessor
+	Stream         *stream.Hub
+	Recorder       v2.Recorder
+	Events         v2.EventDispatcher
+	BatchPublisher v2.BatchPublisher
+	Groups         membership.Resolver
+	Cursors        *query.CursorCodec
+	Service        string
+	Title          string
+	Version        string
 }
 
 // RootHandler handles GET requests to the / endpoint.
@@ -42,10 +56,25 @@ func (a API) RootHandler(ctx echo.Context) error {
 	return ctx.JSON(http.StatusOK, resp)
 }
 
+// MetricsHandler handles GET requests to the /debug/vars endpoint. Only the retention counters are published; the
+// variables that expvar publishes by default, such as the command line and memory statistics, aren't.
+func (a API) MetricsHandler(ctx echo.Context) error {
+	metrics := make(map[string]json.RawMessage)
+	expvar.Do(func(kv expvar.KeyValue) {
+		if strings.HasPrefix(kv.Key, retention.MetricPrefix) {
+			metrics[kv.Key] = json.RawMessage(kv.Value.String())
+		}
+	})
+	return ctx.JSON(http.StatusOK, metrics)
+}
+
 // RegisterHandlers registers the supported request handlers.
 func (a API) RegisterHandlers() {
 	a.Echo.GET("/", a.RootHandler)
 
+	// Operational metrics, such as the number of notifications purged by the retention policy.
+	a.Echo.GET("/debug/vars", a.MetricsHandler)
+
 	// Outbound email. Unversioned because it isn't part of the notifications API proper; it
 	// was absorbed from the retired de-mailer service, whose callers post to a bare base URL.
 	// The body limit applies only to this route because it's the only one whose handler reads
@@ -61,6 +90,7 @@ func (a API) RegisterHandlers() {
 		AMQPClient:   a.AMQPClient,
 		DB:           a.DB,
 		UserSuffix:   a.UserSuffix,
+		Cursors:      a.Cursors,
 		Service:      a.Service,
 		Title:        a.Title,
 		Version:      a.Version,
@@ -70,15 +100,34 @@ func (a API) RegisterHandlers() {
 	// Register the group for API version 2.
 	v2Group := a.Echo.Group("/v2")
 	v2API := v2.API{
-		Echo:         a.Echo,
-		Group:        v2Group,
-		AMQPSettings: a.AMQPSettings,
-		AMQPClient:   a.AMQPClient,
-		DB:           a.DB,
-		UserSuffix:   a.UserSuffix,
-		Service:      a.Service,
-		Title:        a.Title,
-		Version:      a.Version,
+		Echo:           a.Echo,
+		Group:          v2Group,
+		AMQPSettings:   a.AMQPSettings,
+		AMQPClient:     a.AMQPClient,
+		DB:             a.DB,
+		UserSuffix:     a.UserSuffix,
+		Stream:         a.Stream,
+		Recorder:       a.Recorder,
+		Events:         a.Events,
+		BatchPublisher: a.BatchPublisher,
+		Groups:         a.Groups,
+		Service:        a.Service,
+		Title:          a.Title,
+		Version:        a.Version,
 	}
 	v2API.RegisterHandlers()
+
+	// Register the group for API version 3.
+	v3Group := a.Echo.Group("/v3")
+	v3API := v3.API{
+		Echo:       a.Echo,
+		Group:      v3Group,
+		DB:         a.DB,
+		UserSuffix: a.UserSuffix,
+		Cursors:    a.Cursors,
+		Service:    a.Service,
+		Title:      a.Title,
+		Version:    a.Version,
+	}
+	v3API.RegisterHandlers()
 }
@@ -0,0 +1,28 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetricsHandlerPublishesOnlyTheRetentionCounters(t *testing.T) {
+	assert := assert.New(t)
+
+	e := echo.New()
+	rec := httptest.NewRecorder()
+	c := e.NewContext(httptest.NewRequest(http.MethodGet, "/debug/vars", nil), rec)
+
+	assert.NoError(API{Echo: e}.MetricsHandler(c))
+	assert.Equal(http.StatusOK, rec.Code)
+
+	var metrics map[string]json.RawMessage
+	assert.NoError(json.Unmarshal(rec.Body.Bytes(), &metrics))
+	assert.Contains(metrics, "retention_purge_runs")
+	assert.NotContains(metrics, "cmdline")
+	assert.NotContains(metrics, "memstats")
+}
@@ -70,6 +70,39 @@ func (a API) GetMessagesHandler(c echo.Context) error {
 		})
 	}
 
+	// Extract and validate the cursor query parameter. Cursors replace offsets, and they only work when the listing is
+	// sorted by timestamp.
+	var cursor *query.Cursor
+	if encodedCursor := c.QueryParam("cursor"); encodedCursor != "" {
+		cursor, err = a.Cursors.Decode(encodedCursor)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, model.ErrorResponse{
+				Message: "invalid query parameter: cursor",
+			})
+		}
+		if offset != 0 {
+			return c.JSON(http.StatusBadRequest, model.ErrorResponse{
+				Message: "the cursor and offset query parameters may not be used together",
+			})
+		}
+		switch *(sortFieldParam.GetValue().(*query.V1ListingSortField)) {
+		case query.V1ListingSortFieldTimestamp, query.V1ListingSortFieldDateCreated:
+		default:
+			return c.JSON(http.StatusBadRequest, model.ErrorResponse{
+				Message: "the cursor query parameter may only be used when sorting by timestamp",
+			})
+		}
+	}
+
+	// Extract and validate the include_total query parameter.
+	defaultIncludeTotal := true
+	includeTotal, err := query.ValidateBooleanQueryParam(c, "include_total", &defaultIncludeTotal)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, model.ErrorResponse{
+			Message: err.Error(),
+		})
+	}
+
 	// Extract and reformat the filter.
 	var notificationType string
 	filter := strings.ReplaceAll(strings.ToLower(c.QueryParam("filter")), " ", "_")
@@ -99,13 +132,24 @@ func (a API) GetMessagesHandler(c echo.Context) error {
 		SortOrder:        *(sortOrderParam.GetValue().(*query.SortOrder)),
 		SortField:        *(sortFieldParam.GetValue().(*query.V1ListingSortField)),
 		NotificationType: notificationType,
+		Cursor:           cursor,
+		OmitTotal:        !includeTotal,
 	}
-	listing, err := db.V1ListNotifications(ctx, tx, params)
+	listing, next, err := db.V1ListNotifications(ctx, tx, params)
 	if err != nil {
 		a.Echo.Logger.Error(err)
 		return err
 	}
 
+	// Encode the cursor for the next page if there is one.
+	if next != nil {
+		listing.NextCursor, err = a.Cursors.Encode(next)
+		if err != nil {
+			a.Echo.Logger.Error(err)
+			return err
+		}
+	}
+
 	return c.JSON(http.StatusOK, listing)
 }
 
@@ -145,7 +189,7 @@ func (a *API) GetUnseenMessagesHandler(c echo.Context) error {
 		SortField:        query.V1ListingSortFieldTimestamp,
 		NotificationType: "",
 	}
-	listing, err := db.V1ListNotifications(ctx, tx, params)
+	listing, _, err := db.V1ListNotifications(ctx, tx, params)
 	if err != nil {
 		a.Echo.Logger.Error(err)
 		return err
@@ -4,9 +4,10 @@ import (
 	"database/sql"
 	"net/http"
 
-	"github.com/cyverse-de/messaging/v12"
 	"github.com/cyverse-de/notifications/common"
 	"github.com/cyverse-de/notifications/model"
+	"github.com/cyverse-de/notifications/publisher"
+	"github.com/cyverse-de/notifications/query"
 	"github.com/labstack/echo/v4"
 )
 
@@ -15,9 +16,10 @@ type API struct {
 	Echo         *echo.Echo
 	Group        *echo.Group
 	AMQPSettings *common.AMQPSettings
-	AMQPClient   *messaging.Client
+	AMQPClient   publisher.MessagingClient
 	DB           *sql.DB
 	UserSuffix   common.UserSuffix
+	Cursors      *query.CursorCodec
 	Service      string
 	Title        string
 	Version      string
@@ -37,7 +37,16 @@ type rootResponseWrapper struct {
 //
 // Request a Notification
 //
-// Submits a request for a notification to be sent to a user.
+// Submits a request for a notification to be sent to a user. A notification can also be sent to a list of recipients
+// or to the members of a team, in which case each recipient gets a notification of their own. If an email is
+// requested for such a notification, the recipients' email addresses are taken from the `email_addresses` object in
+// the payload, which maps each recipient's username to their address. The members of a team are only looked up when
+// the notification is recorded, so their addresses are looked up along with them; any addresses in `email_addresses`
+// take precedence, and members without an address aren't emailed.
+//
+// A request can be given an idempotency key, either in the `Idempotency-Key` header or in the `idempotency_key` field
+// of the request body. Requests with the same key, notification type, and recipient are only recorded once while the
+// key is remembered.
 //
 // responses:
 //   200: emptyResponse
@@ -48,6 +57,11 @@ type rootResponseWrapper struct {
 // swagger:parameters requestNotificationV1
 type requestNotificationParameters struct {
 
+	// A key that identifies this request, so that it's only recorded once if it's sent more than once.
+	//
+	// in:header
+	IdempotencyKey string `json:"Idempotency-Key"`
+
 	// The notificaiton request.
 	//
 	// in:body
@@ -88,6 +102,21 @@ type notificationListingParametersV1 struct {
 	// default: 0
 	Offset uint64 `json:"offset"`
 
+	// The cursor from the `next_cursor` field of the previous page. The listing continues just after the last message
+	// on that page, which is much faster than a large offset. The cursor remembers the sort direction, but the other
+	// query parameters have to be sent again with every request. Cursors are only issued and accepted when the
+	// listing is sorted by `timestamp` or `date_created`, and they may not be combined with `offset`.
+	//
+	// in:query
+	Cursor string `json:"cursor"`
+
+	// If false, the `total` field is left out of the response. Counting every matching message is expensive for users
+	// with many messages, so clients that page with cursors should turn this off.
+	//
+	// in:query
+	// default: true
+	IncludeTotal bool `json:"include_total"`
+
 	// If true, only messages that have been marked as seen will be displayed. If false, only messages that have not
 	// been marked as seen will be displayed. If not specified, messages will be displayed regardless of whether or
 	// not they've been marked as seen.
@@ -6,6 +6,7 @@ import (
 
 	"github.com/cyverse-de/notifications/db"
 	"github.com/cyverse-de/notifications/model"
+	"github.com/cyverse-de/notifications/publisher"
 	"github.com/cyverse-de/notifications/query"
 	"github.com/labstack/echo/v4"
 )
@@ -64,6 +65,13 @@ func (a *API) MarkMessagesAsSeen(c echo.Context) error {
 		return err
 	}
 
+	// Count the notifications that the user still hasn't seen.
+	total, err := db.CountAndAnnounceUnseen(ctx, tx, user)
+	if err != nil {
+		a.Echo.Logger.Error(err)
+		return err
+	}
+
 	// Commit the transaction.
 	err = tx.Commit()
 	if err != nil {
@@ -71,6 +79,11 @@ func (a *API) MarkMessagesAsSeen(c echo.Context) error {
 		return err
 	}
 
+	// Let the user's other sessions know about the new count.
+	if err := publisher.PublishUnseenCount(ctx, a.AMQPClient, user, total); err != nil {
+		a.Echo.Logger.Error(err)
+	}
+
 	return c.JSON(http.StatusOK, &model.SuccessCount{
 		Success: true,
 		Count:   count,
@@ -102,7 +115,8 @@ func (a *API) MarkAllMessagesAsSeen(c echo.Context) error {
 	}()
 
 	// Obtain the user ID.
-	userID, err := db.GetUserID(ctx, tx, a.UserSuffix.Qualify(usernameWrapper.User))
+	user := a.UserSuffix.Qualify(usernameWrapper.User)
+	userID, err := db.GetUserID(ctx, tx, user)
 	if err != nil {
 		a.Echo.Logger.Error(err)
 		return err
@@ -123,6 +137,13 @@ func (a *API) MarkAllMessagesAsSeen(c echo.Context) error {
 		return err
 	}
 
+	// Count the notifications that the user still hasn't seen.
+	total, err := db.CountAndAnnounceUnseen(ctx, tx, user)
+	if err != nil {
+		a.Echo.Logger.Error(err)
+		return err
+	}
+
 	// Commit the transaction.
 	err = tx.Commit()
 	if err != nil {
@@ -130,6 +151,11 @@ func (a *API) MarkAllMessagesAsSeen(c echo.Context) error {
 		return err
 	}
 
+	// Let the user's other sessions know about the new count.
+	if err := publisher.PublishUnseenCount(ctx, a.AMQPClient, user, total); err != nil {
+		a.Echo.Logger.Error(err)
+	}
+
 	return c.JSON(http.StatusOK, &model.SuccessCount{
 		Count:   count,
 		Success: true,
@@ -192,6 +218,13 @@ func (a *API) DeleteMessages(c echo.Context) error {
 		return err
 	}
 
+	// Count the notifications that the user still hasn't seen.
+	total, err := db.CountAndAnnounceUnseen(ctx, tx, user)
+	if err != nil {
+		a.Echo.Logger.Error(err)
+		return err
+	}
+
 	// Commit the transaction.
 	err = tx.Commit()
 	if err != nil {
@@ -199,6 +232,11 @@ func (a *API) DeleteMessages(c echo.Context) error {
 		return err
 	}
 
+	// Let the user's other sessions know about the new count.
+	if err := publisher.PublishUnseenCount(ctx, a.AMQPClient, user, total); err != nil {
+		a.Echo.Logger.Error(err)
+	}
+
 	return c.JSON(http.StatusOK, &model.SuccessCount{
 		Success: true,
 		Count:   count,
@@ -284,6 +322,13 @@ func (a *API) DeleteMatchingMessages(c echo.Context) error {
 		return err
 	}
 
+	// Count the notifications that the user still hasn't seen.
+	total, err := db.CountAndAnnounceUnseen(ctx, tx, user)
+	if err != nil {
+		a.Echo.Logger.Error(err)
+		return err
+	}
+
 	// Commit the transaction.
 	err = tx.Commit()
 	if err != nil {
@@ -291,6 +336,11 @@ func (a *API) DeleteMatchingMessages(c echo.Context) error {
 		return err
 	}
 
+	// Let the user's other sessions know about the new count.
+	if err := publisher.PublishUnseenCount(ctx, a.AMQPClient, user, total); err != nil {
+		a.Echo.Logger.Error(err)
+	}
+
 	return c.JSON(http.StatusOK, &model.SuccessCount{
 		Success: true,
 		Count:   count,
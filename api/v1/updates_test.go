@@ -1,12 +1,14 @@
 package v1
 
 import (
+	"context"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
 
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/cyverse-de/messaging/v12"
 	"github.com/cyverse-de/notifications/common"
 	"github.com/go-playground/validator/v10"
 	"github.com/labstack/echo/v4"
@@ -23,6 +25,18 @@ func (v testValidator) Validate(i interface{}) error {
 	return v.validator.Struct(i)
 }
 
+// mockMessagingClient records the messages that the handlers publish.
+type mockMessagingClient struct {
+	routingKeys []string
+	bodies      []string
+}
+
+func (m *mockMessagingClient) PublishContextOpts(_ context.Context, key string, body []byte, _ *messaging.PublishingOpts) error {
+	m.routingKeys = append(m.routingKeys, key)
+	m.bodies = append(m.bodies, string(body))
+	return nil
+}
+
 // TestUpdateHandlersQualifyUsernames pins the qualification of every username the update handlers
 // resolve to a user ID, regardless of whether the handler takes it from the query string or from
 // the request body. An unqualified lookup misses the user row that the recorder writes, so the
@@ -47,6 +61,9 @@ func TestUpdateHandlersQualifyUsernames(t *testing.T) {
 				mock.ExpectExec("UPDATE notifications SET seen").
 					WithArgs(true, userID, false).
 					WillReturnResult(sqlmock.NewResult(0, 3))
+				mock.ExpectExec("INSERT INTO broadcast_states").
+					WithArgs(userID, true).
+					WillReturnResult(sqlmock.NewResult(0, 1))
 			},
 		},
 		{
@@ -58,6 +75,9 @@ func TestUpdateHandlersQualifyUsernames(t *testing.T) {
 				mock.ExpectExec("UPDATE notifications SET seen").
 					WithArgs(true, userID, notificationID).
 					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec("INSERT INTO broadcast_states").
+					WithArgs(userID, notificationID).
+					WillReturnResult(sqlmock.NewResult(0, 0))
 			},
 		},
 		{
@@ -69,6 +89,9 @@ func TestUpdateHandlersQualifyUsernames(t *testing.T) {
 				mock.ExpectExec("UPDATE notifications SET deleted").
 					WithArgs(true, userID, notificationID).
 					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec("INSERT INTO broadcast_states").
+					WithArgs(userID, notificationID).
+					WillReturnResult(sqlmock.NewResult(0, 0))
 			},
 		},
 	}
@@ -86,11 +109,23 @@ func TestUpdateHandlersQualifyUsernames(t *testing.T) {
 				WithArgs("sarahr@example.org").
 				WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(userID))
 			tt.expect(mock)
+			mock.ExpectQuery("SELECT \\(SELECT count\\(\\*\\) FROM \\(SELECT un.id").
+				WithArgs("sarahr@example.org", false, false, "sarahr@example.org", "sarahr@example.org", false, false).
+				WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+			mock.ExpectExec("SELECT pg_notify").
+				WithArgs("notification_events", `{"kind":"unseen_count","user":"sarahr@example.org","total":2}`).
+				WillReturnResult(sqlmock.NewResult(0, 1))
 			mock.ExpectCommit()
 
 			e := echo.New()
 			e.Validator = testValidator{validator: validator.New()}
-			a := &API{Echo: e, DB: db, UserSuffix: common.NewUserSuffix("example.org")}
+			messagingClient := &mockMessagingClient{}
+			a := &API{
+				Echo:       e,
+				AMQPClient: messagingClient,
+				DB:         db,
+				UserSuffix: common.NewUserSuffix("example.org"),
+			}
 
 			req := httptest.NewRequest(http.MethodPost, tt.target, strings.NewReader(tt.body))
 			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
@@ -99,6 +134,10 @@ func TestUpdateHandlersQualifyUsernames(t *testing.T) {
 			assert.NoError(tt.handler(a)(e.NewContext(req, rec)))
 			assert.Equal(http.StatusOK, rec.Code)
 			assert.NoError(mock.ExpectationsWereMet(), "not all mock expectations were met")
+
+			// The updated count goes out to the user's other sessions under the bare username.
+			assert.Equal([]string{"notification.sarahr"}, messagingClient.routingKeys)
+			assert.Equal([]string{`{"type":"unseen_count","total":2}`}, messagingClient.bodies)
 		})
 	}
 }
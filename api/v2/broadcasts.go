@@ -0,0 +1,211 @@
+package v2
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/cyverse-de/notifications/common"
+	"github.com/cyverse-de/notifications/db"
+	"github.com/cyverse-de/notifications/membership"
+	"github.com/cyverse-de/notifications/model"
+	"github.com/cyverse-de/notifications/publisher"
+	"github.com/cyverse-de/notifications/query"
+	"github.com/labstack/echo/v4"
+)
+
+// CreateBroadcastHandler handles requests to send a notification to every user, a list of users, or the members of a
+// group. The notification is stored once, no matter how many users it's sent to.
+func (a *API) CreateBroadcastHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	// Extract and validate the request body.
+	request := new(model.BroadcastRequest)
+	if err := c.Bind(request); err != nil {
+		return c.JSON(http.StatusBadRequest, model.InvalidRequestBody(err))
+	}
+	if err := c.Validate(request); err != nil {
+		return c.JSON(http.StatusBadRequest, model.InvalidRequestBody(err))
+	}
+
+	// Determine the recipients. The members of a group are looked up now, so the notification doesn't reach users
+	// who join the group later.
+	broadcast := &db.Broadcast{
+		NotificationType: request.Type,
+		Subject:          request.Subject,
+		Audience:         request.Audience,
+	}
+	switch request.Audience {
+	case model.BroadcastAudienceUsers:
+		broadcast.Usernames = a.qualifyUsernames(request.Users)
+	case model.BroadcastAudienceGroup:
+		if a.Groups == nil {
+			return c.JSON(http.StatusBadRequest, model.ErrorResponse{
+				Message: "notifications can't be sent to groups because no groups are configured",
+			})
+		}
+		members, err := a.Groups.Members(ctx, request.Group)
+		if errors.Is(err, membership.ErrUnknownGroup) {
+			return c.JSON(http.StatusBadRequest, model.ErrorResponse{
+				Message: err.Error(),
+			})
+		}
+		if err != nil {
+			a.Echo.Logger.Error(err)
+			return err
+		}
+		broadcast.Group = request.Group
+		broadcast.Usernames = a.qualifyUsernames(members)
+	}
+
+	// Begin a database transaction.
+	tx, err := a.DB.Begin()
+	if err != nil {
+		a.Echo.Logger.Error(err)
+		return err
+	}
+	defer func() {
+		err = tx.Rollback()
+	}()
+
+	// Save the broadcast, registering the notification type first if this is the first time it's been used.
+	err = db.RegisterNotificationType(ctx, tx, request.Type)
+	if err != nil {
+		a.Echo.Logger.Error(err)
+		return err
+	}
+	err = db.SaveBroadcast(ctx, tx, broadcast)
+	if err != nil {
+		a.Echo.Logger.Error(err)
+		return err
+	}
+
+	// Save the notification in the same format that the recorder uses for other notifications.
+	messageText := request.Message
+	if messageText == "" {
+		messageText = request.Subject
+	}
+	notification := &model.Notification{
+		Type:    strings.ReplaceAll(request.Type, "_", " "),
+		Subject: request.Subject,
+		Payload: request.Payload,
+		Message: map[string]interface{}{
+			"id":        broadcast.ID,
+			"timestamp": common.FormatTimestamp(broadcast.TimeCreated),
+			"text":      messageText,
+		},
+		Broadcast: true,
+	}
+	err = db.SaveBroadcastMessage(ctx, tx, broadcast.ID, notification)
+	if err != nil {
+		a.Echo.Logger.Error(err)
+		return err
+	}
+
+	// Let every replica know about the broadcast, so that clients streaming from any of them receive it.
+	err = db.AnnounceBroadcast(ctx, tx, broadcast.ID)
+	if err != nil {
+		a.Echo.Logger.Error(err)
+		return err
+	}
+
+	// Commit the transaction.
+	err = tx.Commit()
+	if err != nil {
+		a.Echo.Logger.Error(err)
+		return err
+	}
+
+	// Announce the broadcast to the DE UI. A single message is published no matter how many users the broadcast was
+	// sent to, so that large broadcasts don't hold up the response.
+	response := &model.Broadcast{
+		ID:           broadcast.ID,
+		Audience:     broadcast.Audience,
+		Group:        broadcast.Group,
+		Users:        broadcast.Usernames,
+		TimeCreated:  broadcast.TimeCreated,
+		Notification: notification,
+	}
+	if err := publisher.PublishBroadcast(ctx, a.AMQPClient, response); err != nil {
+		a.Echo.Logger.Error(err)
+	}
+
+	return c.JSON(http.StatusCreated, response)
+}
+
+// qualifyUsernames qualifies each of the given usernames.
+func (a *API) qualifyUsernames(usernames []string) []string {
+	result := make([]string, len(usernames))
+	for i, username := range usernames {
+		result[i] = a.UserSuffix.Qualify(username)
+	}
+	return result
+}
+
+// ListBroadcastsHandler handles requests to list the broadcast notifications that have been sent.
+func (a *API) ListBroadcastsHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	// Begin a database transaction.
+	tx, err := a.DB.Begin()
+	if err != nil {
+		a.Echo.Logger.Error(err)
+		return err
+	}
+	defer func() {
+		err = tx.Rollback()
+	}()
+
+	// List the broadcasts.
+	broadcasts, err := db.ListBroadcasts(ctx, tx)
+	if err != nil {
+		a.Echo.Logger.Error(err)
+		return err
+	}
+
+	return c.JSON(http.StatusOK, &model.BroadcastListing{Broadcasts: broadcasts})
+}
+
+// DeleteBroadcastHandler handles requests to permanently remove a broadcast notification. Unlike deleting a message,
+// which only hides it from one user, this removes the notification for every user it was sent to.
+func (a *API) DeleteBroadcastHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	// Extract and validate the broadcast ID.
+	id, err := query.ValidatedPathParam(c, "id", "uuid_rfc4122")
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, model.ErrorResponse{
+			Message: "invalid broadcast ID",
+		})
+	}
+
+	// Begin a database transaction.
+	tx, err := a.DB.Begin()
+	if err != nil {
+		a.Echo.Logger.Error(err)
+		return err
+	}
+	defer func() {
+		err = tx.Rollback()
+	}()
+
+	// Delete the broadcast.
+	count, err := db.DeleteBroadcast(ctx, tx, id)
+	if err != nil {
+		a.Echo.Logger.Error(err)
+		return err
+	}
+	if count == 0 {
+		return c.JSON(http.StatusNotFound, model.NotFound(fmt.Sprintf("broadcast ID %s", id)))
+	}
+
+	// Commit the transaction.
+	err = tx.Commit()
+	if err != nil {
+		a.Echo.Logger.Error(err)
+		return err
+	}
+
+	return c.NoContent(http.StatusOK)
+}
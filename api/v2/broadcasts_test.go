@@ -0,0 +1,94 @@
+package v2
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/cyverse-de/messaging/v12"
+	"github.com/cyverse-de/notifications/membership"
+	"github.com/go-playground/validator/v10"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+// mockMessagingClient records the messages that the handlers publish.
+type mockMessagingClient struct {
+	routingKeys []string
+	bodies      []string
+}
+
+func (m *mockMessagingClient) PublishContextOpts(_ context.Context, key string, body []byte, _ *messaging.PublishingOpts) error {
+	m.routingKeys = append(m.routingKeys, key)
+	m.bodies = append(m.bodies, string(body))
+	return nil
+}
+
+// TestCreateBroadcastPublishesOnce verifies that sending a broadcast to a list of users neither counts nor publishes
+// anything per recipient: the broadcast is saved, announced to the other replicas, and published to the DE UI once.
+func TestCreateBroadcastPublishesOnce(t *testing.T) {
+	assert := assert.New(t)
+
+	database, mock, err := sqlmock.New()
+	assert.NoError(err, "unable to open the mock database connection")
+	defer func() { _ = database.Close() }()
+
+	const broadcastID = "5d37b6c4-4f4b-11ee-9f9a-62d3d0b6a3a1"
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO notification_types").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT id::text FROM notification_types").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("c2ffb2a6-4f4b-11ee-9f9a-62d3d0b6a3a1"))
+	mock.ExpectQuery("INSERT INTO broadcasts").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "time_created"}).AddRow(broadcastID, time.Now()))
+	mock.ExpectExec("UPDATE broadcasts SET outgoing_json").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`SELECT pg_notify`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	e := echo.New()
+	e.Validator = testValidator{validator: validator.New()}
+	client := &mockMessagingClient{}
+	a := &API{Echo: e, DB: database, AMQPClient: client}
+
+	body := `{"type": "system", "subject": "maintenance tonight", "audience": "users", "users": ["sarahr", "ipcdev", "wregglej"]}`
+	req := httptest.NewRequest(http.MethodPost, "/v2/broadcasts", strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+
+	assert.NoError(a.CreateBroadcastHandler(e.NewContext(req, rec)))
+	assert.Equal(http.StatusCreated, rec.Code)
+	assert.NoError(mock.ExpectationsWereMet(), "not all mock expectations were met")
+	assert.Equal([]string{"broadcast"}, client.routingKeys)
+}
+
+func TestCreateBroadcastRejectsUnknownGroups(t *testing.T) {
+	tests := []struct {
+		name   string
+		groups membership.Resolver
+	}{
+		{name: "no groups are configured"},
+		{name: "the group isn't configured", groups: membership.NewStaticResolver(map[string][]string{"staff": {"ipcdev"}})},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			e.Validator = testValidator{validator: validator.New()}
+
+			// No database is needed, because the request is rejected before the broadcast is saved.
+			a := &API{Echo: e, Groups: tt.groups}
+
+			body := `{"type": "system", "subject": "maintenance tonight", "audience": "group", "group": "students"}`
+			req := httptest.NewRequest(http.MethodPost, "/v2/broadcasts", strings.NewReader(body))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec := httptest.NewRecorder()
+
+			err := a.CreateBroadcastHandler(e.NewContext(req, rec))
+			assert.NoError(t, err)
+			assert.Equal(t, http.StatusBadRequest, rec.Code)
+		})
+	}
+}
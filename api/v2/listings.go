@@ -13,6 +13,18 @@ import (
 
 // GetMessagesHandler handles requests for listing notification messages.
 func (a *API) GetMessagesHandler(c echo.Context) error {
+	return a.listMessages(c, false)
+}
+
+// GetTrashHandler handles requests for listing notification messages that have been deleted, so that they can be
+// restored.
+func (a *API) GetTrashHandler(c echo.Context) error {
+	return a.listMessages(c, true)
+}
+
+// listMessages handles requests for listing either the notification messages that have been deleted or the ones that
+// haven't.
+func (a *API) listMessages(c echo.Context, deleted bool) error {
 	var err error
 	ctx := c.Request().Context()
 
@@ -34,8 +46,9 @@ func (a *API) GetMessagesHandler(c echo.Context) error {
 		})
 	}
 
-	// Extract and validate the seen query parameter.
-	defaultSeenValue := false
+	// Extract and validate the seen query parameter. Most deleted messages have been seen, so the trash includes
+	// them unless the caller asks otherwise.
+	defaultSeenValue := deleted
 	seen, err := query.ValidateBooleanQueryParam(c, "seen", &defaultSeenValue)
 	if err != nil {
 		return c.JSON(http.StatusBadRequest, model.ErrorResponse{
@@ -78,11 +91,62 @@ func (a *API) GetMessagesHandler(c echo.Context) error {
 		})
 	}
 
+	// Extract and validate the created-after query parameter.
+	createdAfterParam := query.NewTimestampParam(nil)
+	err = query.ValidateParseableParam(c, "created-after", createdAfterParam)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, model.ErrorResponse{
+			Message: err.Error(),
+		})
+	}
+
+	// Extract and validate the created-before query parameter.
+	createdBeforeParam := query.NewTimestampParam(nil)
+	err = query.ValidateParseableParam(c, "created-before", createdBeforeParam)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, model.ErrorResponse{
+			Message: err.Error(),
+		})
+	}
+
+	// Extract and validate the seen-after query parameter.
+	seenAfterParam := query.NewTimestampParam(nil)
+	err = query.ValidateParseableParam(c, "seen-after", seenAfterParam)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, model.ErrorResponse{
+			Message: err.Error(),
+		})
+	}
+
+	// Extract and validate the seen-before query parameter.
+	seenBeforeParam := query.NewTimestampParam(nil)
+	err = query.ValidateParseableParam(c, "seen-before", seenBeforeParam)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, model.ErrorResponse{
+			Message: err.Error(),
+		})
+	}
+
+	// Only messages that have been seen can match a seen time range, so asking for one implies the seen parameter.
+	seenAfter := seenAfterParam.GetValue().(*time.Time)
+	seenBefore := seenBeforeParam.GetValue().(*time.Time)
+	if seenAfter != nil || seenBefore != nil {
+		seen = true
+	}
+
 	// Extract the subject-search query parameter.
 	subjectSearch := c.QueryParam("subject-search")
 
-	// Extract the type query parameter.
-	notificationType := c.QueryParam("type")
+	// Extract the full-text search query parameter.
+	search := c.QueryParam("q")
+
+	// Extract the type query parameter, which may be repeated to list messages of more than one type.
+	notificationTypes := make([]string, 0)
+	for _, notificationType := range c.QueryParams()["type"] {
+		if notificationType != "" {
+			notificationTypes = append(notificationTypes, notificationType)
+		}
+	}
 
 	// Begin a database transaction
 	tx, err := a.DB.Begin()
@@ -126,17 +190,23 @@ func (a *API) GetMessagesHandler(c echo.Context) error {
 
 	// Obtain the listing.
 	params := &db.V2NotificationListingParameters{
-		User:             user,
-		Limit:            limit,
-		Seen:             seen,
-		SortOrder:        *(sortOrderParam.GetValue().(*query.SortOrder)),
-		BeforeID:         beforeID,
-		BeforeTimestamp:  beforeTimestamp,
-		AfterID:          afterID,
-		AfterTimestamp:   afterTimestamp,
-		CountOnly:        countOnly,
-		SubjectSearch:    subjectSearch,
-		NotificationType: notificationType,
+		User:              user,
+		Limit:             limit,
+		Seen:              seen,
+		SortOrder:         *(sortOrderParam.GetValue().(*query.SortOrder)),
+		BeforeID:          beforeID,
+		BeforeTimestamp:   beforeTimestamp,
+		AfterID:           afterID,
+		AfterTimestamp:    afterTimestamp,
+		CountOnly:         countOnly,
+		SubjectSearch:     subjectSearch,
+		Search:            search,
+		NotificationTypes: notificationTypes,
+		CreatedAfter:      createdAfterParam.GetValue().(*time.Time),
+		CreatedBefore:     createdBeforeParam.GetValue().(*time.Time),
+		SeenAfter:         seenAfter,
+		SeenBefore:        seenBefore,
+		Deleted:           deleted,
 	}
 	listing, err := db.V2ListNotifications(ctx, tx, params)
 	if err != nil {
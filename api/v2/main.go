@@ -4,23 +4,30 @@ import (
 	"database/sql"
 	"net/http"
 
-	"github.com/cyverse-de/messaging/v12"
 	"github.com/cyverse-de/notifications/common"
+	"github.com/cyverse-de/notifications/membership"
 	"github.com/cyverse-de/notifications/model"
+	"github.com/cyverse-de/notifications/publisher"
+	"github.com/cyverse-de/notifications/stream"
 	"github.com/labstack/echo/v4"
 )
 
 // API defines version 1 of the REST API for the notifications service.
 type API struct {
-	Echo         *echo.Echo
-	Group        *echo.Group
-	AMQPSettings *common.AMQPSettings
-	AMQPClient   *messaging.Client
-	DB           *sql.DB
-	UserSuffix   common.UserSuffix
-	Service      string
-	Title        string
-	Version      string
+	Echo           *echo.Echo
+	Group          *echo.Group
+	AMQPSettings   *common.AMQPSettings
+	AMQPClient     publisher.MessagingClient
+	DB             *sql.DB
+	UserSuffix     common.UserSuffix
+	Stream         *stream.Hub
+	Recorder       Recorder
+	Events         EventDispatcher
+	BatchPublisher BatchPublisher
+	Groups         membership.Resolver
+	Service        string
+	Title          string
+	Version        string
 }
 
 // RootHandler handles GET requests to the /v1/ endpoint.
@@ -39,9 +46,29 @@ func (a API) RegisterHandlers() {
 	a.Group.GET("", a.RootHandler)
 	a.Group.GET("/", a.RootHandler)
 	a.Group.GET("/messages", a.GetMessagesHandler)
+	a.Group.POST("/notifications", a.CreateNotificationHandler)
+	a.Group.POST("/notifications/batch", a.CreateNotificationBatchHandler)
+	a.Group.GET("/messages/stream", a.StreamMessagesHandler)
 	a.Group.POST("/messages/delete", a.DeleteMultipleMessagesHandler)
 	a.Group.POST("/messages/seen", a.MarkMultipleMessagesSeenHandler)
+	a.Group.POST("/messages/unseen", a.MarkMultipleMessagesUnseenHandler)
+	a.Group.POST("/messages/restore", a.RestoreMultipleMessagesHandler)
 	a.Group.GET("/messages/:id", a.GetMessageHandler)
 	a.Group.POST("/messages/:id/seen", a.MarkMessageSeenHandler)
+	a.Group.POST("/messages/:id/unseen", a.MarkMessageUnseenHandler)
+	a.Group.POST("/messages/:id/restore", a.RestoreMessageHandler)
 	a.Group.DELETE("/messages/:id", a.DeleteMessageHandler)
+	a.Group.GET("/trash", a.GetTrashHandler)
+	a.Group.POST("/broadcasts", a.CreateBroadcastHandler)
+	a.Group.GET("/broadcasts", a.ListBroadcastsHandler)
+	a.Group.DELETE("/broadcasts/:id", a.DeleteBroadcastHandler)
+	a.Group.GET("/quarantine", a.ListQuarantinedEventsHandler)
+	a.Group.GET("/quarantine/:id", a.GetQuarantinedEventHandler)
+	a.Group.PUT("/quarantine/:id", a.UpdateQuarantinedEventHandler)
+	a.Group.POST("/quarantine/:id/replay", a.ReplayQuarantinedEventHandler)
+	a.Group.DELETE("/quarantine/:id", a.DeleteQuarantinedEventHandler)
+	a.Group.GET("/preferences", a.GetPreferencesHandler)
+	a.Group.PUT("/preferences", a.UpdatePreferencesHandler)
+	a.Group.PUT("/preferences/quiet-hours", a.UpdateQuietHoursHandler)
+	a.Group.DELETE("/preferences/quiet-hours", a.DeleteQuietHoursHandler)
 }
@@ -33,6 +33,81 @@ type rootResponseWrapper struct {
 	Body model.VersionRootResponse
 }
 
+// swagger:route POST /v2/notifications v2 createNotificationV2
+//
+// Create a Notification
+//
+// This endpoint accepts the same request body as `POST /v1/notification`. By default, the notification is queued to
+// be recorded and the response is sent right away, without a body. If `wait` is true, the notification is recorded
+// before the response is sent, the email and DE UI messages are published as usual, and the response contains the ID
+// of the recorded notification along with the notification itself. The notification isn't recorded if the recipient
+// has opted out of notifications of its type. A notification request with an idempotency key that has already been
+// used is only recorded once; if `wait` is true, the response to the duplicate request has `duplicate` set to true and
+// contains the notification that was recorded for the original request. Idempotency keys are scoped to the
+// notification type and recipient, so requests for different types or recipients don't collide.
+//
+// responses:
+//   200: v2NotificationCreated
+//   201: v2NotificationCreated
+//   202: emptyResponse
+//   400: errorResponse
+//   500: errorResponse
+
+// Parameters for the /v2/notifications endpoint.
+// swagger:parameters createNotificationV2
+type createNotificationParametersV2 struct {
+
+	// If true, the notification is recorded before the response is sent.
+	//
+	// in:query
+	// default: false
+	Wait bool `json:"wait"`
+
+	// A key that identifies this request, so that it's only recorded once if it's sent more than once.
+	//
+	// in:header
+	IdempotencyKey string `json:"Idempotency-Key"`
+
+	// in:body
+	Body model.V1NotificationRequest
+}
+
+// Created Notification
+// swagger:response v2NotificationCreated
+type notificationCreatedV2 struct {
+	// in:body
+	Body model.V2NotificationCreated
+}
+
+// swagger:route POST /v2/notifications/batch v2 createNotificationBatchV2
+//
+// Create Several Notifications
+//
+// This endpoint accepts an array of up to 1000 request bodies in the same format as `POST /v1/notification`. Each
+// notification is validated on its own, and the valid ones are queued to be recorded. The response lists the
+// positions of the notifications that were accepted, along with the position of each notification that was rejected
+// and the reason it was rejected. A notification is only accepted once the message broker confirms that it has been
+// queued, so rejected notifications can be resubmitted without duplicating the accepted ones.
+//
+// responses:
+//   200: v2NotificationBatchResponse
+//   400: errorResponse
+
+// Parameters for the /v2/notifications/batch endpoint.
+// swagger:parameters createNotificationBatchV2
+type createNotificationBatchParametersV2 struct {
+
+	// in:body
+	Body []model.V1NotificationRequest
+}
+
+// Batch Notification Results
+// swagger:response v2NotificationBatchResponse
+type notificationBatchResponseV2 struct {
+	// in:body
+	Body model.V2NotificationBatchResponse
+}
+
 // swagger:route GET /v2/messages v2 listMessagesV2
 //
 // List Notification Messages
@@ -51,8 +126,21 @@ type rootResponseWrapper struct {
 //   400: errorResponse
 //   500: errorResponse
 
-// Parameters for the /v2/messages endpoint.
-// swagger:parameters listMessagesV2
+// swagger:route GET /v2/trash v2 listTrashV2
+//
+// List Deleted Notification Messages
+//
+// This endpoint lists notifications that have been deleted, so that they can be restored. It accepts the same query
+// parameters and supports the same paging as the `/v2/messages` endpoint, except that messages that have been seen
+// before are included unless `seen` is set to false.
+//
+// responses:
+//   200: v2NotificationListing
+//   400: errorResponse
+//   500: errorResponse
+
+// Parameters for the /v2/messages and /v2/trash endpoints.
+// swagger:parameters listMessagesV2 listTrashV2
 type notificationListingParametersV2 struct {
 
 	// The username of the person to list notifications for.
@@ -68,7 +156,8 @@ type notificationListingParametersV2 struct {
 	// default: 0
 	Limit uint64 `json:"limit"`
 
-	// If true, messages that have been seen before will be included in the response.
+	// If true, messages that have been seen before will be included in the response. The default is true when listing
+	// deleted messages.
 	//
 	// in:query
 	// default: false
@@ -106,10 +195,42 @@ type notificationListingParametersV2 struct {
 	// in:query
 	SubjectSearch string `json:"subject-search"`
 
-	// If specified, only messages of the given type will be returned.
+	// If specified, only messages matching the full-text search will be returned. The search covers the subject, the
+	// message text, and the names, descriptions, and folders in the payload. Quoted phrases, `or`, and `-` to exclude
+	// words are supported. Each result includes its search rank and highlighted matches. The rank is informational
+	// only: results are still sorted by timestamp so that `before-id` and `after-id` paging works as usual.
+	//
+	// in:query
+	Search string `json:"q"`
+
+	// If specified, only messages of the given type will be returned. This parameter may be repeated to list messages
+	// of several types at once.
+	//
+	// in:query
+	// collection format: multi
+	MessageType []string `json:"type"`
+
+	// If specified, only messages created after the given RFC 3339 timestamp will be returned.
+	//
+	// in:query
+	CreatedAfter string `json:"created-after"`
+
+	// If specified, only messages created before the given RFC 3339 timestamp will be returned.
+	//
+	// in:query
+	CreatedBefore string `json:"created-before"`
+
+	// If specified, only messages that were first marked as seen after the given RFC 3339 timestamp will be
+	// returned. Messages that have been seen are included automatically when this parameter is used.
+	//
+	// in:query
+	SeenAfter string `json:"seen-after"`
+
+	// If specified, only messages that were first marked as seen before the given RFC 3339 timestamp will be
+	// returned. Messages that have been seen are included automatically when this parameter is used.
 	//
 	// in:query
-	MessageType string `json:"type"`
+	SeenBefore string `json:"seen-before"`
 }
 
 // Notification Listing
@@ -119,6 +240,45 @@ type notificationListingV2 struct {
 	Body model.V2NotificationListing
 }
 
+// swagger:route GET /v2/messages/stream v2 streamMessagesV2
+//
+// Stream New Notification Messages
+//
+// This endpoint holds the connection open and sends each notification recorded for the user as a server-sent event
+// named `notification`. The data of each event is a JSON object containing the notification in the `message` field
+// and the user's unseen notification count in the `total` field, which is the same message that the DE UI receives
+// over AMQP. The ID of each event is the notification ID.
+//
+// A client that reconnects with a `Last-Event-ID` header is first sent every notification that was recorded after
+// the one with that ID, including notifications that have been marked as seen since. If the notification with that
+// ID no longer exists, the stream starts with the next new notification. A client that missed more than 100
+// notifications is sent a single event named `reload` instead, whose data contains the unseen notification count in
+// the `total` field; the client should reload the user's notifications when it receives one.
+//
+//     Produces:
+//     - text/event-stream
+//
+// responses:
+//   200: emptyResponse
+//   400: errorResponse
+//   500: errorResponse
+
+// Parameters for the /v2/messages/stream endpoint.
+// swagger:parameters streamMessagesV2
+type streamMessagesParametersV2 struct {
+
+	// The username of the person to stream notifications for.
+	//
+	// in:query
+	// required: true
+	User string `json:"user"`
+
+	// The ID of the last notification that the client received.
+	//
+	// in:header
+	LastEventID string `json:"Last-Event-ID"`
+}
+
 // swagger:route GET /v2/messages/{id} v2 getMessageV2
 //
 // Get Notification Details
@@ -150,6 +310,19 @@ type notificationV2 struct {
 //   404: errorResponse
 //   500: errorResponse
 
+// swagger:route POST /v2/messages/{id}/unseen markMessageUnseenV2
+//
+// Mark a Message Unseen
+//
+// This endpoint updates the database to indicate that the user hasn't seen a notification message yet, so that the
+// user can come back to it later.
+//
+// responses:
+//   200: emptyResponse
+//   400: errorResponse
+//   404: errorResponse
+//   500: errorResponse
+
 // swagger:route DELETE /v2/messages/{id} deleteMessageV2
 //
 // Delete a Message
@@ -162,8 +335,20 @@ type notificationV2 struct {
 //   404: errorResponse
 //   500: errorResponse
 
+// swagger:route POST /v2/messages/{id}/restore restoreMessageV2
+//
+// Restore a Message
+//
+// This endpoint updates the database to indicate that a deleted notification message is no longer deleted.
+//
+// responses:
+//   200: emptyResponse
+//   400: errorResponse
+//   404: errorResponse
+//   500: errorResponse
+
 // Parameters for endpoints that return or update individual notifications.
-// swagger:parameters getMessageV2 markMessageSeenV2 deleteMessageV2
+// swagger:parameters getMessageV2 markMessageSeenV2 markMessageUnseenV2 deleteMessageV2 restoreMessageV2
 type singleNotificationParametersV2 struct {
 	// The username of the authenticated user.
 	//
@@ -189,6 +374,18 @@ type singleNotificationParametersV2 struct {
 //   404: errorResponse
 //   500: errorResponse
 
+// swagger:route POST /v2/messages/unseen markMessagesUnseenV2
+//
+// Mark Multiple Messages Unseen
+//
+// This endpoint updates the database to indicate that the user hasn't seen multiple notification messages yet.
+//
+// responses:
+//   200: emptyResponse
+//   400: errorResponse
+//   404: errorResponse
+//   500: errorResponse
+
 // swagger:route POST /v2/messages/delete deleteMessagesV2
 //
 // Delete Multiple Messages
@@ -202,8 +399,21 @@ type singleNotificationParametersV2 struct {
 //   404: errorResponse
 //   500: errorResponse
 
+// swagger:route POST /v2/messages/restore restoreMessagesV2
+//
+// Restore Multiple Messages
+//
+// This endpoint updates the database to indicate that multiple deleted notification messages are no longer deleted.
+// Restored messages show up in notification listings again.
+//
+// responses:
+//   200: emptyResponse
+//   400: errorResponse
+//   404: errorResponse
+//   500: errorResponse
+
 // Parameters for endpoints that update multiple notifications.
-// swagger:parameters markMessagesSeenV2 deleteMessagesV2
+// swagger:parameters markMessagesSeenV2 markMessagesUnseenV2 deleteMessagesV2 restoreMessagesV2
 type multipleNotificationParametersV2 struct {
 	// The username of the authenticated user.
 	//
@@ -214,3 +424,269 @@ type multipleNotificationParametersV2 struct {
 	// in:body
 	Body model.MultipleMessageUpdateRequest
 }
+
+// swagger:route GET /v2/preferences v2 getPreferencesV2
+//
+// List Notification Preferences
+//
+// This endpoint lists the user's preferences for every notification type. Notification types that the user hasn't set
+// any preferences for are listed with the defaults: notifications are recorded, and emailed whenever an email is
+// requested. The user's quiet hours are included if the user has set any.
+//
+// responses:
+//   200: notificationPreferences
+//   400: errorResponse
+//   500: errorResponse
+
+// swagger:route PUT /v2/preferences v2 updatePreferencesV2
+//
+// Update Notification Preferences
+//
+// This endpoint updates the user's preferences for the notification types in the request body and returns the
+// preferences for every notification type. A notification type with `email` set to false is still recorded and shown
+// in the DE, but never emailed. A notification type with `record` set to false is dropped entirely. A notification
+// type with `digest` set to `daily` or `weekly` has its emails collected into a single digest email for each period.
+//
+// responses:
+//   200: notificationPreferences
+//   400: errorResponse
+//   500: errorResponse
+
+// Notification Preferences
+// swagger:response notificationPreferences
+type notificationPreferencesWrapper struct {
+	// in:body
+	Body model.NotificationPreferences
+}
+
+// swagger:route PUT /v2/preferences/quiet-hours v2 updateQuietHoursV2
+//
+// Set Quiet Hours
+//
+// This endpoint sets the time of day during which the user doesn't want to receive emails, in the user's time zone.
+// Emails for notifications recorded during quiet hours are held and sent when the quiet hours end. Notifications still
+// appear in the DE right away.
+//
+// responses:
+//   200: quietHours
+//   400: errorResponse
+//   500: errorResponse
+
+// swagger:route DELETE /v2/preferences/quiet-hours v2 deleteQuietHoursV2
+//
+// Remove Quiet Hours
+//
+// This endpoint removes the user's quiet hours. Emails that are already being held are still sent when the quiet
+// hours would have ended.
+//
+// responses:
+//   200: emptyResponse
+//   400: errorResponse
+//   500: errorResponse
+
+// Quiet Hours
+// swagger:response quietHours
+type quietHoursWrapper struct {
+	// in:body
+	Body model.QuietHours
+}
+
+// Parameters for the PUT /v2/preferences/quiet-hours endpoint.
+// swagger:parameters updateQuietHoursV2
+type updateQuietHoursParametersV2 struct {
+	// in:body
+	Body model.QuietHours
+}
+
+// Parameters for the /v2/preferences endpoints.
+// swagger:parameters getPreferencesV2 updatePreferencesV2 updateQuietHoursV2 deleteQuietHoursV2
+type preferencesParametersV2 struct {
+	// The username of the authenticated user.
+	//
+	// in:query
+	// required: true
+	User string `json:"user"`
+}
+
+// Parameters for the PUT /v2/preferences endpoint.
+// swagger:parameters updatePreferencesV2
+type updatePreferencesParametersV2 struct {
+	// in:body
+	Body model.NotificationPreferences
+}
+
+// swagger:route POST /v2/broadcasts v2 createBroadcastV2
+//
+// Broadcast a Notification
+//
+// This endpoint sends a single notification to every user, to a list of users, or to the members of a group. The
+// notification is stored once rather than once per user, and it appears in the listings and unseen counts of every
+// user it was sent to. Each user can mark it as seen or deleted without affecting anyone else. The members of a group
+// are looked up when the notification is sent, so users who join the group later don't receive it. Broadcasts to
+// groups are rejected unless group definitions are configured. The notification is delivered to the message stream of
+// every user it was sent to, and a single message describing it is published to the DE UI with the routing key
+// `broadcast`. Users can only mark a broadcast as seen or deleted once they're in the database, which happens the first
+// time they receive a notification of their own.
+//
+// responses:
+//   201: broadcast
+//   400: errorResponse
+//   500: errorResponse
+
+// Parameters for the POST /v2/broadcasts endpoint.
+// swagger:parameters createBroadcastV2
+type createBroadcastParametersV2 struct {
+	// in:body
+	Body model.BroadcastRequest
+}
+
+// Broadcast Notification
+// swagger:response broadcast
+type broadcastWrapper struct {
+	// in:body
+	Body model.Broadcast
+}
+
+// swagger:route GET /v2/broadcasts v2 listBroadcastsV2
+//
+// List Broadcast Notifications
+//
+// This endpoint lists every broadcast notification that has been sent, most recent first.
+//
+// responses:
+//   200: broadcastListing
+//   500: errorResponse
+
+// Broadcast Notification Listing
+// swagger:response broadcastListing
+type broadcastListingWrapper struct {
+	// in:body
+	Body model.BroadcastListing
+}
+
+// swagger:route DELETE /v2/broadcasts/{id} v2 deleteBroadcastV2
+//
+// Remove a Broadcast Notification
+//
+// This endpoint permanently removes a broadcast notification for every user it was sent to, along with each user's
+// seen and deleted state for it.
+//
+// responses:
+//   200: emptyResponse
+//   400: errorResponse
+//   404: errorResponse
+//   500: errorResponse
+
+// Parameters for the DELETE /v2/broadcasts/{id} endpoint.
+// swagger:parameters deleteBroadcastV2
+type deleteBroadcastParametersV2 struct {
+	// The broadcast ID.
+	//
+	// in:path
+	ID string
+}
+
+// swagger:route GET /v2/quarantine v2 listQuarantinedEventsV2
+//
+// List Quarantined Notification Events
+//
+// This endpoint lists the notification events that were discarded because they couldn't be recorded, most recent
+// first. Events are quarantined when they fail with an error that retrying can't fix, or when recording them panics.
+//
+// responses:
+//   200: quarantinedEventListing
+//   500: errorResponse
+
+// Quarantined Notification Event Listing
+// swagger:response quarantinedEventListing
+type quarantinedEventListingWrapper struct {
+	// in:body
+	Body model.QuarantinedEventListing
+}
+
+// swagger:route GET /v2/quarantine/{id} v2 getQuarantinedEventV2
+//
+// Get a Quarantined Notification Event
+//
+// This endpoint returns a single quarantined notification event.
+//
+// responses:
+//   200: quarantinedEvent
+//   400: errorResponse
+//   404: errorResponse
+//   500: errorResponse
+
+// swagger:route PUT /v2/quarantine/{id} v2 updateQuarantinedEventV2
+//
+// Edit a Quarantined Notification Event
+//
+// This endpoint replaces the routing key and body of a quarantined notification event, so that the problem that kept
+// it from being recorded can be fixed before it's replayed.
+//
+// responses:
+//   200: quarantinedEvent
+//   400: errorResponse
+//   404: errorResponse
+//   500: errorResponse
+
+// Parameters for the PUT /v2/quarantine/{id} endpoint.
+// swagger:parameters updateQuarantinedEventV2
+type updateQuarantinedEventParametersV2 struct {
+	// in:body
+	Body model.QuarantinedEventUpdate
+}
+
+// swagger:route POST /v2/quarantine/{id}/replay v2 replayQuarantinedEventV2
+//
+// Replay a Quarantined Notification Event
+//
+// This endpoint records a quarantined notification event as though it had just been delivered. If the event is
+// recorded, it's marked as replayed but kept until it's deleted. If it still can't be recorded, the response describes
+// the error and the event's error is updated. An event that has already been replayed is only replayed again if
+// `force` is true, because replaying it again records its notification again.
+//
+// responses:
+//   200: quarantinedEvent
+//   400: errorResponse
+//   404: errorResponse
+//   409: errorResponse
+//   500: errorResponse
+
+// Parameters for the POST /v2/quarantine/{id}/replay endpoint.
+// swagger:parameters replayQuarantinedEventV2
+type replayQuarantinedEventParametersV2 struct {
+
+	// If true, the event is replayed even if it has been replayed already.
+	//
+	// in:query
+	// default: false
+	Force bool `json:"force"`
+}
+
+// swagger:route DELETE /v2/quarantine/{id} v2 deleteQuarantinedEventV2
+//
+// Remove a Quarantined Notification Event
+//
+// This endpoint permanently removes a quarantined notification event once it has been dealt with.
+//
+// responses:
+//   200: emptyResponse
+//   400: errorResponse
+//   404: errorResponse
+//   500: errorResponse
+
+// Parameters for the endpoints that refer to a single quarantined notification event.
+// swagger:parameters getQuarantinedEventV2 updateQuarantinedEventV2 replayQuarantinedEventV2 deleteQuarantinedEventV2
+type quarantinedEventIDParameterV2 struct {
+	// The quarantined event ID.
+	//
+	// in:path
+	ID string
+}
+
+// Quarantined Notification Event
+// swagger:response quarantinedEvent
+type quarantinedEventWrapper struct {
+	// in:body
+	Body model.QuarantinedEvent
+}
@@ -0,0 +1,230 @@
+package v2
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/cyverse-de/messaging/v12"
+	"github.com/cyverse-de/notifications/db"
+	"github.com/cyverse-de/notifications/model"
+	"github.com/cyverse-de/notifications/publisher"
+	"github.com/cyverse-de/notifications/query"
+	"github.com/cyverse-de/notifications/recorder"
+	"github.com/labstack/echo/v4"
+)
+
+// Recorder is the subset of recorder.Recorder that the API uses to record notifications while the caller waits.
+type Recorder interface {
+	RecordNotification(ctx context.Context, updateType string, body []byte, routingKey string) (string, error)
+}
+
+// EventDispatcher passes events to the handlers registered for their categories. The API uses it to replay quarantined
+// events.
+type EventDispatcher interface {
+	Dispatch(ctx context.Context, routingKey string, body []byte) error
+}
+
+// BatchPublisher publishes batches of messages and reports which of them the broker confirmed.
+type BatchPublisher interface {
+	PublishBatch(ctx context.Context, messages []publisher.Message) []error
+}
+
+// maxBatchSize is the maximum number of notifications that may be submitted in a single batch.
+const maxBatchSize = 1000
+
+// notificationRoutingKey returns the routing key that notification requests of the given type are published with.
+func notificationRoutingKey(notificationType string) string {
+	return fmt.Sprintf("events.notification.update.%s", notificationType)
+}
+
+// buildNotificationEvent validates an incoming notification request and serializes it in the format that the
+// recorder consumes. Errors returned by this function indicate that the request is invalid.
+func buildNotificationEvent(request *model.V1NotificationRequest, timestamp time.Time) ([]byte, error) {
+	if err := request.Prepare(); err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(&recorder.Request{
+		RequestType:    request.Type,
+		User:           request.User,
+		Recipients:     request.Recipients,
+		Team:           request.Team,
+		IdempotencyKey: request.IdempotencyKey,
+		Subject:        request.Subject,
+		Timestamp:      timestamp.Format(time.RFC3339Nano),
+		Email:          request.Email,
+		EmailTemplate:  request.EmailTemplate,
+		Payload:        request.Payload,
+		Message:        request.Message,
+	})
+}
+
+// CreateNotificationHandler handles requests to create a notification. By default, the notification is published to
+// the AMQP exchange to be recorded later, the same way version 1 of the API does it. If the wait query parameter is
+// true, the notification is recorded before the response is sent, and the response contains the recorded
+// notification.
+func (a *API) CreateNotificationHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	// Extract and validate the wait query parameter.
+	defaultWait := false
+	wait, err := query.ValidateBooleanQueryParam(c, "wait", &defaultWait)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, model.ErrorResponse{
+			Message: err.Error(),
+		})
+	}
+
+	// Extract and validate the request body.
+	request := new(model.V1NotificationRequest)
+	if err = c.Bind(request); err != nil {
+		return c.JSON(http.StatusBadRequest, model.InvalidRequestBody(err))
+	}
+	if request.IdempotencyKey == "" {
+		request.IdempotencyKey = c.Request().Header.Get(model.IdempotencyKeyHeader)
+	}
+	if err = c.Validate(request); err != nil {
+		return c.JSON(http.StatusBadRequest, model.InvalidRequestBody(err))
+	}
+	body, err := buildNotificationEvent(request, time.Now())
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, model.InvalidRequestBody(err))
+	}
+	routingKey := notificationRoutingKey(request.Type)
+
+	// Publish the request if the caller doesn't want to wait for the notification to be recorded.
+	if !wait {
+		err = a.AMQPClient.PublishContextOpts(ctx, routingKey, body, messaging.JSONPublishingOpts)
+		if err != nil {
+			a.Echo.Logger.Errorf("unable to publish the notification request: %s", err.Error())
+			return c.JSON(http.StatusInternalServerError, model.InternalError(err))
+		}
+		return c.NoContent(http.StatusAccepted)
+	}
+
+	// Record the notification. The recorder queues the email request and the message for the DE UI itself. The ID of
+	// the notification that was recorded for the original request is returned for a duplicate request.
+	id, err := a.Recorder.RecordNotification(ctx, request.Type, body, routingKey)
+	duplicate := errors.Is(err, recorder.ErrDuplicateRequest)
+	if err != nil && !duplicate {
+		var unrecoverable recorder.UnrecoverableError
+		if errors.As(err, &unrecoverable) {
+			return c.JSON(http.StatusBadRequest, model.ErrorResponse{
+				Message: err.Error(),
+			})
+		}
+		a.Echo.Logger.Error(err)
+		return c.JSON(http.StatusInternalServerError, model.InternalError(err))
+	}
+
+	// The notification isn't recorded if the recipient has opted out of notifications of this type.
+	if id == "" {
+		return c.JSON(http.StatusOK, model.V2NotificationCreated{Recorded: false, Duplicate: duplicate})
+	}
+
+	// Look up the recorded notification so that it can be returned in the same format as the listings.
+	tx, err := a.DB.Begin()
+	if err != nil {
+		a.Echo.Logger.Error(err)
+		return err
+	}
+	defer func() {
+		err = tx.Rollback()
+	}()
+	notification, err := db.GetNotification(ctx, tx, a.UserSuffix.Qualify(request.User), id)
+	if err != nil {
+		a.Echo.Logger.Error(err)
+		return err
+	}
+
+	// The notification for a duplicate request was recorded earlier.
+	if duplicate {
+		return c.JSON(http.StatusOK, model.V2NotificationCreated{
+			Recorded:     false,
+			Duplicate:    true,
+			ID:           id,
+			Notification: notification,
+		})
+	}
+
+	return c.JSON(http.StatusCreated, model.V2NotificationCreated{
+		Recorded:     true,
+		ID:           id,
+		Notification: notification,
+	})
+}
+
+// CreateNotificationBatchHandler handles requests to create several notifications at once. Each notification in the
+// batch is validated on its own, and the valid ones are published to the AMQP exchange to be recorded later. The
+// response lists the notifications that were accepted and the ones that were rejected, so that callers can correct
+// or resubmit the rejected ones without resubmitting the whole batch.
+func (a *API) CreateNotificationBatchHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	// Extract the request body.
+	var requests []*model.V1NotificationRequest
+	if err := c.Bind(&requests); err != nil {
+		return c.JSON(http.StatusBadRequest, model.InvalidRequestBody(err))
+	}
+	if len(requests) > maxBatchSize {
+		return c.JSON(http.StatusBadRequest, model.ErrorResponse{
+			Message: fmt.Sprintf("at most %d notifications may be submitted in a single batch", maxBatchSize),
+		})
+	}
+
+	// Validate each of the notifications, keeping track of where the valid ones came from.
+	result := model.V2NotificationBatchResponse{
+		Accepted: make([]int, 0, len(requests)),
+		Rejected: make([]*model.V2NotificationBatchRejection, 0),
+	}
+	reject := func(index int, err error) {
+		result.Rejected = append(result.Rejected, &model.V2NotificationBatchRejection{
+			Index:   index,
+			Message: err.Error(),
+		})
+	}
+	messages := make([]publisher.Message, 0, len(requests))
+	indexes := make([]int, 0, len(requests))
+	timestamp := time.Now()
+	for i, request := range requests {
+		if request == nil {
+			reject(i, errors.New("the notification request is null"))
+			continue
+		}
+		if err := c.Validate(request); err != nil {
+			reject(i, err)
+			continue
+		}
+		body, err := buildNotificationEvent(request, timestamp)
+		if err != nil {
+			reject(i, err)
+			continue
+		}
+		messages = append(messages, publisher.Message{RoutingKey: notificationRoutingKey(request.Type), Body: body})
+		indexes = append(indexes, i)
+	}
+
+	// Publish the valid notifications.
+	if len(messages) > 0 {
+		for j, err := range a.BatchPublisher.PublishBatch(ctx, messages) {
+			if err != nil {
+				a.Echo.Logger.Errorf("unable to publish notification %d of a batch: %s", indexes[j], err.Error())
+				reject(indexes[j], fmt.Errorf("unable to queue the notification: %w", err))
+				continue
+			}
+			result.Accepted = append(result.Accepted, indexes[j])
+		}
+	}
+
+	// Publishing failures are reported after the validation failures, so the rejections are put back in order.
+	sort.Slice(result.Rejected, func(i, j int) bool {
+		return result.Rejected[i].Index < result.Rejected[j].Index
+	})
+
+	return c.JSON(http.StatusOK, result)
+}
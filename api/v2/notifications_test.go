@@ -0,0 +1,274 @@
+package v2
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/cyverse-de/notifications/common"
+	"github.com/cyverse-de/notifications/model"
+	"github.com/cyverse-de/notifications/publisher"
+	"github.com/cyverse-de/notifications/recorder"
+	"github.com/go-playground/validator/v10"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+// testValidator mirrors the validator that main.go installs on the Echo instance.
+type testValidator struct {
+	validator *validator.Validate
+}
+
+func (v testValidator) Validate(i interface{}) error {
+	return v.validator.Struct(i)
+}
+
+// mockRecorder returns the given ID and error for every notification.
+type mockRecorder struct {
+	id  string
+	err error
+}
+
+func (m *mockRecorder) RecordNotification(context.Context, string, []byte, string) (string, error) {
+	return m.id, m.err
+}
+
+// createNotification sends a notification request to the handler and returns the response.
+func createNotification(t *testing.T, a *API, target, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	assert.NoError(t, a.CreateNotificationHandler(a.Echo.NewContext(req, rec)))
+	return rec
+}
+
+// newNotificationAPI returns an API that records notifications with the given recorder.
+func newNotificationAPI(database *sql.DB, r Recorder) *API {
+	e := echo.New()
+	e.Validator = testValidator{validator: validator.New()}
+	return &API{Echo: e, DB: database, UserSuffix: common.NewUserSuffix("example.org"), Recorder: r}
+}
+
+func TestCreateNotificationWaitsForTheNotification(t *testing.T) {
+	assert := assert.New(t)
+
+	database, mock, err := sqlmock.New()
+	assert.NoError(err, "unable to open the mock database connection")
+	defer func() { _ = database.Close() }()
+
+	// The recorded notification is looked up so that it can be returned.
+	const id = "46ae63be-7030-4cdd-8eb9-66aa49fcf38b"
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT nt.name AS type").
+		WillReturnRows(
+			sqlmock.NewRows([]string{"type", "seen", "deleted", "message", "seen_at", "deleted_at"}).
+				AddRow("analysis", false, false, []byte(`{"message":{"id":"`+id+`"},"subject":"job completed"}`), nil, nil),
+		)
+	mock.ExpectRollback()
+
+	a := newNotificationAPI(database, &mockRecorder{id: id})
+	rec := createNotification(t, a, "/v2/notifications?wait=true",
+		`{"type": "analysis", "user": "sarahr", "subject": "job completed"}`)
+	assert.Equal(http.StatusCreated, rec.Code)
+
+	var result model.V2NotificationCreated
+	assert.NoError(json.Unmarshal(rec.Body.Bytes(), &result))
+	assert.True(result.Recorded)
+	assert.Equal(id, result.ID)
+	if assert.NotNil(result.Notification) {
+		assert.Equal("job completed", result.Notification.Subject)
+	}
+
+	assert.NoError(mock.ExpectationsWereMet(), "not all mock expectations were met")
+}
+
+func TestCreateNotificationForUsersWhoOptedOut(t *testing.T) {
+	assert := assert.New(t)
+
+	a := newNotificationAPI(nil, &mockRecorder{})
+	rec := createNotification(t, a, "/v2/notifications?wait=true",
+		`{"type": "analysis", "user": "sarahr", "subject": "job completed"}`)
+	assert.Equal(http.StatusOK, rec.Code)
+
+	var result model.V2NotificationCreated
+	assert.NoError(json.Unmarshal(rec.Body.Bytes(), &result))
+	assert.False(result.Recorded)
+	assert.Empty(result.ID)
+}
+
+func TestCreateNotificationRejectsInvalidRequests(t *testing.T) {
+	tests := []struct {
+		name     string
+		target   string
+		body     string
+		recorder *mockRecorder
+	}{
+		{
+			name:   "invalid wait parameter",
+			target: "/v2/notifications?wait=maybe",
+			body:   `{"type": "analysis", "user": "sarahr", "subject": "job completed"}`,
+		},
+		{
+			name:   "missing user",
+			target: "/v2/notifications?wait=true",
+			body:   `{"type": "analysis", "subject": "job completed"}`,
+		},
+		{
+			name:   "email without a template",
+			target: "/v2/notifications?wait=true",
+			body:   `{"type": "analysis", "user": "sarahr", "subject": "job completed", "email": true}`,
+		},
+		{
+			name:     "unrecoverable recorder error",
+			target:   "/v2/notifications?wait=true",
+			body:     `{"type": "analysis", "user": "sarahr", "subject": "job completed"}`,
+			recorder: &mockRecorder{err: recorder.NewUnrecoverableError("the user doesn't exist")},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := tt.recorder
+			if r == nil {
+				r = &mockRecorder{}
+			}
+			rec := createNotification(t, newNotificationAPI(nil, r), tt.target, tt.body)
+			assert.Equal(t, http.StatusBadRequest, rec.Code)
+		})
+	}
+}
+
+// mockBatchPublisher records the messages that it's asked to publish and fails the ones with the given routing key.
+type mockBatchPublisher struct {
+	messages   []publisher.Message
+	failingKey string
+}
+
+func (m *mockBatchPublisher) PublishBatch(_ context.Context, messages []publisher.Message) []error {
+	m.messages = append(m.messages, messages...)
+	errs := make([]error, len(messages))
+	for i, message := range messages {
+		if message.RoutingKey == m.failingKey {
+			errs[i] = errors.New("the broker did not acknowledge the message")
+		}
+	}
+	return errs
+}
+
+func TestCreateNotificationReturnsTheOriginalNotificationForDuplicates(t *testing.T) {
+	assert := assert.New(t)
+
+	database, mock, err := sqlmock.New()
+	assert.NoError(err, "unable to open the mock database connection")
+	defer func() { _ = database.Close() }()
+
+	// The notification that was recorded for the original request is looked up.
+	const id = "46ae63be-7030-4cdd-8eb9-66aa49fcf38b"
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT nt.name AS type").
+		WithArgs("sarahr@example.org", id, "sarahr@example.org", "sarahr@example.org", id).
+		WillReturnRows(
+			sqlmock.NewRows([]string{"type", "seen", "deleted", "message", "seen_at", "deleted_at"}).
+				AddRow("analysis", false, false, []byte(`{"message":{"id":"`+id+`"},"subject":"job completed"}`), nil, nil),
+		)
+	mock.ExpectRollback()
+
+	e := echo.New()
+	e.Validator = testValidator{validator: validator.New()}
+	a := &API{
+		Echo:       e,
+		DB:         database,
+		UserSuffix: common.NewUserSuffix("example.org"),
+		Recorder:   &mockRecorder{id: id, err: recorder.ErrDuplicateRequest},
+	}
+
+	body := `{"type": "analysis", "user": "sarahr", "subject": "job completed", "idempotency_key": "job-1234-completed"}`
+	req := httptest.NewRequest(http.MethodPost, "/v2/notifications?wait=true", strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+
+	assert.NoError(a.CreateNotificationHandler(e.NewContext(req, rec)))
+	assert.Equal(http.StatusOK, rec.Code)
+
+	var result model.V2NotificationCreated
+	assert.NoError(json.Unmarshal(rec.Body.Bytes(), &result))
+	assert.False(result.Recorded)
+	assert.True(result.Duplicate)
+	assert.Equal(id, result.ID)
+	if assert.NotNil(result.Notification) {
+		assert.Equal("job completed", result.Notification.Subject)
+	}
+
+	assert.NoError(mock.ExpectationsWereMet(), "not all mock expectations were met")
+}
+
+func TestCreateNotificationBatch(t *testing.T) {
+	assert := assert.New(t)
+
+	body := `[
+		{"type": "analysis", "user": "sarahr", "subject": "first"},
+		{"type": "analysis", "subject": "no user"},
+		{"type": "data", "user": "sarahr", "subject": "unpublishable"},
+		{"type": "analysis", "user": "sarahr", "subject": "bad email", "email": true},
+		{"type": "apps", "user": "ipcdev", "subject": "last"}
+	]`
+
+	e := echo.New()
+	e.Validator = testValidator{validator: validator.New()}
+	batchPublisher := &mockBatchPublisher{failingKey: "events.notification.update.data"}
+	a := &API{Echo: e, BatchPublisher: batchPublisher}
+
+	req := httptest.NewRequest(http.MethodPost, "/v2/notifications/batch", strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+
+	err := a.CreateNotificationBatchHandler(e.NewContext(req, rec))
+	assert.NoError(err)
+	assert.Equal(http.StatusOK, rec.Code)
+
+	var result model.V2NotificationBatchResponse
+	assert.NoError(json.Unmarshal(rec.Body.Bytes(), &result))
+	assert.Equal([]int{0, 4}, result.Accepted)
+	if assert.Len(result.Rejected, 3) {
+		assert.Equal(1, result.Rejected[0].Index)
+		assert.Equal(2, result.Rejected[1].Index)
+		assert.Contains(result.Rejected[1].Message, "unable to queue the notification")
+		assert.Equal(3, result.Rejected[2].Index)
+		assert.Contains(result.Rejected[2].Message, "no email template")
+	}
+
+	// Each notification is published with the routing key for its own type.
+	if assert.Len(batchPublisher.messages, 3) {
+		assert.Equal("events.notification.update.analysis", batchPublisher.messages[0].RoutingKey)
+		assert.Equal("events.notification.update.data", batchPublisher.messages[1].RoutingKey)
+		assert.Equal("events.notification.update.apps", batchPublisher.messages[2].RoutingKey)
+	}
+}
+
+func TestCreateNotificationBatchRejectsOversizedBatches(t *testing.T) {
+	items := make([]string, maxBatchSize+1)
+	for i := range items {
+		items[i] = `{"type": "analysis", "user": "sarahr", "subject": "hi"}`
+	}
+
+	e := echo.New()
+	e.Validator = testValidator{validator: validator.New()}
+	batchPublisher := &mockBatchPublisher{}
+	a := &API{Echo: e, BatchPublisher: batchPublisher}
+
+	req := httptest.NewRequest(http.MethodPost, "/v2/notifications/batch", strings.NewReader("["+strings.Join(items, ",")+"]"))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+
+	err := a.CreateNotificationBatchHandler(e.NewContext(req, rec))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Empty(t, batchPublisher.messages)
+}
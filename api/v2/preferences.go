@@ -0,0 +1,247 @@
+package v2
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+
+	"github.com/cyverse-de/notifications/db"
+	"github.com/cyverse-de/notifications/model"
+	"github.com/cyverse-de/notifications/query"
+	"github.com/labstack/echo/v4"
+)
+
+// GetPreferencesHandler handles requests to list a user's notification preferences.
+func (a *API) GetPreferencesHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	// Extract and validate the user query parameter.
+	user, err := query.ValidatedQueryParam(c, "user", "required")
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, model.ErrorResponse{
+			Message: "missing required query parameter: user",
+		})
+	}
+	user = a.UserSuffix.Qualify(user)
+
+	// Begin a database transaction.
+	tx, err := a.DB.Begin()
+	if err != nil {
+		a.Echo.Logger.Error(err)
+		return err
+	}
+	defer func() {
+		err = tx.Rollback()
+	}()
+
+	// List the preferences.
+	preferences, err := a.listPreferences(ctx, tx, user)
+	if err != nil {
+		a.Echo.Logger.Error(err)
+		return err
+	}
+
+	return c.JSON(http.StatusOK, preferences)
+}
+
+// UpdatePreferencesHandler handles requests to update a user's notification preferences. Only the
+// notification types in the request body are updated.
+func (a *API) UpdatePreferencesHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	// Extract and validate the user query parameter.
+	user, err := query.ValidatedQueryParam(c, "user", "required")
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, model.ErrorResponse{
+			Message: "missing required query parameter: user",
+		})
+	}
+	user = a.UserSuffix.Qualify(user)
+
+	// Parse and validate the request body.
+	body := new(model.NotificationPreferences)
+	if err = c.Bind(body); err != nil {
+		return c.JSON(http.StatusBadRequest, model.InvalidRequestBody(err))
+	}
+	if err = c.Validate(body); err != nil {
+		return c.JSON(http.StatusBadRequest, model.InvalidRequestBody(err))
+	}
+
+	// Begin a database transaction.
+	tx, err := a.DB.Begin()
+	if err != nil {
+		a.Echo.Logger.Error(err)
+		return err
+	}
+	defer func() {
+		err = tx.Rollback()
+	}()
+
+	// Look up the user ID. The user might not have received any notifications yet.
+	userID, err := db.GetOrCreateUserID(ctx, tx, user)
+	if err != nil {
+		a.Echo.Logger.Error(err)
+		return err
+	}
+
+	// Save the preferences.
+	for _, preference := range body.Preferences {
+		notificationTypeID, err := db.GetNotificationTypeID(ctx, tx, preference.Type)
+		if err != nil {
+			a.Echo.Logger.Error(err)
+			return err
+		}
+		if notificationTypeID == "" {
+			return c.JSON(http.StatusBadRequest, model.ErrorResponse{
+				Message: fmt.Sprintf("unknown notification type: %s", preference.Type),
+			})
+		}
+
+		err = db.SavePreference(ctx, tx, userID, notificationTypeID, preference)
+		if err != nil {
+			a.Echo.Logger.Error(err)
+			return err
+		}
+	}
+
+	// List the updated preferences.
+	preferences, err := a.listPreferences(ctx, tx, user)
+	if err != nil {
+		a.Echo.Logger.Error(err)
+		return err
+	}
+
+	// Commit the transaction.
+	err = tx.Commit()
+	if err != nil {
+		a.Echo.Logger.Error(err)
+		return err
+	}
+
+	return c.JSON(http.StatusOK, preferences)
+}
+
+// UpdateQuietHoursHandler handles requests to set a user's quiet hours.
+func (a *API) UpdateQuietHoursHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	// Extract and validate the user query parameter.
+	user, err := query.ValidatedQueryParam(c, "user", "required")
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, model.ErrorResponse{
+			Message: "missing required query parameter: user",
+		})
+	}
+	user = a.UserSuffix.Qualify(user)
+
+	// Parse and validate the request body.
+	body := new(model.QuietHours)
+	if err = c.Bind(body); err != nil {
+		return c.JSON(http.StatusBadRequest, model.InvalidRequestBody(err))
+	}
+	if err = c.Validate(body); err != nil {
+		return c.JSON(http.StatusBadRequest, model.InvalidRequestBody(err))
+	}
+
+	// Begin a database transaction.
+	tx, err := a.DB.Begin()
+	if err != nil {
+		a.Echo.Logger.Error(err)
+		return err
+	}
+	defer func() {
+		err = tx.Rollback()
+	}()
+
+	// Look up the user ID. The user might not have received any notifications yet.
+	userID, err := db.GetOrCreateUserID(ctx, tx, user)
+	if err != nil {
+		a.Echo.Logger.Error(err)
+		return err
+	}
+
+	// Save the quiet hours.
+	err = db.SaveQuietHours(ctx, tx, userID, body)
+	if err != nil {
+		a.Echo.Logger.Error(err)
+		return err
+	}
+
+	// Commit the transaction.
+	err = tx.Commit()
+	if err != nil {
+		a.Echo.Logger.Error(err)
+		return err
+	}
+
+	return c.JSON(http.StatusOK, body)
+}
+
+// DeleteQuietHoursHandler handles requests to remove a user's quiet hours. Emails that are already
+// being held are still released when the quiet hours would have ended.
+func (a *API) DeleteQuietHoursHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	// Extract and validate the user query parameter.
+	user, err := query.ValidatedQueryParam(c, "user", "required")
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, model.ErrorResponse{
+			Message: "missing required query parameter: user",
+		})
+	}
+	user = a.UserSuffix.Qualify(user)
+
+	// Begin a database transaction.
+	tx, err := a.DB.Begin()
+	if err != nil {
+		a.Echo.Logger.Error(err)
+		return err
+	}
+	defer func() {
+		err = tx.Rollback()
+	}()
+
+	// Look up the user ID.
+	userID, err := db.GetUserID(ctx, tx, user)
+	if err != nil {
+		a.Echo.Logger.Error(err)
+		return err
+	}
+
+	// There's nothing to do if the user isn't in the database.
+	if userID == "" {
+		return nil
+	}
+
+	// Delete the quiet hours.
+	err = db.DeleteQuietHours(ctx, tx, userID)
+	if err != nil {
+		a.Echo.Logger.Error(err)
+		return err
+	}
+
+	// Commit the transaction.
+	err = tx.Commit()
+	if err != nil {
+		a.Echo.Logger.Error(err)
+		return err
+	}
+
+	return nil
+}
+
+// listPreferences lists a user's preferences for every notification type along with the user's quiet hours.
+func (a *API) listPreferences(ctx context.Context, tx *sql.Tx, user string) (*model.NotificationPreferences, error) {
+	preferences, err := db.ListPreferences(ctx, tx, user)
+	if err != nil {
+		return nil, err
+	}
+
+	quietHours, err := db.GetQuietHours(ctx, tx, user)
+	if err != nil {
+		return nil, err
+	}
+
+	return &model.NotificationPreferences{Preferences: preferences, QuietHours: quietHours}, nil
+}
@@ -0,0 +1,253 @@
+package v2
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	preferencesUser    = "sarahr@example.org"
+	preferencesUserID  = "8f3a6c1e-3cbc-11eb-8a0b-f64e9b87c109"
+	analysisTypeID     = "a1c2e4f6-3cbc-11eb-8a0b-f64e9b87c109"
+	preferencesColumns = "nt.name, COALESCE(p.email, true), COALESCE(p.record, true), COALESCE(p.digest, '')"
+)
+
+// expectPreferenceListing sets up the queries that list a user's preferences. The analysis preferences are the ones
+// given, and the data preferences are always the defaults. The user's quiet hours are listed if they're given.
+func expectPreferenceListing(mock sqlmock.Sqlmock, email, record bool, digest string, quietHours []string) {
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT " + preferencesColumns + " FROM notification_types nt")).
+		WithArgs(preferencesUser).
+		WillReturnRows(
+			sqlmock.NewRows([]string{"name", "email", "record", "digest"}).
+				AddRow("analysis", email, record, digest).
+				AddRow("data", true, true, ""),
+		)
+
+	rows := sqlmock.NewRows([]string{"time_zone", "start", "end"})
+	if quietHours != nil {
+		rows.AddRow(quietHours[0], quietHours[1], quietHours[2])
+	}
+	mock.ExpectQuery(regexp.QuoteMeta("FROM user_quiet_hours q JOIN users u ON q.user_id = u.id")).
+		WithArgs(preferencesUser).
+		WillReturnRows(rows)
+}
+
+// callPreferencesHandler calls one of the preferences handlers with the given query string and request body.
+func callPreferencesHandler(
+	t *testing.T,
+	a *API,
+	handler func(echo.Context) error,
+	method, rawQuery, body string,
+) *httptest.ResponseRecorder {
+	t.Helper()
+
+	req := httptest.NewRequest(method, "/v2/preferences?"+rawQuery, strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	assert.NoError(t, handler(a.Echo.NewContext(req, rec)))
+	return rec
+}
+
+func TestGetPreferences(t *testing.T) {
+	assert := assert.New(t)
+
+	database, mock, err := sqlmock.New()
+	assert.NoError(err, "unable to open the mock database connection")
+	defer func() { _ = database.Close() }()
+
+	mock.ExpectBegin()
+	expectPreferenceListing(mock, false, true, "daily", []string{"America/Phoenix", "22:00", "07:00"})
+	mock.ExpectRollback()
+
+	a := newNotificationAPI(database, nil)
+	rec := callPreferencesHandler(t, a, a.GetPreferencesHandler, http.MethodGet, "user=sarahr", "")
+
+	assert.NoError(mock.ExpectationsWereMet(), "not all mock expectations were met")
+	assert.Equal(http.StatusOK, rec.Code)
+	assert.JSONEq(`{
+		"preferences": [
+			{"type": "analysis", "email": false, "record": true, "digest": "daily"},
+			{"type": "data", "email": true, "record": true}
+		],
+		"quiet_hours": {"time_zone": "America/Phoenix", "start": "22:00", "end": "07:00"}
+	}`, rec.Body.String())
+}
+
+func TestGetPreferencesForUnknownUsers(t *testing.T) {
+	assert := assert.New(t)
+
+	database, mock, err := sqlmock.New()
+	assert.NoError(err, "unable to open the mock database connection")
+	defer func() { _ = database.Close() }()
+
+	// The defaults are listed without adding the user to the database.
+	mock.ExpectBegin()
+	expectPreferenceListing(mock, true, true, "", nil)
+	mock.ExpectRollback()
+
+	a := newNotificationAPI(database, nil)
+	rec := callPreferencesHandler(t, a, a.GetPreferencesHandler, http.MethodGet, "user=sarahr", "")
+
+	assert.NoError(mock.ExpectationsWereMet(), "not all mock expectations were met")
+	assert.Equal(http.StatusOK, rec.Code)
+	assert.JSONEq(`{
+		"preferences": [
+			{"type": "analysis", "email": true, "record": true},
+			{"type": "data", "email": true, "record": true}
+		]
+	}`, rec.Body.String())
+}
+
+func TestUpdatePreferences(t *testing.T) {
+	tests := []struct {
+		name      string
+		knownUser bool
+	}{
+		{name: "a known user", knownUser: true},
+		{name: "an unknown user", knownUser: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			database, mock, err := sqlmock.New()
+			assert.NoError(err, "unable to open the mock database connection")
+			defer func() { _ = database.Close() }()
+
+			// Users who aren't in the database yet are added so that their preferences can be saved.
+			mock.ExpectBegin()
+			userRows := sqlmock.NewRows([]string{"id"})
+			if tt.knownUser {
+				userRows.AddRow(preferencesUserID)
+			}
+			mock.ExpectQuery(regexp.QuoteMeta("SELECT id FROM users WHERE username = $1")).
+				WithArgs(preferencesUser).
+				WillReturnRows(userRows)
+			if !tt.knownUser {
+				mock.ExpectQuery(regexp.QuoteMeta("INSERT INTO users (username) VALUES ($1)")).
+					WithArgs(preferencesUser).
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(preferencesUserID))
+			}
+
+			// Only the notification types in the request body are saved.
+			mock.ExpectQuery(regexp.QuoteMeta("SELECT id FROM notification_types WHERE name = $1")).
+				WithArgs("analysis").
+				WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(analysisTypeID))
+			mock.ExpectExec(regexp.QuoteMeta("INSERT INTO user_preferences")).
+				WithArgs(preferencesUserID, analysisTypeID, false, true, "weekly").
+				WillReturnResult(sqlmock.NewResult(0, 1))
+
+			// The updated preferences are listed before the transaction is committed.
+			expectPreferenceListing(mock, false, true, "weekly", nil)
+			mock.ExpectCommit()
+
+			a := newNotificationAPI(database, nil)
+			rec := callPreferencesHandler(t, a, a.UpdatePreferencesHandler, http.MethodPut, "user=sarahr",
+				`{"preferences": [{"type": "analysis", "email": false, "record": true, "digest": "weekly"}]}`,
+			)
+
+			assert.NoError(mock.ExpectationsWereMet(), "not all mock expectations were met")
+			assert.Equal(http.StatusOK, rec.Code)
+			assert.JSONEq(`{
+				"preferences": [
+					{"type": "analysis", "email": false, "record": true, "digest": "weekly"},
+					{"type": "data", "email": true, "record": true}
+				]
+			}`, rec.Body.String())
+		})
+	}
+}
+
+func TestUpdatePreferencesRejectsUnknownNotificationTypes(t *testing.T) {
+	assert := assert.New(t)
+
+	database, mock, err := sqlmock.New()
+	assert.NoError(err, "unable to open the mock database connection")
+	defer func() { _ = database.Close() }()
+
+	// Nothing is saved, and the transaction is rolled back.
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT id FROM users WHERE username = $1")).
+		WithArgs(preferencesUser).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(preferencesUserID))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT id FROM notification_types WHERE name = $1")).
+		WithArgs("horoscope").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectRollback()
+
+	a := newNotificationAPI(database, nil)
+	rec := callPreferencesHandler(t, a, a.UpdatePreferencesHandler, http.MethodPut, "user=sarahr",
+		`{"preferences": [{"type": "horoscope", "email": false, "record": true}]}`,
+	)
+
+	assert.NoError(mock.ExpectationsWereMet(), "not all mock expectations were met")
+	assert.Equal(http.StatusBadRequest, rec.Code)
+	assert.JSONEq(`{"message": "unknown notification type: horoscope"}`, rec.Body.String())
+}
+
+func TestPreferencesRejectInvalidRequests(t *testing.T) {
+	tests := []struct {
+		name     string
+		handler  func(*API) func(echo.Context) error
+		method   string
+		rawQuery string
+		body     string
+	}{
+		{
+			name:    "a listing without a user",
+			handler: func(a *API) func(echo.Context) error { return a.GetPreferencesHandler },
+			method:  http.MethodGet,
+		},
+		{
+			name:    "an update without a user",
+			handler: func(a *API) func(echo.Context) error { return a.UpdatePreferencesHandler },
+			method:  http.MethodPut,
+			body:    `{"preferences": [{"type": "analysis", "email": true, "record": true}]}`,
+		},
+		{
+			name:     "an update with a body that isn't JSON",
+			handler:  func(a *API) func(echo.Context) error { return a.UpdatePreferencesHandler },
+			method:   http.MethodPut,
+			rawQuery: "user=sarahr",
+			body:     `preferences`,
+		},
+		{
+			name:     "an update without any preferences",
+			handler:  func(a *API) func(echo.Context) error { return a.UpdatePreferencesHandler },
+			method:   http.MethodPut,
+			rawQuery: "user=sarahr",
+			body:     `{}`,
+		},
+		{
+			name:     "an update without a notification type",
+			handler:  func(a *API) func(echo.Context) error { return a.UpdatePreferencesHandler },
+			method:   http.MethodPut,
+			rawQuery: "user=sarahr",
+			body:     `{"preferences": [{"email": true, "record": true}]}`,
+		},
+		{
+			name:     "an update with an invalid digest period",
+			handler:  func(a *API) func(echo.Context) error { return a.UpdatePreferencesHandler },
+			method:   http.MethodPut,
+			rawQuery: "user=sarahr",
+			body:     `{"preferences": [{"type": "analysis", "email": true, "record": true, "digest": "hourly"}]}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// No database is needed, because the request is rejected before anything is looked up.
+			a := newNotificationAPI(nil, nil)
+			rec := callPreferencesHandler(t, a, tt.handler(a), tt.method, tt.rawQuery, tt.body)
+			assert.Equal(t, http.StatusBadRequest, rec.Code)
+		})
+	}
+}
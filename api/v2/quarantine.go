@@ -0,0 +1,266 @@
+package v2
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/cyverse-de/notifications/db"
+	"github.com/cyverse-de/notifications/model"
+	"github.com/cyverse-de/notifications/query"
+	"github.com/cyverse-de/notifications/recorder"
+	"github.com/labstack/echo/v4"
+)
+
+// ListQuarantinedEventsHandler handles requests to list the notification events that were discarded because they
+// couldn't be recorded.
+func (a *API) ListQuarantinedEventsHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	// Begin a database transaction.
+	tx, err := a.DB.Begin()
+	if err != nil {
+		a.Echo.Logger.Error(err)
+		return err
+	}
+	defer func() {
+		err = tx.Rollback()
+	}()
+
+	// List the quarantined events.
+	events, err := db.ListQuarantinedEvents(ctx, tx)
+	if err != nil {
+		a.Echo.Logger.Error(err)
+		return err
+	}
+
+	return c.JSON(http.StatusOK, &model.QuarantinedEventListing{Events: events})
+}
+
+// GetQuarantinedEventHandler handles requests to look up a single quarantined notification event.
+func (a *API) GetQuarantinedEventHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	// Extract and validate the event ID.
+	id, err := query.ValidatedPathParam(c, "id", "uuid_rfc4122")
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, model.ErrorResponse{
+			Message: "invalid quarantined event ID",
+		})
+	}
+
+	// Begin a database transaction.
+	tx, err := a.DB.Begin()
+	if err != nil {
+		a.Echo.Logger.Error(err)
+		return err
+	}
+	defer func() {
+		err = tx.Rollback()
+	}()
+
+	// Look up the event.
+	event, err := db.GetQuarantinedEvent(ctx, tx, id)
+	if err != nil {
+		a.Echo.Logger.Error(err)
+		return err
+	}
+	if event == nil {
+		return c.JSON(http.StatusNotFound, model.NotFound(fmt.Sprintf("quarantined event ID %s", id)))
+	}
+
+	return c.JSON(http.StatusOK, event)
+}
+
+// UpdateQuarantinedEventHandler handles requests to edit a quarantined notification event so that the problem that
+// kept it from being recorded can be fixed before it's replayed.
+func (a *API) UpdateQuarantinedEventHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	// Extract and validate the event ID.
+	id, err := query.ValidatedPathParam(c, "id", "uuid_rfc4122")
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, model.ErrorResponse{
+			Message: "invalid quarantined event ID",
+		})
+	}
+
+	// Extract and validate the request body.
+	update := new(model.QuarantinedEventUpdate)
+	if err = c.Bind(update); err != nil {
+		return c.JSON(http.StatusBadRequest, model.InvalidRequestBody(err))
+	}
+	if err = c.Validate(update); err != nil {
+		return c.JSON(http.StatusBadRequest, model.InvalidRequestBody(err))
+	}
+
+	// Begin a database transaction.
+	tx, err := a.DB.Begin()
+	if err != nil {
+		a.Echo.Logger.Error(err)
+		return err
+	}
+	defer func() {
+		err = tx.Rollback()
+	}()
+
+	// Update the event.
+	count, err := db.UpdateQuarantinedEvent(ctx, tx, id, update.RoutingKey, []byte(update.Body), time.Now())
+	if err != nil {
+		a.Echo.Logger.Error(err)
+		return err
+	}
+	if count == 0 {
+		return c.JSON(http.StatusNotFound, model.NotFound(fmt.Sprintf("quarantined event ID %s", id)))
+	}
+
+	// Look up the updated event so that it can be returned.
+	event, err := db.GetQuarantinedEvent(ctx, tx, id)
+	if err != nil {
+		a.Echo.Logger.Error(err)
+		return err
+	}
+
+	// Commit the transaction.
+	err = tx.Commit()
+	if err != nil {
+		a.Echo.Logger.Error(err)
+		return err
+	}
+
+	return c.JSON(http.StatusOK, event)
+}
+
+// ReplayQuarantinedEventHandler handles requests to record a quarantined notification event again, as though it had
+// just been delivered. The event is kept, and marked as replayed, if it's recorded; it's up to the caller to delete
+// it once it's been dealt with. The error is updated if the event still can't be recorded. An event that has been
+// replayed already is only replayed again if the force query parameter is true, so that it isn't recorded twice by
+// accident.
+func (a *API) ReplayQuarantinedEventHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	// Extract and validate the event ID.
+	id, err := query.ValidatedPathParam(c, "id", "uuid_rfc4122")
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, model.ErrorResponse{
+			Message: "invalid quarantined event ID",
+		})
+	}
+
+	// Extract and validate the force query parameter.
+	defaultForce := false
+	force, err := query.ValidateBooleanQueryParam(c, "force", &defaultForce)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, model.ErrorResponse{
+			Message: err.Error(),
+		})
+	}
+
+	// Begin a database transaction.
+	tx, err := a.DB.Begin()
+	if err != nil {
+		a.Echo.Logger.Error(err)
+		return err
+	}
+	defer func() {
+		err = tx.Rollback()
+	}()
+
+	// Look up the event, locking it so that concurrent replays of the same event take turns.
+	event, err := db.GetQuarantinedEventForUpdate(ctx, tx, id)
+	if err != nil {
+		a.Echo.Logger.Error(err)
+		return err
+	}
+	if event == nil {
+		return c.JSON(http.StatusNotFound, model.NotFound(fmt.Sprintf("quarantined event ID %s", id)))
+	}
+	if event.TimeReplayed != nil && !force {
+		return c.JSON(http.StatusConflict, model.ErrorResponse{
+			Message: fmt.Sprintf("quarantined event ID %s has already been replayed", id),
+		})
+	}
+
+	// Dispatch the event the same way the event consumer does. Handlers use transactions of their own, so the event
+	// is handled even if the quarantined event can't be updated afterward. An event that no longer has a handler can't
+	// be replayed until it's edited.
+	replayErr := a.Events.Dispatch(ctx, event.RoutingKey, []byte(event.Body))
+	var unrecoverable recorder.UnrecoverableError
+	switch {
+	case replayErr == nil:
+		now := time.Now()
+		if _, err = db.MarkQuarantinedEventReplayed(ctx, tx, id, now); err != nil {
+			a.Echo.Logger.Error(err)
+			return err
+		}
+		event.TimeReplayed = &now
+	case errors.As(replayErr, &unrecoverable), errors.Is(replayErr, recorder.ErrNoHandler):
+		if _, err = db.SetQuarantinedEventError(ctx, tx, id, replayErr.Error()); err != nil {
+			a.Echo.Logger.Error(err)
+			return err
+		}
+	default:
+		a.Echo.Logger.Error(replayErr)
+		return c.JSON(http.StatusInternalServerError, model.InternalError(replayErr))
+	}
+
+	// Commit the transaction.
+	err = tx.Commit()
+	if err != nil {
+		a.Echo.Logger.Error(err)
+		return err
+	}
+
+	// The event still can't be recorded.
+	if replayErr != nil {
+		return c.JSON(http.StatusBadRequest, model.ErrorResponse{
+			Message: replayErr.Error(),
+		})
+	}
+
+	return c.JSON(http.StatusOK, event)
+}
+
+// DeleteQuarantinedEventHandler handles requests to permanently remove a quarantined notification event once it has
+// been dealt with.
+func (a *API) DeleteQuarantinedEventHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	// Extract and validate the event ID.
+	id, err := query.ValidatedPathParam(c, "id", "uuid_rfc4122")
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, model.ErrorResponse{
+			Message: "invalid quarantined event ID",
+		})
+	}
+
+	// Begin a database transaction.
+	tx, err := a.DB.Begin()
+	if err != nil {
+		a.Echo.Logger.Error(err)
+		return err
+	}
+	defer func() {
+		err = tx.Rollback()
+	}()
+
+	// Delete the event.
+	count, err := db.DeleteQuarantinedEvent(ctx, tx, id)
+	if err != nil {
+		a.Echo.Logger.Error(err)
+		return err
+	}
+	if count == 0 {
+		return c.JSON(http.StatusNotFound, model.NotFound(fmt.Sprintf("quarantined event ID %s", id)))
+	}
+
+	// Commit the transaction.
+	err = tx.Commit()
+	if err != nil {
+		a.Echo.Logger.Error(err)
+		return err
+	}
+
+	return c.NoContent(http.StatusOK)
+}
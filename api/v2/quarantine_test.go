@@ -0,0 +1,171 @@
+package v2
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/cyverse-de/notifications/model"
+	"github.com/cyverse-de/notifications/recorder"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+// mockDispatcher records the events that it's asked to dispatch and fails with err.
+type mockDispatcher struct {
+	routingKeys []string
+	bodies      []string
+	err         error
+}
+
+func (m *mockDispatcher) Dispatch(_ context.Context, routingKey string, body []byte) error {
+	m.routingKeys = append(m.routingKeys, routingKey)
+	m.bodies = append(m.bodies, string(body))
+	return m.err
+}
+
+func TestReplayQuarantinedEvent(t *testing.T) {
+	const id = "7f0e3a9c-9c1e-11ef-8d3f-0242ac120002"
+	const routingKey = "events.notification.update.analysis"
+
+	tests := []struct {
+		name      string
+		recordErr error
+		wantCode  int
+	}{
+		{name: "the event is recorded", wantCode: http.StatusOK},
+		{
+			name:      "the event still can't be recorded",
+			recordErr: recorder.NewUnrecoverableError("unable to parse message body: unexpected end of JSON input"),
+			wantCode:  http.StatusBadRequest,
+		},
+		{
+			name:      "the event's category has no handler",
+			recordErr: fmt.Errorf("%w: notification", recorder.ErrNoHandler),
+			wantCode:  http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			database, mock, err := sqlmock.New()
+			assert.NoError(err, "unable to open the mock database connection")
+			defer func() { _ = database.Close() }()
+
+			mock.ExpectBegin()
+			mock.ExpectQuery("SELECT id, routing_key, body, error, time_quarantined, time_updated, time_replayed " +
+				"FROM quarantined_events WHERE id = \\$1 FOR UPDATE").
+				WithArgs(id).
+				WillReturnRows(
+					sqlmock.NewRows([]string{
+						"id", "routing_key", "body", "error", "time_quarantined", "time_updated", "time_replayed",
+					}).AddRow(id, routingKey, []byte(`{"type":`), "unable to parse message body", time.Now(), nil, nil),
+				)
+			if tt.recordErr == nil {
+				mock.ExpectExec("UPDATE quarantined_events SET time_replayed = \\$1 WHERE id = \\$2").
+					WithArgs(sqlmock.AnyArg(), id).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			} else {
+				mock.ExpectExec("UPDATE quarantined_events SET error = \\$1 WHERE id = \\$2").
+					WithArgs(tt.recordErr.Error(), id).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			}
+			mock.ExpectCommit()
+
+			e := echo.New()
+			rec := httptest.NewRecorder()
+			c := e.NewContext(httptest.NewRequest(http.MethodPost, "/v2/quarantine/"+id+"/replay", nil), rec)
+			c.SetParamNames("id")
+			c.SetParamValues(id)
+
+			events := &mockDispatcher{err: tt.recordErr}
+			a := &API{Echo: e, DB: database, Events: events}
+			assert.NoError(a.ReplayQuarantinedEventHandler(c))
+
+			assert.Equal(tt.wantCode, rec.Code)
+			assert.Equal([]string{routingKey}, events.routingKeys)
+			assert.Equal([]string{`{"type":`}, events.bodies)
+			assert.NoError(mock.ExpectationsWereMet(), "not all mock expectations were met")
+
+			if tt.recordErr == nil {
+				var event model.QuarantinedEvent
+				assert.NoError(json.Unmarshal(rec.Body.Bytes(), &event))
+				assert.NotNil(event.TimeReplayed, "the event wasn't marked as replayed")
+			}
+		})
+	}
+}
+
+func TestReplayQuarantinedEventOnlyReplaysAgainWhenForced(t *testing.T) {
+	const id = "7f0e3a9c-9c1e-11ef-8d3f-0242ac120002"
+	const routingKey = "events.notification.update.analysis"
+
+	tests := []struct {
+		name       string
+		target     string
+		wantCode   int
+		wantReplay bool
+	}{
+		{name: "a replayed event isn't replayed again", target: "/replay", wantCode: http.StatusConflict},
+		{
+			name:       "a replayed event is replayed again if forced",
+			target:     "/replay?force=true",
+			wantCode:   http.StatusOK,
+			wantReplay: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			database, mock, err := sqlmock.New()
+			assert.NoError(err, "unable to open the mock database connection")
+			defer func() { _ = database.Close() }()
+
+			replayedAt := time.Now().Add(-time.Hour)
+			mock.ExpectBegin()
+			mock.ExpectQuery("SELECT id, routing_key, body, error, time_quarantined, time_updated, time_replayed " +
+				"FROM quarantined_events WHERE id = \\$1 FOR UPDATE").
+				WithArgs(id).
+				WillReturnRows(
+					sqlmock.NewRows([]string{
+						"id", "routing_key", "body", "error", "time_quarantined", "time_updated", "time_replayed",
+					}).AddRow(id, routingKey, []byte(`{}`), "unable to record", time.Now(), nil, replayedAt),
+				)
+			if tt.wantReplay {
+				mock.ExpectExec("UPDATE quarantined_events SET time_replayed = \\$1 WHERE id = \\$2").
+					WithArgs(sqlmock.AnyArg(), id).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+			} else {
+				mock.ExpectRollback()
+			}
+
+			e := echo.New()
+			rec := httptest.NewRecorder()
+			c := e.NewContext(httptest.NewRequest(http.MethodPost, "/v2/quarantine/"+id+tt.target, nil), rec)
+			c.SetParamNames("id")
+			c.SetParamValues(id)
+
+			events := &mockDispatcher{}
+			a := &API{Echo: e, DB: database, Events: events}
+			assert.NoError(a.ReplayQuarantinedEventHandler(c))
+
+			assert.Equal(tt.wantCode, rec.Code)
+			if tt.wantReplay {
+				assert.Len(events.routingKeys, 1)
+			} else {
+				assert.Empty(events.routingKeys, "the event was recorded again")
+			}
+			assert.NoError(mock.ExpectationsWereMet(), "not all mock expectations were met")
+		})
+	}
+}
@@ -0,0 +1,175 @@
+package v2
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/cyverse-de/notifications/db"
+	"github.com/cyverse-de/notifications/model"
+	"github.com/cyverse-de/notifications/query"
+	"github.com/cyverse-de/notifications/stream"
+	"github.com/labstack/echo/v4"
+)
+
+// streamHeartbeatInterval is how often a comment is written to an idle stream, so that proxies
+// sitting between the client and the service don't close the connection for inactivity.
+const streamHeartbeatInterval = 30 * time.Second
+
+// missedNotificationsLimit is the largest number of missed notifications that a resuming client is
+// sent. A client that missed more than this is sent a reload event instead, so that a client that
+// was away for a long time doesn't hold a transaction open while its whole backlog is replayed.
+const missedNotificationsLimit = 100
+
+// missedNotifications returns the notifications that were recorded for a user after the one with
+// the given ID, oldest first. The notification with the given ID is not included. Nothing is
+// returned if the notification doesn't exist, because a stream that can't be resumed is better
+// started fresh than refused; a refused EventSource never reconnects. A single reload event is
+// returned if more than missedNotificationsLimit notifications were missed.
+func (a *API) missedNotifications(ctx context.Context, user, lastEventID string) ([]stream.Event, error) {
+	tx, err := a.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	// Look up the position of the last notification the client received.
+	lastTimestamp, err := db.GetNotificationTimestamp(ctx, tx, lastEventID)
+	if err != nil {
+		return nil, err
+	}
+	if lastTimestamp == nil {
+		a.Echo.Logger.Warnf("unable to resume the message stream for %s: message %s does not exist", user, lastEventID)
+		return nil, nil
+	}
+
+	// List every notification the client hasn't received yet, whether or not it's been seen since.
+	// The after-id boundary is inclusive, so room is left for the last notification the client
+	// received and for one more than the limit, which shows that the limit was exceeded.
+	listing, err := db.V2ListNotificationPage(ctx, tx, &db.V2NotificationListingParameters{
+		User:           user,
+		Limit:          missedNotificationsLimit + 2,
+		Seen:           true,
+		SortOrder:      query.SortOrderAscending,
+		AfterID:        lastEventID,
+		AfterTimestamp: lastTimestamp,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Every replayed notification carries the current unseen total.
+	total, err := db.CountUnreadNotifications(ctx, tx, user)
+	if err != nil {
+		return nil, err
+	}
+
+	// The after-id boundary is inclusive, so the last notification the client received is skipped.
+	events := make([]stream.Event, 0, len(listing))
+	for _, notification := range listing {
+		id, _ := notification.Message["id"].(string)
+		if id == lastEventID {
+			continue
+		}
+		events = append(events, stream.NewNotificationEvent(total, notification))
+	}
+
+	// The client has to reload its notifications if it missed too many of them.
+	if len(events) > missedNotificationsLimit {
+		return []stream.Event{stream.NewReloadEvent(total)}, nil
+	}
+
+	return events, nil
+}
+
+// StreamMessagesHandler handles requests to stream a user's new notifications as server-sent
+// events. A client that reconnects with a Last-Event-ID header is first sent the notifications it
+// missed, or a reload event if it missed too many of them.
+func (a *API) StreamMessagesHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	// Extract and validate the user query parameter.
+	user, err := query.ValidatedQueryParam(c, "user", "required")
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, model.ErrorResponse{
+			Message: "missing required query parameter: user",
+		})
+	}
+	user = a.UserSuffix.Qualify(user)
+
+	// Extract and validate the Last-Event-ID header.
+	lastEventID := c.Request().Header.Get("Last-Event-ID")
+	if lastEventID != "" {
+		if err = query.ValidateUUID(lastEventID); err != nil {
+			return c.JSON(http.StatusBadRequest, model.ErrorResponse{
+				Message: "invalid Last-Event-ID header: " + err.Error(),
+			})
+		}
+	}
+
+	// Subscribe before looking for missed notifications, so that nothing recorded in between is lost.
+	sub := a.Stream.Subscribe(user)
+	defer sub.Close()
+
+	// Look up the notifications the client missed, if it's resuming a stream.
+	var missed []stream.Event
+	sent := make(map[string]bool)
+	if lastEventID != "" {
+		missed, err = a.missedNotifications(ctx, user, lastEventID)
+		if err != nil {
+			a.Echo.Logger.Error(err)
+			return err
+		}
+	}
+
+	// Start the stream.
+	resp := c.Response()
+	resp.Header().Set(echo.HeaderContentType, "text/event-stream")
+	resp.Header().Set(echo.HeaderCacheControl, "no-cache")
+	resp.Header().Set(echo.HeaderConnection, "keep-alive")
+	resp.Header().Set("X-Accel-Buffering", "no")
+	resp.WriteHeader(http.StatusOK)
+
+	// Send the missed notifications.
+	for _, event := range missed {
+		if _, err = event.WriteTo(resp); err != nil {
+			return nil
+		}
+		sent[event.ID] = true
+	}
+	resp.Flush()
+
+	heartbeat := time.NewTicker(streamHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	// Relay events until the client goes away or the subscription is closed.
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+
+		case event, ok := <-sub.Events():
+			if !ok {
+				return nil
+			}
+
+			// A notification recorded while the missed ones were being looked up can arrive twice.
+			if event.ID != "" && sent[event.ID] {
+				continue
+			}
+			if _, err = event.WriteTo(resp); err != nil {
+				a.Echo.Logger.Errorf("unable to write to the message stream for %s: %s", user, err.Error())
+				return nil
+			}
+			resp.Flush()
+
+		case <-heartbeat.C:
+			if _, err = resp.Write([]byte(": keepalive\n\n")); err != nil {
+				return nil
+			}
+			resp.Flush()
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package v2
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/cyverse-de/notifications/common"
+	"github.com/cyverse-de/notifications/stream"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	streamUser  = "sarahr@example.org"
+	lastEventID = "00000000-0000-4000-8000-000000000000"
+)
+
+// missedNotificationID returns the ID of the nth notification that was recorded after the last one the client
+// received.
+func missedNotificationID(n int) string {
+	return fmt.Sprintf("00000000-0000-4000-8000-%012d", n)
+}
+
+// listingRows returns the rows of a notification listing: the last notification that the client received, because
+// the after-id boundary is inclusive, followed by the given number of notifications that it missed. Every other
+// missed notification has already been seen.
+func listingRows(lastTimestamp time.Time, missed int) *sqlmock.Rows {
+	rows := sqlmock.NewRows([]string{
+		"type", "seen", "deleted", "message", "id", "time_created", "seen_at", "deleted_at",
+	})
+	ids := []string{lastEventID}
+	for n := 1; n <= missed; n++ {
+		ids = append(ids, missedNotificationID(n))
+	}
+	for n, id := range ids {
+		message := fmt.Sprintf(`{"message": {"id": "%s", "text": "job %d completed"}}`, id, n)
+		rows.AddRow("analysis", n%2 == 0, false, message, id, lastTimestamp.Add(time.Duration(n)*time.Second), nil, nil)
+	}
+	return rows
+}
+
+// expectMissedNotifications sets up the queries that catch a resuming client up.
+func expectMissedNotifications(mock sqlmock.Sqlmock, lastTimestamp time.Time, missed int) {
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT time_created FROM notifications WHERE id = \$1 UNION ALL`).
+		WithArgs(lastEventID, lastEventID).
+		WillReturnRows(sqlmock.NewRows([]string{"time_created"}).AddRow(lastTimestamp))
+
+	// Seen notifications are replayed, because the client may not have received them before they were seen, but
+	// deleted notifications aren't.
+	args := []driver.Value{lastTimestamp, lastTimestamp, lastEventID}
+	listingArgs := append(append([]driver.Value{streamUser, false}, args...), streamUser, streamUser, false)
+	listingArgs = append(listingArgs, args...)
+	mock.ExpectQuery(`WHERE n.deleted = \$2 AND \(n.time_created > \$3 OR \(n.time_created = \$4 AND n.id >= \$5\)\) ` +
+		`ORDER BY n.time_created ASC, n.id ASC LIMIT 102`).
+		WithArgs(listingArgs...).
+		WillReturnRows(listingRows(lastTimestamp, missed))
+
+	mock.ExpectQuery(`SELECT \(SELECT count\(\*\)`).
+		WithArgs(streamUser, false, false, streamUser, streamUser, false, false).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(3))
+	mock.ExpectRollback()
+}
+
+// streamMessages calls the stream handler with the given Last-Event-ID header. The hub is closed beforehand, so the
+// handler returns as soon as it has caught the client up.
+func streamMessages(t *testing.T, a *API, lastEventID string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	a.Stream = stream.NewHub()
+	a.Stream.Close()
+
+	req := httptest.NewRequest(http.MethodGet, "/v2/messages/stream?user=sarahr", nil)
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	rec := httptest.NewRecorder()
+
+	assert.NoError(t, a.StreamMessagesHandler(a.Echo.NewContext(req, rec)))
+	return rec
+}
+
+func TestStreamMessagesResumes(t *testing.T) {
+	assert := assert.New(t)
+
+	database, mock, err := sqlmock.New()
+	assert.NoError(err, "unable to open the mock database connection")
+	defer func() { _ = database.Close() }()
+
+	lastTimestamp := time.Date(2026, time.October, 14, 3, 0, 0, 0, time.UTC)
+	expectMissedNotifications(mock, lastTimestamp, 2)
+
+	a := &API{Echo: echo.New(), DB: database, UserSuffix: common.NewUserSuffix("example.org")}
+	rec := streamMessages(t, a, lastEventID)
+
+	assert.NoError(mock.ExpectationsWereMet(), "not all mock expectations were met")
+	assert.Equal(http.StatusOK, rec.Code)
+	assert.Equal("text/event-stream", rec.Header().Get(echo.HeaderContentType))
+
+	// Only the missed notifications are sent, oldest first, along with the current unseen total.
+	body := rec.Body.String()
+	assert.NotContains(body, "id: "+lastEventID)
+	first := strings.Index(body, "id: "+missedNotificationID(1))
+	second := strings.Index(body, "id: "+missedNotificationID(2))
+	assert.True(first >= 0 && second > first, "the missed notifications weren't sent in order: %s", body)
+	assert.Equal(2, strings.Count(body, "event: notification\n"))
+	assert.Equal(2, strings.Count(body, `"total":3`))
+}
+
+func TestStreamMessagesSendsAReloadEventForLongBacklogs(t *testing.T) {
+	assert := assert.New(t)
+
+	database, mock, err := sqlmock.New()
+	assert.NoError(err, "unable to open the mock database connection")
+	defer func() { _ = database.Close() }()
+
+	lastTimestamp := time.Date(2026, time.October, 14, 3, 0, 0, 0, time.UTC)
+	expectMissedNotifications(mock, lastTimestamp, missedNotificationsLimit+1)
+
+	a := &API{Echo: echo.New(), DB: database, UserSuffix: common.NewUserSuffix("example.org")}
+	rec := streamMessages(t, a, lastEventID)
+
+	assert.NoError(mock.ExpectationsWereMet(), "not all mock expectations were met")
+	assert.Equal("event: reload\ndata: {\"total\":3}\n\n", rec.Body.String())
+}
+
+func TestStreamMessagesStartsFreshForUnknownEventIDs(t *testing.T) {
+	assert := assert.New(t)
+
+	database, mock, err := sqlmock.New()
+	assert.NoError(err, "unable to open the mock database connection")
+	defer func() { _ = database.Close() }()
+
+	// Nothing is listed for a notification that doesn't exist.
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT time_created FROM notifications`).
+		WithArgs(lastEventID, lastEventID).
+		WillReturnRows(sqlmock.NewRows([]string{"time_created"}))
+	mock.ExpectRollback()
+
+	a := &API{Echo: echo.New(), DB: database, UserSuffix: common.NewUserSuffix("example.org")}
+	rec := streamMessages(t, a, lastEventID)
+
+	assert.NoError(mock.ExpectationsWereMet(), "not all mock expectations were met")
+	assert.Equal(http.StatusOK, rec.Code)
+	assert.Empty(rec.Body.String())
+}
+
+func TestStreamMessagesRejectsInvalidRequests(t *testing.T) {
+	tests := []struct {
+		name        string
+		target      string
+		lastEventID string
+	}{
+		{name: "a missing user", target: "/v2/messages/stream"},
+		{name: "an invalid Last-Event-ID header", target: "/v2/messages/stream?user=sarahr", lastEventID: "not-a-uuid"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// No database is needed, because the request is rejected before anything is looked up.
+			a := &API{Echo: echo.New(), Stream: stream.NewHub()}
+
+			req := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			if tt.lastEventID != "" {
+				req.Header.Set("Last-Event-ID", tt.lastEventID)
+			}
+			rec := httptest.NewRecorder()
+
+			assert.NoError(t, a.StreamMessagesHandler(a.Echo.NewContext(req, rec)))
+			assert.Equal(t, http.StatusBadRequest, rec.Code)
+		})
+	}
+}
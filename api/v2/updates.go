@@ -9,6 +9,7 @@ import (
 
 	"github.com/cyverse-de/notifications/db"
 	"github.com/cyverse-de/notifications/model"
+	"github.com/cyverse-de/notifications/publisher"
 	"github.com/cyverse-de/notifications/query"
 	"github.com/labstack/echo/v4"
 )
@@ -91,6 +92,13 @@ func (a API) updateMultipleMessages(
 		return err
 	}
 
+	// Count the notifications that the user still hasn't seen.
+	total, err := db.CountAndAnnounceUnseen(ctx, tx, user)
+	if err != nil {
+		a.Echo.Logger.Error(err)
+		return err
+	}
+
 	// Commit the transaction.
 	err = tx.Commit()
 	if err != nil {
@@ -98,6 +106,11 @@ func (a API) updateMultipleMessages(
 		return err
 	}
 
+	// Let the user's other sessions know about the new count.
+	if err := publisher.PublishUnseenCount(ctx, a.AMQPClient, user, total); err != nil {
+		a.Echo.Logger.Error(err)
+	}
+
 	return nil
 }
 
@@ -162,6 +175,13 @@ func (a *API) updateSingleMessage(
 		return c.JSON(http.StatusNotFound, model.NotFound(notificationDesc))
 	}
 
+	// Count the notifications that the user still hasn't seen.
+	total, err := db.CountAndAnnounceUnseen(ctx, tx, user)
+	if err != nil {
+		a.Echo.Logger.Error(err)
+		return err
+	}
+
 	// Commit the transaction.
 	err = tx.Commit()
 	if err != nil {
@@ -169,6 +189,11 @@ func (a *API) updateSingleMessage(
 		return err
 	}
 
+	// Let the user's other sessions know about the new count.
+	if err := publisher.PublishUnseenCount(ctx, a.AMQPClient, user, total); err != nil {
+		a.Echo.Logger.Error(err)
+	}
+
 	return nil
 }
 
@@ -196,6 +221,30 @@ func (a *API) MarkMultipleMessagesSeenHandler(c echo.Context) error {
 	})
 }
 
+// MarkMultipleMessagesUnseenHandler updates multiple messages in the database to indicate that the user hasn't seen
+// them yet, so that the user can come back to them later.
+func (a *API) MarkMultipleMessagesUnseenHandler(c echo.Context) error {
+	return a.updateMultipleMessages(c, func(ctx context.Context, tx *sql.Tx, userID string, body *model.MultipleMessageUpdateRequest) error {
+		var err error
+
+		if body.AllNotifications {
+			_, err = db.MarkAllMessagesAsUnseen(ctx, tx, userID)
+			if err != nil {
+				a.Echo.Logger.Error(err)
+				return err
+			}
+		} else {
+			_, err = db.MarkMessagesAsUnseen(ctx, tx, userID, body.IDs)
+			if err != nil {
+				a.Echo.Logger.Error(err)
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
 // DeleteMultipleMessagesHandler marks multiple messages in the database as deleted.
 func (a *API) DeleteMultipleMessagesHandler(c echo.Context) error {
 	return a.updateMultipleMessages(c, func(ctx context.Context, tx *sql.Tx, userID string, body *model.MultipleMessageUpdateRequest) error {
@@ -219,12 +268,45 @@ func (a *API) DeleteMultipleMessagesHandler(c echo.Context) error {
 	})
 }
 
+// RestoreMultipleMessagesHandler marks multiple deleted messages in the database as no longer deleted.
+func (a *API) RestoreMultipleMessagesHandler(c echo.Context) error {
+	return a.updateMultipleMessages(c, func(ctx context.Context, tx *sql.Tx, userID string, body *model.MultipleMessageUpdateRequest) error {
+		var err error
+
+		if body.AllNotifications {
+			_, err = db.RestoreAllMessages(ctx, tx, userID)
+			if err != nil {
+				a.Echo.Logger.Error(err)
+				return err
+			}
+		} else {
+			_, err = db.RestoreMessages(ctx, tx, userID, body.IDs)
+			if err != nil {
+				a.Echo.Logger.Error(err)
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
 // MarkMessageSeenHandler updates a message in the database to indicate that the user has already seen it.
 func (a *API) MarkMessageSeenHandler(ctx echo.Context) error {
 	return a.updateSingleMessage(ctx, db.MarkMessageAsSeen)
 }
 
+// MarkMessageUnseenHandler updates a message in the database to indicate that the user hasn't seen it yet.
+func (a *API) MarkMessageUnseenHandler(ctx echo.Context) error {
+	return a.updateSingleMessage(ctx, db.MarkMessageAsUnseen)
+}
+
 // DeleteMessageHandler updates a message in the database to indicate that it has been deleted.
 func (a *API) DeleteMessageHandler(ctx echo.Context) error {
 	return a.updateSingleMessage(ctx, db.DeleteMessage)
 }
+
+// RestoreMessageHandler updates a deleted message in the database to indicate that it's no longer deleted.
+func (a *API) RestoreMessageHandler(ctx echo.Context) error {
+	return a.updateSingleMessage(ctx, db.RestoreMessage)
+}
@@ -0,0 +1,183 @@
+package v2
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/cyverse-de/notifications/common"
+	"github.com/go-playground/validator/v10"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestUpdatesForUnknownUsers verifies that updates for users who aren't in the database are rejected without adding
+// the users to the database.
+func TestUpdatesForUnknownUsers(t *testing.T) {
+	const notificationID = "1e1e8a24-fbbc-4dd1-a5a4-e0dcb4a4b5c9"
+
+	tests := []struct {
+		name    string
+		handler func(*API) func(echo.Context) error
+		target  string
+		body    string
+		params  []string
+	}{
+		{
+			name:    "mark multiple messages as seen",
+			handler: func(a *API) func(echo.Context) error { return a.MarkMultipleMessagesSeenHandler },
+			target:  "/v2/messages/seen?user=sarahr",
+			body:    `{"all_notifications": true}`,
+		},
+		{
+			name:    "mark a single message as seen",
+			handler: func(a *API) func(echo.Context) error { return a.MarkMessageSeenHandler },
+			target:  "/v2/messages/" + notificationID + "/seen?user=sarahr",
+			params:  []string{notificationID},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			database, mock, err := sqlmock.New()
+			assert.NoError(err, "unable to open the mock database connection")
+			defer func() { _ = database.Close() }()
+
+			// Only the lookup runs; nothing is inserted.
+			mock.ExpectBegin()
+			mock.ExpectQuery("SELECT id FROM users WHERE username = \\$1").
+				WithArgs("sarahr@example.org").
+				WillReturnRows(sqlmock.NewRows([]string{"id"}))
+			mock.ExpectRollback()
+
+			e := echo.New()
+			e.Validator = testValidator{validator: validator.New()}
+			a := &API{Echo: e, DB: database, UserSuffix: common.NewUserSuffix("example.org")}
+
+			req := httptest.NewRequest(http.MethodPost, tt.target, strings.NewReader(tt.body))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			if tt.params != nil {
+				c.SetParamNames("id")
+				c.SetParamValues(tt.params...)
+			}
+
+			assert.NoError(tt.handler(a)(c))
+			assert.Equal(http.StatusNotFound, rec.Code)
+			assert.NoError(mock.ExpectationsWereMet(), "not all mock expectations were met")
+		})
+	}
+}
+
+// expectUnseenUpdate sets up the statements that mark notifications as unseen and count the user's unseen
+// notifications. The commit fails with the given error if there is one.
+func expectUnseenUpdate(mock sqlmock.Sqlmock, userID string, commitErr error) {
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id FROM users WHERE username = \\$1").
+		WithArgs("sarahr@example.org").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(userID))
+	mock.ExpectExec("UPDATE notifications SET seen = \\$1, seen_at = \\$2").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO broadcast_states").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT \\(SELECT count\\(\\*\\)").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(4))
+	mock.ExpectExec("SELECT pg_notify").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit().WillReturnError(commitErr)
+}
+
+// TestMarkMessagesUnseenPublishesTheUnseenCount verifies that the updated unseen count is published to the DE UI once
+// notifications are marked as unseen, and only if the transaction commits.
+func TestMarkMessagesUnseenPublishesTheUnseenCount(t *testing.T) {
+	const (
+		notificationID = "1e1e8a24-fbbc-4dd1-a5a4-e0dcb4a4b5c9"
+		userID         = "8f3a6c1e-3cbc-11eb-8a0b-f64e9b87c109"
+	)
+
+	requests := []struct {
+		name    string
+		handler func(*API) func(echo.Context) error
+		target  string
+		body    string
+		params  []string
+	}{
+		{
+			name:    "mark multiple messages as unseen",
+			handler: func(a *API) func(echo.Context) error { return a.MarkMultipleMessagesUnseenHandler },
+			target:  "/v2/messages/unseen?user=sarahr",
+			body:    `{"all_notifications": true}`,
+		},
+		{
+			name:    "mark a single message as unseen",
+			handler: func(a *API) func(echo.Context) error { return a.MarkMessageUnseenHandler },
+			target:  "/v2/messages/" + notificationID + "/unseen?user=sarahr",
+			params:  []string{notificationID},
+		},
+	}
+
+	outcomes := []struct {
+		name        string
+		commitErr   error
+		routingKeys []string
+		bodies      []string
+	}{
+		{
+			name:        "the transaction commits",
+			routingKeys: []string{"notification.sarahr"},
+			bodies:      []string{`{"type": "unseen_count", "total": 4}`},
+		},
+		{
+			name:      "the transaction fails to commit",
+			commitErr: errors.New("connection reset"),
+		},
+	}
+
+	for _, tt := range requests {
+		for _, outcome := range outcomes {
+			t.Run(tt.name+" when "+outcome.name, func(t *testing.T) {
+				assert := assert.New(t)
+
+				database, mock, err := sqlmock.New()
+				assert.NoError(err, "unable to open the mock database connection")
+				defer func() { _ = database.Close() }()
+
+				expectUnseenUpdate(mock, userID, outcome.commitErr)
+
+				e := echo.New()
+				e.Validator = testValidator{validator: validator.New()}
+				client := &mockMessagingClient{}
+				a := &API{Echo: e, DB: database, UserSuffix: common.NewUserSuffix("example.org"), AMQPClient: client}
+
+				req := httptest.NewRequest(http.MethodPost, tt.target, strings.NewReader(tt.body))
+				req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+				rec := httptest.NewRecorder()
+				c := e.NewContext(req, rec)
+				if tt.params != nil {
+					c.SetParamNames("id")
+					c.SetParamValues(tt.params...)
+				}
+
+				err = tt.handler(a)(c)
+				if outcome.commitErr != nil {
+					assert.Error(err)
+				} else {
+					assert.NoError(err)
+					assert.Equal(http.StatusOK, rec.Code)
+				}
+				assert.NoError(mock.ExpectationsWereMet(), "not all mock expectations were met")
+
+				// Nothing is published unless the transaction commits.
+				assert.Equal(outcome.routingKeys, client.routingKeys)
+				if assert.Len(client.bodies, len(outcome.bodies)) {
+					for i, body := range outcome.bodies {
+						assert.JSONEq(body, client.bodies[i])
+					}
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package v3
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/cyverse-de/notifications/db"
+	"github.com/cyverse-de/notifications/model"
+	"github.com/cyverse-de/notifications/query"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	// defaultLimit is the number of messages on a page if the caller doesn't say otherwise.
+	defaultLimit = 50
+
+	// maxLimit is the largest number of messages that can be requested on a single page.
+	maxLimit = 1000
+)
+
+// GetMessagesHandler handles requests for listing notification messages one page at a time.
+func (a *API) GetMessagesHandler(c echo.Context) error {
+	var err error
+	ctx := c.Request().Context()
+
+	// Extract and validate the user query parameter.
+	user, err := query.ValidatedQueryParam(c, "user", "required")
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, model.ErrorResponse{
+			Message: "missing required query parameter: user",
+		})
+	}
+	user = a.UserSuffix.Qualify(user)
+
+	// Extract and validate the limit query parameter.
+	defaultLimitValue := uint64(defaultLimit)
+	limit, err := query.ValidateUIntQueryParam(c, "limit", &defaultLimitValue)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, model.ErrorResponse{
+			Message: err.Error(),
+		})
+	}
+	if limit == 0 || limit > maxLimit {
+		return c.JSON(http.StatusBadRequest, model.ErrorResponse{
+			Message: fmt.Sprintf("invalid query parameter: limit must be between 1 and %d", maxLimit),
+		})
+	}
+
+	// Extract and validate the seen query parameter.
+	defaultSeenValue := false
+	seen, err := query.ValidateBooleanQueryParam(c, "seen", &defaultSeenValue)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, model.ErrorResponse{
+			Message: err.Error(),
+		})
+	}
+
+	// Extract and validate the sort-dir query parameter.
+	defaultSortOrder := query.SortOrderDescending
+	sortOrderParam := query.NewSortOrderParam(&defaultSortOrder)
+	err = query.ValidateParseableParam(c, "sort-dir", sortOrderParam)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, model.ErrorResponse{
+			Message: err.Error(),
+		})
+	}
+
+	// Extract and validate the cursor query parameter.
+	var cursor *query.Cursor
+	if encodedCursor := c.QueryParam("cursor"); encodedCursor != "" {
+		cursor, err = a.Cursors.Decode(encodedCursor)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, model.ErrorResponse{
+				Message: "invalid query parameter: cursor",
+			})
+		}
+	}
+
+	// Extract and validate the created-after query parameter.
+	createdAfterParam := query.NewTimestampParam(nil)
+	err = query.ValidateParseableParam(c, "created-after", createdAfterParam)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, model.ErrorResponse{
+			Message: err.Error(),
+		})
+	}
+
+	// Extract and validate the created-before query parameter.
+	createdBeforeParam := query.NewTimestampParam(nil)
+	err = query.ValidateParseableParam(c, "created-before", createdBeforeParam)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, model.ErrorResponse{
+			Message: err.Error(),
+		})
+	}
+
+	// Extract the type query parameter, which may be repeated to list messages of more than one type.
+	notificationTypes := make([]string, 0)
+	for _, notificationType := range c.QueryParams()["type"] {
+		if notificationType != "" {
+			notificationTypes = append(notificationTypes, notificationType)
+		}
+	}
+
+	// Begin a database transaction
+	tx, err := a.DB.Begin()
+	if err != nil {
+		a.Echo.Logger.Error(err)
+		return err
+	}
+	defer func() {
+		err = tx.Rollback()
+	}()
+
+	// Obtain the listing.
+	params := &db.V3NotificationListingParameters{
+		V2NotificationListingParameters: db.V2NotificationListingParameters{
+			User:              user,
+			Limit:             limit,
+			Seen:              seen,
+			SortOrder:         *(sortOrderParam.GetValue().(*query.SortOrder)),
+			SubjectSearch:     c.QueryParam("subject-search"),
+			Search:            c.QueryParam("q"),
+			NotificationTypes: notificationTypes,
+			CreatedAfter:      createdAfterParam.GetValue().(*time.Time),
+			CreatedBefore:     createdBeforeParam.GetValue().(*time.Time),
+		},
+		Cursor: cursor,
+	}
+	page, err := db.V3ListNotifications(ctx, tx, params)
+	if err != nil {
+		a.Echo.Logger.Error(err)
+		return err
+	}
+
+	// Encode the cursor for the next page if there is one.
+	listing := &model.V3NotificationListing{Messages: page.Messages}
+	if page.Next != nil {
+		listing.NextCursor, err = a.Cursors.Encode(page.Next)
+		if err != nil {
+			a.Echo.Logger.Error(err)
+			return err
+		}
+	}
+
+	return c.JSON(http.StatusOK, listing)
+}
@@ -0,0 +1,170 @@
+package v3
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/cyverse-de/notifications/common"
+	"github.com/cyverse-de/notifications/model"
+	"github.com/cyverse-de/notifications/query"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+var listingColumns = []string{"type", "seen", "deleted", "message", "id", "time_created", "seen_at", "deleted_at"}
+
+func newListingAPI(database *sql.DB) *API {
+	return &API{
+		Echo:       echo.New(),
+		DB:         database,
+		UserSuffix: common.NewUserSuffix("example.org"),
+		Cursors:    query.NewCursorCodec([]byte("test cursor secret")),
+	}
+}
+
+// getMessages calls the listing handler with the given query string and returns the response.
+func getMessages(t *testing.T, a *API, rawQuery string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodGet, "/v3/messages?"+rawQuery, nil)
+	rec := httptest.NewRecorder()
+	assert.NoError(t, a.GetMessagesHandler(a.Echo.NewContext(req, rec)))
+	return rec
+}
+
+func TestGetMessagesPagesWithCursors(t *testing.T) {
+	assert := assert.New(t)
+
+	database, mock, err := sqlmock.New()
+	assert.NoError(err, "unable to open the mock database connection")
+	defer func() { _ = database.Close() }()
+
+	const user = "sarahr@example.org"
+	newest := time.Date(2026, time.October, 14, 3, 0, 0, 0, time.UTC)
+	ids := []string{
+		"14d2d5b4-3cbc-11eb-8a0b-f64e9b87c109",
+		"1c5e9a3e-3cbc-11eb-8a0b-f64e9b87c109",
+		"24a6e5d2-3cbc-11eb-8a0b-f64e9b87c109",
+	}
+	row := func(rows *sqlmock.Rows, i int) *sqlmock.Rows {
+		message := `{"message": {"id": "` + ids[i] + `"}}`
+		return rows.AddRow("analysis", false, false, []byte(message), ids[i], newest.Add(-time.Duration(i)*time.Hour), nil, nil)
+	}
+
+	// The first page fetches one row more than the limit, newest first by default.
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(
+		"WHERE n.deleted = $2 AND n.seen = $3 ORDER BY n.time_created DESC, n.id DESC LIMIT 3 )",
+	)).
+		WithArgs(user, false, false, user, user, false, false).
+		WillReturnRows(row(row(row(sqlmock.NewRows(listingColumns), 0), 1), 2))
+	mock.ExpectRollback()
+
+	a := newListingAPI(database)
+	rec := getMessages(t, a, "user=sarahr&limit=2")
+	assert.Equal(http.StatusOK, rec.Code)
+
+	var page model.V3NotificationListing
+	assert.NoError(json.Unmarshal(rec.Body.Bytes(), &page))
+	if assert.Len(page.Messages, 2) {
+		assert.Equal(ids[0], page.Messages[0].Message["id"])
+		assert.Equal(ids[1], page.Messages[1].Message["id"])
+	}
+
+	// The cursor points to the last notification on the page.
+	cursor, err := a.Cursors.Decode(page.NextCursor)
+	if assert.NoError(err) {
+		assert.Equal(ids[1], cursor.ID)
+		assert.True(newest.Add(-time.Hour).Equal(cursor.TimeCreated))
+		assert.Equal(query.SortOrderDescending, cursor.SortOrder)
+	}
+
+	// The next page starts after the cursor, and it's the last page.
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(
+		"WHERE n.deleted = $2 AND n.seen = $3 AND (n.time_created, n.id) < ($4, $5) "+
+			"ORDER BY n.time_created DESC, n.id DESC LIMIT 3 )",
+	)).
+		WithArgs(user, false, false, cursor.TimeCreated, ids[1], user, user, false, false, cursor.TimeCreated, ids[1]).
+		WillReturnRows(row(sqlmock.NewRows(listingColumns), 2))
+	mock.ExpectRollback()
+
+	rec = getMessages(t, a, "user=sarahr&limit=2&cursor="+page.NextCursor)
+	assert.Equal(http.StatusOK, rec.Code)
+
+	page = model.V3NotificationListing{}
+	assert.NoError(json.Unmarshal(rec.Body.Bytes(), &page))
+	if assert.Len(page.Messages, 1) {
+		assert.Equal(ids[2], page.Messages[0].Message["id"])
+	}
+	assert.Empty(page.NextCursor)
+	assert.NoError(mock.ExpectationsWereMet(), "not all mock expectations were met")
+}
+
+func TestGetMessagesAppliesFilters(t *testing.T) {
+	assert := assert.New(t)
+
+	database, mock, err := sqlmock.New()
+	assert.NoError(err, "unable to open the mock database connection")
+	defer func() { _ = database.Close() }()
+
+	const user = "sarahr@example.org"
+	after := time.Date(2026, time.October, 1, 0, 0, 0, 0, time.UTC)
+	before := time.Date(2026, time.October, 14, 0, 0, 0, 0, time.UTC)
+
+	// Seen notifications are included, and the types, creation times, and sort order are applied.
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(
+		"WHERE n.deleted = $2 AND nt.name IN ($3,$4) AND n.time_created > $5 AND n.time_created < $6 "+
+			"ORDER BY n.time_created ASC, n.id ASC LIMIT 51 )",
+	)).
+		WithArgs(user, false, "analysis", "data", after, before, user, user, false, "analysis", "data", after, before).
+		WillReturnRows(sqlmock.NewRows(listingColumns))
+	mock.ExpectRollback()
+
+	rec := getMessages(t, newListingAPI(database),
+		"user=sarahr&seen=true&sort-dir=asc&type=analysis&type=Data"+
+			"&created-after=2026-10-01T00:00:00Z&created-before=2026-10-14T00:00:00Z",
+	)
+	assert.Equal(http.StatusOK, rec.Code)
+	assert.JSONEq(`{"messages": []}`, rec.Body.String())
+	assert.NoError(mock.ExpectationsWereMet(), "not all mock expectations were met")
+}
+
+func TestGetMessagesRejectsInvalidRequests(t *testing.T) {
+	// A cursor signed with a different key can't be used.
+	otherCursor, err := query.NewCursorCodec([]byte("some other secret")).Encode(&query.Cursor{
+		TimeCreated: time.Date(2026, time.October, 14, 3, 0, 0, 0, time.UTC),
+		ID:          "14d2d5b4-3cbc-11eb-8a0b-f64e9b87c109",
+		SortOrder:   query.SortOrderDescending,
+	})
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		rawQuery string
+	}{
+		{name: "a missing user", rawQuery: "limit=10"},
+		{name: "a zero limit", rawQuery: "user=sarahr&limit=0"},
+		{name: "a limit that's too large", rawQuery: "user=sarahr&limit=1001"},
+		{name: "a limit that isn't a number", rawQuery: "user=sarahr&limit=ten"},
+		{name: "an invalid sort direction", rawQuery: "user=sarahr&sort-dir=sideways"},
+		{name: "an invalid creation time", rawQuery: "user=sarahr&created-after=yesterday"},
+		{name: "a cursor that isn't a cursor", rawQuery: "user=sarahr&cursor=not-a-cursor"},
+		{name: "a cursor signed with a different key", rawQuery: "user=sarahr&cursor=" + otherCursor},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// No database is needed, because the request is rejected before the listing is looked up.
+			rec := getMessages(t, newListingAPI(nil), tt.rawQuery)
+			assert.Equal(t, http.StatusBadRequest, rec.Code)
+		})
+	}
+}
@@ -0,0 +1,41 @@
+package v3
+
+import (
+	"database/sql"
+	"net/http"
+
+	"github.com/cyverse-de/notifications/common"
+	"github.com/cyverse-de/notifications/model"
+	"github.com/cyverse-de/notifications/query"
+	"github.com/labstack/echo/v4"
+)
+
+// API defines version 3 of the REST API for the notifications service.
+type API struct {
+	Echo       *echo.Echo
+	Group      *echo.Group
+	DB         *sql.DB
+	UserSuffix common.UserSuffix
+	Cursors    *query.CursorCodec
+	Service    string
+	Title      string
+	Version    string
+}
+
+// RootHandler handles GET requests to the /v3/ endpoint.
+func (a API) RootHandler(ctx echo.Context) error {
+	resp := model.VersionRootResponse{
+		Service:    a.Service,
+		Title:      a.Title,
+		Version:    a.Version,
+		APIVersion: "v3",
+	}
+	return ctx.JSON(http.StatusOK, resp)
+}
+
+// RegisterHandlers registers the supported request handlers.
+func (a API) RegisterHandlers() {
+	a.Group.GET("", a.RootHandler)
+	a.Group.GET("/", a.RootHandler)
+	a.Group.GET("/messages", a.GetMessagesHandler)
+}
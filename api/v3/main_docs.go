@@ -0,0 +1,126 @@
+// Package v3 DE Notifications API Version 3
+//
+// Documentation of the DE Notifications API Version 3
+//
+//     Schemes: http
+//     BasePath: /v3
+//     Version: 1.0.0
+//
+//     Consumes:
+//     - application/json
+//
+//     Produces:
+//     - application/json
+//
+// swagger:meta
+package v3
+
+import "github.com/cyverse-de/notifications/model"
+
+// swagger:route GET /v3 v3 getRoot
+//
+// Information About API Version 3
+//
+// Lists general information about API version 3.
+//
+// responses:
+//   200: v3RootResponse
+
+// Information About API Version 3
+// swagger:response v3RootResponse
+type rootResponseWrapper struct {
+	// in:body
+	Body model.VersionRootResponse
+}
+
+// swagger:route GET /v3/messages v3 listMessagesV3
+//
+// List Notification Messages
+//
+// This endpoint lists notifications that match the criteria specified in the query parameters, one page at a time.
+// Notifications are always sorted by timestamp then by ID.
+//
+// The response includes a `next_cursor` field if there are more matching messages. Passing its value in the `cursor`
+// query parameter returns the next page. Cursors are opaque and signed, so they can't be constructed or modified by
+// clients. The cursor remembers the sort direction, but the other query parameters have to be sent again with every
+// request.
+//
+// responses:
+//   200: v3NotificationListing
+//   400: errorResponse
+//   500: errorResponse
+
+// Parameters for the /v3/messages endpoint.
+// swagger:parameters listMessagesV3
+type notificationListingParametersV3 struct {
+
+	// The username of the person to list notifications for.
+	//
+	// in:query
+	// required: true
+	User string `json:"user"`
+
+	// The maximum number of results to return on a page.
+	//
+	// in:query
+	// minimum: 1
+	// maximum: 1000
+	// default: 50
+	Limit uint64 `json:"limit"`
+
+	// The cursor from the `next_cursor` field of the previous page. The first page is returned if this parameter
+	// isn't specified.
+	//
+	// in:query
+	Cursor string `json:"cursor"`
+
+	// If true, messages that have been seen before will be included in the response.
+	//
+	// in:query
+	// default: false
+	Seen bool `json:"seen"`
+
+	// The direction to use when sorting results. This parameter is ignored if a cursor is specified.
+	//
+	// in:query
+	// enum: asc,desc
+	// default: desc
+	SortDir string `json:"sort-dir"`
+
+	// If specified, only messages with subjects containing the search string will be returned.
+	//
+	// in:query
+	SubjectSearch string `json:"subject-search"`
+
+	// If specified, only messages matching the full-text search will be returned. The search syntax is the same as
+	// in version 2 of the API. Each result includes its search rank, but the rank is informational only: results are
+	// still sorted by time, because the cursors encode a position in time.
+	//
+	// in:query
+	Search string `json:"q"`
+
+	// If specified, only messages of the given type will be returned. This parameter may be repeated to list messages
+	// of several types at once.
+	//
+	// in:query
+	// collection format: multi
+	MessageType []string `json:"type"`
+
+	// If specified, only messages created after the given RFC 3339 timestamp will be returned.
+	//
+	// in:query
+	CreatedAfter string `json:"created-after"`
+
+	// If specified, only messages created before the given RFC 3339 timestamp will be returned.
+	//
+	// in:query
+	CreatedBefore string `json:"created-before"`
+}
+
+// Notification Listing
+// swagger:response v3NotificationListing
+type notificationListingV3 struct {
+	// in:body
+	Body model.V3NotificationListing
+}
+
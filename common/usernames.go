@@ -28,3 +28,13 @@ func (s UserSuffix) Qualify(username string) string {
 	}
 	return username + string(s)
 }
+
+// BareUsername converts a username to the form that callers send and that the DE UI subscribes to
+// notifications with. It's the inverse of Qualify, and is just as tolerant of a username that's
+// already in the requested form.
+func BareUsername(username string) string {
+	if i := strings.IndexByte(username, '@'); i >= 0 {
+		return username[:i]
+	}
+	return username
+}
@@ -90,3 +90,13 @@ func TestNewUserSuffixNormalizesTheDomain(t *testing.T) {
 		}
 	}
 }
+
+func TestBareUsername(t *testing.T) {
+	suffix := NewUserSuffix("iplantcollaborative.org")
+
+	for _, username := range []string{"jdoe", "jdoe@iplantcollaborative.org", suffix.Qualify("jdoe")} {
+		if actual := BareUsername(username); actual != "jdoe" {
+			t.Errorf("BareUsername(%q) = %q, want \"jdoe\"", username, actual)
+		}
+	}
+}
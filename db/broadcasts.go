@@ -0,0 +1,387 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/cyverse-de/notifications/model"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+
+	sq "github.com/Masterminds/squirrel"
+)
+
+// userNotificationSources returns a query for each kind of notification that a user can see, for use as the `n` table
+// in listings and counts: the notifications addressed to the user, and the broadcasts that include the user along with
+// the user's seen and deleted state for each of them. Both queries have the same columns, so the filters that apply to
+// one apply to the other.
+func userNotificationSources(user string) (sq.SelectBuilder, sq.SelectBuilder) {
+
+	// The notifications addressed to the user.
+	addressed := sq.Select().
+		Column("un.id").
+		Column("un.notification_type_id").
+		Column("un.subject").
+		Column("un.seen").
+		Column("un.seen_at").
+		Column("un.deleted").
+		Column("un.deleted_at").
+		Column("un.time_created").
+		Column("un.outgoing_json").
+		Column("un.search_vector").
+		From("notifications un").
+		Join("users u ON un.user_id = u.id").
+		Where(sq.Eq{"u.username": user})
+
+	// The broadcasts that include the user. A broadcast that the user hasn't done anything with yet has no state row,
+	// and is neither seen nor deleted.
+	broadcasts := sq.Select().
+		Column("b.id").
+		Column("b.notification_type_id").
+		Column("b.subject").
+		Column("COALESCE(s.seen, false) AS seen").
+		Column("s.seen_at").
+		Column("COALESCE(s.deleted, false) AS deleted").
+		Column("s.deleted_at").
+		Column("b.time_created").
+		Column("b.outgoing_json").
+		Column("b.search_vector").
+		From("broadcasts b").
+		LeftJoin("users bu ON bu.username = ?", user).
+		LeftJoin("broadcast_states s ON s.broadcast_id = b.id AND s.user_id = bu.id").
+		Where(sq.Expr("(b.audience = 'all' OR b.usernames @> ARRAY[?]::text[])", user))
+
+	return addressed, broadcasts
+}
+
+// userNotificationQuery adds the columns, joins, and conditions of a listing or a count to a query whose `n` table
+// contains one kind of notification that a user can see.
+type userNotificationQuery func(sq.SelectBuilder) (sq.SelectBuilder, error)
+
+// selectUserNotifications builds a query that lists the notifications that a user can see, without any columns; the
+// caller selects them from the `n` table. The query that build returns is run against each kind of notification on
+// its own, sorted by orderBy and limited to limit+offset rows. That way the notifications addressed to the user are
+// read through the index on their user, deletion flag, and creation time exactly as they would be if there were no
+// broadcasts, and no more than limit+offset rows of each kind are merged. The merged rows are sorted by orderBy again,
+// and the limit and offset are applied to them. The columns in orderBy have to be selected by build. A limit of zero
+// means that the listing isn't limited.
+func selectUserNotifications(
+	user string,
+	build userNotificationQuery,
+	orderBy []string,
+	limit uint64,
+	offset uint64,
+) (sq.SelectBuilder, error) {
+	addressedSource, broadcastSource := userNotificationSources(user)
+
+	// Build the query for each kind of notification.
+	addressed, err := build(sq.Select().FromSelect(addressedSource, "n"))
+	if err != nil {
+		return sq.SelectBuilder{}, err
+	}
+	broadcasts, err := build(sq.Select().FromSelect(broadcastSource, "n"))
+	if err != nil {
+		return sq.SelectBuilder{}, err
+	}
+	if limit > 0 {
+		addressed = addressed.OrderBy(orderBy...).Limit(limit + offset)
+		broadcasts = broadcasts.OrderBy(orderBy...).Limit(limit + offset)
+	}
+
+	// Merge the results.
+	union := addressed.Prefix("(").SuffixExpr(sq.ConcatExpr(") UNION ALL (", broadcasts, ")"))
+	queryBuilder := psql.Select().FromSelect(union, "n").OrderBy(orderBy...)
+	if limit > 0 {
+		queryBuilder = queryBuilder.Limit(limit)
+	}
+	if offset > 0 {
+		queryBuilder = queryBuilder.Offset(offset)
+	}
+
+	return queryBuilder, nil
+}
+
+// countUserNotifications builds a query that counts the notifications that a user can see and that match the
+// conditions that build adds. Each kind of notification is counted on its own, so that the notifications addressed to
+// the user are counted through the indexes on their table.
+func countUserNotifications(user string, build userNotificationQuery) (sq.SelectBuilder, error) {
+	addressedSource, broadcastSource := userNotificationSources(user)
+
+	// Build the count for each kind of notification.
+	addressed, err := build(sq.Select("count(*)").FromSelect(addressedSource, "n"))
+	if err != nil {
+		return sq.SelectBuilder{}, err
+	}
+	broadcasts, err := build(sq.Select("count(*)").FromSelect(broadcastSource, "n"))
+	if err != nil {
+		return sq.SelectBuilder{}, err
+	}
+
+	return psql.Select().Column(sq.Expr("(?) + (?) AS count", addressed, broadcasts)), nil
+}
+
+// broadcastVisibleToUser matches the broadcasts that include the user in the `u` table.
+const broadcastVisibleToUser = "(b.audience = 'all' OR b.usernames @> ARRAY[u.username])"
+
+// Broadcast describes a broadcast notification as it's stored in the database.
+type Broadcast struct {
+	ID               string
+	NotificationType string
+	Subject          string
+	Audience         string
+	Group            string
+	Usernames        []string
+	TimeCreated      time.Time
+}
+
+// SaveBroadcast saves a broadcast notification, filling in its ID and creation time. The outgoing notification JSON
+// refers to the ID, so it's added afterward with SaveBroadcastMessage.
+func SaveBroadcast(ctx context.Context, tx *sql.Tx, broadcast *Broadcast) error {
+	wrapMsg := "unable to save the broadcast notification"
+
+	// Get the notification type ID.
+	notificationTypeID, err := RequireNotificationTypeID(ctx, tx, broadcast.NotificationType)
+	if err != nil {
+		return errors.Wrap(err, wrapMsg)
+	}
+
+	// The group name and usernames are stored as NULL when they don't apply.
+	var group *string
+	if broadcast.Group != "" {
+		group = &broadcast.Group
+	}
+	var usernames any
+	if broadcast.Usernames != nil {
+		usernames = pq.Array(broadcast.Usernames)
+	}
+
+	// Build the statement.
+	statement, args, err := psql.Insert("broadcasts").
+		Columns("notification_type_id", "subject", "audience", "group_name", "usernames", "outgoing_json").
+		Values(notificationTypeID, broadcast.Subject, broadcast.Audience, group, usernames, "{}").
+		Suffix("RETURNING id, time_created").
+		ToSql()
+	if err != nil {
+		return errors.Wrap(err, wrapMsg)
+	}
+
+	// Execute the statement, scanning the ID and creation time into the broadcast.
+	err = tx.QueryRowContext(ctx, statement, args...).Scan(&broadcast.ID, &broadcast.TimeCreated)
+	if err != nil {
+		return errors.Wrap(err, wrapMsg)
+	}
+
+	return nil
+}
+
+// SaveBroadcastMessage adds the outgoing notification JSON to a broadcast notification.
+func SaveBroadcastMessage(ctx context.Context, tx *sql.Tx, id string, message *model.Notification) error {
+	wrapMsg := "unable to save the broadcast notification JSON"
+
+	// Marshal the outgoing notification.
+	outgoingJSON, err := json.Marshal(message)
+	if err != nil {
+		return errors.Wrap(err, wrapMsg)
+	}
+
+	// Build the statement.
+	statement, args, err := psql.Update("broadcasts").
+		Set("outgoing_json", outgoingJSON).
+		Where(sq.Eq{"id": id}).
+		ToSql()
+	if err != nil {
+		return errors.Wrap(err, wrapMsg)
+	}
+
+	// Execute the statement.
+	_, err = tx.ExecContext(ctx, statement, args...)
+	if err != nil {
+		return errors.Wrap(err, wrapMsg)
+	}
+
+	return nil
+}
+
+// ListBroadcasts lists every broadcast notification, most recent first.
+func ListBroadcasts(ctx context.Context, tx *sql.Tx) ([]*model.Broadcast, error) {
+	wrapMsg := "unable to list the broadcast notifications"
+
+	// Build the query.
+	query, args, err := psql.Select().
+		Column("b.id").
+		Column("b.audience").
+		Column("COALESCE(b.group_name, '')").
+		Column("b.usernames").
+		Column("b.time_created").
+		Column("nt.name AS type").
+		Column("b.outgoing_json").
+		From("broadcasts b").
+		Join("notification_types nt ON b.notification_type_id = nt.id").
+		OrderBy("b.time_created DESC", "b.id DESC").
+		ToSql()
+	if err != nil {
+		return nil, errors.Wrap(err, wrapMsg)
+	}
+
+	// Execute the query.
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, wrapMsg)
+	}
+	defer func() { _ = rows.Close() }()
+
+	// Build the listing from the result set.
+	broadcasts := make([]*model.Broadcast, 0)
+	for rows.Next() {
+		var broadcast model.Broadcast
+		var usernames pq.StringArray
+		var notificationType string
+		var messageText []byte
+
+		err = rows.Scan(
+			&broadcast.ID,
+			&broadcast.Audience,
+			&broadcast.Group,
+			&usernames,
+			&broadcast.TimeCreated,
+			&notificationType,
+			&messageText,
+		)
+		if err != nil {
+			return nil, errors.Wrap(err, wrapMsg)
+		}
+
+		broadcast.Users = usernames
+		broadcast.Notification, err = formatNotification(messageText, notificationType, false, false)
+		if err != nil {
+			return nil, errors.Wrap(err, wrapMsg)
+		}
+
+		broadcasts = append(broadcasts, &broadcast)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, errors.Wrap(err, wrapMsg)
+	}
+
+	return broadcasts, nil
+}
+
+// DeleteBroadcast permanently removes a broadcast notification, along with every user's state for it. The number of
+// broadcasts that were removed is returned.
+func DeleteBroadcast(ctx context.Context, tx *sql.Tx, id string) (int, error) {
+	wrapMsg := fmt.Sprintf("unable to delete broadcast notification %s", id)
+
+	// Build the statement.
+	statement, args, err := psql.Delete("broadcasts").
+		Where(sq.Eq{"id": id}).
+		ToSql()
+	if err != nil {
+		return 0, errors.Wrap(err, wrapMsg)
+	}
+
+	// Execute the statement.
+	result, err := tx.ExecContext(ctx, statement, args...)
+	if err != nil {
+		return 0, errors.Wrap(err, wrapMsg)
+	}
+
+	// Determine how many rows were affected.
+	count, err := result.RowsAffected()
+	if err != nil {
+		return 0, errors.Wrap(err, wrapMsg)
+	}
+
+	return int(count), nil
+}
+
+// broadcastStateFilter limits the broadcasts whose state setBroadcastFlag changes.
+type broadcastStateFilter struct {
+
+	// If specified, only the broadcasts with these IDs are changed. Otherwise, only the broadcasts whose flag doesn't
+	// have the new value yet are changed, so that the count of changed broadcasts is accurate.
+	IDs []string
+
+	// If specified, only the broadcasts that the user has or hasn't seen are changed.
+	Seen *bool
+
+	// If specified, only the broadcasts of this notification type are changed.
+	NotificationTypeID string
+}
+
+// setBroadcastFlag sets the seen or deleted flag in the user's state for the broadcasts that include the user and
+// match the filter, creating the state if necessary. The time the flag was first set is recorded the same way it is
+// for other notifications. The number of broadcasts that were changed is returned.
+func setBroadcastFlag(
+	ctx context.Context,
+	tx *sql.Tx,
+	userID string,
+	flag string,
+	value bool,
+	filter *broadcastStateFilter,
+) (int, error) {
+	wrapMsg := fmt.Sprintf("unable to update the %s flag of the broadcast notifications", flag)
+
+	// The time is only recorded when the flag is set, and only if it wasn't set already.
+	timeExpr := "NULL::timestamp with time zone"
+	onConflict := fmt.Sprintf(
+		"ON CONFLICT (broadcast_id, user_id) DO UPDATE SET %[1]s = EXCLUDED.%[1]s, %[1]s_at = NULL",
+		flag,
+	)
+	if value {
+		timeExpr = "now()"
+		onConflict = fmt.Sprintf(
+			"ON CONFLICT (broadcast_id, user_id) DO UPDATE SET %[1]s = EXCLUDED.%[1]s, "+
+				"%[1]s_at = COALESCE(broadcast_states.%[1]s_at, EXCLUDED.%[1]s_at)",
+			flag,
+		)
+	}
+
+	// Select the broadcasts to change.
+	selectBuilder := sq.Select().
+		Column("b.id").
+		Column("u.id").
+		Column(fmt.Sprintf("%t", value)).
+		Column(timeExpr).
+		From("broadcasts b").
+		Join("users u ON u.id = ?", userID).
+		LeftJoin("broadcast_states s ON s.broadcast_id = b.id AND s.user_id = u.id").
+		Where(broadcastVisibleToUser)
+	if filter.IDs != nil {
+		selectBuilder = selectBuilder.Where(sq.Eq{"b.id": filter.IDs})
+	} else {
+		selectBuilder = selectBuilder.Where(sq.NotEq{fmt.Sprintf("COALESCE(s.%s, false)", flag): value})
+	}
+	if filter.Seen != nil {
+		selectBuilder = selectBuilder.Where(sq.Eq{"COALESCE(s.seen, false)": *filter.Seen})
+	}
+	if filter.NotificationTypeID != "" {
+		selectBuilder = selectBuilder.Where(sq.Eq{"b.notification_type_id": filter.NotificationTypeID})
+	}
+
+	// Build the statement.
+	statement, args, err := psql.Insert("broadcast_states").
+		Columns("broadcast_id", "user_id", flag, flag+"_at").
+		Select(selectBuilder).
+		Suffix(onConflict).
+		ToSql()
+	if err != nil {
+		return 0, errors.Wrap(err, wrapMsg)
+	}
+
+	// Execute the statement.
+	result, err := tx.ExecContext(ctx, statement, args...)
+	if err != nil {
+		return 0, errors.Wrap(err, wrapMsg)
+	}
+
+	// Determine how many rows were affected.
+	count, err := result.RowsAffected()
+	if err != nil {
+		return 0, errors.Wrap(err, wrapMsg)
+	}
+
+	return int(count), nil
+}
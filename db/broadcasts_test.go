@@ -0,0 +1,95 @@
+package db
+
+import (
+	"context"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSaveBroadcastStoresTheUsernamesAsAnArray(t *testing.T) {
+	assert := assert.New(t)
+
+	db, mock, err := sqlmock.New()
+	assert.NoError(err, "unable to open the mock database connection")
+	defer func() { _ = db.Close() }()
+
+	const typeID = "7d3b1f2a-3cbb-11eb-8a0b-f64e9b87c109"
+	const id = "0bd8d8a2-3cbc-11eb-8a0b-f64e9b87c109"
+	timeCreated := time.Date(2026, time.October, 5, 12, 0, 0, 0, time.UTC)
+	usernames := []string{"sarahr@example.org", "ipcdev@example.org"}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id::text FROM notification_types WHERE name =").
+		WithArgs("system").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(typeID))
+	mock.ExpectQuery(regexp.QuoteMeta(
+		"INSERT INTO broadcasts (notification_type_id,subject,audience,group_name,usernames,outgoing_json) "+
+			"VALUES ($1,$2,$3,$4,$5,$6) RETURNING id, time_created",
+	)).
+		WithArgs(typeID, "maintenance tonight", "users", nil, pq.Array(usernames), "{}").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "time_created"}).AddRow(id, timeCreated))
+	mock.ExpectRollback()
+
+	tx, err := db.Begin()
+	assert.NoError(err, "unable to begin a transaction")
+
+	broadcast := &Broadcast{
+		NotificationType: "system",
+		Subject:          "maintenance tonight",
+		Audience:         "users",
+		Usernames:        usernames,
+	}
+	if assert.NoError(SaveBroadcast(context.Background(), tx, broadcast)) {
+		assert.Equal(id, broadcast.ID)
+		assert.Equal(timeCreated, broadcast.TimeCreated)
+	}
+
+	_ = tx.Rollback()
+	assert.NoError(mock.ExpectationsWereMet(), "not all mock expectations were met")
+}
+
+func TestSetBroadcastFlagOnlyCountsChangedBroadcasts(t *testing.T) {
+	assert := assert.New(t)
+
+	db, mock, err := sqlmock.New()
+	assert.NoError(err, "unable to open the mock database connection")
+	defer func() { _ = db.Close() }()
+
+	const userID = "8d0f1e5e-3cbb-11eb-8a0b-f64e9b87c109"
+	const typeID = "7d3b1f2a-3cbb-11eb-8a0b-f64e9b87c109"
+
+	// Without a list of IDs, only the broadcasts that aren't deleted yet are selected, and the first deletion time is
+	// kept for the ones that already have state.
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(
+		"INSERT INTO broadcast_states (broadcast_id,user_id,deleted,deleted_at) "+
+			"SELECT b.id, u.id, true, now() FROM broadcasts b JOIN users u ON u.id = $1 "+
+			"LEFT JOIN broadcast_states s ON s.broadcast_id = b.id AND s.user_id = u.id "+
+			"WHERE "+broadcastVisibleToUser+" AND COALESCE(s.deleted, false) <> $2 "+
+			"AND COALESCE(s.seen, false) = $3 AND b.notification_type_id = $4 "+
+			"ON CONFLICT (broadcast_id, user_id) DO UPDATE SET deleted = EXCLUDED.deleted, "+
+			"deleted_at = COALESCE(broadcast_states.deleted_at, EXCLUDED.deleted_at)",
+	)).
+		WithArgs(userID, true, true, typeID).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectRollback()
+
+	tx, err := db.Begin()
+	assert.NoError(err, "unable to begin a transaction")
+
+	seen := true
+	count, err := setBroadcastFlag(context.Background(), tx, userID, "deleted", true, &broadcastStateFilter{
+		Seen:               &seen,
+		NotificationTypeID: typeID,
+	})
+	assert.NoError(err)
+	assert.Equal(2, count)
+
+	_ = tx.Rollback()
+	assert.NoError(mock.ExpectationsWereMet(), "not all mock expectations were met")
+}
@@ -0,0 +1,123 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/cyverse-de/messaging/v12"
+	"github.com/pkg/errors"
+
+	sq "github.com/Masterminds/squirrel"
+)
+
+// DeferEmail stores the email request for a notification so that it can be sent at the given time instead of right
+// away.
+func DeferEmail(
+	ctx context.Context,
+	tx *sql.Tx,
+	notificationID string,
+	emailRequest *messaging.EmailRequest,
+	releaseAt time.Time,
+) error {
+	wrapMsg := fmt.Sprintf("unable to defer the email for notification %s", notificationID)
+
+	// Marshal the email request.
+	emailRequestJSON, err := json.Marshal(emailRequest)
+	if err != nil {
+		return errors.Wrap(err, wrapMsg)
+	}
+
+	// Build the statement.
+	statement, args, err := psql.Insert("deferred_emails").
+		Columns("notification_id", "email_request", "release_at").
+		Values(notificationID, emailRequestJSON, releaseAt).
+		ToSql()
+	if err != nil {
+		return errors.Wrap(err, wrapMsg)
+	}
+
+	// Execute the statement.
+	_, err = tx.ExecContext(ctx, statement, args...)
+	if err != nil {
+		return errors.Wrap(err, wrapMsg)
+	}
+
+	return nil
+}
+
+// DeferredEmail describes an email that was held until the end of the recipient's quiet hours.
+type DeferredEmail struct {
+	ID           string
+	EmailRequest *messaging.EmailRequest
+}
+
+// ClaimDeferredEmails locks and returns up to limit deferred emails that are due to be sent at the given time, oldest
+// first. Emails that another transaction has already claimed are skipped, so two replicas can never release the same
+// email. The emails stay locked until the transaction ends.
+func ClaimDeferredEmails(ctx context.Context, tx *sql.Tx, now time.Time, limit uint64) ([]*DeferredEmail, error) {
+	wrapMsg := "unable to claim the deferred emails"
+
+	// Build the query.
+	query, args, err := psql.Select().
+		Column("id").
+		Column("email_request").
+		From("deferred_emails").
+		Where(sq.LtOrEq{"release_at": now}).
+		OrderBy("release_at", "id").
+		Limit(limit).
+		Suffix("FOR UPDATE SKIP LOCKED").
+		ToSql()
+	if err != nil {
+		return nil, errors.Wrap(err, wrapMsg)
+	}
+
+	// Execute the query.
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, wrapMsg)
+	}
+	defer func() { _ = rows.Close() }()
+
+	// Build the list of emails.
+	emails := make([]*DeferredEmail, 0)
+	for rows.Next() {
+		var email DeferredEmail
+		var emailRequestJSON []byte
+		if err = rows.Scan(&email.ID, &emailRequestJSON); err != nil {
+			return nil, errors.Wrap(err, wrapMsg)
+		}
+		if err = json.Unmarshal(emailRequestJSON, &email.EmailRequest); err != nil {
+			return nil, errors.Wrap(err, wrapMsg)
+		}
+		emails = append(emails, &email)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, errors.Wrap(err, wrapMsg)
+	}
+
+	return emails, nil
+}
+
+// DeleteDeferredEmails deletes deferred emails once they've been released.
+func DeleteDeferredEmails(ctx context.Context, tx *sql.Tx, ids []string) error {
+	wrapMsg := "unable to delete the released deferred emails"
+
+	// Build the statement.
+	statement, args, err := psql.Delete("deferred_emails").
+		Where(sq.Eq{"id": ids}).
+		ToSql()
+	if err != nil {
+		return errors.Wrap(err, wrapMsg)
+	}
+
+	// Execute the statement.
+	_, err = tx.ExecContext(ctx, statement, args...)
+	if err != nil {
+		return errors.Wrap(err, wrapMsg)
+	}
+
+	return nil
+}
@@ -0,0 +1,188 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/cyverse-de/messaging/v12"
+	"github.com/pkg/errors"
+
+	sq "github.com/Masterminds/squirrel"
+)
+
+// SavePendingDigestItem stores the email request for a notification so that it can be sent later as part of a digest
+// instead of being sent right away.
+func SavePendingDigestItem(
+	ctx context.Context,
+	tx *sql.Tx,
+	notificationID string,
+	period string,
+	emailRequest *messaging.EmailRequest,
+) error {
+	wrapMsg := fmt.Sprintf("unable to save the %s digest item for notification %s", period, notificationID)
+
+	// Marshal the email request.
+	emailRequestJSON, err := json.Marshal(emailRequest)
+	if err != nil {
+		return errors.Wrap(err, wrapMsg)
+	}
+
+	// Build the statement.
+	statement, args, err := psql.Insert("pending_digest_items").
+		Columns("notification_id", "period", "email_request").
+		Values(notificationID, period, emailRequestJSON).
+		ToSql()
+	if err != nil {
+		return errors.Wrap(err, wrapMsg)
+	}
+
+	// Execute the statement.
+	_, err = tx.ExecContext(ctx, statement, args...)
+	if err != nil {
+		return errors.Wrap(err, wrapMsg)
+	}
+
+	return nil
+}
+
+// DigestRecipient describes a user who has pending digest items.
+type DigestRecipient struct {
+	UserID   string
+	Username string
+}
+
+// ListDigestRecipients lists the users who have digest items for the given period that were collected before the
+// cutoff time.
+func ListDigestRecipients(ctx context.Context, tx *sql.Tx, period string, cutoff time.Time) ([]*DigestRecipient, error) {
+	wrapMsg := fmt.Sprintf("unable to list the %s digest recipients", period)
+
+	// Build the query.
+	query, args, err := psql.Select().
+		Distinct().
+		Column("u.id").
+		Column("u.username").
+		From("pending_digest_items p").
+		Join("notifications n ON p.notification_id = n.id").
+		Join("users u ON n.user_id = u.id").
+		Where(sq.Eq{"p.period": period}).
+		Where(sq.Lt{"p.time_created": cutoff}).
+		OrderBy("u.username").
+		ToSql()
+	if err != nil {
+		return nil, errors.Wrap(err, wrapMsg)
+	}
+
+	// Execute the query.
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, wrapMsg)
+	}
+	defer func() { _ = rows.Close() }()
+
+	// Build the list of recipients.
+	recipients := make([]*DigestRecipient, 0)
+	for rows.Next() {
+		var recipient DigestRecipient
+		if err = rows.Scan(&recipient.UserID, &recipient.Username); err != nil {
+			return nil, errors.Wrap(err, wrapMsg)
+		}
+		recipients = append(recipients, &recipient)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, errors.Wrap(err, wrapMsg)
+	}
+
+	return recipients, nil
+}
+
+// DigestItem describes a single notification in a digest.
+type DigestItem struct {
+	ID           string
+	Subject      string
+	Text         string
+	TimeCreated  time.Time
+	EmailRequest *messaging.EmailRequest
+}
+
+// ClaimDigestItems locks and returns a user's digest items for the given period that were collected before the cutoff
+// time, oldest first. Items that another transaction has already claimed are skipped, so two replicas can never
+// include the same item in a digest. The items stay locked until the transaction ends.
+func ClaimDigestItems(
+	ctx context.Context,
+	tx *sql.Tx,
+	userID string,
+	period string,
+	cutoff time.Time,
+) ([]*DigestItem, error) {
+	wrapMsg := fmt.Sprintf("unable to claim the %s digest items", period)
+
+	// Build the query.
+	query, args, err := psql.Select().
+		Column("p.id").
+		Column("n.subject").
+		Column("COALESCE(n.outgoing_json->'message'->>'text', '')").
+		Column("n.time_created").
+		Column("p.email_request").
+		From("pending_digest_items p").
+		Join("notifications n ON p.notification_id = n.id").
+		Where(sq.Eq{"n.user_id": userID}).
+		Where(sq.Eq{"p.period": period}).
+		Where(sq.Lt{"p.time_created": cutoff}).
+		OrderBy("n.time_created", "p.id").
+		Suffix("FOR UPDATE OF p SKIP LOCKED").
+		ToSql()
+	if err != nil {
+		return nil, errors.Wrap(err, wrapMsg)
+	}
+
+	// Execute the query.
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, wrapMsg)
+	}
+	defer func() { _ = rows.Close() }()
+
+	// Build the list of items.
+	items := make([]*DigestItem, 0)
+	for rows.Next() {
+		var item DigestItem
+		var emailRequestJSON []byte
+		err = rows.Scan(&item.ID, &item.Subject, &item.Text, &item.TimeCreated, &emailRequestJSON)
+		if err != nil {
+			return nil, errors.Wrap(err, wrapMsg)
+		}
+		if err = json.Unmarshal(emailRequestJSON, &item.EmailRequest); err != nil {
+			return nil, errors.Wrap(err, wrapMsg)
+		}
+		items = append(items, &item)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, errors.Wrap(err, wrapMsg)
+	}
+
+	return items, nil
+}
+
+// DeleteDigestItems deletes digest items once they've been sent.
+func DeleteDigestItems(ctx context.Context, tx *sql.Tx, ids []string) error {
+	wrapMsg := "unable to delete the sent digest items"
+
+	// Build the statement.
+	statement, args, err := psql.Delete("pending_digest_items").
+		Where(sq.Eq{"id": ids}).
+		ToSql()
+	if err != nil {
+		return errors.Wrap(err, wrapMsg)
+	}
+
+	// Execute the statement.
+	_, err = tx.ExecContext(ctx, statement, args...)
+	if err != nil {
+		return errors.Wrap(err, wrapMsg)
+	}
+
+	return nil
+}
@@ -0,0 +1,146 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+
+	sq "github.com/Masterminds/squirrel"
+)
+
+// IdempotencyKey identifies a notification request that should only be recorded once. Callers choose their own keys,
+// so each key is scoped to the notification type and recipient of the request, and unrelated requests that happen to
+// use the same key don't affect each other.
+type IdempotencyKey struct {
+
+	// The key that the caller chose.
+	Key string
+
+	// The notification type.
+	NotificationType string
+
+	// The recipient of the request: the qualified username of a single recipient, or the team or list of recipients
+	// that the request was addressed to.
+	Recipient string
+}
+
+// where returns the condition that matches the idempotency key.
+func (k *IdempotencyKey) where() sq.Eq {
+	return sq.Eq{
+		"idempotency_key":   k.Key,
+		"notification_type": k.NotificationType,
+		"recipient":         k.Recipient,
+	}
+}
+
+// ClaimIdempotencyKey records that the notification request with the given idempotency key is being recorded, and
+// reports whether it should be. A key that was claimed before and hasn't expired yet can't be claimed again, so the
+// request is a duplicate if false is returned; the IDs of the notifications that were recorded for the request that
+// claimed the key are returned along with it. An expired key is claimed again as though it were new. The key is
+// released if the transaction is rolled back, and a concurrent claim of the same key waits for the transaction to
+// finish.
+func ClaimIdempotencyKey(
+	ctx context.Context,
+	tx *sql.Tx,
+	key *IdempotencyKey,
+	now, expiresAt time.Time,
+) (bool, []string, error) {
+	wrapMsg := "unable to claim the idempotency key"
+
+	// Build the statement.
+	statement, args, err := psql.Insert("idempotency_keys").
+		Columns("idempotency_key", "notification_type", "recipient", "time_created", "expires_at").
+		Values(key.Key, key.NotificationType, key.Recipient, now, expiresAt).
+		Suffix(
+			"ON CONFLICT (idempotency_key, notification_type, recipient) DO UPDATE " +
+				"SET notification_ids = '{}', time_created = EXCLUDED.time_created, expires_at = EXCLUDED.expires_at " +
+				"WHERE idempotency_keys.expires_at <= EXCLUDED.time_created",
+		).
+		ToSql()
+	if err != nil {
+		return false, nil, errors.Wrap(err, wrapMsg)
+	}
+
+	// Execute the statement.
+	result, err := tx.ExecContext(ctx, statement, args...)
+	if err != nil {
+		return false, nil, errors.Wrap(err, wrapMsg)
+	}
+
+	// No row is affected if the key was claimed already and hasn't expired.
+	count, err := result.RowsAffected()
+	if err != nil {
+		return false, nil, errors.Wrap(err, wrapMsg)
+	}
+	if count > 0 {
+		return true, nil, nil
+	}
+
+	// Look up the notifications that were recorded for the request that claimed the key.
+	query, args, err := psql.Select("notification_ids").
+		From("idempotency_keys").
+		Where(key.where()).
+		ToSql()
+	if err != nil {
+		return false, nil, errors.Wrap(err, wrapMsg)
+	}
+	var ids pq.StringArray
+	if err = tx.QueryRowContext(ctx, query, args...).Scan(&ids); err != nil {
+		return false, nil, errors.Wrap(err, wrapMsg)
+	}
+
+	return false, ids, nil
+}
+
+// SaveIdempotencyKeyNotifications stores the IDs of the notifications that were recorded for the request that claimed
+// an idempotency key, so that they can be returned for duplicates of the request.
+func SaveIdempotencyKeyNotifications(ctx context.Context, tx *sql.Tx, key *IdempotencyKey, ids []string) error {
+	wrapMsg := "unable to save the notification IDs for the idempotency key"
+
+	// Build the statement.
+	statement, args, err := psql.Update("idempotency_keys").
+		Set("notification_ids", pq.Array(ids)).
+		Where(key.where()).
+		ToSql()
+	if err != nil {
+		return errors.Wrap(err, wrapMsg)
+	}
+
+	// Execute the statement.
+	if _, err = tx.ExecContext(ctx, statement, args...); err != nil {
+		return errors.Wrap(err, wrapMsg)
+	}
+
+	return nil
+}
+
+// PurgeExpiredIdempotencyKeys permanently deletes the idempotency keys that expired before the given time, returning
+// the number of keys that were deleted.
+func PurgeExpiredIdempotencyKeys(ctx context.Context, tx *sql.Tx, now time.Time) (int64, error) {
+	wrapMsg := "unable to purge the expired idempotency keys"
+
+	// Build the statement.
+	statement, args, err := psql.Delete("idempotency_keys").
+		Where(sq.LtOrEq{"expires_at": now}).
+		ToSql()
+	if err != nil {
+		return 0, errors.Wrap(err, wrapMsg)
+	}
+
+	// Execute the statement.
+	result, err := tx.ExecContext(ctx, statement, args...)
+	if err != nil {
+		return 0, errors.Wrap(err, wrapMsg)
+	}
+
+	// Determine the number of keys that were deleted.
+	count, err := result.RowsAffected()
+	if err != nil {
+		return 0, errors.Wrap(err, wrapMsg)
+	}
+
+	return count, nil
+}
@@ -0,0 +1,99 @@
+package db
+
+import (
+	"context"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClaimIdempotencyKey(t *testing.T) {
+	tests := []struct {
+		name        string
+		affected    int64
+		wantClaimed bool
+		wantIDs     []string
+	}{
+		{name: "a new or expired key is claimed", affected: 1, wantClaimed: true},
+		{
+			name:     "a key that hasn't expired is not claimed again",
+			affected: 0,
+			wantIDs:  []string{"46ae63be-7030-4cdd-8eb9-66aa49fcf38b"},
+		},
+	}
+
+	now := time.Date(2026, time.October, 14, 3, 0, 0, 0, time.UTC)
+	expiresAt := now.Add(24 * time.Hour)
+	key := &IdempotencyKey{Key: "job-1234-completed", NotificationType: "analysis", Recipient: "sarahr@example.org"}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			db, mock, err := sqlmock.New()
+			assert.NoError(err, "unable to open the mock database connection")
+			defer func() { _ = db.Close() }()
+
+			mock.ExpectBegin()
+			mock.ExpectExec(regexp.QuoteMeta(
+				"INSERT INTO idempotency_keys "+
+					"(idempotency_key,notification_type,recipient,time_created,expires_at) VALUES ($1,$2,$3,$4,$5) "+
+					"ON CONFLICT (idempotency_key, notification_type, recipient) DO UPDATE",
+			)).
+				WithArgs("job-1234-completed", "analysis", "sarahr@example.org", now, expiresAt).
+				WillReturnResult(sqlmock.NewResult(0, tt.affected))
+
+			// The notifications recorded for the original request are looked up for a duplicate.
+			if !tt.wantClaimed {
+				mock.ExpectQuery(regexp.QuoteMeta(
+					"SELECT notification_ids FROM idempotency_keys "+
+						"WHERE idempotency_key = $1 AND notification_type = $2 AND recipient = $3",
+				)).
+					WithArgs("job-1234-completed", "analysis", "sarahr@example.org").
+					WillReturnRows(sqlmock.NewRows([]string{"notification_ids"}).AddRow(`{` + tt.wantIDs[0] + `}`))
+			}
+			mock.ExpectRollback()
+
+			tx, err := db.Begin()
+			assert.NoError(err, "unable to begin a transaction")
+
+			claimed, ids, err := ClaimIdempotencyKey(context.Background(), tx, key, now, expiresAt)
+			assert.NoError(err)
+			assert.Equal(tt.wantClaimed, claimed)
+			assert.Equal(tt.wantIDs, ids)
+
+			_ = tx.Rollback()
+			assert.NoError(mock.ExpectationsWereMet(), "not all mock expectations were met")
+		})
+	}
+}
+
+func TestSaveIdempotencyKeyNotifications(t *testing.T) {
+	assert := assert.New(t)
+
+	db, mock, err := sqlmock.New()
+	assert.NoError(err, "unable to open the mock database connection")
+	defer func() { _ = db.Close() }()
+
+	ids := []string{"46ae63be-7030-4cdd-8eb9-66aa49fcf38b"}
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(
+		"UPDATE idempotency_keys SET notification_ids = $1 "+
+			"WHERE idempotency_key = $2 AND notification_type = $3 AND recipient = $4",
+	)).
+		WithArgs(pq.Array(ids), "job-1234-completed", "analysis", "sarahr@example.org").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectRollback()
+
+	tx, err := db.Begin()
+	assert.NoError(err, "unable to begin a transaction")
+
+	key := &IdempotencyKey{Key: "job-1234-completed", NotificationType: "analysis", Recipient: "sarahr@example.org"}
+	assert.NoError(SaveIdempotencyKeyNotifications(context.Background(), tx, key, ids))
+
+	_ = tx.Rollback()
+	assert.NoError(mock.ExpectationsWereMet(), "not all mock expectations were met")
+}
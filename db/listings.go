@@ -48,6 +48,15 @@ type V1NotificationListingParameters struct {
 	SortOrder        query.SortOrder
 	SortField        query.V1ListingSortField
 	NotificationType string
+
+	// If specified, the listing starts just after the notification that the cursor points to rather than at Offset.
+	// Cursors can only be used when the listing is sorted by timestamp, and the sort order in the cursor takes
+	// precedence over SortOrder.
+	Cursor *query.Cursor
+
+	// If true, the total number of matching notifications isn't counted, which saves a scan of every matching
+	// notification on large listings.
+	OmitTotal bool
 }
 
 // getNotificationListingSortColumn returns the sort column to use for a V1ListingSortField value.
@@ -63,6 +72,26 @@ func getV1NotificationListingSortColumn(sortField query.V1ListingSortField) (str
 	return "", fmt.Errorf("unrecognized sort field: %s", string(sortField))
 }
 
+// v1ApplyFilters adds the filters that version 1 of the API supports to a query whose `n` table contains the
+// notifications that a user can see. Deleted notifications are never included.
+func v1ApplyFilters(queryBuilder sq.SelectBuilder, seen *bool, notificationType string) sq.SelectBuilder {
+	queryBuilder = queryBuilder.
+		Join("notification_types nt ON n.notification_type_id = nt.id").
+		Where(sq.Eq{"n.deleted": false})
+
+	// Apply the seen parameter if requested.
+	if seen != nil {
+		queryBuilder = queryBuilder.Where(sq.Eq{"n.seen": *seen})
+	}
+
+	// Apply the notification type parameter if requested.
+	if notificationType != "" {
+		queryBuilder = queryBuilder.Where(sq.Eq{"nt.name": notificationType})
+	}
+
+	return queryBuilder
+}
+
 // V1NotificationCountingParameters describes the parameters available for counting notification messages.
 type V1NotificationCountingParameters struct {
 	User             string
@@ -74,22 +103,11 @@ type V1NotificationCountingParameters struct {
 func v1CountNotifications(ctx context.Context, tx *sql.Tx, params *V1NotificationCountingParameters) (int, error) {
 
 	// Begin building the query.
-	queryBuilder := psql.Select().
-		Column("count(*)").
-		From("notifications n").
-		Join("users u ON n.user_id = u.id").
-		Join("notification_types nt ON n.notification_type_id = nt.id").
-		Where(sq.Eq{"u.username": params.User}).
-		Where(sq.Eq{"n.deleted": false})
-
-	// Apply the seen parameter if requested.
-	if params.Seen != nil {
-		queryBuilder = queryBuilder.Where(sq.Eq{"n.seen": *params.Seen})
-	}
-
-	// Apply the notification type parameter if requested.
-	if params.NotificationType != "" {
-		queryBuilder = queryBuilder.Where(sq.Eq{"nt.name": params.NotificationType})
+	queryBuilder, err := countUserNotifications(params.User, func(queryBuilder sq.SelectBuilder) (sq.SelectBuilder, error) {
+		return v1ApplyFilters(queryBuilder, params.Seen, params.NotificationType), nil
+	})
+	if err != nil {
+		return 0, err
 	}
 
 	// Build the query.
@@ -109,8 +127,13 @@ func v1CountNotifications(ctx context.Context, tx *sql.Tx, params *V1Notificatio
 	return result, nil
 }
 
-// V1ListNotifications lists notifications for a user.
-func V1ListNotifications(ctx context.Context, tx *sql.Tx, params *V1NotificationListingParameters) (*model.V1NotificationListing, error) {
+// V1ListNotifications lists notifications for a user. If the listing is sorted by timestamp and limited, a cursor
+// pointing to the last notification in the listing is returned as well when there are more notifications to list.
+func V1ListNotifications(
+	ctx context.Context,
+	tx *sql.Tx,
+	params *V1NotificationListingParameters,
+) (*model.V1NotificationListing, *query.Cursor, error) {
 	wrapMsg := "unable to obtain the notification listing"
 
 	// Obtain the total number of unseen messages for the user.
@@ -125,91 +148,140 @@ func V1ListNotifications(ctx context.Context, tx *sql.Tx, params *V1Notification
 		},
 	)
 	if err != nil {
-		return nil, errors.Wrap(err, wrapMsg)
+		return nil, nil, errors.Wrap(err, wrapMsg)
 	}
 
-	// Begin building the query.
-	queryBuilder := psql.Select().
-		Column("nt.name AS type").
-		Column("n.seen").
-		Column("n.deleted").
-		Column("n.outgoing_json AS message").
-		Column("count(*) OVER () AS total").
-		From("notifications n").
-		Join("users u ON n.user_id = u.id").
-		Join("notification_types nt ON n.notification_type_id = nt.id").
-		Where(sq.Eq{"u.username": params.User}).
-		Where(sq.Eq{"n.deleted": false})
-
-	// Apply the seen parameter if requested.
-	if params.Seen != nil {
-		queryBuilder = queryBuilder.Where(sq.Eq{"n.seen": *params.Seen})
+	// Determine the sort column and order.
+	sortColumn, err := getV1NotificationListingSortColumn(params.SortField)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, wrapMsg)
 	}
-
-	// Apply the notification type parameter if requested.
-	if params.NotificationType != "" {
-		queryBuilder = queryBuilder.Where(sq.Eq{"nt.name": params.NotificationType})
+	sortOrder := params.SortOrder
+	if params.Cursor != nil {
+		if sortColumn != "n.time_created" {
+			return nil, nil, errors.Wrap(fmt.Errorf("cursors can't be used with sort field %s", params.SortField), wrapMsg)
+		}
+		sortOrder = params.Cursor.SortOrder
 	}
 
-	// Apply the limit if requested.
-	if params.Limit > 0 {
-		queryBuilder = queryBuilder.Limit(params.Limit)
+	// Cursors can be issued if the listing is sorted by timestamp and doesn't include everything at once.
+	issueCursor := sortColumn == "n.time_created" && params.Limit > 0
+
+	// Apply sorting. Notifications created at the same time are sorted by ID so that cursors are unambiguous.
+	orderBy := []string{fmt.Sprintf("%s %s", sortColumn, string(sortOrder))}
+	if sortColumn == "n.time_created" {
+		orderBy = v2SortOrderColumns(sortOrder)
 	}
 
-	// Apply the offset if requested.
-	if params.Offset != 0 {
-		queryBuilder = queryBuilder.Offset(params.Offset)
+	// Apply the limit if requested. One extra notification is fetched when a cursor might be issued, to tell whether
+	// there's anything left to list. The offset only applies if there's no cursor.
+	limit, offset := params.Limit, params.Offset
+	if issueCursor {
+		limit++
+	}
+	if params.Cursor != nil {
+		offset = 0
 	}
 
-	// Apply sorting.
-	sortColumn, err := getV1NotificationListingSortColumn(params.SortField)
+	// Build the query. The subject is selected so that the notifications can be sorted by it.
+	queryBuilder, err := selectUserNotifications(
+		params.User,
+		func(queryBuilder sq.SelectBuilder) (sq.SelectBuilder, error) {
+			queryBuilder = v1ApplyFilters(queryBuilder, params.Seen, params.NotificationType).
+				Column("nt.name AS type").
+				Column("n.seen").
+				Column("n.deleted").
+				Column("n.outgoing_json AS message").
+				Column("n.id").
+				Column("n.time_created").
+				Column("n.subject")
+
+			// Skip to the cursor if requested.
+			if params.Cursor != nil {
+				queryBuilder = addCursorWhereClause(queryBuilder, params.Cursor)
+			}
+
+			return queryBuilder, nil
+		},
+		orderBy,
+		limit,
+		offset,
+	)
 	if err != nil {
-		return nil, errors.Wrap(err, wrapMsg)
+		return nil, nil, errors.Wrap(err, wrapMsg)
 	}
-	queryBuilder = queryBuilder.OrderBy(fmt.Sprintf("%s %s", sortColumn, string(params.SortOrder)))
 
 	// Build the query.
-	query, args, err := queryBuilder.ToSql()
+	listingQuery, args, err := queryBuilder.
+		Columns("n.type", "n.seen", "n.deleted", "n.message", "n.id", "n.time_created").
+		ToSql()
 	if err != nil {
-		return nil, errors.Wrap(err, wrapMsg)
+		return nil, nil, errors.Wrap(err, wrapMsg)
 	}
 
 	// Query the database.
-	rows, err := tx.QueryContext(ctx, query, args...)
+	rows, err := tx.QueryContext(ctx, listingQuery, args...)
 	if err != nil {
-		return nil, errors.Wrap(err, wrapMsg)
+		return nil, nil, errors.Wrap(err, wrapMsg)
 	}
 	defer func() { _ = rows.Close() }()
 
-	// Build the listing from the result set.
-	var total int
+	// Build the listing from the result set, stopping at the extra notification if there is one.
+	var next, last *query.Cursor
 	listing := make([]*model.Notification, 0)
 	for rows.Next() {
-		var notificationType string
+		if issueCursor && uint64(len(listing)) == params.Limit {
+			next = last
+			break
+		}
+
+		var notificationType, id string
 		var messageText []byte
 		var seen, deleted bool
+		var timeCreated time.Time
 
 		// Fetch the data for the current row from the database.
-		err = rows.Scan(&notificationType, &seen, &deleted, &messageText, &total)
+		err = rows.Scan(&notificationType, &seen, &deleted, &messageText, &id, &timeCreated)
 		if err != nil {
-			return nil, errors.Wrap(err, wrapMsg)
+			return nil, nil, errors.Wrap(err, wrapMsg)
 		}
 
 		// Unmarshal the message and plug in any values that might have changed.
 		message, err := formatNotification(messageText, notificationType, seen, deleted)
 		if err != nil {
-			return nil, errors.Wrap(err, wrapMsg)
+			return nil, nil, errors.Wrap(err, wrapMsg)
 		}
 
 		listing = append(listing, message)
+		last = &query.Cursor{TimeCreated: timeCreated, ID: id, SortOrder: sortOrder}
+	}
+	if err = rows.Err(); err != nil {
+		return nil, nil, errors.Wrap(err, wrapMsg)
 	}
 
 	result := &model.V1NotificationListing{
 		Messages:    listing,
-		Total:       fmt.Sprintf("%d", total),
 		UnseenTotal: fmt.Sprintf("%d", unseenCount),
 	}
-	return result, nil
+
+	// Count the matching notifications unless the total was omitted.
+	if !params.OmitTotal {
+		total, err := v1CountNotifications(
+			ctx,
+			tx,
+			&V1NotificationCountingParameters{
+				User:             params.User,
+				Seen:             params.Seen,
+				NotificationType: params.NotificationType,
+			},
+		)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, wrapMsg)
+		}
+		result.Total = fmt.Sprintf("%d", total)
+	}
+
+	return result, next, nil
 }
 
 // V1CountNotifications counts notifications for a user.
@@ -258,8 +330,29 @@ type V2NotificationListingParameters struct {
 	// If specified, only messages with subjects matching the given search string will be returned.
 	SubjectSearch string
 
-	// If specified, only messages of the given type will be returned.
-	NotificationType string
+	// If specified, only messages matching the given full-text search will be returned, and each message will include
+	// its search rank and the matching portions of its subject and text. The search string uses the same syntax as web
+	// search engines: quoted phrases, `or`, and `-` to exclude words.
+	Search string
+
+	// If specified, only messages of the given types will be returned.
+	NotificationTypes []string
+
+	// If specified, only messages created after the given time will be returned.
+	CreatedAfter *time.Time
+
+	// If specified, only messages created before the given time will be returned.
+	CreatedBefore *time.Time
+
+	// If specified, only messages that the user first marked as seen after the given time will be returned.
+	SeenAfter *time.Time
+
+	// If specified, only messages that the user first marked as seen before the given time will be returned.
+	SeenBefore *time.Time
+
+	// If true, only messages that have been marked as deleted will be returned. Otherwise, only messages that haven't
+	// been marked as deleted will be returned.
+	Deleted bool
 }
 
 // v2SubjectSearchString converts a string to a search string suitable for a subject search.
@@ -271,16 +364,64 @@ func v2SubjectSearchString(s string) string {
 	return s
 }
 
-// v2ListNotificationsBaseQuery builds the base query for notification listings in version 2 of the API.
-func v2ListNotificationsBaseQuery(params *V2NotificationListingParameters) sq.SelectBuilder {
+// v2SearchQuery is the SQL expression for the full-text search query. The search vector stored in the notifications
+// table is built with the same text search configuration. The rank that's computed with it is informational only;
+// listings are always sorted by time so that keyset paging works for searches too.
+const v2SearchQuery = "websearch_to_tsquery('english', ?)"
+
+// v2SearchStartSel and v2SearchStopSel mark the matches in search results until the rest of the text has been
+// HTML-escaped, at which point they're replaced with <mark> tags. They're characters from the Unicode private use
+// area, which doesn't appear in notification text; at worst, one that does turns into a stray <mark> tag.
+const (
+	v2SearchStartSel = "\uE000"
+	v2SearchStopSel  = "\uE001"
+)
+
+// v2SearchHeadlineOptions are the options used to highlight the matches in search results.
+var v2SearchHeadlineOptions = fmt.Sprintf(`StartSel="%s", StopSel="%s"`, v2SearchStartSel, v2SearchStopSel)
+
+// v2SearchHighlighter HTML-escapes a search result and replaces the markers around the matches with <mark> tags.
+var v2SearchHighlighter = strings.NewReplacer(
+	"&", "&amp;",
+	"<", "&lt;",
+	">", "&gt;",
+	`"`, "&#34;",
+	"'", "&#39;",
+	v2SearchStartSel, "<mark>",
+	v2SearchStopSel, "</mark>",
+)
+
+// v2AddSearchColumns adds the columns that describe how well each message matches a full-text search to a listing
+// query.
+func v2AddSearchColumns(queryBuilder sq.SelectBuilder, search string) sq.SelectBuilder {
+	return queryBuilder.
+		Column(sq.Expr(fmt.Sprintf("ts_rank(n.search_vector, %s) AS search_rank", v2SearchQuery), search)).
+		Column(sq.Expr(
+			fmt.Sprintf(
+				"ts_headline('english', n.subject, %s, '%s, HighlightAll=true') AS search_subject",
+				v2SearchQuery,
+				v2SearchHeadlineOptions,
+			),
+			search,
+		)).
+		Column(sq.Expr(
+			fmt.Sprintf(
+				"ts_headline('english', COALESCE(n.outgoing_json->'message'->>'text', ''), %s, '%s') AS search_text",
+				v2SearchQuery,
+				v2SearchHeadlineOptions,
+			),
+			search,
+		))
+}
 
-	// Begin building the base query.
-	queryBuilder := psql.Select().
-		From("notifications n").
-		Join("users u ON n.user_id = u.id").
+// v2ApplyFilters adds the filters of a notification listing in version 2 of the API to a query whose `n` table contains
+// the notifications that a user can see.
+func v2ApplyFilters(queryBuilder sq.SelectBuilder, params *V2NotificationListingParameters) sq.SelectBuilder {
+
+	// Begin with the filters that always apply.
+	queryBuilder = queryBuilder.
 		Join("notification_types nt ON n.notification_type_id = nt.id").
-		Where(sq.Eq{"u.username": params.User}).
-		Where(sq.Eq{"n.deleted": false})
+		Where(sq.Eq{"n.deleted": params.Deleted})
 
 	// Apply the seen parameter if the user didn't request to see messages that have been marked as seen.
 	if !params.Seen {
@@ -292,10 +433,34 @@ func v2ListNotificationsBaseQuery(params *V2NotificationListingParameters) sq.Se
 		queryBuilder = queryBuilder.Where(sq.Like{"lower(n.subject)": v2SubjectSearchString(params.SubjectSearch)})
 	}
 
+	// Apply the full-text search parameter if it was specified.
+	if params.Search != "" {
+		queryBuilder = queryBuilder.Where(sq.Expr("n.search_vector @@ "+v2SearchQuery, params.Search))
+	}
+
 	// Apply the notification type parameter if it was specified.
-	if params.NotificationType != "" {
-		notificationType := strings.ToLower(params.NotificationType)
-		queryBuilder = queryBuilder.Where(sq.Eq{"nt.name": notificationType})
+	if len(params.NotificationTypes) > 0 {
+		notificationTypes := make([]string, len(params.NotificationTypes))
+		for i, notificationType := range params.NotificationTypes {
+			notificationTypes[i] = strings.ToLower(notificationType)
+		}
+		queryBuilder = queryBuilder.Where(sq.Eq{"nt.name": notificationTypes})
+	}
+
+	// Apply the creation time range if it was specified.
+	if params.CreatedAfter != nil {
+		queryBuilder = queryBuilder.Where(sq.Gt{"n.time_created": *params.CreatedAfter})
+	}
+	if params.CreatedBefore != nil {
+		queryBuilder = queryBuilder.Where(sq.Lt{"n.time_created": *params.CreatedBefore})
+	}
+
+	// Apply the seen time range if it was specified.
+	if params.SeenAfter != nil {
+		queryBuilder = queryBuilder.Where(sq.Gt{"n.seen_at": *params.SeenAfter})
+	}
+	if params.SeenBefore != nil {
+		queryBuilder = queryBuilder.Where(sq.Lt{"n.seen_at": *params.SeenBefore})
 	}
 
 	return queryBuilder
@@ -376,45 +541,98 @@ func v2AddPaginationSettings(
 	return queryBuilder, nil
 }
 
-// v2ApplySortOrder applies the sort order to a notification listing for version 2 of the API.
-func v2ApplySortOrder(queryBuilder sq.SelectBuilder, sortOrder query.SortOrder) sq.SelectBuilder {
-	return queryBuilder.OrderBy(fmt.Sprintf("n.time_created %s, n.id %s", sortOrder, sortOrder))
+// v2SortOrderColumns returns the ORDER BY columns for a notification listing for version 2 of the API.
+func v2SortOrderColumns(sortOrder query.SortOrder) []string {
+	return []string{fmt.Sprintf("n.time_created %s", sortOrder), fmt.Sprintf("n.id %s", sortOrder)}
 }
 
-// v2GetListing returns an acutal notification listing for version 2 of the API.
-func v2GetListing(ctx context.Context, tx *sql.Tx, params *V2NotificationListingParameters) ([]*model.Notification, error) {
-
-	// Begin building the query.
-	listingQueryBuilder := v2ListNotificationsBaseQuery(params).
+// v2AddListingColumns adds the columns that v2ScanListingRow expects to a notification listing query.
+func v2AddListingColumns(queryBuilder sq.SelectBuilder, search string) sq.SelectBuilder {
+	queryBuilder = queryBuilder.
 		Column("nt.name AS type").
 		Column("n.seen").
 		Column("n.deleted").
 		Column("n.outgoing_json AS message").
 		Column("n.id").
-		Column("n.time_created AS time_created")
+		Column("n.time_created AS time_created").
+		Column("n.seen_at").
+		Column("n.deleted_at")
+
+	// Include the search rank and highlights if this is a search.
+	if search != "" {
+		queryBuilder = v2AddSearchColumns(queryBuilder, search)
+	}
 
-	// Add the pagination settings. These only apply to the listing query.
-	listingQueryBuilder, err := v2AddPaginationSettings(listingQueryBuilder, params)
+	return queryBuilder
+}
+
+// v2ScanListingRow builds a notification from the current row of a result set for a query built with
+// v2AddListingColumns. The notification ID and creation time are returned as well so that callers can page through
+// listings.
+func v2ScanListingRow(rows *sql.Rows, search string) (*model.Notification, string, time.Time, error) {
+	var notificationType string
+	var messageText []byte
+	var seen, deleted bool
+	var id string
+	var timeCreated time.Time
+	var seenAt, deletedAt *time.Time
+	var searchMatch model.SearchMatch
+
+	// Fetch the data for the current row from the database.
+	dest := []any{&notificationType, &seen, &deleted, &messageText, &id, &timeCreated, &seenAt, &deletedAt}
+	if search != "" {
+		dest = append(dest, &searchMatch.Rank, &searchMatch.Subject, &searchMatch.Text)
+	}
+	err := rows.Scan(dest...)
 	if err != nil {
-		return nil, err
+		return nil, "", time.Time{}, err
+	}
+
+	// Unmarshal the message and plug in any values that might have changed.
+	message, err := formatNotification(messageText, notificationType, seen, deleted)
+	if err != nil {
+		return nil, "", time.Time{}, err
 	}
+	message.SeenAt = seenAt
+	message.DeletedAt = deletedAt
+	if search != "" {
+		searchMatch.Subject = v2SearchHighlighter.Replace(searchMatch.Subject)
+		searchMatch.Text = v2SearchHighlighter.Replace(searchMatch.Text)
+		message.Search = &searchMatch
+	}
+
+	return message, id, timeCreated, nil
+}
 
-	// Sort the inner query based on the requested sort order and boundary settings.
+// v2GetListing returns an acutal notification listing for version 2 of the API.
+func v2GetListing(ctx context.Context, tx *sql.Tx, params *V2NotificationListingParameters) ([]*model.Notification, error) {
+
+	// Sort the inner query based on the requested sort order and boundary settings. Search results are still sorted
+	// by timestamp so that paging works the same way for searches as it does for any other listing.
 	innerSortOrder := params.SortOrder
 	if params.AfterTimestamp != nil {
 		innerSortOrder = query.SortOrderAscending
 	} else if params.BeforeTimestamp != nil {
 		innerSortOrder = query.SortOrderDescending
 	}
-	listingQueryBuilder = v2ApplySortOrder(listingQueryBuilder, innerSortOrder)
 
-	// Apply the limit.
-	if params.Limit > 0 {
-		listingQueryBuilder = listingQueryBuilder.Limit(params.Limit)
+	// Build the query. The pagination settings only apply to the listing query.
+	listingQueryBuilder, err := selectUserNotifications(
+		params.User,
+		func(queryBuilder sq.SelectBuilder) (sq.SelectBuilder, error) {
+			queryBuilder = v2AddListingColumns(v2ApplyFilters(queryBuilder, params), params.Search)
+			return v2AddPaginationSettings(queryBuilder, params)
+		},
+		v2SortOrderColumns(innerSortOrder),
+		params.Limit,
+		0,
+	)
+	if err != nil {
+		return nil, err
 	}
 
 	// Generate the listing query.
-	listingQuery, listingQueryArgs, err := listingQueryBuilder.ToSql()
+	listingQuery, listingQueryArgs, err := listingQueryBuilder.Column("*").ToSql()
 	if err != nil {
 		return nil, err
 	}
@@ -437,23 +655,10 @@ func v2GetListing(ctx context.Context, tx *sql.Tx, params *V2NotificationListing
 	// Build the listing from the result set.
 	listing := make([]*model.Notification, 0)
 	for listingRows.Next() {
-		var notificationType string
-		var messageText []byte
-		var seen, deleted bool
-		var id, timeCreated string
-
-		// Fetch the data for the current row from the database.
-		err = listingRows.Scan(&notificationType, &seen, &deleted, &messageText, &id, &timeCreated)
+		message, _, _, err := v2ScanListingRow(listingRows, params.Search)
 		if err != nil {
 			return nil, err
 		}
-
-		// Unmarshal the message and plug in any values that might have changed.
-		message, err := formatNotification(messageText, notificationType, seen, deleted)
-		if err != nil {
-			return nil, err
-		}
-
 		listing = append(listing, message)
 	}
 
@@ -464,7 +669,13 @@ func v2GetListing(ctx context.Context, tx *sql.Tx, params *V2NotificationListing
 // of the API, not considering paging parameters.
 func v2GetNotificationCount(ctx context.Context, tx *sql.Tx, params *V2NotificationListingParameters) (int, error) {
 	var total int
-	countQuery, countQueryArgs, err := v2ListNotificationsBaseQuery(params).Column("count(*) AS count").ToSql()
+	countQueryBuilder, err := countUserNotifications(params.User, func(queryBuilder sq.SelectBuilder) (sq.SelectBuilder, error) {
+		return v2ApplyFilters(queryBuilder, params), nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	countQuery, countQueryArgs, err := countQueryBuilder.ToSql()
 	if err != nil {
 		return 0, err
 	}
@@ -488,33 +699,32 @@ type runBoundaryIDQueryParams struct {
 
 // runBoundaryIDQuery runs a single boundary ID query and returns the result.
 func runBoundaryIDQuery(params *runBoundaryIDQueryParams) (string, error) {
-	var err error
-
-	// Build the base query.
-	builder := v2ListNotificationsBaseQuery(params.ListingParams).Column("n.id")
-
-	// A WHERE clause is needed if we're querying relative to an existing message.
-	if params.ComparisonID != "" && params.ComparisonTimestamp != nil {
-
-		// The specific WHERE clause to add depends on the sort order.
-		whereFn := v2AddBeforeIDWhereClause
-		if params.SortOrder == query.SortOrderAscending {
-			whereFn = v2AddAfterIDWhereClause
-		}
-
-		// Add the clause to the query.
-		builder, err = whereFn(builder, params.ComparisonID, params.ComparisonTimestamp)
-		if err != nil {
-			return "", err
-		}
-	}
-
-	// Add the sort order, offset and limit to the query.
-	builder = v2ApplySortOrder(builder, params.SortOrder)
-	builder = builder.Offset(params.Offset).Limit(1)
-
 	// Build the query.
-	query, args, err := builder.ToSql()
+	builder, err := selectUserNotifications(
+		params.ListingParams.User,
+		func(queryBuilder sq.SelectBuilder) (sq.SelectBuilder, error) {
+			queryBuilder = v2ApplyFilters(queryBuilder, params.ListingParams).Column("n.id").Column("n.time_created")
+
+			// A WHERE clause is needed if we're querying relative to an existing message.
+			if params.ComparisonID == "" || params.ComparisonTimestamp == nil {
+				return queryBuilder, nil
+			}
+
+			// The specific WHERE clause to add depends on the sort order.
+			whereFn := v2AddBeforeIDWhereClause
+			if params.SortOrder == query.SortOrderAscending {
+				whereFn = v2AddAfterIDWhereClause
+			}
+			return whereFn(queryBuilder, params.ComparisonID, params.ComparisonTimestamp)
+		},
+		v2SortOrderColumns(params.SortOrder),
+		1,
+		params.Offset,
+	)
+	if err != nil {
+		return "", err
+	}
+	query, args, err := builder.Column("n.id").ToSql()
 	if err != nil {
 		return "", err
 	}
@@ -595,24 +805,48 @@ func V2ListNotifications(ctx context.Context, tx *sql.Tx, params *V2Notification
 	return result, nil
 }
 
+// V2ListNotificationPage returns a single page of a notification listing for version 2 of the API, without the total
+// or the boundary IDs, for callers that only need the notifications themselves.
+func V2ListNotificationPage(
+	ctx context.Context,
+	tx *sql.Tx,
+	params *V2NotificationListingParameters,
+) ([]*model.Notification, error) {
+	listing, err := v2GetListing(ctx, tx, params)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to obtain the notification listing")
+	}
+	return listing, nil
+}
+
 // GetNotification returns the notification with the given ID.
 func GetNotification(ctx context.Context, tx *sql.Tx, user string, id string) (*model.Notification, error) {
 	wrapMsg := "unable to look up the notification"
 
 	// Begin building the query.
-	queryBuilder := psql.Select().
-		Column("nt.name AS type").
-		Column("n.seen").
-		Column("n.deleted").
-		Column("n.outgoing_json AS message").
-		From("notifications n").
-		Join("users u ON n.user_id = u.id").
-		Join("notification_types nt ON n.notification_type_id = nt.id").
-		Where(sq.Eq{"u.username": user}).
-		Where(sq.Eq{"n.id": id})
+	queryBuilder, err := selectUserNotifications(
+		user,
+		func(queryBuilder sq.SelectBuilder) (sq.SelectBuilder, error) {
+			return queryBuilder.
+				Column("nt.name AS type").
+				Column("n.seen").
+				Column("n.deleted").
+				Column("n.outgoing_json AS message").
+				Column("n.seen_at").
+				Column("n.deleted_at").
+				Join("notification_types nt ON n.notification_type_id = nt.id").
+				Where(sq.Eq{"n.id": id}), nil
+		},
+		nil,
+		0,
+		0,
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, wrapMsg)
+	}
 
 	// Generate the query.
-	query, args, err := queryBuilder.ToSql()
+	query, args, err := queryBuilder.Column("*").ToSql()
 	if err != nil {
 		return nil, errors.Wrap(err, wrapMsg)
 	}
@@ -630,9 +864,10 @@ func GetNotification(ctx context.Context, tx *sql.Tx, user string, id string) (*
 		var notificationType string
 		var messageText []byte
 		var seen, deleted bool
+		var seenAt, deletedAt *time.Time
 
 		// Fetch the data for the current row from the database.
-		err = rows.Scan(&notificationType, &seen, &deleted, &messageText)
+		err = rows.Scan(&notificationType, &seen, &deleted, &messageText, &seenAt, &deletedAt)
 		if err != nil {
 			return nil, errors.Wrap(err, wrapMsg)
 		}
@@ -642,7 +877,111 @@ func GetNotification(ctx context.Context, tx *sql.Tx, user string, id string) (*
 		if err != nil {
 			return nil, errors.Wrap(err, wrapMsg)
 		}
+		message.SeenAt = seenAt
+		message.DeletedAt = deletedAt
 	}
 
 	return message, nil
 }
+
+// addCursorWhereClause adds a component to the WHERE clause ensuring that only messages that come after the position in
+// a cursor will be included in a listing sorted by timestamp and ID. The row comparison can use the index on the sort
+// columns directly.
+func addCursorWhereClause(queryBuilder sq.SelectBuilder, cursor *query.Cursor) sq.SelectBuilder {
+	comparison := ">"
+	if cursor.SortOrder == query.SortOrderDescending {
+		comparison = "<"
+	}
+	return queryBuilder.Where(
+		sq.Expr(fmt.Sprintf("(n.time_created, n.id) %s (?, ?)", comparison), cursor.TimeCreated, cursor.ID),
+	)
+}
+
+// V3NotificationListingParameters describes the parameters available for listing notifications in version 3 of the
+// API. The filters are the same as in version 2, but the version 2 paging fields (BeforeID, BeforeTimestamp, AfterID,
+// AfterTimestamp, and CountOnly) are ignored in favor of the cursor. The sort order in the cursor takes precedence
+// over the one in the filters.
+type V3NotificationListingParameters struct {
+	V2NotificationListingParameters
+
+	// If specified, the listing starts just after the notification that the cursor points to.
+	Cursor *query.Cursor
+}
+
+// V3NotificationPage is a single page of a notification listing in version 3 of the API.
+type V3NotificationPage struct {
+	Messages []*model.Notification
+
+	// Points to the last notification in the listing if there are more notifications to list, nil otherwise.
+	Next *query.Cursor
+}
+
+// V3ListNotifications lists a page of notifications for a user. The page starts just after the cursor and the query
+// fetches one notification more than the limit, which tells us whether there's another page without running any
+// other queries.
+func V3ListNotifications(ctx context.Context, tx *sql.Tx, params *V3NotificationListingParameters) (*V3NotificationPage, error) {
+	wrapMsg := "unable to obtain the notification listing"
+
+	// Determine the sort order.
+	sortOrder := params.SortOrder
+	if params.Cursor != nil {
+		sortOrder = params.Cursor.SortOrder
+	}
+
+	// Begin building the query, skipping to the position in the cursor if there is one.
+	limit := params.Limit
+	if limit > 0 {
+		limit++
+	}
+	queryBuilder, err := selectUserNotifications(
+		params.User,
+		func(queryBuilder sq.SelectBuilder) (sq.SelectBuilder, error) {
+			queryBuilder = v2AddListingColumns(v2ApplyFilters(queryBuilder, &params.V2NotificationListingParameters), params.Search)
+			if params.Cursor != nil {
+				queryBuilder = addCursorWhereClause(queryBuilder, params.Cursor)
+			}
+			return queryBuilder, nil
+		},
+		v2SortOrderColumns(sortOrder),
+		limit,
+		0,
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, wrapMsg)
+	}
+
+	// Generate the query.
+	listingQuery, args, err := queryBuilder.Column("*").ToSql()
+	if err != nil {
+		return nil, errors.Wrap(err, wrapMsg)
+	}
+
+	// Execute the query.
+	rows, err := tx.QueryContext(ctx, listingQuery, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, wrapMsg)
+	}
+	defer func() { _ = rows.Close() }()
+
+	// Build the listing from the result set, stopping at the extra notification if there is one.
+	result := &V3NotificationPage{Messages: make([]*model.Notification, 0)}
+	var last query.Cursor
+	for rows.Next() {
+		if params.Limit > 0 && uint64(len(result.Messages)) == params.Limit {
+			result.Next = &last
+			break
+		}
+
+		message, id, timeCreated, err := v2ScanListingRow(rows, params.Search)
+		if err != nil {
+			return nil, errors.Wrap(err, wrapMsg)
+		}
+		result.Messages = append(result.Messages, message)
+		last = query.Cursor{TimeCreated: timeCreated, ID: id, SortOrder: sortOrder}
+	}
+	if err = rows.Err(); err != nil {
+		return nil, errors.Wrap(err, wrapMsg)
+	}
+
+	return result, nil
+}
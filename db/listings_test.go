@@ -0,0 +1,317 @@
+package db
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/cyverse-de/notifications/model"
+	"github.com/cyverse-de/notifications/query"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestV2ListNotificationsAppliesFiltersToEveryQuery(t *testing.T) {
+	assert := assert.New(t)
+
+	db, mock, err := sqlmock.New()
+	assert.NoError(err, "unable to open the mock database connection")
+	defer func() { _ = db.Close() }()
+
+	// The filters are applied to the notifications addressed to the user, whose query has one argument of its own,
+	// and to the broadcasts, whose query has two.
+	createdAfter := time.Date(2026, time.October, 5, 0, 0, 0, 0, time.UTC)
+	createdBefore := createdAfter.AddDate(0, 0, 7)
+	filters := func(first int) string {
+		return regexp.QuoteMeta(fmt.Sprintf(
+			"WHERE n.deleted = $%d AND nt.name IN ($%d,$%d) AND n.time_created > $%d AND n.time_created < $%d",
+			first, first+1, first+2, first+3, first+4,
+		))
+	}
+	args := []driver.Value{
+		"sarahr@example.org", false, "data", "analysis", createdAfter, createdBefore,
+		"sarahr@example.org", "sarahr@example.org", false, "data", "analysis", createdAfter, createdBefore,
+	}
+
+	// The listing, the count, and the boundary ID query all have to be filtered the same way, or the total and the
+	// paging links wouldn't match the listing. The listing and the boundary ID query sort and limit each kind of
+	// notification before merging them.
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT \\* FROM \\(SELECT \\* FROM .* " + filters(2) + " ORDER BY .* LIMIT 10 \\) " +
+		"UNION ALL .* " + filters(9) + " ORDER BY .* LIMIT 10\\)\\) AS n ORDER BY").
+		WithArgs(args...).
+		WillReturnRows(sqlmock.NewRows(
+			[]string{"type", "seen", "deleted", "message", "id", "time_created", "seen_at", "deleted_at"},
+		))
+	mock.ExpectQuery("SELECT \\(SELECT count\\(\\*\\) FROM .* " + filters(2) + "\\) \\+ " +
+		"\\(SELECT count\\(\\*\\) FROM .* " + filters(9) + "\\) AS count$").
+		WithArgs(args...).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectQuery("SELECT n.id FROM .* " + filters(2) + " ORDER BY .* UNION ALL .* " + filters(9) + " ORDER BY").
+		WithArgs(args...).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectRollback()
+
+	tx, err := db.Begin()
+	assert.NoError(err, "unable to begin a transaction")
+
+	listing, err := V2ListNotifications(context.Background(), tx, &V2NotificationListingParameters{
+		User:              "sarahr@example.org",
+		Limit:             10,
+		Seen:              true,
+		SortOrder:         query.SortOrderDescending,
+		NotificationTypes: []string{"Data", "analysis"},
+		CreatedAfter:      &createdAfter,
+		CreatedBefore:     &createdBefore,
+	})
+	if assert.NoError(err) {
+		assert.Equal(0, listing.Total)
+		assert.Empty(listing.Messages)
+	}
+
+	_ = tx.Rollback()
+	assert.NoError(mock.ExpectationsWereMet(), "not all mock expectations were met")
+}
+
+func TestV2ListNotificationsSearch(t *testing.T) {
+	assert := assert.New(t)
+
+	db, mock, err := sqlmock.New()
+	assert.NoError(err, "unable to open the mock database connection")
+	defer func() { _ = db.Close() }()
+
+	const search = `"word count" -failed`
+	const id = "0bd8d8a2-3cbc-11eb-8a0b-f64e9b87c109"
+	timeCreated := time.Date(2026, time.October, 5, 0, 0, 0, 0, time.UTC)
+
+	// The search columns come before the filters, so their placeholders are numbered first.
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(
+		"SELECT * FROM (SELECT * FROM (( SELECT nt.name AS type, n.seen, n.deleted, n.outgoing_json AS message, n.id, "+
+			"n.time_created AS time_created, n.seen_at, n.deleted_at, "+
+			"ts_rank(n.search_vector, websearch_to_tsquery('english', $1)) AS search_rank, "+
+			"ts_headline('english', n.subject, websearch_to_tsquery('english', $2), "+
+			"'StartSel=\"\uE000\", StopSel=\"\uE001\", HighlightAll=true') AS search_subject, "+
+			"ts_headline('english', COALESCE(n.outgoing_json->'message'->>'text', ''), "+
+			"websearch_to_tsquery('english', $3), 'StartSel=\"\uE000\", StopSel=\"\uE001\"') AS search_text "+
+			"FROM (SELECT un.id",
+	)+".*"+regexp.QuoteMeta(
+		") AS n JOIN notification_types nt ON n.notification_type_id = nt.id "+
+			"WHERE n.deleted = $5 AND n.search_vector @@ websearch_to_tsquery('english', $6) ) UNION ALL ",
+	)+".*"+regexp.QuoteMeta(
+		"WHERE n.deleted = $12 AND n.search_vector @@ websearch_to_tsquery('english', $13))) AS n "+
+			"ORDER BY n.time_created DESC, n.id DESC) AS listing ORDER BY time_created DESC, id DESC",
+	)).
+		WithArgs(
+			search, search, search, "sarahr@example.org", false, search,
+			search, search, search, "sarahr@example.org", "sarahr@example.org", false, search,
+		).
+		WillReturnRows(
+			sqlmock.NewRows([]string{
+				"type", "seen", "deleted", "message", "id", "time_created", "seen_at", "deleted_at",
+				"search_rank", "search_subject", "search_text",
+			}).AddRow(
+				"analysis", true, false, []byte(`{"message":{"id":"`+id+`"},"subject":"word count completed"}`), id,
+				timeCreated, timeCreated, nil,
+				0.6,
+				"\uE000word\uE001 \uE000count\uE001 <img src=x onerror=alert(1)> completed",
+				"\uE000word\uE001 \uE000count\uE001 completed",
+			),
+		)
+	mock.ExpectQuery(regexp.QuoteMeta("n.search_vector @@ websearch_to_tsquery('english', $7)) AS count")).
+		WithArgs("sarahr@example.org", false, search, "sarahr@example.org", "sarahr@example.org", false, search).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectRollback()
+
+	tx, err := db.Begin()
+	assert.NoError(err, "unable to begin a transaction")
+
+	listing, err := V2ListNotifications(context.Background(), tx, &V2NotificationListingParameters{
+		User:      "sarahr@example.org",
+		Seen:      true,
+		SortOrder: query.SortOrderDescending,
+		Search:    search,
+	})
+	if assert.NoError(err) && assert.Len(listing.Messages, 1) {
+		assert.Equal(&model.SearchMatch{
+			Rank:    0.6,
+			Subject: "<mark>word</mark> <mark>count</mark> &lt;img src=x onerror=alert(1)&gt; completed",
+			Text:    "<mark>word</mark> <mark>count</mark> completed",
+		}, listing.Messages[0].Search)
+	}
+
+	_ = tx.Rollback()
+	assert.NoError(mock.ExpectationsWereMet(), "not all mock expectations were met")
+}
+
+func TestV3ListNotificationsFetchesOneExtraRow(t *testing.T) {
+	assert := assert.New(t)
+
+	db, mock, err := sqlmock.New()
+	assert.NoError(err, "unable to open the mock database connection")
+	defer func() { _ = db.Close() }()
+
+	cursorTime := time.Date(2026, time.October, 5, 12, 0, 0, 0, time.UTC)
+	const cursorID = "0bd8d8a2-3cbc-11eb-8a0b-f64e9b87c109"
+	ids := []string{"14d2d5b4-3cbc-11eb-8a0b-f64e9b87c109", "1c5e9a3e-3cbc-11eb-8a0b-f64e9b87c109"}
+	columns := []string{"type", "seen", "deleted", "message", "id", "time_created", "seen_at", "deleted_at"}
+
+	// The sort order comes from the cursor, and there are no count or boundary queries.
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(
+		"WHERE n.deleted = $2 AND (n.time_created, n.id) > ($3, $4) "+
+			"ORDER BY n.time_created ASC, n.id ASC LIMIT 2 ) UNION ALL ",
+	)+".*"+regexp.QuoteMeta(
+		"WHERE n.deleted = $7 AND (n.time_created, n.id) > ($8, $9) "+
+			"ORDER BY n.time_created ASC, n.id ASC LIMIT 2)) AS n ORDER BY n.time_created ASC, n.id ASC LIMIT 2",
+	)).
+		WithArgs(
+			"sarahr@example.org", false, cursorTime, cursorID,
+			"sarahr@example.org", "sarahr@example.org", false, cursorTime, cursorID,
+		).
+		WillReturnRows(
+			sqlmock.NewRows(columns).
+				AddRow("analysis", true, false, []byte(`{"subject":"first"}`), ids[0], cursorTime.Add(time.Minute), nil, nil).
+				AddRow("analysis", true, false, []byte(`{"subject":"second"}`), ids[1], cursorTime.Add(time.Hour), nil, nil),
+		)
+	mock.ExpectRollback()
+
+	tx, err := db.Begin()
+	assert.NoError(err, "unable to begin a transaction")
+
+	page, err := V3ListNotifications(context.Background(), tx, &V3NotificationListingParameters{
+		V2NotificationListingParameters: V2NotificationListingParameters{
+			User:      "sarahr@example.org",
+			Limit:     1,
+			Seen:      true,
+			SortOrder: query.SortOrderDescending,
+		},
+		Cursor: &query.Cursor{TimeCreated: cursorTime, ID: cursorID, SortOrder: query.SortOrderAscending},
+	})
+	if assert.NoError(err) && assert.Len(page.Messages, 1) {
+		assert.Equal("first", page.Messages[0].Subject)
+		assert.Equal(
+			&query.Cursor{TimeCreated: cursorTime.Add(time.Minute), ID: ids[0], SortOrder: query.SortOrderAscending},
+			page.Next,
+		)
+	}
+
+	_ = tx.Rollback()
+	assert.NoError(mock.ExpectationsWereMet(), "not all mock expectations were met")
+}
+
+func TestV1ListNotificationsWithOffset(t *testing.T) {
+	assert := assert.New(t)
+
+	db, mock, err := sqlmock.New()
+	assert.NoError(err, "unable to open the mock database connection")
+	defer func() { _ = db.Close() }()
+
+	timeCreated := time.Date(2026, time.October, 5, 12, 0, 0, 0, time.UTC)
+	const id = "14d2d5b4-3cbc-11eb-8a0b-f64e9b87c109"
+
+	// Existing clients page with offsets, as they always have. Each kind of notification is limited to the rows up to
+	// the end of the page, and the offset is applied once they've been merged.
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT \(SELECT count\(\*\) FROM \(SELECT un.id`).
+		WithArgs("sarahr@example.org", false, false, "sarahr@example.org", "sarahr@example.org", false, false).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(4))
+	mock.ExpectQuery(regexp.QuoteMeta(
+		"SELECT n.type, n.seen, n.deleted, n.message, n.id, n.time_created FROM (( SELECT nt.name AS type, "+
+			"n.seen, n.deleted, n.outgoing_json AS message, n.id, n.time_created, n.subject FROM (SELECT un.id",
+	)+".* ORDER BY n.time_created DESC, n.id DESC LIMIT 12 \\) UNION ALL .*"+regexp.QuoteMeta(
+		"ORDER BY n.time_created DESC, n.id DESC LIMIT 12)) AS n ORDER BY n.time_created DESC, n.id DESC LIMIT 2 OFFSET 10",
+	)+"$").
+		WithArgs("sarahr@example.org", false, "sarahr@example.org", "sarahr@example.org", false).
+		WillReturnRows(
+			sqlmock.NewRows([]string{"type", "seen", "deleted", "message", "id", "time_created"}).
+				AddRow("analysis", true, false, []byte(`{"subject":"first"}`), id, timeCreated),
+		)
+	mock.ExpectQuery(`SELECT \(SELECT count\(\*\) FROM \(SELECT un.id`).
+		WithArgs("sarahr@example.org", false, "sarahr@example.org", "sarahr@example.org", false).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(11))
+	mock.ExpectRollback()
+
+	tx, err := db.Begin()
+	assert.NoError(err, "unable to begin a transaction")
+
+	listing, next, err := V1ListNotifications(context.Background(), tx, &V1NotificationListingParameters{
+		User:      "sarahr@example.org",
+		Offset:    10,
+		Limit:     1,
+		SortOrder: query.SortOrderDescending,
+		SortField: query.V1ListingSortFieldTimestamp,
+	})
+	if assert.NoError(err) {
+		assert.Len(listing.Messages, 1)
+		assert.Equal("11", listing.Total)
+		assert.Equal("4", listing.UnseenTotal)
+		assert.Nil(next, "no cursor should be issued for the last page")
+	}
+
+	_ = tx.Rollback()
+	assert.NoError(mock.ExpectationsWereMet(), "not all mock expectations were met")
+}
+
+func TestV1ListNotificationsWithCursor(t *testing.T) {
+	assert := assert.New(t)
+
+	db, mock, err := sqlmock.New()
+	assert.NoError(err, "unable to open the mock database connection")
+	defer func() { _ = db.Close() }()
+
+	cursorTime := time.Date(2026, time.October, 5, 12, 0, 0, 0, time.UTC)
+	const cursorID = "0bd8d8a2-3cbc-11eb-8a0b-f64e9b87c109"
+	ids := []string{"14d2d5b4-3cbc-11eb-8a0b-f64e9b87c109", "1c5e9a3e-3cbc-11eb-8a0b-f64e9b87c109"}
+
+	// The total isn't requested, so it isn't counted.
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT \(SELECT count\(\*\) FROM \(SELECT un.id`).
+		WithArgs("sarahr@example.org", false, false, "sarahr@example.org", "sarahr@example.org", false, false).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(4))
+	mock.ExpectQuery(regexp.QuoteMeta(
+		"SELECT n.type, n.seen, n.deleted, n.message, n.id, n.time_created FROM (( SELECT nt.name AS type",
+	)+".*"+regexp.QuoteMeta(
+		"WHERE n.deleted = $2 AND (n.time_created, n.id) < ($3, $4) "+
+			"ORDER BY n.time_created DESC, n.id DESC LIMIT 2 ) UNION ALL ",
+	)+".*"+regexp.QuoteMeta(
+		"WHERE n.deleted = $7 AND (n.time_created, n.id) < ($8, $9) "+
+			"ORDER BY n.time_created DESC, n.id DESC LIMIT 2)) AS n ORDER BY n.time_created DESC, n.id DESC LIMIT 2",
+	)+"$").
+		WithArgs(
+			"sarahr@example.org", false, cursorTime, cursorID,
+			"sarahr@example.org", "sarahr@example.org", false, cursorTime, cursorID,
+		).
+		WillReturnRows(
+			sqlmock.NewRows([]string{"type", "seen", "deleted", "message", "id", "time_created"}).
+				AddRow("analysis", true, false, []byte(`{"subject":"first"}`), ids[0], cursorTime.Add(-time.Minute)).
+				AddRow("analysis", true, false, []byte(`{"subject":"second"}`), ids[1], cursorTime.Add(-time.Hour)),
+		)
+	mock.ExpectRollback()
+
+	tx, err := db.Begin()
+	assert.NoError(err, "unable to begin a transaction")
+
+	listing, next, err := V1ListNotifications(context.Background(), tx, &V1NotificationListingParameters{
+		User:      "sarahr@example.org",
+		Limit:     1,
+		SortOrder: query.SortOrderAscending,
+		SortField: query.V1ListingSortFieldTimestamp,
+		Cursor:    &query.Cursor{TimeCreated: cursorTime, ID: cursorID, SortOrder: query.SortOrderDescending},
+		OmitTotal: true,
+	})
+	if assert.NoError(err) {
+		assert.Len(listing.Messages, 1)
+		assert.Empty(listing.Total)
+		assert.Equal(
+			&query.Cursor{TimeCreated: cursorTime.Add(-time.Minute), ID: ids[0], SortOrder: query.SortOrderDescending},
+			next,
+		)
+	}
+
+	_ = tx.Rollback()
+	assert.NoError(mock.ExpectationsWereMet(), "not all mock expectations were met")
+}
@@ -48,11 +48,15 @@ func GetNotificationTypeID(ctx context.Context, tx *sql.Tx, name string) (string
 func GetNotificationTimestamp(ctx context.Context, tx *sql.Tx, notificationID string) (*time.Time, error) {
 	wrapMsg := fmt.Sprintf("unable to get the timestmp for notification %s", notificationID)
 
-	// Build the query.
+	// Build the query. The notification may be a broadcast.
 	query, args, err := psql.Select().
 		Column("time_created").
 		From("notifications").
 		Where(sq.Eq{"id": notificationID}).
+		SuffixExpr(sq.ConcatExpr(
+			"UNION ALL ",
+			sq.Select("time_created").From("broadcasts").Where(sq.Eq{"id": notificationID}),
+		)).
 		ToSql()
 	if err != nil {
 		return nil, errors.Wrap(err, wrapMsg)
@@ -80,7 +84,7 @@ func GetNotificationTimestamp(ctx context.Context, tx *sql.Tx, notificationID st
 }
 
 // FilterMissingIDs returns the IDs in the given ID list that refer to notifications that either don't exist or were
-// not directed to the user with the given user ID.
+// not directed to the user with the given user ID. Broadcasts that include the user count as directed to the user.
 func FilterMissingIDs(ctx context.Context, tx *sql.Tx, userID string, ids []string) ([]string, error) {
 	wrapMsg := "error encountered while verifying notification IDs"
 
@@ -90,6 +94,14 @@ func FilterMissingIDs(ctx context.Context, tx *sql.Tx, userID string, ids []stri
 		Column("id").
 		Where(sq.Eq{"id": ids}).
 		Where(sq.Eq{"user_id": userID}).
+		SuffixExpr(sq.ConcatExpr(
+			"UNION ALL ",
+			sq.Select("b.id").
+				From("broadcasts b").
+				Join("users u ON u.id = ?", userID).
+				Where(sq.Eq{"b.id": ids}).
+				Where(broadcastVisibleToUser),
+		)).
 		ToSql()
 	if err != nil {
 		return nil, errors.Wrap(err, wrapMsg)
@@ -19,13 +19,13 @@ func CountUnreadNotifications(ctx context.Context, tx *sql.Tx, user string) (int
 	var total int64
 
 	// Build the statement to count the unread notifications.
-	statement, args, err := psql.Select("count(*)").
-		From("notifications n").
-		Join("users u ON n.user_id = u.id").
-		Where(sq.Eq{"u.username": user}).
-		Where(sq.Eq{"n.deleted": false}).
-		Where(sq.Eq{"n.seen": false}).
-		ToSql()
+	countBuilder, err := countUserNotifications(user, func(queryBuilder sq.SelectBuilder) (sq.SelectBuilder, error) {
+		return queryBuilder.Where(sq.Eq{"n.deleted": false}).Where(sq.Eq{"n.seen": false}), nil
+	})
+	if err != nil {
+		return 0, errors.Wrap(err, wrapMsg)
+	}
+	statement, args, err := countBuilder.ToSql()
 	if err != nil {
 		return 0, errors.Wrap(err, wrapMsg)
 	}
@@ -0,0 +1,99 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+// NotificationEventChannel is the PostgreSQL notification channel that notification events are sent on. Every
+// replica of the service listens on it, so that each replica hears about notifications recorded by the others.
+const NotificationEventChannel = "notification_events"
+
+// NotificationEventRecorded is the kind of event that's sent when a notification is recorded.
+const NotificationEventRecorded = "recorded"
+
+// NotificationEventBroadcast is the kind of event that's sent when a broadcast notification is sent. Broadcast events
+// aren't addressed to a single user.
+const NotificationEventBroadcast = "broadcast"
+
+// NotificationEventUnseenCount is the kind of event that's sent when a user's unseen notification count changes
+// without a new notification having been recorded.
+const NotificationEventUnseenCount = "unseen_count"
+
+// NotificationEvent is the payload of a notification on NotificationEventChannel. The payload carries identifiers
+// rather than the notification itself because PostgreSQL limits payloads to 8000 bytes.
+type NotificationEvent struct {
+
+	// The kind of event.
+	Kind string `json:"kind"`
+
+	// The notification ID.
+	ID string `json:"id,omitempty"`
+
+	// The qualified username of the notification recipient. Broadcast events don't have one.
+	User string `json:"user,omitempty"`
+
+	// The recipient's unseen notification count, for unseen count events.
+	Total *int64 `json:"total,omitempty"`
+}
+
+// ParseNotificationEvent parses the payload of a notification on NotificationEventChannel.
+func ParseNotificationEvent(payload string) (*NotificationEvent, error) {
+	var event NotificationEvent
+	if err := json.Unmarshal([]byte(payload), &event); err != nil {
+		return nil, errors.Wrap(err, "unable to parse the notification event")
+	}
+	return &event, nil
+}
+
+// sendNotificationEvent sends an event on NotificationEventChannel. PostgreSQL holds the event until the transaction
+// commits and drops it if the transaction rolls back, so listeners only hear about committed changes.
+func sendNotificationEvent(ctx context.Context, tx *sql.Tx, event *NotificationEvent) error {
+	wrapMsg := fmt.Sprintf("unable to send the %s notification event", event.Kind)
+
+	// Serialize the event.
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return errors.Wrap(err, wrapMsg)
+	}
+
+	// NOTIFY doesn't accept bind parameters, but pg_notify does.
+	_, err = tx.ExecContext(ctx, "SELECT pg_notify($1, $2)", NotificationEventChannel, string(payload))
+	if err != nil {
+		return errors.Wrap(err, wrapMsg)
+	}
+
+	return nil
+}
+
+// AnnounceNotification tells every replica that a notification was recorded once the transaction commits.
+func AnnounceNotification(ctx context.Context, tx *sql.Tx, id, user string) error {
+	return sendNotificationEvent(ctx, tx, &NotificationEvent{
+		Kind: NotificationEventRecorded,
+		ID:   id,
+		User: user,
+	})
+}
+
+// AnnounceUnseenCount tells every replica what a user's unseen notification count is once the transaction commits.
+func AnnounceUnseenCount(ctx context.Context, tx *sql.Tx, user string, total int64) error {
+	return sendNotificationEvent(ctx, tx, &NotificationEvent{
+		Kind:  NotificationEventUnseenCount,
+		User:  user,
+		Total: &total,
+	})
+}
+
+// AnnounceBroadcast tells every replica that a broadcast notification was sent once the transaction commits. Each
+// replica works out which of the users who are streaming from it the broadcast was sent to, because the list of
+// recipients wouldn't fit in the event.
+func AnnounceBroadcast(ctx context.Context, tx *sql.Tx, id string) error {
+	return sendNotificationEvent(ctx, tx, &NotificationEvent{
+		Kind: NotificationEventBroadcast,
+		ID:   id,
+	})
+}
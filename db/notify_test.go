@@ -0,0 +1,66 @@
+package db
+
+import (
+	"context"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAnnounceNotification(t *testing.T) {
+	assert := assert.New(t)
+
+	db, mock, err := sqlmock.New()
+	assert.NoError(err, "unable to open the mock database connection")
+	defer func() { _ = db.Close() }()
+
+	// The event goes out through pg_notify so that it's only delivered if the transaction commits.
+	mock.ExpectBegin()
+	mock.ExpectExec(`SELECT pg_notify\(\$1, \$2\)`).
+		WithArgs(
+			NotificationEventChannel,
+			`{"kind":"recorded","id":"46ae63be-7030-4cdd-8eb9-66aa49fcf38b","user":"sarahr@example.org"}`,
+		).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectRollback()
+
+	tx, err := db.Begin()
+	assert.NoError(err, "unable to begin a transaction")
+	err = AnnounceNotification(context.Background(), tx, "46ae63be-7030-4cdd-8eb9-66aa49fcf38b", "sarahr@example.org")
+	assert.NoError(err)
+	_ = tx.Rollback()
+
+	assert.NoError(mock.ExpectationsWereMet(), "not all mock expectations were met")
+}
+
+func TestAnnounceBroadcast(t *testing.T) {
+	assert := assert.New(t)
+
+	db, mock, err := sqlmock.New()
+	assert.NoError(err, "unable to open the mock database connection")
+	defer func() { _ = db.Close() }()
+
+	// A broadcast event isn't addressed to anyone in particular.
+	mock.ExpectBegin()
+	mock.ExpectExec(`SELECT pg_notify\(\$1, \$2\)`).
+		WithArgs(NotificationEventChannel, `{"kind":"broadcast","id":"46ae63be-7030-4cdd-8eb9-66aa49fcf38b"}`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectRollback()
+
+	tx, err := db.Begin()
+	assert.NoError(err, "unable to begin a transaction")
+	assert.NoError(AnnounceBroadcast(context.Background(), tx, "46ae63be-7030-4cdd-8eb9-66aa49fcf38b"))
+	_ = tx.Rollback()
+
+	assert.NoError(mock.ExpectationsWereMet(), "not all mock expectations were met")
+}
+
+func TestParseNotificationEvent(t *testing.T) {
+	event, err := ParseNotificationEvent(`{"kind":"recorded","id":"1","user":"sarahr@example.org"}`)
+	assert.NoError(t, err)
+	assert.Equal(t, &NotificationEvent{Kind: NotificationEventRecorded, ID: "1", User: "sarahr@example.org"}, event)
+
+	_, err = ParseNotificationEvent("{not json")
+	assert.Error(t, err, "a malformed payload must be reported")
+}
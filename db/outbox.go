@@ -0,0 +1,220 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+
+	sq "github.com/Masterminds/squirrel"
+)
+
+const (
+	// OutboxKindEmail identifies outbox messages that contain email requests.
+	OutboxKindEmail = "email"
+
+	// OutboxKindNotification identifies outbox messages that contain notification messages for the DE UI.
+	OutboxKindNotification = "notification"
+)
+
+// SaveOutboxMessage stores a message that has to be published once the transaction commits. Storing the message in
+// the same transaction as the notification it belongs to means that it's published if and only if the notification
+// is recorded.
+func SaveOutboxMessage(ctx context.Context, tx *sql.Tx, notificationID, kind string, message interface{}) error {
+	wrapMsg := fmt.Sprintf("unable to save the %s message for notification %s in the outbox", kind, notificationID)
+
+	// Marshal the message.
+	messageJSON, err := json.Marshal(message)
+	if err != nil {
+		return errors.Wrap(err, wrapMsg)
+	}
+
+	// Build the statement.
+	statement, args, err := psql.Insert("outbox").
+		Columns("notification_id", "kind", "message").
+		Values(notificationID, kind, messageJSON).
+		ToSql()
+	if err != nil {
+		return errors.Wrap(err, wrapMsg)
+	}
+
+	// Execute the statement.
+	_, err = tx.ExecContext(ctx, statement, args...)
+	if err != nil {
+		return errors.Wrap(err, wrapMsg)
+	}
+
+	return nil
+}
+
+// OutboxMessage describes a message in the outbox that hasn't been published yet.
+type OutboxMessage struct {
+	ID             string
+	NotificationID string
+	Kind           string
+	Message        json.RawMessage
+	Attempts       int
+}
+
+// ClaimOutboxMessages locks and returns up to limit messages that haven't been published or marked as failed yet and
+// are due to be tried at the given time, oldest first. Messages that another transaction has already claimed are
+// skipped, so two replicas can never publish the same message at the same time. The messages stay locked until the
+// transaction ends. A message that was rescheduled isn't due until its next attempt, so newer messages can be claimed
+// ahead of it.
+func ClaimOutboxMessages(ctx context.Context, tx *sql.Tx, now time.Time, limit uint64) ([]*OutboxMessage, error) {
+	wrapMsg := "unable to claim the outbox messages"
+
+	// Build the query.
+	query, args, err := psql.Select().
+		Column("id").
+		Column("notification_id").
+		Column("kind").
+		Column("message").
+		Column("attempts").
+		From("outbox").
+		Where("sent_at IS NULL").
+		Where("failed_at IS NULL").
+		Where(sq.LtOrEq{"next_attempt_at": now}).
+		OrderBy("time_created", "id").
+		Limit(limit).
+		Suffix("FOR UPDATE SKIP LOCKED").
+		ToSql()
+	if err != nil {
+		return nil, errors.Wrap(err, wrapMsg)
+	}
+
+	// Execute the query.
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, wrapMsg)
+	}
+	defer func() { _ = rows.Close() }()
+
+	// Build the list of messages.
+	messages := make([]*OutboxMessage, 0)
+	for rows.Next() {
+		var message OutboxMessage
+		var messageJSON []byte
+		err = rows.Scan(&message.ID, &message.NotificationID, &message.Kind, &messageJSON, &message.Attempts)
+		if err != nil {
+			return nil, errors.Wrap(err, wrapMsg)
+		}
+		message.Message = messageJSON
+		messages = append(messages, &message)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, errors.Wrap(err, wrapMsg)
+	}
+
+	return messages, nil
+}
+
+// MarkOutboxMessagesSent records that outbox messages were published at the given time.
+func MarkOutboxMessagesSent(ctx context.Context, tx *sql.Tx, ids []string, sentAt time.Time) error {
+	wrapMsg := "unable to mark the outbox messages as sent"
+
+	// Build the statement.
+	statement, args, err := psql.Update("outbox").
+		Set("sent_at", sentAt).
+		Set("attempts", sq.Expr("attempts + 1")).
+		Where(sq.Eq{"id": ids}).
+		ToSql()
+	if err != nil {
+		return errors.Wrap(err, wrapMsg)
+	}
+
+	// Execute the statement.
+	_, err = tx.ExecContext(ctx, statement, args...)
+	if err != nil {
+		return errors.Wrap(err, wrapMsg)
+	}
+
+	return nil
+}
+
+// RescheduleOutboxMessage records a failed attempt to publish an outbox message, along with the time of the next
+// attempt.
+func RescheduleOutboxMessage(
+	ctx context.Context,
+	tx *sql.Tx,
+	id string,
+	nextAttemptAt time.Time,
+	lastError string,
+) error {
+	wrapMsg := fmt.Sprintf("unable to reschedule outbox message %s", id)
+
+	// Build the statement.
+	statement, args, err := psql.Update("outbox").
+		Set("attempts", sq.Expr("attempts + 1")).
+		Set("next_attempt_at", nextAttemptAt).
+		Set("last_error", lastError).
+		Where(sq.Eq{"id": id}).
+		ToSql()
+	if err != nil {
+		return errors.Wrap(err, wrapMsg)
+	}
+
+	// Execute the statement.
+	_, err = tx.ExecContext(ctx, statement, args...)
+	if err != nil {
+		return errors.Wrap(err, wrapMsg)
+	}
+
+	return nil
+}
+
+// MarkOutboxMessageFailed records that an outbox message can never be published, along with the reason why. The
+// message is no longer tried, but it's kept so that the problem can be looked into.
+func MarkOutboxMessageFailed(ctx context.Context, tx *sql.Tx, id string, failedAt time.Time, lastError string) error {
+	wrapMsg := fmt.Sprintf("unable to mark outbox message %s as failed", id)
+
+	// Build the statement.
+	statement, args, err := psql.Update("outbox").
+		Set("attempts", sq.Expr("attempts + 1")).
+		Set("failed_at", failedAt).
+		Set("last_error", lastError).
+		Where(sq.Eq{"id": id}).
+		ToSql()
+	if err != nil {
+		return errors.Wrap(err, wrapMsg)
+	}
+
+	// Execute the statement.
+	_, err = tx.ExecContext(ctx, statement, args...)
+	if err != nil {
+		return errors.Wrap(err, wrapMsg)
+	}
+
+	return nil
+}
+
+// PurgeSentOutboxMessages permanently deletes the outbox messages that were published before the given time,
+// returning the number of messages that were deleted.
+func PurgeSentOutboxMessages(ctx context.Context, tx *sql.Tx, before time.Time) (int64, error) {
+	wrapMsg := "unable to purge the sent outbox messages"
+
+	// Build the statement.
+	statement, args, err := psql.Delete("outbox").
+		Where(sq.Lt{"sent_at": before}).
+		ToSql()
+	if err != nil {
+		return 0, errors.Wrap(err, wrapMsg)
+	}
+
+	// Execute the statement.
+	result, err := tx.ExecContext(ctx, statement, args...)
+	if err != nil {
+		return 0, errors.Wrap(err, wrapMsg)
+	}
+
+	// Determine the number of messages that were deleted.
+	count, err := result.RowsAffected()
+	if err != nil {
+		return 0, errors.Wrap(err, wrapMsg)
+	}
+
+	return count, nil
+}
@@ -0,0 +1,207 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/cyverse-de/notifications/model"
+	"github.com/pkg/errors"
+
+	sq "github.com/Masterminds/squirrel"
+)
+
+// ListPreferences lists a user's preferences for every known notification type. The defaults are
+// listed for notification types that the user hasn't set any preferences for, and for users who
+// aren't in the database yet.
+func ListPreferences(ctx context.Context, tx *sql.Tx, user string) ([]*model.NotificationPreference, error) {
+	wrapMsg := fmt.Sprintf("unable to list the notification preferences for %s", user)
+
+	// Build the query. The user is matched in the join condition rather than the where clause so that notification
+	// types without a preference row are still listed.
+	query, args, err := psql.Select().
+		Column("nt.name").
+		Column("COALESCE(p.email, true)").
+		Column("COALESCE(p.record, true)").
+		Column("COALESCE(p.digest, '')").
+		From("notification_types nt").
+		JoinClause(
+			"LEFT JOIN (user_preferences p JOIN users u ON p.user_id = u.id) "+
+				"ON p.notification_type_id = nt.id AND u.username = ?",
+			user,
+		).
+		OrderBy("nt.name").
+		ToSql()
+	if err != nil {
+		return nil, errors.Wrap(err, wrapMsg)
+	}
+
+	// Execute the query.
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, wrapMsg)
+	}
+	defer func() { _ = rows.Close() }()
+
+	// Build the list of preferences.
+	preferences := make([]*model.NotificationPreference, 0)
+	for rows.Next() {
+		var preference model.NotificationPreference
+		err = rows.Scan(&preference.Type, &preference.Email, &preference.Record, &preference.Digest)
+		if err != nil {
+			return nil, errors.Wrap(err, wrapMsg)
+		}
+		preferences = append(preferences, &preference)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, errors.Wrap(err, wrapMsg)
+	}
+
+	return preferences, nil
+}
+
+// GetPreference returns a user's preferences for a single notification type. The defaults are
+// returned if the user hasn't set any preferences for the notification type.
+func GetPreference(ctx context.Context, tx *sql.Tx, user, notificationType string) (*model.NotificationPreference, error) {
+	wrapMsg := fmt.Sprintf("unable to look up the %s notification preferences for %s", notificationType, user)
+
+	// Build the query.
+	query, args, err := psql.Select().
+		Column("p.email").
+		Column("p.record").
+		Column("COALESCE(p.digest, '')").
+		From("user_preferences p").
+		Join("users u ON p.user_id = u.id").
+		Join("notification_types nt ON p.notification_type_id = nt.id").
+		Where(sq.Eq{"u.username": user}).
+		Where(sq.Eq{"nt.name": notificationType}).
+		ToSql()
+	if err != nil {
+		return nil, errors.Wrap(err, wrapMsg)
+	}
+
+	// Execute the query; it's not an error if there are no results.
+	preference := model.DefaultNotificationPreference(notificationType)
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&preference.Email, &preference.Record, &preference.Digest)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, errors.Wrap(err, wrapMsg)
+	}
+
+	return preference, nil
+}
+
+// SavePreference stores a user's preferences for a single notification type, replacing any
+// preferences that were already stored for it.
+func SavePreference(
+	ctx context.Context,
+	tx *sql.Tx,
+	userID string,
+	notificationTypeID string,
+	preference *model.NotificationPreference,
+) error {
+	wrapMsg := fmt.Sprintf("unable to save the %s notification preferences", preference.Type)
+
+	// An empty digest period is stored as NULL.
+	var digest *string
+	if preference.Digest != "" {
+		digest = &preference.Digest
+	}
+
+	// Build the statement.
+	statement, args, err := psql.Insert("user_preferences").
+		Columns("user_id", "notification_type_id", "email", "record", "digest").
+		Values(userID, notificationTypeID, preference.Email, preference.Record, digest).
+		Suffix(
+			"ON CONFLICT (user_id, notification_type_id) DO UPDATE " +
+				"SET email = EXCLUDED.email, record = EXCLUDED.record, digest = EXCLUDED.digest",
+		).
+		ToSql()
+	if err != nil {
+		return errors.Wrap(err, wrapMsg)
+	}
+
+	// Execute the statement.
+	_, err = tx.ExecContext(ctx, statement, args...)
+	if err != nil {
+		return errors.Wrap(err, wrapMsg)
+	}
+
+	return nil
+}
+
+// GetQuietHours returns a user's quiet hours, or nil if the user hasn't set any.
+func GetQuietHours(ctx context.Context, tx *sql.Tx, user string) (*model.QuietHours, error) {
+	wrapMsg := fmt.Sprintf("unable to look up the quiet hours for %s", user)
+
+	// Build the query.
+	query, args, err := psql.Select().
+		Column("q.time_zone").
+		Column("to_char(q.start_time, 'HH24:MI')").
+		Column("to_char(q.end_time, 'HH24:MI')").
+		From("user_quiet_hours q").
+		Join("users u ON q.user_id = u.id").
+		Where(sq.Eq{"u.username": user}).
+		ToSql()
+	if err != nil {
+		return nil, errors.Wrap(err, wrapMsg)
+	}
+
+	// Execute the query; it's not an error if there are no results.
+	var quietHours model.QuietHours
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&quietHours.TimeZone, &quietHours.Start, &quietHours.End)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, wrapMsg)
+	}
+
+	return &quietHours, nil
+}
+
+// SaveQuietHours stores a user's quiet hours, replacing any that were already stored.
+func SaveQuietHours(ctx context.Context, tx *sql.Tx, userID string, quietHours *model.QuietHours) error {
+	wrapMsg := "unable to save the quiet hours"
+
+	// Build the statement.
+	statement, args, err := psql.Insert("user_quiet_hours").
+		Columns("user_id", "time_zone", "start_time", "end_time").
+		Values(userID, quietHours.TimeZone, quietHours.Start, quietHours.End).
+		Suffix(
+			"ON CONFLICT (user_id) DO UPDATE " +
+				"SET time_zone = EXCLUDED.time_zone, start_time = EXCLUDED.start_time, end_time = EXCLUDED.end_time",
+		).
+		ToSql()
+	if err != nil {
+		return errors.Wrap(err, wrapMsg)
+	}
+
+	// Execute the statement.
+	_, err = tx.ExecContext(ctx, statement, args...)
+	if err != nil {
+		return errors.Wrap(err, wrapMsg)
+	}
+
+	return nil
+}
+
+// DeleteQuietHours removes a user's quiet hours. It's not an error if the user hasn't set any.
+func DeleteQuietHours(ctx context.Context, tx *sql.Tx, userID string) error {
+	wrapMsg := "unable to delete the quiet hours"
+
+	// Build the statement.
+	statement, args, err := psql.Delete("user_quiet_hours").
+		Where(sq.Eq{"user_id": userID}).
+		ToSql()
+	if err != nil {
+		return errors.Wrap(err, wrapMsg)
+	}
+
+	// Execute the statement.
+	_, err = tx.ExecContext(ctx, statement, args...)
+	if err != nil {
+		return errors.Wrap(err, wrapMsg)
+	}
+
+	return nil
+}
@@ -0,0 +1,77 @@
+package db
+
+import (
+	"context"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/cyverse-de/notifications/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetPreferenceDefaultsWhenNoneAreStored(t *testing.T) {
+	assert := assert.New(t)
+
+	db, mock, err := sqlmock.New()
+	assert.NoError(err, "unable to open the mock database connection")
+	defer func() { _ = db.Close() }()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT p.email, p.record, COALESCE\\(p.digest, ''\\) FROM user_preferences p").
+		WithArgs("sarahr@example.org", "analysis").
+		WillReturnRows(sqlmock.NewRows([]string{"email", "record", "digest"}))
+	mock.ExpectQuery("SELECT p.email, p.record, COALESCE\\(p.digest, ''\\) FROM user_preferences p").
+		WithArgs("sarahr@example.org", "analysis_periodic_notification").
+		WillReturnRows(sqlmock.NewRows([]string{"email", "record", "digest"}).AddRow(false, true, "weekly"))
+	mock.ExpectRollback()
+
+	tx, err := db.Begin()
+	assert.NoError(err, "unable to begin a transaction")
+
+	preference, err := GetPreference(context.Background(), tx, "sarahr@example.org", "analysis")
+	assert.NoError(err)
+	assert.Equal(model.DefaultNotificationPreference("analysis"), preference)
+
+	preference, err = GetPreference(context.Background(), tx, "sarahr@example.org", "analysis_periodic_notification")
+	assert.NoError(err)
+	assert.Equal(&model.NotificationPreference{Type: "analysis_periodic_notification", Email: false, Record: true, Digest: "weekly"}, preference)
+
+	_ = tx.Rollback()
+	assert.NoError(mock.ExpectationsWereMet(), "not all mock expectations were met")
+}
+
+func TestListPreferencesIncludesEveryNotificationType(t *testing.T) {
+	assert := assert.New(t)
+
+	db, mock, err := sqlmock.New()
+	assert.NoError(err, "unable to open the mock database connection")
+	defer func() { _ = db.Close() }()
+
+	// The user has to be matched in the join condition; matching it in a where clause would drop the
+	// notification types that the user hasn't set any preferences for.
+	mock.ExpectBegin()
+	mock.ExpectQuery(
+		`SELECT nt.name, COALESCE\(p.email, true\), COALESCE\(p.record, true\), COALESCE\(p.digest, ''\) FROM notification_types nt ` +
+			`LEFT JOIN \(user_preferences p JOIN users u ON p.user_id = u.id\) ` +
+			`ON p.notification_type_id = nt.id AND u.username = \$1 ORDER BY nt.name`,
+	).
+		WithArgs("sarahr@example.org").
+		WillReturnRows(
+			sqlmock.NewRows([]string{"name", "email", "record", "digest"}).
+				AddRow("analysis", true, true, "").
+				AddRow("analysis_periodic_notification", false, true, ""),
+		)
+	mock.ExpectRollback()
+
+	tx, err := db.Begin()
+	assert.NoError(err, "unable to begin a transaction")
+	preferences, err := ListPreferences(context.Background(), tx, "sarahr@example.org")
+	assert.NoError(err)
+	assert.Equal([]*model.NotificationPreference{
+		{Type: "analysis", Email: true, Record: true},
+		{Type: "analysis_periodic_notification", Email: false, Record: true},
+	}, preferences)
+	_ = tx.Rollback()
+
+	assert.NoError(mock.ExpectationsWereMet(), "not all mock expectations were met")
+}
@@ -0,0 +1,122 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/pkg/errors"
+
+	sq "github.com/Masterminds/squirrel"
+)
+
+// PurgeNotificationsParameters describes a single batch of notifications to hard-delete.
+type PurgeNotificationsParameters struct {
+
+	// True to purge notifications that have been marked as deleted, or false to purge notifications that have been
+	// marked as seen.
+	Deleted bool
+
+	// Only notifications that were marked as deleted or as seen before this time are purged. Notifications that were
+	// marked before the time was recorded are aged by the time they were created instead.
+	Before time.Time
+
+	// If this is set, only notifications of these types are purged.
+	Types []string
+
+	// Notifications of these types are never purged. This is used to leave the types with a retention rule of their
+	// own alone while the default rule is applied.
+	ExcludedTypes []string
+
+	// The maximum number of notifications to purge.
+	Limit uint64
+}
+
+// PurgeNotifications permanently deletes a batch of notifications that have been marked as deleted or as seen,
+// returning the number of notifications that were deleted. Rows that another transaction has locked are skipped
+// rather than waited on, so concurrent purges never block each other or the API.
+func PurgeNotifications(ctx context.Context, tx *sql.Tx, params *PurgeNotificationsParameters) (int64, error) {
+	wrapMsg := "unable to purge notifications"
+
+	// Select the batch of notifications to purge.
+	batch := psql.Select("n.id").
+		From("notifications n").
+		Limit(params.Limit).
+		Suffix("FOR UPDATE OF n SKIP LOCKED")
+	if params.Deleted {
+		batch = batch.
+			Where(sq.Expr("COALESCE(n.deleted_at, n.time_created) < ?", params.Before)).
+			Where(sq.Eq{"n.deleted": true})
+	} else {
+		batch = batch.
+			Where(sq.Expr("COALESCE(n.seen_at, n.time_created) < ?", params.Before)).
+			Where(sq.Eq{"n.seen": true})
+	}
+	if len(params.Types) > 0 || len(params.ExcludedTypes) > 0 {
+		batch = batch.Join("notification_types nt ON n.notification_type_id = nt.id")
+	}
+	if len(params.Types) > 0 {
+		batch = batch.Where(sq.Eq{"nt.name": params.Types})
+	}
+	if len(params.ExcludedTypes) > 0 {
+		batch = batch.Where(sq.NotEq{"nt.name": params.ExcludedTypes})
+	}
+
+	// Build the statement.
+	statement, args, err := psql.Delete("notifications").
+		Where(batch.Prefix("id IN (").Suffix(")")).
+		ToSql()
+	if err != nil {
+		return 0, errors.Wrap(err, wrapMsg)
+	}
+
+	// Execute the statement.
+	result, err := tx.ExecContext(ctx, statement, args...)
+	if err != nil {
+		return 0, errors.Wrap(err, wrapMsg)
+	}
+
+	// Determine the number of notifications that were deleted.
+	count, err := result.RowsAffected()
+	if err != nil {
+		return 0, errors.Wrap(err, wrapMsg)
+	}
+
+	return count, nil
+}
+
+// PurgeBroadcasts permanently deletes a batch of broadcasts that were created before the given time, returning the
+// number of broadcasts that were deleted. Each recipient's seen and deleted flags go along with the broadcast. As
+// with notifications, rows that another transaction has locked are skipped rather than waited on.
+func PurgeBroadcasts(ctx context.Context, tx *sql.Tx, before time.Time, limit uint64) (int64, error) {
+	wrapMsg := "unable to purge broadcasts"
+
+	// Select the batch of broadcasts to purge.
+	batch := psql.Select("b.id").
+		From("broadcasts b").
+		Where(sq.Lt{"b.time_created": before}).
+		Limit(limit).
+		Suffix("FOR UPDATE OF b SKIP LOCKED")
+
+	// Build the statement.
+	statement, args, err := psql.Delete("broadcasts").
+		Where(batch.Prefix("id IN (").Suffix(")")).
+		ToSql()
+	if err != nil {
+		return 0, errors.Wrap(err, wrapMsg)
+	}
+
+	// Execute the statement.
+	result, err := tx.ExecContext(ctx, statement, args...)
+	if err != nil {
+		return 0, errors.Wrap(err, wrapMsg)
+	}
+
+	// Determine the number of broadcasts that were deleted.
+	count, err := result.RowsAffected()
+	if err != nil {
+		return 0, errors.Wrap(err, wrapMsg)
+	}
+
+	return count, nil
+}
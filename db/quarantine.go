@@ -0,0 +1,240 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/cyverse-de/notifications/model"
+	"github.com/pkg/errors"
+
+	sq "github.com/Masterminds/squirrel"
+)
+
+// QuarantineEvent stores a notification event that couldn't be recorded, so that it can be fixed and replayed later.
+func QuarantineEvent(ctx context.Context, tx *sql.Tx, routingKey string, body []byte, errorText string) error {
+	wrapMsg := "unable to quarantine the notification event"
+
+	// Build the statement.
+	statement, args, err := psql.Insert("quarantined_events").
+		Columns("routing_key", "body", "error").
+		Values(routingKey, body, errorText).
+		ToSql()
+	if err != nil {
+		return errors.Wrap(err, wrapMsg)
+	}
+
+	// Execute the statement.
+	_, err = tx.ExecContext(ctx, statement, args...)
+	if err != nil {
+		return errors.Wrap(err, wrapMsg)
+	}
+
+	return nil
+}
+
+// quarantinedEvents returns a query for the quarantined events.
+func quarantinedEvents() sq.SelectBuilder {
+	return psql.Select().
+		Column("id").
+		Column("routing_key").
+		Column("body").
+		Column("error").
+		Column("time_quarantined").
+		Column("time_updated").
+		Column("time_replayed").
+		From("quarantined_events")
+}
+
+// scanQuarantinedEvent scans a single row returned by a quarantinedEvents query.
+func scanQuarantinedEvent(row sq.RowScanner) (*model.QuarantinedEvent, error) {
+	var event model.QuarantinedEvent
+	var body []byte
+	var timeUpdated, timeReplayed sql.NullTime
+
+	err := row.Scan(
+		&event.ID,
+		&event.RoutingKey,
+		&body,
+		&event.Error,
+		&event.TimeQuarantined,
+		&timeUpdated,
+		&timeReplayed,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	event.Body = string(body)
+	if timeUpdated.Valid {
+		event.TimeUpdated = &timeUpdated.Time
+	}
+	if timeReplayed.Valid {
+		event.TimeReplayed = &timeReplayed.Time
+	}
+
+	return &event, nil
+}
+
+// ListQuarantinedEvents lists every quarantined event, most recent first.
+func ListQuarantinedEvents(ctx context.Context, tx *sql.Tx) ([]*model.QuarantinedEvent, error) {
+	wrapMsg := "unable to list the quarantined notification events"
+
+	// Build the query.
+	query, args, err := quarantinedEvents().
+		OrderBy("time_quarantined DESC", "id DESC").
+		ToSql()
+	if err != nil {
+		return nil, errors.Wrap(err, wrapMsg)
+	}
+
+	// Execute the query.
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, wrapMsg)
+	}
+	defer func() { _ = rows.Close() }()
+
+	// Build the listing from the result set.
+	events := make([]*model.QuarantinedEvent, 0)
+	for rows.Next() {
+		event, err := scanQuarantinedEvent(rows)
+		if err != nil {
+			return nil, errors.Wrap(err, wrapMsg)
+		}
+		events = append(events, event)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, errors.Wrap(err, wrapMsg)
+	}
+
+	return events, nil
+}
+
+// GetQuarantinedEvent returns a single quarantined event, or nil if it doesn't exist.
+func GetQuarantinedEvent(ctx context.Context, tx *sql.Tx, id string) (*model.QuarantinedEvent, error) {
+	return getQuarantinedEvent(ctx, tx, id, quarantinedEvents())
+}
+
+// GetQuarantinedEventForUpdate works like GetQuarantinedEvent, but also locks the event until the transaction ends,
+// so that concurrent transactions that lock the same event take turns.
+func GetQuarantinedEventForUpdate(ctx context.Context, tx *sql.Tx, id string) (*model.QuarantinedEvent, error) {
+	return getQuarantinedEvent(ctx, tx, id, quarantinedEvents().Suffix("FOR UPDATE"))
+}
+
+// getQuarantinedEvent looks up a single quarantined event with the given query, returning nil if it doesn't exist.
+func getQuarantinedEvent(
+	ctx context.Context,
+	tx *sql.Tx,
+	id string,
+	queryBuilder sq.SelectBuilder,
+) (*model.QuarantinedEvent, error) {
+	wrapMsg := fmt.Sprintf("unable to look up quarantined notification event %s", id)
+
+	// Build the query.
+	query, args, err := queryBuilder.
+		Where(sq.Eq{"id": id}).
+		ToSql()
+	if err != nil {
+		return nil, errors.Wrap(err, wrapMsg)
+	}
+
+	// Execute the query.
+	event, err := scanQuarantinedEvent(tx.QueryRowContext(ctx, query, args...))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, wrapMsg)
+	}
+
+	return event, nil
+}
+
+// updateQuarantinedEvent sets columns of a single quarantined event, returning the number of events that were
+// updated.
+func updateQuarantinedEvent(ctx context.Context, tx *sql.Tx, id string, values map[string]interface{}) (int, error) {
+	wrapMsg := fmt.Sprintf("unable to update quarantined notification event %s", id)
+
+	// Build the statement.
+	statement, args, err := psql.Update("quarantined_events").
+		SetMap(values).
+		Where(sq.Eq{"id": id}).
+		ToSql()
+	if err != nil {
+		return 0, errors.Wrap(err, wrapMsg)
+	}
+
+	// Execute the statement.
+	result, err := tx.ExecContext(ctx, statement, args...)
+	if err != nil {
+		return 0, errors.Wrap(err, wrapMsg)
+	}
+
+	// Determine how many rows were affected.
+	count, err := result.RowsAffected()
+	if err != nil {
+		return 0, errors.Wrap(err, wrapMsg)
+	}
+
+	return int(count), nil
+}
+
+// UpdateQuarantinedEvent replaces the routing key and body of a quarantined event so that it can be replayed after
+// the problem with it has been fixed. The number of events that were updated is returned.
+func UpdateQuarantinedEvent(
+	ctx context.Context,
+	tx *sql.Tx,
+	id string,
+	routingKey string,
+	body []byte,
+	updatedAt time.Time,
+) (int, error) {
+	return updateQuarantinedEvent(ctx, tx, id, map[string]interface{}{
+		"routing_key":  routingKey,
+		"body":         body,
+		"time_updated": updatedAt,
+	})
+}
+
+// MarkQuarantinedEventReplayed records that a quarantined event was replayed successfully.
+func MarkQuarantinedEventReplayed(ctx context.Context, tx *sql.Tx, id string, replayedAt time.Time) (int, error) {
+	return updateQuarantinedEvent(ctx, tx, id, map[string]interface{}{
+		"time_replayed": replayedAt,
+	})
+}
+
+// SetQuarantinedEventError records the error from an unsuccessful attempt to replay a quarantined event.
+func SetQuarantinedEventError(ctx context.Context, tx *sql.Tx, id string, errorText string) (int, error) {
+	return updateQuarantinedEvent(ctx, tx, id, map[string]interface{}{
+		"error": errorText,
+	})
+}
+
+// DeleteQuarantinedEvent permanently removes a quarantined event. The number of events that were removed is returned.
+func DeleteQuarantinedEvent(ctx context.Context, tx *sql.Tx, id string) (int, error) {
+	wrapMsg := fmt.Sprintf("unable to delete quarantined notification event %s", id)
+
+	// Build the statement.
+	statement, args, err := psql.Delete("quarantined_events").
+		Where(sq.Eq{"id": id}).
+		ToSql()
+	if err != nil {
+		return 0, errors.Wrap(err, wrapMsg)
+	}
+
+	// Execute the statement.
+	result, err := tx.ExecContext(ctx, statement, args...)
+	if err != nil {
+		return 0, errors.Wrap(err, wrapMsg)
+	}
+
+	// Determine how many rows were affected.
+	count, err := result.RowsAffected()
+	if err != nil {
+		return 0, errors.Wrap(err, wrapMsg)
+	}
+
+	return int(count), nil
+}
@@ -0,0 +1,22 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+)
+
+// CountAndAnnounceUnseen counts the notifications that the user hasn't seen yet and tells every replica of the service
+// about the new count once the transaction commits. It should be called after the user's notifications have been
+// updated.
+func CountAndAnnounceUnseen(ctx context.Context, tx *sql.Tx, user string) (int64, error) {
+	total, err := CountUnreadNotifications(ctx, tx, user)
+	if err != nil {
+		return 0, err
+	}
+
+	if err = AnnounceUnseenCount(ctx, tx, user, total); err != nil {
+		return 0, err
+	}
+
+	return total, nil
+}
@@ -9,6 +9,13 @@ import (
 	"github.com/pkg/errors"
 )
 
+// seenAtExpr and deletedAtExpr record the time that a message was first marked as seen or deleted. Marking a message
+// again leaves the original time alone; clearing the flag clears the time as well.
+var (
+	seenAtExpr    = sq.Expr("COALESCE(seen_at, now())")
+	deletedAtExpr = sq.Expr("COALESCE(deleted_at, now())")
+)
+
 // MarkMessageAsSeen marks a single message as seen in the database if it exists and is targeted to the user with the
 // given user ID. The number of messages that were updated is returned.
 func MarkMessageAsSeen(ctx context.Context, tx *sql.Tx, userID string, id string) (int, error) {
@@ -17,6 +24,7 @@ func MarkMessageAsSeen(ctx context.Context, tx *sql.Tx, userID string, id string
 	// Build the SQL statement.
 	statement, args, err := psql.Update("notifications").
 		Set("seen", true).
+		Set("seen_at", seenAtExpr).
 		Where(sq.Eq{"user_id": userID}).
 		Where(sq.Eq{"id": id}).
 		ToSql()
@@ -36,7 +44,13 @@ func MarkMessageAsSeen(ctx context.Context, tx *sql.Tx, userID string, id string
 		return 0, errors.Wrap(err, wrapMsg)
 	}
 
-	return int(count), nil
+	// Update the broadcasts that include the user as well.
+	broadcastCount, err := setBroadcastFlag(ctx, tx, userID, "seen", true, &broadcastStateFilter{IDs: []string{id}})
+	if err != nil {
+		return 0, errors.Wrap(err, wrapMsg)
+	}
+
+	return int(count) + broadcastCount, nil
 }
 
 // DeleteMessage marks a single message as deleted in the database if it exists and is targeted to the user with the
@@ -47,6 +61,7 @@ func DeleteMessage(ctx context.Context, tx *sql.Tx, userID string, id string) (i
 	// Build the SQL statement.
 	statement, args, err := psql.Update("notifications").
 		Set("deleted", true).
+		Set("deleted_at", deletedAtExpr).
 		Where(sq.Eq{"user_id": userID}).
 		Where(sq.Eq{"id": id}).
 		ToSql()
@@ -66,7 +81,13 @@ func DeleteMessage(ctx context.Context, tx *sql.Tx, userID string, id string) (i
 		return 0, errors.Wrap(err, wrapMsg)
 	}
 
-	return int(count), nil
+	// Update the broadcasts that include the user as well.
+	broadcastCount, err := setBroadcastFlag(ctx, tx, userID, "deleted", true, &broadcastStateFilter{IDs: []string{id}})
+	if err != nil {
+		return 0, errors.Wrap(err, wrapMsg)
+	}
+
+	return int(count) + broadcastCount, nil
 }
 
 // MarkMessagesAsSeen takes a list of UUIDs and marks the corresponding messages as seen in the database if the
@@ -78,6 +99,7 @@ func MarkMessagesAsSeen(ctx context.Context, tx *sql.Tx, userID string, uuids []
 	// Build the SQL statement.
 	statement, args, err := psql.Update("notifications").
 		Set("seen", true).
+		Set("seen_at", seenAtExpr).
 		Where(sq.Eq{"user_id": userID}).
 		Where(sq.Eq{"id": uuids}).
 		ToSql()
@@ -97,7 +119,13 @@ func MarkMessagesAsSeen(ctx context.Context, tx *sql.Tx, userID string, uuids []
 		return 0, errors.Wrap(err, wrapMsg)
 	}
 
-	return int(count), nil
+	// Update the broadcasts that include the user as well.
+	broadcastCount, err := setBroadcastFlag(ctx, tx, userID, "seen", true, &broadcastStateFilter{IDs: uuids})
+	if err != nil {
+		return 0, errors.Wrap(err, wrapMsg)
+	}
+
+	return int(count) + broadcastCount, nil
 }
 
 // MarkAllMessagesAsSeen marks all messages in the database that are targeted for the specified user ID as having
@@ -108,6 +136,7 @@ func MarkAllMessagesAsSeen(ctx context.Context, tx *sql.Tx, userID string) (int,
 	// Build the SQL statement.
 	statement, args, err := psql.Update("notifications").
 		Set("seen", true).
+		Set("seen_at", seenAtExpr).
 		Where(sq.Eq{"user_id": userID}).
 		Where(sq.Eq{"seen": false}).
 		ToSql()
@@ -127,7 +156,13 @@ func MarkAllMessagesAsSeen(ctx context.Context, tx *sql.Tx, userID string) (int,
 		return 0, errors.Wrap(err, wrapMsg)
 	}
 
-	return int(count), nil
+	// Update the broadcasts that include the user as well.
+	broadcastCount, err := setBroadcastFlag(ctx, tx, userID, "seen", true, &broadcastStateFilter{})
+	if err != nil {
+		return 0, errors.Wrap(err, wrapMsg)
+	}
+
+	return int(count) + broadcastCount, nil
 }
 
 // DeleteMessages takes a list of UUIDs and deletes the corresponding messages in the database if they exist and were
@@ -139,6 +174,7 @@ func DeleteMessages(ctx context.Context, tx *sql.Tx, userID string, uuids []stri
 	// Build the SQL statement.
 	statement, args, err := psql.Update("notifications").
 		Set("deleted", true).
+		Set("deleted_at", deletedAtExpr).
 		Where(sq.Eq{"user_id": userID}).
 		Where(sq.Eq{"id": uuids}).
 		ToSql()
@@ -158,7 +194,13 @@ func DeleteMessages(ctx context.Context, tx *sql.Tx, userID string, uuids []stri
 		return 0, errors.Wrap(err, wrapMsg)
 	}
 
-	return int(count), nil
+	// Update the broadcasts that include the user as well.
+	broadcastCount, err := setBroadcastFlag(ctx, tx, userID, "deleted", true, &broadcastStateFilter{IDs: uuids})
+	if err != nil {
+		return 0, errors.Wrap(err, wrapMsg)
+	}
+
+	return int(count) + broadcastCount, nil
 }
 
 // DeleteMatchingMessagesParameters represents the parameters that may be specified when deleting messages matching a
@@ -177,6 +219,7 @@ func DeleteMatchingMessages(ctx context.Context, tx *sql.Tx, userID string, para
 	// adding the WHERE clause to `Where("not deleted")` fixed the problem.
 	queryBuilder := psql.Update("notifications").
 		Set("deleted", true).
+		Set("deleted_at", deletedAtExpr).
 		Where("not deleted").
 		Where(sq.Eq{"user_id": userID})
 
@@ -208,5 +251,238 @@ func DeleteMatchingMessages(ctx context.Context, tx *sql.Tx, userID string, para
 		return 0, errors.Wrap(err, wrapMsg)
 	}
 
-	return int(count), nil
+	// Update the broadcasts that include the user as well.
+	broadcastCount, err := setBroadcastFlag(ctx, tx, userID, "deleted", true, &broadcastStateFilter{
+		Seen:               params.Seen,
+		NotificationTypeID: params.NotificationTypeID,
+	})
+	if err != nil {
+		return 0, errors.Wrap(err, wrapMsg)
+	}
+
+	return int(count) + broadcastCount, nil
+}
+
+// RestoreMessage marks a single deleted message as no longer deleted if it exists and is targeted to the user with the
+// given user ID. The number of messages that were updated is returned.
+func RestoreMessage(ctx context.Context, tx *sql.Tx, userID string, id string) (int, error) {
+	wrapMsg := fmt.Sprintf("unable to restore message %s", id)
+
+	// Build the SQL statement.
+	statement, args, err := psql.Update("notifications").
+		Set("deleted", false).
+		Set("deleted_at", nil).
+		Where(sq.Eq{"user_id": userID}).
+		Where(sq.Eq{"id": id}).
+		ToSql()
+	if err != nil {
+		return 0, errors.Wrap(err, wrapMsg)
+	}
+
+	// Execute the SQL statement.
+	result, err := tx.ExecContext(ctx, statement, args...)
+	if err != nil {
+		return 0, errors.Wrap(err, wrapMsg)
+	}
+
+	// Determine how many rows were affected. We require any DBMS that we use to support this.
+	count, err := result.RowsAffected()
+	if err != nil {
+		return 0, errors.Wrap(err, wrapMsg)
+	}
+
+	// Update the broadcasts that include the user as well.
+	broadcastCount, err := setBroadcastFlag(ctx, tx, userID, "deleted", false, &broadcastStateFilter{IDs: []string{id}})
+	if err != nil {
+		return 0, errors.Wrap(err, wrapMsg)
+	}
+
+	return int(count) + broadcastCount, nil
+}
+
+// RestoreMessages takes a list of UUIDs and marks the corresponding messages as no longer deleted in the database if
+// they exist and were targeted to the user with the given user ID. A count of the number of messages that were
+// eligible to be updated (even if some messages weren't deleted) is returned.
+func RestoreMessages(ctx context.Context, tx *sql.Tx, userID string, uuids []string) (int, error) {
+	wrapMsg := "unable to restore messages"
+
+	// Build the SQL statement.
+	statement, args, err := psql.Update("notifications").
+		Set("deleted", false).
+		Set("deleted_at", nil).
+		Where(sq.Eq{"user_id": userID}).
+		Where(sq.Eq{"id": uuids}).
+		ToSql()
+	if err != nil {
+		return 0, errors.Wrap(err, wrapMsg)
+	}
+
+	// Execute the SQL statement.
+	result, err := tx.ExecContext(ctx, statement, args...)
+	if err != nil {
+		return 0, errors.Wrap(err, wrapMsg)
+	}
+
+	// Determine how many rows were affected. We require any DBMS that we use to support this.
+	count, err := result.RowsAffected()
+	if err != nil {
+		return 0, errors.Wrap(err, wrapMsg)
+	}
+
+	// Update the broadcasts that include the user as well.
+	broadcastCount, err := setBroadcastFlag(ctx, tx, userID, "deleted", false, &broadcastStateFilter{IDs: uuids})
+	if err != nil {
+		return 0, errors.Wrap(err, wrapMsg)
+	}
+
+	return int(count) + broadcastCount, nil
+}
+
+// RestoreAllMessages marks all deleted messages in the database that are targeted for the specified user ID as no
+// longer deleted.
+func RestoreAllMessages(ctx context.Context, tx *sql.Tx, userID string) (int, error) {
+	wrapMsg := fmt.Sprintf("unable to restore all messages for user, %s", userID)
+
+	// Build the SQL statement. The WHERE clause uses a literal for the same reason as in DeleteMatchingMessages.
+	statement, args, err := psql.Update("notifications").
+		Set("deleted", false).
+		Set("deleted_at", nil).
+		Where("deleted").
+		Where(sq.Eq{"user_id": userID}).
+		ToSql()
+	if err != nil {
+		return 0, errors.Wrap(err, wrapMsg)
+	}
+
+	// Execute the SQL statement.
+	result, err := tx.ExecContext(ctx, statement, args...)
+	if err != nil {
+		return 0, errors.Wrap(err, wrapMsg)
+	}
+
+	// Determine how many rows were affected. We require any DBMS that we use to support this.
+	count, err := result.RowsAffected()
+	if err != nil {
+		return 0, errors.Wrap(err, wrapMsg)
+	}
+
+	// Update the broadcasts that include the user as well.
+	broadcastCount, err := setBroadcastFlag(ctx, tx, userID, "deleted", false, &broadcastStateFilter{})
+	if err != nil {
+		return 0, errors.Wrap(err, wrapMsg)
+	}
+
+	return int(count) + broadcastCount, nil
+}
+
+// MarkMessageAsUnseen marks a single message as not yet seen in the database if it exists and is targeted to the user
+// with the given user ID. The number of messages that were updated is returned.
+func MarkMessageAsUnseen(ctx context.Context, tx *sql.Tx, userID string, id string) (int, error) {
+	wrapMsg := fmt.Sprintf("unable to mark message %s as unseen", id)
+
+	// Build the SQL statement.
+	statement, args, err := psql.Update("notifications").
+		Set("seen", false).
+		Set("seen_at", nil).
+		Where(sq.Eq{"user_id": userID}).
+		Where(sq.Eq{"id": id}).
+		ToSql()
+	if err != nil {
+		return 0, errors.Wrap(err, wrapMsg)
+	}
+
+	// Execute the SQL statement.
+	result, err := tx.ExecContext(ctx, statement, args...)
+	if err != nil {
+		return 0, errors.Wrap(err, wrapMsg)
+	}
+
+	// Determine how many rows were affected. We require any DBMS that we use to support this.
+	count, err := result.RowsAffected()
+	if err != nil {
+		return 0, errors.Wrap(err, wrapMsg)
+	}
+
+	// Update the broadcasts that include the user as well.
+	broadcastCount, err := setBroadcastFlag(ctx, tx, userID, "seen", false, &broadcastStateFilter{IDs: []string{id}})
+	if err != nil {
+		return 0, errors.Wrap(err, wrapMsg)
+	}
+
+	return int(count) + broadcastCount, nil
+}
+
+// MarkMessagesAsUnseen takes a list of UUIDs and marks the corresponding messages as not yet seen in the database if
+// the corresponding messages exist and were targeted to the user with the given user ID. A count of the number of
+// messages that were eligible to be updated (even if some messages weren't marked as seen) is returned.
+func MarkMessagesAsUnseen(ctx context.Context, tx *sql.Tx, userID string, uuids []string) (int, error) {
+	wrapMsg := "unable to mark messages as unseen"
+
+	// Build the SQL statement.
+	statement, args, err := psql.Update("notifications").
+		Set("seen", false).
+		Set("seen_at", nil).
+		Where(sq.Eq{"user_id": userID}).
+		Where(sq.Eq{"id": uuids}).
+		ToSql()
+	if err != nil {
+		return 0, errors.Wrap(err, wrapMsg)
+	}
+
+	// Execute the SQL statement.
+	result, err := tx.ExecContext(ctx, statement, args...)
+	if err != nil {
+		return 0, errors.Wrap(err, wrapMsg)
+	}
+
+	// Determine how many rows were affected. We require any DBMS that we use to support this.
+	count, err := result.RowsAffected()
+	if err != nil {
+		return 0, errors.Wrap(err, wrapMsg)
+	}
+
+	// Update the broadcasts that include the user as well.
+	broadcastCount, err := setBroadcastFlag(ctx, tx, userID, "seen", false, &broadcastStateFilter{IDs: uuids})
+	if err != nil {
+		return 0, errors.Wrap(err, wrapMsg)
+	}
+
+	return int(count) + broadcastCount, nil
+}
+
+// MarkAllMessagesAsUnseen marks all messages in the database that are targeted for the specified user ID as not yet
+// having been seen by the user.
+func MarkAllMessagesAsUnseen(ctx context.Context, tx *sql.Tx, userID string) (int, error) {
+	wrapMsg := fmt.Sprintf("unable to mark all messages for user, %s, as unseen", userID)
+
+	// Build the SQL statement. The WHERE clause uses a literal for the same reason as in DeleteMatchingMessages.
+	statement, args, err := psql.Update("notifications").
+		Set("seen", false).
+		Set("seen_at", nil).
+		Where("seen").
+		Where(sq.Eq{"user_id": userID}).
+		ToSql()
+	if err != nil {
+		return 0, errors.Wrap(err, wrapMsg)
+	}
+
+	// Execute the SQL statement.
+	result, err := tx.ExecContext(ctx, statement, args...)
+	if err != nil {
+		return 0, errors.Wrap(err, wrapMsg)
+	}
+
+	// Determine how many rows were affected. We require any DBMS that we use to support this.
+	count, err := result.RowsAffected()
+	if err != nil {
+		return 0, errors.Wrap(err, wrapMsg)
+	}
+
+	// Update the broadcasts that include the user as well.
+	broadcastCount, err := setBroadcastFlag(ctx, tx, userID, "seen", false, &broadcastStateFilter{})
+	if err != nil {
+		return 0, errors.Wrap(err, wrapMsg)
+	}
+
+	return int(count) + broadcastCount, nil
 }
@@ -0,0 +1,121 @@
+package db
+
+import (
+	"context"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRestoreAllMessagesOnlyTouchesDeletedMessages(t *testing.T) {
+	assert := assert.New(t)
+
+	db, mock, err := sqlmock.New()
+	assert.NoError(err, "unable to open the mock database connection")
+	defer func() { _ = db.Close() }()
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE notifications SET deleted = \$1, deleted_at = \$2 WHERE deleted AND user_id = \$3`).
+		WithArgs(false, nil, "8d0f1e5e-3cbb-11eb-8a0b-f64e9b87c109").
+		WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectExec(`INSERT INTO broadcast_states`).
+		WithArgs("8d0f1e5e-3cbb-11eb-8a0b-f64e9b87c109", false).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+
+	tx, err := db.Begin()
+	assert.NoError(err, "unable to begin a transaction")
+
+	count, err := RestoreAllMessages(context.Background(), tx, "8d0f1e5e-3cbb-11eb-8a0b-f64e9b87c109")
+	assert.NoError(err)
+	assert.Equal(3, count)
+
+	_ = tx.Rollback()
+	assert.NoError(mock.ExpectationsWereMet(), "not all mock expectations were met")
+}
+
+func TestV2ListNotificationsListsDeletedMessagesOnRequest(t *testing.T) {
+	assert := assert.New(t)
+
+	db, mock, err := sqlmock.New()
+	assert.NoError(err, "unable to open the mock database connection")
+	defer func() { _ = db.Close() }()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT \(SELECT count\(\*\) FROM \(SELECT un.id.* WHERE n.deleted = \$2\) \+ .* WHERE n.deleted = \$5\) AS count$`).
+		WithArgs("sarahr@example.org", true, "sarahr@example.org", "sarahr@example.org", true).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+	mock.ExpectRollback()
+
+	tx, err := db.Begin()
+	assert.NoError(err, "unable to begin a transaction")
+
+	listing, err := V2ListNotifications(context.Background(), tx, &V2NotificationListingParameters{
+		User:      "sarahr@example.org",
+		Seen:      true,
+		CountOnly: true,
+		Deleted:   true,
+	})
+	assert.NoError(err)
+	assert.Equal(2, listing.Total)
+
+	_ = tx.Rollback()
+	assert.NoError(mock.ExpectationsWereMet(), "not all mock expectations were met")
+}
+
+func TestMarkMessagesAsUnseen(t *testing.T) {
+	assert := assert.New(t)
+
+	db, mock, err := sqlmock.New()
+	assert.NoError(err, "unable to open the mock database connection")
+	defer func() { _ = db.Close() }()
+
+	ids := []string{"0bd8d8a2-3cbc-11eb-8a0b-f64e9b87c109", "14d2d5b4-3cbc-11eb-8a0b-f64e9b87c109"}
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE notifications SET seen = \$1, seen_at = \$2 WHERE user_id = \$3 AND id IN \(\$4,\$5\)`).
+		WithArgs(false, nil, "8d0f1e5e-3cbb-11eb-8a0b-f64e9b87c109", ids[0], ids[1]).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec(`INSERT INTO broadcast_states`).
+		WithArgs("8d0f1e5e-3cbb-11eb-8a0b-f64e9b87c109", ids[0], ids[1]).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+
+	tx, err := db.Begin()
+	assert.NoError(err, "unable to begin a transaction")
+
+	count, err := MarkMessagesAsUnseen(context.Background(), tx, "8d0f1e5e-3cbb-11eb-8a0b-f64e9b87c109", ids)
+	assert.NoError(err)
+	assert.Equal(2, count)
+
+	_ = tx.Rollback()
+	assert.NoError(mock.ExpectationsWereMet(), "not all mock expectations were met")
+}
+
+func TestMarkMessageAsSeenKeepsTheFirstSeenTime(t *testing.T) {
+	assert := assert.New(t)
+
+	db, mock, err := sqlmock.New()
+	assert.NoError(err, "unable to open the mock database connection")
+	defer func() { _ = db.Close() }()
+
+	const id = "0bd8d8a2-3cbc-11eb-8a0b-f64e9b87c109"
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE notifications SET seen = \$1, seen_at = COALESCE\(seen_at, now\(\)\) WHERE user_id = \$2 AND id = \$3`).
+		WithArgs(true, "8d0f1e5e-3cbb-11eb-8a0b-f64e9b87c109", id).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`INSERT INTO broadcast_states`).
+		WithArgs("8d0f1e5e-3cbb-11eb-8a0b-f64e9b87c109", id).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+
+	tx, err := db.Begin()
+	assert.NoError(err, "unable to begin a transaction")
+
+	count, err := MarkMessageAsSeen(context.Background(), tx, "8d0f1e5e-3cbb-11eb-8a0b-f64e9b87c109", id)
+	assert.NoError(err)
+	assert.Equal(1, count)
+
+	_ = tx.Rollback()
+	assert.NoError(mock.ExpectationsWereMet(), "not all mock expectations were met")
+}
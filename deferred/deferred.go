@@ -0,0 +1,126 @@
+// Package deferred releases the notification emails that were held until the end of their recipients' quiet hours.
+package deferred
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/cyverse-de/messaging/v12"
+	"github.com/cyverse-de/notifications/db"
+	"github.com/sirupsen/logrus"
+)
+
+// log derives from the standard logrus logger, so the formatting and level that main sets up
+// apply here too.
+var log = logrus.WithFields(logrus.Fields{"package": "deferred"})
+
+const (
+	// checkInterval is how often the releaser looks for emails that are due. Deferred emails go out within this long
+	// of the end of their recipients' quiet hours.
+	checkInterval = time.Minute
+
+	// batchSize is the maximum number of emails that are claimed in a single transaction.
+	batchSize = 100
+)
+
+// MessagingClient is the subset of messaging.Client that the releaser uses.
+type MessagingClient interface {
+	PublishEmailRequestContext(context.Context, *messaging.EmailRequest) error
+}
+
+// Releaser periodically publishes the deferred emails that are due to the email request queue.
+type Releaser struct {
+	db              *sql.DB
+	messagingClient MessagingClient
+	now             func() time.Time
+}
+
+// NewReleaser returns a releaser that publishes emails with the given messaging client.
+func NewReleaser(db *sql.DB, messagingClient MessagingClient) *Releaser {
+	return &Releaser{
+		db:              db,
+		messagingClient: messagingClient,
+		now:             time.Now,
+	}
+}
+
+// Run releases the deferred emails that are due until the context is canceled.
+func (r *Releaser) Run(ctx context.Context) {
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+
+	for {
+		r.ReleaseDue(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ReleaseDue releases every deferred email that's due. Failures are logged; the emails that couldn't be released stay
+// in the database and are tried again the next time around.
+func (r *Releaser) ReleaseDue(ctx context.Context) {
+	now := r.now()
+	for ctx.Err() == nil {
+		released, claimed, err := r.releaseBatch(ctx, now)
+		if err != nil {
+			log.Errorf("unable to release the deferred emails: %s", err)
+			return
+		}
+
+		// Stop once the due emails run out, or if none of the batch could be published; the broker is probably down.
+		if claimed < batchSize || released == 0 {
+			return
+		}
+	}
+}
+
+// releaseBatch claims, publishes, and deletes a batch of deferred emails in a single transaction, returning the
+// number of emails that were released and the number that were claimed. A replica that finds the emails claimed
+// skips them. The one gap is a replica that dies between publishing and committing, which releases the emails again
+// later.
+func (r *Releaser) releaseBatch(ctx context.Context, now time.Time) (int, int, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	// Claim the emails that are due.
+	emails, err := db.ClaimDeferredEmails(ctx, tx, now, batchSize)
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(emails) == 0 {
+		return 0, 0, nil
+	}
+
+	// Publish them. An email that can't be published is left for the next attempt.
+	releasedIDs := make([]string, 0, len(emails))
+	for _, email := range emails {
+		if err = r.messagingClient.PublishEmailRequestContext(ctx, email.EmailRequest); err != nil {
+			log.Errorf("unable to release deferred email %s: %s", email.ID, err)
+			continue
+		}
+		releasedIDs = append(releasedIDs, email.ID)
+	}
+	if len(releasedIDs) == 0 {
+		return 0, len(emails), nil
+	}
+
+	// Remove the emails that were released.
+	if err = db.DeleteDeferredEmails(ctx, tx, releasedIDs); err != nil {
+		return 0, 0, err
+	}
+	if err = tx.Commit(); err != nil {
+		return 0, 0, err
+	}
+
+	return len(releasedIDs), len(emails), nil
+}
@@ -0,0 +1,78 @@
+package deferred
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/cyverse-de/messaging/v12"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeMessagingClient records the email requests it publishes, failing for the addresses in failFor.
+type fakeMessagingClient struct {
+	published []*messaging.EmailRequest
+	failFor   map[string]bool
+}
+
+func (f *fakeMessagingClient) PublishEmailRequestContext(_ context.Context, req *messaging.EmailRequest) error {
+	if f.failFor[req.ToAddress] {
+		return errors.New("the broker is unreachable")
+	}
+	f.published = append(f.published, req)
+	return nil
+}
+
+func TestReleaseDueLeavesEmailsThatCannotBePublished(t *testing.T) {
+	assert := assert.New(t)
+
+	database, mock, err := sqlmock.New()
+	assert.NoError(err, "unable to open the mock database connection")
+	defer func() { _ = database.Close() }()
+
+	now := time.Date(2026, time.October, 14, 7, 0, 30, 0, time.UTC)
+
+	// Only the email that was published is deleted.
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id, email_request FROM deferred_emails WHERE release_at <= \\$1 " +
+		"ORDER BY release_at, id LIMIT 100 FOR UPDATE SKIP LOCKED").
+		WithArgs(now).
+		WillReturnRows(
+			sqlmock.NewRows([]string{"id", "email_request"}).
+				AddRow("1", []byte(`{"template":"analysis_status_change","to":"sarahr@cyverse.org"}`)).
+				AddRow("2", []byte(`{"template":"analysis_status_change","to":"ipcdev@cyverse.org"}`)),
+		)
+	mock.ExpectExec("DELETE FROM deferred_emails WHERE id IN \\(\\$1\\)").
+		WithArgs("1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	messagingClient := &fakeMessagingClient{failFor: map[string]bool{"ipcdev@cyverse.org": true}}
+	releaser := NewReleaser(database, messagingClient)
+	releaser.now = func() time.Time { return now }
+	releaser.ReleaseDue(context.Background())
+
+	assert.NoError(mock.ExpectationsWereMet(), "not all mock expectations were met")
+	if assert.Len(messagingClient.published, 1) {
+		assert.Equal("sarahr@cyverse.org", messagingClient.published[0].ToAddress)
+	}
+}
+
+func TestReleaseDueWithNothingDue(t *testing.T) {
+	database, mock, err := sqlmock.New()
+	assert.NoError(t, err, "unable to open the mock database connection")
+	defer func() { _ = database.Close() }()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id, email_request FROM deferred_emails").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "email_request"}))
+	mock.ExpectRollback()
+
+	messagingClient := &fakeMessagingClient{}
+	NewReleaser(database, messagingClient).ReleaseDue(context.Background())
+
+	assert.NoError(t, mock.ExpectationsWereMet(), "not all mock expectations were met")
+	assert.Empty(t, messagingClient.published)
+}
@@ -0,0 +1,191 @@
+// Package digest sends the notification emails that users have asked to receive as daily or weekly digests rather
+// than one at a time.
+package digest
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/cyverse-de/messaging/v12"
+	"github.com/cyverse-de/notifications/common"
+	"github.com/cyverse-de/notifications/db"
+	"github.com/cyverse-de/notifications/mailer"
+	"github.com/cyverse-de/notifications/model"
+	"github.com/sirupsen/logrus"
+)
+
+// log derives from the standard logrus logger, so the formatting and level that main sets up
+// apply here too.
+var log = logrus.WithFields(logrus.Fields{"package": "digest"})
+
+// checkInterval is how often the scheduler looks for digests that are due. Digests go out within this long of the
+// end of their period.
+const checkInterval = 5 * time.Minute
+
+// templateName is the name of the email template that digests are rendered with.
+const templateName = "digest"
+
+// periods lists the digest periods in the order they're checked.
+var periods = []string{model.DigestDaily, model.DigestWeekly}
+
+// EmailProcessor formats and sends a single email request. It's satisfied by *mailer.EmailProcessor.
+type EmailProcessor interface {
+	Process(ctx context.Context, body []byte) error
+}
+
+// Scheduler periodically sends each user a digest of the notification emails that were held for them.
+type Scheduler struct {
+	db        *sql.DB
+	processor EmailProcessor
+	now       func() time.Time
+}
+
+// NewScheduler returns a scheduler that sends digests through the given email processor.
+func NewScheduler(db *sql.DB, processor EmailProcessor) *Scheduler {
+	return &Scheduler{
+		db:        db,
+		processor: processor,
+		now:       time.Now,
+	}
+}
+
+// PeriodStart returns the start of the digest period that contains the given time. Daily periods start at midnight
+// UTC and weekly periods start at midnight UTC on Monday. Every item collected before the start of the current period
+// is due to be sent.
+func PeriodStart(period string, t time.Time) time.Time {
+	t = t.UTC()
+	start := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	if period == model.DigestWeekly {
+		daysSinceMonday := (int(start.Weekday()) + 6) % 7
+		start = start.AddDate(0, 0, -daysSinceMonday)
+	}
+	return start
+}
+
+// Run sends the digests that are due until the context is canceled.
+func (s *Scheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+
+	for {
+		s.SendDue(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// SendDue sends every digest that's due. Failures are logged; the items that couldn't be sent stay in the database
+// and are tried again the next time around.
+func (s *Scheduler) SendDue(ctx context.Context) {
+	now := s.now()
+	for _, period := range periods {
+		cutoff := PeriodStart(period, now)
+
+		recipients, err := s.listRecipients(ctx, period, cutoff)
+		if err != nil {
+			log.Errorf("unable to look for %s digests that are due: %s", period, err)
+			continue
+		}
+
+		for _, recipient := range recipients {
+			if ctx.Err() != nil {
+				return
+			}
+			if err = s.sendDigest(ctx, recipient, period, cutoff); err != nil {
+				log.Errorf("unable to send the %s digest for %s: %s", period, recipient.Username, err)
+			}
+		}
+	}
+}
+
+// listRecipients lists the users who have digests due for a period.
+func (s *Scheduler) listRecipients(ctx context.Context, period string, cutoff time.Time) ([]*db.DigestRecipient, error) {
+	tx, err := s.db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	return db.ListDigestRecipients(ctx, tx, period, cutoff)
+}
+
+// sendDigest sends a single user's digest for a period. The digest items are claimed, sent, and deleted in one
+// transaction: a replica that finds them claimed skips them, and a failed send releases them for the next attempt.
+// The one gap is a replica that dies between sending the email and committing, which sends the digest again later.
+func (s *Scheduler) sendDigest(ctx context.Context, recipient *db.DigestRecipient, period string, cutoff time.Time) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	// Claim the items. There's nothing to do if another replica got to them first.
+	items, err := db.ClaimDigestItems(ctx, tx, recipient.UserID, period, cutoff)
+	if err != nil {
+		return err
+	}
+	if len(items) == 0 {
+		return nil
+	}
+
+	// Build and send the digest. A digest that the mailer rejects outright would be rejected again on every attempt,
+	// so its items are discarded rather than retried.
+	body, err := json.Marshal(buildEmailRequest(recipient, period, items))
+	if err != nil {
+		return err
+	}
+	if err = s.processor.Process(ctx, body); err != nil {
+		if mailer.ErrorCode(err) >= http.StatusInternalServerError {
+			return err
+		}
+		log.Errorf("discarding the %s digest for %s because it was rejected: %s", period, recipient.Username, err)
+	}
+
+	// Remove the items that were sent.
+	ids := make([]string, len(items))
+	for i, item := range items {
+		ids[i] = item.ID
+	}
+	if err = db.DeleteDigestItems(ctx, tx, ids); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// buildEmailRequest builds the email request for a digest. The digest goes to the address on the most recent item,
+// in case the user's address changed during the period.
+func buildEmailRequest(recipient *db.DigestRecipient, period string, items []*db.DigestItem) *messaging.EmailRequest {
+	entries := make([]map[string]any, len(items))
+	for i, item := range items {
+		entries[i] = map[string]any{
+			"subject":   item.Subject,
+			"text":      item.Text,
+			"timestamp": item.TimeCreated.UTC().Format("Jan 2, 2006 15:04 MST"),
+		}
+	}
+
+	return &messaging.EmailRequest{
+		TemplateName: templateName,
+		Subject:      fmt.Sprintf("Your %s summary of DE notifications", period),
+		ToAddress:    items[len(items)-1].EmailRequest.ToAddress,
+		TemplateValues: map[string]any{
+			"user":   common.BareUsername(recipient.Username),
+			"period": period,
+			"count":  len(items),
+			"items":  entries,
+		},
+	}
+}
@@ -0,0 +1,155 @@
+package digest
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/cyverse-de/notifications/mailer"
+	"github.com/cyverse-de/notifications/model"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeProcessor records the email requests it's asked to send, or fails every request when err is set.
+type fakeProcessor struct {
+	bodies []string
+	err    error
+}
+
+func (f *fakeProcessor) Process(_ context.Context, body []byte) error {
+	if f.err != nil {
+		return f.err
+	}
+	f.bodies = append(f.bodies, string(body))
+	return nil
+}
+
+func TestPeriodStart(t *testing.T) {
+	// Wednesday afternoon in a time zone east of UTC, where it's already Thursday.
+	now := time.Date(2026, time.October, 14, 23, 30, 0, 0, time.FixedZone("NZDT", 13*60*60))
+
+	assert.Equal(t, time.Date(2026, time.October, 14, 0, 0, 0, 0, time.UTC), PeriodStart(model.DigestDaily, now))
+	assert.Equal(t, time.Date(2026, time.October, 12, 0, 0, 0, 0, time.UTC), PeriodStart(model.DigestWeekly, now))
+
+	// A Monday is the start of its own week.
+	monday := time.Date(2026, time.October, 12, 8, 0, 0, 0, time.UTC)
+	assert.Equal(t, time.Date(2026, time.October, 12, 0, 0, 0, 0, time.UTC), PeriodStart(model.DigestWeekly, monday))
+}
+
+// expectDigest sets up the queries for a single daily digest with a single item.
+func expectDigest(mock sqlmock.Sqlmock, cutoff time.Time) {
+	const userID = "e26b7f58-8f6e-4b5f-9b23-cfe6f2bbd7c1"
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT DISTINCT u.id, u.username FROM pending_digest_items p").
+		WithArgs(model.DigestDaily, cutoff).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username"}).AddRow(userID, "sarahr@example.org"))
+	mock.ExpectRollback()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("FROM pending_digest_items p")+".*"+regexp.QuoteMeta("FOR UPDATE OF p SKIP LOCKED")).
+		WithArgs(userID, model.DigestDaily, cutoff).
+		WillReturnRows(
+			sqlmock.NewRows([]string{"id", "subject", "text", "time_created", "email_request"}).
+				AddRow(
+					"1e1e8a24-fbbc-4dd1-a5a4-e0dcb4a4b5c9",
+					"some job status changed",
+					"some job status changed",
+					time.Date(2026, time.October, 13, 17, 0, 0, 0, time.UTC),
+					[]byte(`{"template":"analysis_status_change","to":"sarahr@cyverse.org","values":{}}`),
+				),
+		)
+}
+
+func TestSendDueSendsAndRemovesTheDigest(t *testing.T) {
+	assert := assert.New(t)
+
+	database, mock, err := sqlmock.New()
+	assert.NoError(err, "unable to open the mock database connection")
+	defer func() { _ = database.Close() }()
+
+	now := time.Date(2026, time.October, 14, 0, 2, 0, 0, time.UTC)
+	cutoff := PeriodStart(model.DigestDaily, now)
+
+	expectDigest(mock, cutoff)
+	mock.ExpectExec("DELETE FROM pending_digest_items WHERE id IN").
+		WithArgs("1e1e8a24-fbbc-4dd1-a5a4-e0dcb4a4b5c9").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	// No weekly digests are due.
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT DISTINCT u.id, u.username FROM pending_digest_items p").
+		WithArgs(model.DigestWeekly, PeriodStart(model.DigestWeekly, now)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username"}))
+	mock.ExpectRollback()
+
+	processor := &fakeProcessor{}
+	scheduler := NewScheduler(database, processor)
+	scheduler.now = func() time.Time { return now }
+	scheduler.SendDue(context.Background())
+
+	assert.NoError(mock.ExpectationsWereMet(), "not all mock expectations were met")
+	if assert.Len(processor.bodies, 1) {
+		assert.Contains(processor.bodies[0], `"template":"digest"`)
+		assert.Contains(processor.bodies[0], `"to":"sarahr@cyverse.org"`)
+		assert.Contains(processor.bodies[0], `"user":"sarahr"`)
+	}
+}
+
+func TestAFailedSendKeepsTheDigestItems(t *testing.T) {
+	assert := assert.New(t)
+
+	database, mock, err := sqlmock.New()
+	assert.NoError(err, "unable to open the mock database connection")
+	defer func() { _ = database.Close() }()
+
+	now := time.Date(2026, time.October, 14, 0, 2, 0, 0, time.UTC)
+	cutoff := PeriodStart(model.DigestDaily, now)
+
+	// The transaction is rolled back, which releases the items for the next attempt.
+	expectDigest(mock, cutoff)
+	mock.ExpectRollback()
+
+	scheduler := NewScheduler(database, &fakeProcessor{err: errors.New("smtp is down")})
+
+	rec, err := scheduler.listRecipients(context.Background(), model.DigestDaily, cutoff)
+	assert.NoError(err)
+	if assert.Len(rec, 1) {
+		assert.Error(scheduler.sendDigest(context.Background(), rec[0], model.DigestDaily, cutoff))
+	}
+
+	assert.NoError(mock.ExpectationsWereMet(), "not all mock expectations were met")
+}
+
+func TestARejectedDigestIsDiscarded(t *testing.T) {
+	assert := assert.New(t)
+
+	database, mock, err := sqlmock.New()
+	assert.NoError(err, "unable to open the mock database connection")
+	defer func() { _ = database.Close() }()
+
+	now := time.Date(2026, time.October, 14, 0, 2, 0, 0, time.UTC)
+	cutoff := PeriodStart(model.DigestDaily, now)
+
+	expectDigest(mock, cutoff)
+	mock.ExpectExec("DELETE FROM pending_digest_items WHERE id IN").
+		WithArgs("1e1e8a24-fbbc-4dd1-a5a4-e0dcb4a4b5c9").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	processor := &fakeProcessor{err: mailer.NewHTTPError(http.StatusBadRequest, "unknown template")}
+	scheduler := NewScheduler(database, processor)
+
+	rec, err := scheduler.listRecipients(context.Background(), model.DigestDaily, cutoff)
+	assert.NoError(err)
+	if assert.Len(rec, 1) {
+		assert.NoError(scheduler.sendDigest(context.Background(), rec[0], model.DigestDaily, cutoff))
+	}
+
+	assert.NoError(mock.ExpectationsWereMet(), "not all mock expectations were met")
+}
@@ -17,6 +17,21 @@ func TestFormatMessage(t *testing.T) {
 		wantParts  []string
 		wantAbsent []string
 	}{
+		{
+			name:     "digest",
+			template: "digest",
+			values: `{
+				"user": "someuser",
+				"period": "daily",
+				"count": 2,
+				"items": [
+					{"subject": "first job completed", "text": "first job completed", "timestamp": "Oct 13, 2026 17:00 UTC"},
+					{"subject": "second job failed", "text": "see the logs", "timestamp": "Oct 13, 2026 18:00 UTC"}
+				]
+			}`,
+			wantHTML:  true,
+			wantParts: []string{"Hi someuser", "daily summary", "first job completed", "second job failed", "see the logs"},
+		},
 		{
 			name:     "analysis status change",
 			template: "analysis_status_change",
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
 	"fmt"
 	"os"
 	"os/signal"
@@ -18,8 +19,16 @@ import (
 	"github.com/cyverse-de/notifications/api"
 	"github.com/cyverse-de/notifications/common"
 	"github.com/cyverse-de/notifications/db"
+	"github.com/cyverse-de/notifications/deferred"
+	"github.com/cyverse-de/notifications/digest"
 	"github.com/cyverse-de/notifications/mailer"
+	"github.com/cyverse-de/notifications/membership"
+	"github.com/cyverse-de/notifications/outbox"
+	"github.com/cyverse-de/notifications/publisher"
+	"github.com/cyverse-de/notifications/query"
 	"github.com/cyverse-de/notifications/recorder"
+	"github.com/cyverse-de/notifications/retention"
+	"github.com/cyverse-de/notifications/stream"
 	"github.com/go-playground/validator/v10"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
@@ -180,6 +189,21 @@ func validateConfig(cfg *viper.Viper) error {
 	return nil
 }
 
+// cursorKey returns the key that v3 listing cursors are signed with. Every replica has to sign cursors with the same
+// key, so it normally comes from the configuration; a random key is generated if there isn't one, which only works
+// for a single replica.
+func cursorKey(cfg *viper.Viper) ([]byte, bool, error) {
+	if secret := cfg.GetString("notifications.cursor_secret"); secret != "" {
+		return []byte(secret), true, nil
+	}
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, false, err
+	}
+	return key, false, nil
+}
+
 func main() {
 	optionValues := parseCommandLine()
 
@@ -225,6 +249,40 @@ func main() {
 		e.Logger.Fatalf("invalid configuration: %s", err.Error())
 	}
 
+	// Read the retention policy. Nothing is purged unless the configuration says otherwise.
+	retentionPolicy, err := retention.PolicyFromConfig(cfg)
+	if err != nil {
+		e.Logger.Fatalf("invalid retention policy: %s", err.Error())
+	}
+	retentionBatchSize := cfg.GetInt("notifications.retention.batch_size")
+	if retentionBatchSize < 0 {
+		e.Logger.Fatalf("invalid retention policy: notifications.retention.batch_size may not be negative")
+	}
+
+	// Read how long the idempotency keys of notification requests are remembered.
+	idempotencyWindow := cfg.GetDuration("notifications.idempotency_window")
+	if idempotencyWindow < 0 {
+		e.Logger.Fatalf("invalid configuration: notifications.idempotency_window may not be negative")
+	}
+
+	// Read how many times a notification event that can't be recorded is retried before it's
+	// dead-lettered.
+	maxRetries := cfg.GetInt("notifications.max_retries")
+	if maxRetries < 0 {
+		e.Logger.Fatalf("invalid configuration: notifications.max_retries may not be negative")
+	}
+
+	// Read how many workers record notification events, and how many unacknowledged events the
+	// broker hands them at a time.
+	workers := cfg.GetInt("notifications.workers")
+	if workers < 0 {
+		e.Logger.Fatalf("invalid configuration: notifications.workers may not be negative")
+	}
+	prefetch := cfg.GetInt("notifications.prefetch")
+	if prefetch < 0 {
+		e.Logger.Fatalf("invalid configuration: notifications.prefetch may not be negative")
+	}
+
 	// Retrieve the AMQP settings.
 	amqpSettings := &common.AMQPSettings{
 		URI:          cfg.GetString("amqp.uri"),
@@ -269,26 +327,99 @@ func main() {
 	// Callers send bare usernames; the DE stores them qualified.
 	userSuffix := common.NewUserSuffix(cfg.GetString("notifications.uid.domain"))
 
+	// Clients holding a v2 message stream open are fed by the notification events that every
+	// replica's recorder sends through the database, so that a client hears about every
+	// notification no matter which replica recorded it.
+	streamHub := stream.NewHub()
+
+	// Set up the signing of the cursors that the v3 API hands out.
+	key, configured, err := cursorKey(cfg)
+	if err != nil {
+		e.Logger.Fatalf("unable to generate the cursor signing key: %s", err.Error())
+	}
+	if !configured {
+		e.Logger.Warn("notifications.cursor_secret is not set; v3 cursors will only work on the replica that issued them")
+	}
+
+	// Load the teams that notifications can be addressed to. Notifications addressed to teams are
+	// discarded unless team definitions are configured.
+	var teams membership.Resolver
+	if teamsFile := cfg.GetString("notifications.teams_file"); teamsFile != "" {
+		teams, err = membership.LoadStaticResolver(teamsFile)
+		if err != nil {
+			e.Logger.Fatalf("unable to load the team definitions: %s", err.Error())
+		}
+	}
+
+	// The recorder is shared by the event consumer and the v2 API, which records notifications
+	// while the caller waits when asked to. The messages it queues are published by the outbox
+	// relay on their own connection, so that a failed publish on its behalf doesn't reconnect the
+	// connection the API publishes on.
+	recorderClient, err := createMessagingClient(amqpSettings)
+	if err != nil {
+		e.Logger.Fatalf("unable to create the recorder messaging client: %s", err.Error())
+	}
+	notificationRecorder := recorder.New(
+		recorder.NewDatabaseClient(db),
+		userSuffix,
+		teams,
+		idempotencyWindow,
+	)
+
+	// Events are dispatched to handlers by routing key category, both by the event consumer and
+	// when quarantined events are replayed.
+	eventHandlers := recorder.NewHandlers()
+	eventHandlers.Register(recorder.NotificationCategory, notificationRecorder)
+
+	// Load the rules that turn other events, such as analysis status changes, into notifications.
+	// Events in other categories are ignored unless rules are configured.
+	if rulesFile := cfg.GetString("notifications.rules_file"); rulesFile != "" {
+		rules, err := recorder.LoadRuleHandler(rulesFile, notificationRecorder)
+		if err != nil {
+			e.Logger.Fatalf("unable to load the notification rules: %s", err.Error())
+		}
+		rules.Register(eventHandlers)
+	}
+
+	// Batches of notifications are published with publisher confirms, so that the API can tell
+	// callers which of their notifications were queued. The connection is opened on first use.
+	batchPublisher := publisher.New(amqpSettings)
+
+	// Load the groups that broadcast notifications can be sent to. Broadcasts to groups are rejected
+	// unless group definitions are configured.
+	var groups membership.Resolver
+	if groupsFile := cfg.GetString("notifications.groups_file"); groupsFile != "" {
+		groups, err = membership.LoadStaticResolver(groupsFile)
+		if err != nil {
+			e.Logger.Fatalf("unable to load the group definitions: %s", err.Error())
+		}
+	}
+
 	// Define the primary API handler.
 	a := api.API{
-		Echo:         e,
-		AMQPSettings: amqpSettings,
-		AMQPClient:   amqpClient,
-		DB:           db,
-		UserSuffix:   userSuffix,
-		Mailer:       emailProcessor,
-		Service:      serviceName,
-		Title:        serviceInfo.Title,
-		Version:      serviceInfo.Version,
+		Echo:           e,
+		AMQPSettings:   amqpSettings,
+		AMQPClient:     amqpClient,
+		DB:             db,
+		UserSuffix:     userSuffix,
+		Mailer:         emailProcessor,
+		Stream:         streamHub,
+		Recorder:       notificationRecorder,
+		Events:         eventHandlers,
+		BatchPublisher: batchPublisher,
+		Groups:         groups,
+		Cursors:        query.NewCursorCodec(key),
+		Service:        serviceName,
+		Title:          serviceInfo.Title,
+		Version:        serviceInfo.Version,
 	}
 
 	// Register the handlers.
 	a.RegisterHandlers()
 
-	// Record the notification events that the v1 API publishes. The consumer gets its own
-	// connection because the messaging client dedicates a connection to listening, and the recorder
-	// gets a third one so that a failed publish on its behalf doesn't reconnect the connection the
-	// API publishes on. Both are closed by the ordered shutdown at the end of main rather than
+	// Record the notification events that the API publishes. The consumer gets its own
+	// connection because the messaging client dedicates a connection to listening. It and the
+	// recorder's connection are closed by the ordered shutdown at the end of main rather than
 	// by defers, so that they outlive the mailer drain.
 	e.Logger.Info("starting the event recorder")
 	consumerClient, err := messaging.NewClient(amqpSettings.URI, true)
@@ -296,17 +427,20 @@ func main() {
 		e.Logger.Fatalf("unable to create the consumer messaging client: %s", err.Error())
 	}
 
-	recorderClient, err := createMessagingClient(amqpSettings)
-	if err != nil {
-		e.Logger.Fatalf("unable to create the recorder messaging client: %s", err.Error())
-	}
-
+	// Dead-lettered events are published with publisher confirms on a channel of
+	// their own, so that they don't wait behind large notification batches from the API.
+	deadLetterPublisher := publisher.New(amqpSettings)
 	consumer := recorder.NewConsumer(
 		consumerClient,
 		recorderClient,
+		deadLetterPublisher,
 		amqpSettings,
 		cfg.GetString("email.request"),
-		recorder.New(recorder.NewDatabaseClient(db), recorderClient, userSuffix),
+		notificationRecorder,
+		eventHandlers,
+		maxRetries,
+		workers,
+		prefetch,
 	)
 	if err = consumer.Listen(); err != nil {
 		e.Logger.Fatalf("unable to start recording notification events: %s", err.Error())
@@ -315,6 +449,32 @@ func main() {
 	signalCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer stop()
 
+	// Relay notification events to the open message streams until shutdown begins.
+	e.Logger.Info("starting the notification event listener")
+	go stream.NewListener(db, databaseURI, streamHub).Run(signalCtx)
+
+	// Send the digests of the emails that users asked to have collected. Every replica runs the
+	// scheduler; the digest items are claimed in the database, so each digest is sent only once.
+	e.Logger.Info("starting the digest scheduler")
+	go digest.NewScheduler(db, emailProcessor).Run(signalCtx)
+
+	// Release the emails that were held until the end of their recipients' quiet hours. They're
+	// published the same way the recorder publishes emails that aren't held, and every replica
+	// runs the releaser; the emails are claimed in the database, so each is released only once.
+	e.Logger.Info("starting the deferred email releaser")
+	go deferred.NewReleaser(db, recorderClient).Run(signalCtx)
+
+	// Publish the email requests and UI messages that the recorder queues in the outbox. Every
+	// replica runs the relay; the messages are claimed in the database, so each is published once.
+	e.Logger.Info("starting the outbox relay")
+	go outbox.NewRelay(db, recorderClient).Run(signalCtx)
+
+	// Permanently remove the notifications that the retention policy no longer calls for. Every
+	// replica runs the purge; the notifications are locked as they're deleted, so replicas skip
+	// each other's batches rather than waiting on them.
+	e.Logger.Info("starting the notification purge")
+	go retention.NewPurger(db, retentionPolicy, uint64(retentionBatchSize)).Run(signalCtx)
+
 	// Send the email requests the recorder publishes. This gets a fourth connection rather
 	// than sharing the recorder's: shutdown drains and closes it on its own, which would stop
 	// the recorder too if they shared one. It starts in the background so a broker outage
@@ -373,6 +533,10 @@ func main() {
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
+
+		// Open message streams never finish on their own, so they're closed rather than drained.
+		streamHub.Close()
+
 		shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), httpShutdownTimeout)
 		defer cancelShutdown()
 		if err := e.Shutdown(shutdownCtx); err != nil {
@@ -384,5 +548,7 @@ func main() {
 
 	consumerClient.Close()
 	recorderClient.Close()
+	batchPublisher.Close()
+	deadLetterPublisher.Close()
 	amqpClient.Close()
 }
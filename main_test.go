@@ -105,3 +105,23 @@ func TestValidateConfig(t *testing.T) {
 		})
 	}
 }
+
+func TestCursorKey(t *testing.T) {
+	assert := assert.New(t)
+
+	cfg := viper.New()
+	cfg.Set("notifications.cursor_secret", "shared secret")
+	key, configured, err := cursorKey(cfg)
+	assert.NoError(err)
+	assert.True(configured)
+	assert.Equal([]byte("shared secret"), key)
+
+	// Without a configured secret, each replica gets a random key of its own.
+	first, configured, err := cursorKey(viper.New())
+	assert.NoError(err)
+	assert.False(configured)
+	second, _, err := cursorKey(viper.New())
+	assert.NoError(err)
+	assert.Len(first, 32)
+	assert.NotEqual(first, second)
+}
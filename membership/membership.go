@@ -0,0 +1,135 @@
+// Package membership resolves named groups of users, such as the audience of a broadcast notification, to the
+// usernames of their members.
+package membership
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// ErrUnknownGroup is returned when the resolver doesn't know about the requested group.
+var ErrUnknownGroup = errors.New("unknown group")
+
+// Resolver looks up the members of a group.
+type Resolver interface {
+
+	// Members returns the usernames of the members of the group, as they appear in the group definition. An error
+	// wrapping ErrUnknownGroup is returned if the group doesn't exist.
+	Members(ctx context.Context, group string) ([]string, error)
+}
+
+// AddressResolver is implemented by resolvers that also know the email addresses of the members of a group.
+type AddressResolver interface {
+
+	// Addresses returns the email addresses of the members of the group who have one, keyed by username. An error
+	// wrapping ErrUnknownGroup is returned if the group doesn't exist.
+	Addresses(ctx context.Context, group string) (map[string]string, error)
+}
+
+// member is a single entry in a group definition file: either a username or an object containing a username and an
+// email address.
+type member struct {
+	Username string `json:"username"`
+	Email    string `json:"email"`
+}
+
+// UnmarshalJSON accepts either form of group member.
+func (m *member) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &m.Username); err == nil {
+		return nil
+	}
+	type plain member
+	return json.Unmarshal(data, (*plain)(m))
+}
+
+// StaticResolver resolves groups from a fixed set of group definitions.
+type StaticResolver struct {
+	groups    map[string][]string
+	addresses map[string]string
+}
+
+// NewStaticResolver returns a resolver for the given group definitions, which map group names to member usernames.
+func NewStaticResolver(groups map[string][]string) *StaticResolver {
+	if groups == nil {
+		groups = make(map[string][]string)
+	}
+	return &StaticResolver{groups: groups, addresses: make(map[string]string)}
+}
+
+// LoadStaticResolver reads group definitions from a JSON file containing an object that maps each group name to a
+// list of members. Each member is either a username or an object containing a username and an email address, such as
+// {"username": "sarahr", "email": "sarahr@example.org"}. A user's address applies to every group they're in.
+func LoadStaticResolver(path string) (*StaticResolver, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the group definitions: %w", err)
+	}
+
+	var definitions map[string][]member
+	if err = json.Unmarshal(contents, &definitions); err != nil {
+		return nil, fmt.Errorf("unable to parse the group definitions in %s: %w", path, err)
+	}
+
+	// Separate the usernames from the email addresses.
+	groups := make(map[string][]string, len(definitions))
+	addresses := make(map[string]string)
+	for group, members := range definitions {
+		usernames := make([]string, len(members))
+		for i, m := range members {
+			if m.Username == "" {
+				return nil, fmt.Errorf("a member of group %s in %s has no username", group, path)
+			}
+			usernames[i] = m.Username
+			if m.Email != "" {
+				addresses[m.Username] = m.Email
+			}
+		}
+		groups[group] = usernames
+	}
+
+	resolver := NewStaticResolver(groups)
+	resolver.addresses = addresses
+	return resolver, nil
+}
+
+// Members returns the usernames of the members of the group, sorted and without duplicates.
+func (r *StaticResolver) Members(_ context.Context, group string) ([]string, error) {
+	members, ok := r.groups[group]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownGroup, group)
+	}
+
+	// Remove the duplicates, which the file format doesn't prevent.
+	seen := make(map[string]bool, len(members))
+	result := make([]string, 0, len(members))
+	for _, member := range members {
+		if !seen[member] {
+			seen[member] = true
+			result = append(result, member)
+		}
+	}
+	sort.Strings(result)
+
+	return result, nil
+}
+
+// Addresses returns the email addresses of the members of the group who have one, keyed by username.
+func (r *StaticResolver) Addresses(ctx context.Context, group string) (map[string]string, error) {
+	members, err := r.Members(ctx, group)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]string)
+	for _, username := range members {
+		if addr, ok := r.addresses[username]; ok {
+			result[username] = addr
+		}
+	}
+
+	return result, nil
+}
@@ -0,0 +1,67 @@
+package membership
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadStaticResolver(t *testing.T) {
+	assert := assert.New(t)
+
+	path := filepath.Join(t.TempDir(), "groups.json")
+	err := os.WriteFile(path, []byte(`{"staff": ["sarahr", "ipcdev", "sarahr"]}`), 0o600)
+	assert.NoError(err)
+
+	resolver, err := LoadStaticResolver(path)
+	if !assert.NoError(err) {
+		return
+	}
+
+	members, err := resolver.Members(context.Background(), "staff")
+	assert.NoError(err)
+	assert.Equal([]string{"ipcdev", "sarahr"}, members)
+
+	_, err = resolver.Members(context.Background(), "students")
+	assert.True(errors.Is(err, ErrUnknownGroup))
+}
+
+func TestLoadStaticResolverReadsEmailAddresses(t *testing.T) {
+	assert := assert.New(t)
+
+	path := filepath.Join(t.TempDir(), "teams.json")
+	contents := `{
+		"staff": ["ipcdev", {"username": "sarahr", "email": "sarahr@example.org"}],
+		"students": [{"username": "sarahr", "email": "sarahr@example.org"}, "wregglej"]
+	}`
+	assert.NoError(os.WriteFile(path, []byte(contents), 0o600))
+
+	resolver, err := LoadStaticResolver(path)
+	if !assert.NoError(err) {
+		return
+	}
+
+	members, err := resolver.Members(context.Background(), "staff")
+	assert.NoError(err)
+	assert.Equal([]string{"ipcdev", "sarahr"}, members)
+
+	// Only the members who have an address are included.
+	addresses, err := resolver.Addresses(context.Background(), "students")
+	assert.NoError(err)
+	assert.Equal(map[string]string{"sarahr": "sarahr@example.org"}, addresses)
+
+	_, err = resolver.Addresses(context.Background(), "faculty")
+	assert.True(errors.Is(err, ErrUnknownGroup))
+}
+
+func TestLoadStaticResolverRejectsMembersWithoutUsernames(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "teams.json")
+	assert.NoError(t, os.WriteFile(path, []byte(`{"staff": [{"email": "sarahr@example.org"}]}`), 0o600))
+
+	_, err := LoadStaticResolver(path)
+	assert.Error(t, err)
+}
@@ -1,6 +1,11 @@
 package model
 
-import "fmt"
+import (
+	"fmt"
+	"time"
+
+	"github.com/cyverse-de/notifications/common"
+)
 
 // RootResponse describes the response of the root endpoint.
 type RootResponse struct {
@@ -74,8 +79,20 @@ type V1NotificationRequest struct {
 	// the recorder parses it back out of.
 	Type string `json:"type" validate:"required,max=32,excludesall=."`
 
-	// The username of the notification recipient.
-	User string `json:"user" validate:"required"`
+	// The username of the notification recipient. Exactly one of `user`, `recipients`, and `team` must be specified.
+	User string `json:"user" validate:"required_without_all=Recipients Team"`
+
+	// The usernames of the notification recipients, for notifications sent to several users at once. Each recipient
+	// gets a notification of their own.
+	Recipients []string `json:"recipients" validate:"omitempty,dive,required"`
+
+	// The name of the team whose members should each get a notification of their own. The members are looked up
+	// when the notification is recorded, and so are the email addresses of any members who should be emailed.
+	Team string `json:"team"`
+
+	// An optional key that identifies the request, so that a request that's submitted more than once, such as when a
+	// caller retries after a timeout, is only recorded once. The Idempotency-Key header may be used instead.
+	IdempotencyKey string `json:"idempotency_key" validate:"max=255"`
 
 	// The subject line of the notification.
 	Subject string `json:"subject" validate:"required"`
@@ -93,12 +110,146 @@ type V1NotificationRequest struct {
 	Payload map[string]interface{} `json:"payload"`
 }
 
+// IdempotencyKeyHeader is the request header that may contain the idempotency key of a notification request instead
+// of the request body.
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// EmailAddressesKey is the payload key containing the email addresses of the recipients of a notification that's sent
+// to several users at once. It maps each recipient's username to their email address.
+const EmailAddressesKey = "email_addresses"
+
+// FansOut returns true if the notification is sent to a list of recipients or to a team rather than to a single user.
+func (r *V1NotificationRequest) FansOut() bool {
+	return len(r.Recipients) > 0 || r.Team != ""
+}
+
+// ValidateRecipients verifies that the notification is sent to exactly one of a single user, a list of recipients, or
+// a team.
+func (r *V1NotificationRequest) ValidateRecipients() error {
+	count := 0
+	for _, specified := range []bool{r.User != "", len(r.Recipients) > 0, r.Team != ""} {
+		if specified {
+			count++
+		}
+	}
+	if count != 1 {
+		return fmt.Errorf("exactly one of user, recipients, and team must be specified")
+	}
+	return nil
+}
+
+// ValidateEmailRequest verifies that we have the required fields if an email was requested. A notification that's
+// sent to several users at once takes the recipients' email addresses from the email_addresses object in the payload
+// instead of from the email_address field.
+func (r *V1NotificationRequest) ValidateEmailRequest() error {
+	if !r.Email {
+		return nil
+	}
+
+	// Notifications sent to several users at once are validated differently.
+	if r.FansOut() {
+		return r.validateFanOutEmailRequest()
+	}
+
+	// Verify that an email template was provided.
+	if common.IsBlank(r.EmailTemplate) {
+		return fmt.Errorf("an email was requested, but no email template was specified")
+	}
+
+	// Verify that a valid email address was provided.
+	v, ok := r.Payload["email_address"]
+	if !ok || v == nil {
+		return fmt.Errorf("an email was requested, but no email address was provided")
+	}
+	addr, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("an email was requested, but the type of the email address was invalid")
+	}
+	if common.IsBlank(addr) {
+		return fmt.Errorf("an email was requested, but the email address was blank")
+	}
+	if err := common.ValidateEmailAddress(addr); err != nil {
+		return fmt.Errorf("an email was requsted, but an invalid email address was specified: %s", err.Error())
+	}
+
+	return nil
+}
+
+// validateFanOutEmailRequest verifies that we have the required fields if an email was requested for a notification
+// that's sent to several users at once. Every recipient in a list of recipients needs an email address. The members
+// of a team aren't known until the notification is recorded, so their addresses are looked up along with them, and
+// the addresses in the email_addresses object are optional.
+func (r *V1NotificationRequest) validateFanOutEmailRequest() error {
+
+	// Verify that an email template was provided.
+	if common.IsBlank(r.EmailTemplate) {
+		return fmt.Errorf("an email was requested, but no email template was specified")
+	}
+
+	// Verify that the email addresses were provided.
+	v, present := r.Payload[EmailAddressesKey]
+	addrs, ok := v.(map[string]interface{})
+	if !ok && (present || r.Team == "") {
+		return fmt.Errorf("an email was requested, but no %s object was provided", EmailAddressesKey)
+	}
+	for username, v := range addrs {
+		addr, ok := v.(string)
+		if !ok {
+			return fmt.Errorf("an email was requested, but the type of the email address for %s was invalid", username)
+		}
+		if err := common.ValidateEmailAddress(addr); err != nil {
+			return fmt.Errorf(
+				"an email was requested, but an invalid email address was specified for %s: %s", username, err.Error(),
+			)
+		}
+	}
+	for _, recipient := range r.Recipients {
+		if _, ok := addrs[recipient]; !ok {
+			return fmt.Errorf("an email was requested, but no email address was provided for %s", recipient)
+		}
+	}
+
+	return nil
+}
+
+// Prepare performs the checks that the validation tags on the request can't express and fixes the timestamps in the
+// payload. Every endpoint that accepts notification requests calls it after validating the request body, so that they
+// all accept the same requests. Errors returned by this function indicate that the request is invalid.
+func (r *V1NotificationRequest) Prepare() error {
+
+	// Verify that the notification is addressed to exactly one user, list of recipients, or team.
+	if err := r.ValidateRecipients(); err != nil {
+		return err
+	}
+
+	// Verify that we have the required values if an email was requested.
+	if err := r.ValidateEmailRequest(); err != nil {
+		return err
+	}
+
+	// Ensure that the analysis start and end dates are in the correct format if they're present.
+	return r.FixTimestamps()
+}
+
+// FixTimestamps ensures that the analysis start and end dates in the payload are in the correct format if they're
+// present.
+func (r *V1NotificationRequest) FixTimestamps() error {
+	if err := common.FixTimestampInMap(r.Payload, "startdate"); err != nil {
+		return err
+	}
+	return common.FixTimestampInMap(r.Payload, "enddate")
+}
+
 // Notification describes a single notification in a notification listing.
 type Notification struct {
 
 	// True if the notification has been marked as deleted.
 	Deleted bool `json:"deleted"`
 
+	// The time that the notification was marked as deleted. Only version 2 of the API includes this field, and only
+	// for notifications that are currently marked as deleted.
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+
 	// The email address to send the notification to, if an email was requested.
 	Email bool `json:"email"`
 
@@ -114,6 +265,10 @@ type Notification struct {
 	// Indicates whether or not the message has been marked as seen by the user.
 	Seen bool `json:"seen"`
 
+	// The time that the user first marked the message as seen. Only version 2 of the API includes this field, and
+	// only for messages that are currently marked as seen.
+	SeenAt *time.Time `json:"seen_at,omitempty"`
+
 	// The subject line of the notification.
 	Subject string `json:"subject"`
 
@@ -122,6 +277,62 @@ type Notification struct {
 
 	// The username of the notification recipient.
 	User string `json:"user"`
+
+	// Describes how well the notification matched a full-text search. Only search results include this field.
+	Search *SearchMatch `json:"search,omitempty"`
+
+	// True if the notification was broadcast to many users at once. Broadcast notifications aren't addressed to any
+	// one user, so the user field is blank.
+	Broadcast bool `json:"broadcast,omitempty"`
+}
+
+// SearchMatch describes how well a notification matched a full-text search.
+type SearchMatch struct {
+
+	// The search rank. Higher numbers indicate better matches. The rank is informational only: search results are
+	// sorted by time like any other listing, so that they can be paged through the same way.
+	Rank float64 `json:"rank"`
+
+	// The notification subject as HTML, with the matching words wrapped in <mark> tags. The rest of the subject is
+	// HTML-escaped, so it's safe to render as HTML as long as it's treated as HTML everywhere; clients that display it
+	// as plain text have to unescape it.
+	Subject string `json:"subject"`
+
+	// An excerpt of the notification text as HTML, with the matching words wrapped in <mark> tags. The rest of the text
+	// is HTML-escaped like the subject is.
+	Text string `json:"text"`
+}
+
+// WrappedNotification describes a notification sent to a client along with the recipient's unseen
+// notification count. It has the same shape as the message the DE UI receives over AMQP.
+type WrappedNotification struct {
+
+	// The number of notifications that the user hasn't marked as seen yet.
+	Total int64 `json:"total"`
+
+	// The notification itself.
+	Message *Notification `json:"message"`
+}
+
+// UnseenCountUpdateType identifies an unseen count update.
+const UnseenCountUpdateType = "unseen_count"
+
+// UnseenCountUpdate describes the message sent to a client when a user's unseen notification count
+// changes without a new notification having been recorded; for example, because notifications were
+// marked as seen or deleted in another browser tab. It has no message field, and its type field is
+// always UnseenCountUpdateType, so the DE UI can tell it apart from a new notification.
+type UnseenCountUpdate struct {
+
+	// Always UnseenCountUpdateType.
+	Type string `json:"type"`
+
+	// The number of notifications that the user hasn't marked as seen yet.
+	Total int64 `json:"total"`
+}
+
+// NewUnseenCountUpdate returns an unseen count update for the given total.
+func NewUnseenCountUpdate(total int64) *UnseenCountUpdate {
+	return &UnseenCountUpdate{Type: UnseenCountUpdateType, Total: total}
 }
 
 // V1NotificationListing describes the response body to a notification listing request in version 1 of the API.
@@ -130,11 +341,16 @@ type V1NotificationListing struct {
 	// The message listing.
 	Messages []*Notification `json:"messages"`
 
-	// The total number of messages available to be listed.
-	Total string `json:"total"`
+	// The total number of messages available to be listed. Note: this element will be missing if the total wasn't
+	// requested.
+	Total string `json:"total,omitempty"`
 
 	// The total number of messages that haven't been marked as seen yet.
 	UnseenTotal string `json:"unseen_total"`
+
+	// An opaque cursor to pass in the `cursor` query parameter to get the next page. Note: this element will be
+	// missing if this is the last page or if the listing isn't limited and sorted by timestamp.
+	NextCursor string `json:"next_cursor,omitempty"`
 }
 
 // V2NotificationListing describes the response body to a notification listing request in version 2 of the API.
@@ -158,6 +374,58 @@ type V2NotificationListing struct {
 	AfterID string `json:"after_id,omitempty"`
 }
 
+// V2NotificationCreated describes the response body to a request to create a notification in version 2 of the API
+// when the caller waits for the notification to be recorded.
+type V2NotificationCreated struct {
+
+	// True if the notification was recorded. Notifications aren't recorded for users who have opted out of recording
+	// notifications of the requested type.
+	Recorded bool `json:"recorded"`
+
+	// True if the notification wasn't recorded because a request with the same idempotency key was recorded already.
+	Duplicate bool `json:"duplicate,omitempty"`
+
+	// The ID of the recorded notification. For a duplicate request, this is the ID of the notification that was
+	// recorded for the original request. Note: this element will be missing if no notification was recorded.
+	ID string `json:"id,omitempty"`
+
+	// The recorded notification, formatted as it appears in notification listings. Note: this element will be missing
+	// if no notification was recorded.
+	Notification *Notification `json:"notification,omitempty"`
+}
+
+// V2NotificationBatchRejection describes a notification in a batch that was rejected.
+type V2NotificationBatchRejection struct {
+
+	// The position of the notification in the request body, starting at zero.
+	Index int `json:"index"`
+
+	// A message describing why the notification was rejected.
+	Message string `json:"message"`
+}
+
+// V2NotificationBatchResponse describes the response body to a batch notification request in version 2 of the API.
+type V2NotificationBatchResponse struct {
+
+	// The positions of the notifications in the request body that were accepted, starting at zero. Accepted
+	// notifications have been queued to be recorded.
+	Accepted []int `json:"accepted"`
+
+	// The notifications that were rejected, either because they were invalid or because they couldn't be queued.
+	Rejected []*V2NotificationBatchRejection `json:"rejected"`
+}
+
+// V3NotificationListing describes a single page of a notification listing in version 3 of the API.
+type V3NotificationListing struct {
+
+	// The message listing.
+	Messages []*Notification `json:"messages"`
+
+	// An opaque cursor to pass in the `cursor` query parameter to get the next page. Note: this element will be
+	// missing if this is the last page.
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
 // V1NotificationCounts describes the response body for a notification count request in version 1 of the API.
 type V1NotificationCounts struct {
 
@@ -200,3 +468,209 @@ type MultipleMessageUpdateRequest struct {
 	// ignored.
 	AllNotifications bool `json:"all_notifications"`
 }
+
+// NotificationPreference describes a user's preferences for a single notification type.
+type NotificationPreference struct {
+
+	// The notification type, as it appears in the notification_types table.
+	Type string `json:"type" validate:"required"`
+
+	// True if notifications of this type should be emailed to the user when an email is requested.
+	Email bool `json:"email"`
+
+	// True if notifications of this type should be recorded at all. A notification that isn't
+	// recorded never appears in the UI and is never emailed.
+	Record bool `json:"record"`
+
+	// The period of the digest that emails for this notification type are collected into, either
+	// DigestDaily or DigestWeekly. Emails are sent as soon as the notification is recorded if this
+	// is empty.
+	Digest string `json:"digest,omitempty" validate:"omitempty,oneof=daily weekly"`
+}
+
+// The digest periods that a user can choose for a notification type.
+const (
+	DigestDaily  = "daily"
+	DigestWeekly = "weekly"
+)
+
+// DefaultNotificationPreference returns the preferences that apply to a notification type when the
+// user hasn't set any: everything is recorded, and emails are sent whenever they're requested.
+func DefaultNotificationPreference(notificationType string) *NotificationPreference {
+	return &NotificationPreference{
+		Type:   notificationType,
+		Email:  true,
+		Record: true,
+	}
+}
+
+// NotificationPreferences describes the request and response bodies of the preferences endpoints.
+type NotificationPreferences struct {
+
+	// The preferences for each notification type.
+	Preferences []*NotificationPreference `json:"preferences" validate:"required,dive"`
+
+	// The user's quiet hours, if they've set any. This is ignored in requests to update the
+	// preferences for each notification type.
+	QuietHours *QuietHours `json:"quiet_hours,omitempty" validate:"-"`
+}
+
+// QuietHours describes the time of day during which a user doesn't want to receive emails. Emails
+// that would be sent during quiet hours are held until the quiet hours end. The quiet hours wrap
+// around midnight if the end time is earlier than the start time.
+type QuietHours struct {
+
+	// The IANA name of the user's time zone, such as America/Phoenix.
+	TimeZone string `json:"time_zone" validate:"required,timezone"`
+
+	// The time of day that quiet hours start, in 24-hour HH:MM format.
+	Start string `json:"start" validate:"required,datetime=15:04"`
+
+	// The time of day that quiet hours end, in 24-hour HH:MM format.
+	End string `json:"end" validate:"required,datetime=15:04"`
+}
+
+// ReleaseTime reports whether the given time falls within the quiet hours and, if it does, when
+// the quiet hours end. An error is returned if the quiet hours can't be interpreted.
+func (q *QuietHours) ReleaseTime(t time.Time) (time.Time, bool, error) {
+	location, err := time.LoadLocation(q.TimeZone)
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("invalid time zone %q: %w", q.TimeZone, err)
+	}
+	start, err := time.Parse("15:04", q.Start)
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("invalid quiet hours start time %q: %w", q.Start, err)
+	}
+	end, err := time.Parse("15:04", q.End)
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("invalid quiet hours end time %q: %w", q.End, err)
+	}
+
+	// Work in minutes since midnight in the user's time zone.
+	local := t.In(location)
+	now := local.Hour()*60 + local.Minute()
+	startMinute := start.Hour()*60 + start.Minute()
+	endMinute := end.Hour()*60 + end.Minute()
+	endToday := time.Date(local.Year(), local.Month(), local.Day(), end.Hour(), end.Minute(), 0, 0, location)
+
+	switch {
+	case startMinute == endMinute:
+		return time.Time{}, false, nil
+	case startMinute < endMinute && now >= startMinute && now < endMinute:
+		return endToday, true, nil
+	case startMinute > endMinute && now >= startMinute:
+		return endToday.AddDate(0, 0, 1), true, nil
+	case startMinute > endMinute && now < endMinute:
+		return endToday, true, nil
+	default:
+		return time.Time{}, false, nil
+	}
+}
+
+// The audiences that a broadcast notification can be sent to.
+const (
+	BroadcastAudienceAll   = "all"
+	BroadcastAudienceUsers = "users"
+	BroadcastAudienceGroup = "group"
+)
+
+// BroadcastRequest describes a request to send a single notification to many users at once.
+type BroadcastRequest struct {
+
+	// The notification type. The same restrictions apply as for other notification types.
+	Type string `json:"type" validate:"required,max=32,excludesall=."`
+
+	// The subject line of the notification.
+	Subject string `json:"subject" validate:"required"`
+
+	// The message text of the notification.
+	Message string `json:"message"`
+
+	// The notification payload, which contains arbitrary information about the notification.
+	Payload map[string]interface{} `json:"payload"`
+
+	// Who the notification is for: every user, the users listed in `users`, or the members of the group in `group`.
+	Audience string `json:"audience" validate:"required,oneof=all users group"`
+
+	// The usernames of the recipients if the audience is `users`.
+	Users []string `json:"users" validate:"required_if=Audience users,dive,required"`
+
+	// The name of the group to send the notification to if the audience is `group`. The group's members are looked up
+	// when the notification is sent; users who join the group later don't receive it.
+	Group string `json:"group" validate:"required_if=Audience group"`
+}
+
+// Broadcast describes a notification that was broadcast to many users at once.
+type Broadcast struct {
+
+	// The broadcast ID, which is also the notification ID.
+	ID string `json:"id"`
+
+	// Who the notification is for: `all`, `users`, or `group`.
+	Audience string `json:"audience"`
+
+	// The name of the group the notification was sent to. Note: this element will be missing unless the audience is
+	// `group`.
+	Group string `json:"group,omitempty"`
+
+	// The usernames of the recipients. Note: this element will be missing if the audience is `all`.
+	Users []string `json:"users,omitempty"`
+
+	// The time the notification was sent.
+	TimeCreated time.Time `json:"time_created"`
+
+	// The notification, formatted as it appears in notification listings, but without any user's seen or deleted
+	// state.
+	Notification *Notification `json:"notification"`
+}
+
+// BroadcastListing describes the response body to a request to list broadcast notifications.
+type BroadcastListing struct {
+
+	// The broadcast notifications, most recent first.
+	Broadcasts []*Broadcast `json:"broadcasts"`
+}
+
+// QuarantinedEvent describes a notification event that was discarded because it couldn't be recorded.
+type QuarantinedEvent struct {
+
+	// The quarantined event ID.
+	ID string `json:"id"`
+
+	// The routing key that the event was published with.
+	RoutingKey string `json:"routing_key"`
+
+	// The body of the event, exactly as it was published unless it has been edited since.
+	Body string `json:"body"`
+
+	// The error that kept the event from being recorded, or the error from the most recent attempt to replay it.
+	Error string `json:"error"`
+
+	// The time the event was quarantined.
+	TimeQuarantined time.Time `json:"time_quarantined"`
+
+	// The time the event was last edited. Note: this element will be missing if the event hasn't been edited.
+	TimeUpdated *time.Time `json:"time_updated,omitempty"`
+
+	// The time the event was replayed successfully. Note: this element will be missing if the event hasn't been
+	// replayed successfully.
+	TimeReplayed *time.Time `json:"time_replayed,omitempty"`
+}
+
+// QuarantinedEventListing describes the response body to a request to list quarantined notification events.
+type QuarantinedEventListing struct {
+
+	// The quarantined events, most recent first.
+	Events []*QuarantinedEvent `json:"events"`
+}
+
+// QuarantinedEventUpdate describes the request body to a request to edit a quarantined notification event before it's
+// replayed.
+type QuarantinedEventUpdate struct {
+
+	// The routing key to replay the event with.
+	RoutingKey string `json:"routing_key" validate:"required"`
+
+	// The body to replay the event with.
+	Body string `json:"body" validate:"required"`
+}
@@ -3,6 +3,7 @@ package model
 import (
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/go-playground/validator/v10"
 	"github.com/stretchr/testify/assert"
@@ -41,3 +42,122 @@ func TestV1NotificationRequestTypeValidation(t *testing.T) {
 		})
 	}
 }
+
+func TestV1NotificationRequestRecipientValidation(t *testing.T) {
+	addresses := map[string]interface{}{"sarahr": "sarahr@cyverse.org"}
+	tests := []struct {
+		name      string
+		request   V1NotificationRequest
+		wantValid bool
+	}{
+		{name: "a single user is accepted", request: V1NotificationRequest{User: "sarahr"}, wantValid: true},
+		{name: "a list of recipients is accepted", request: V1NotificationRequest{Recipients: []string{"sarahr"}}, wantValid: true},
+		{name: "a team is accepted", request: V1NotificationRequest{Team: "pals"}, wantValid: true},
+		{name: "no recipient is rejected", request: V1NotificationRequest{}, wantValid: false},
+		{name: "a user and a team are rejected", request: V1NotificationRequest{User: "sarahr", Team: "pals"}, wantValid: false},
+		{
+			name: "an email for a list of recipients needs an address for each of them",
+			request: V1NotificationRequest{
+				Recipients:    []string{"sarahr", "ipcdev"},
+				Email:         true,
+				EmailTemplate: "added_to_team",
+				Payload:       map[string]interface{}{EmailAddressesKey: addresses},
+			},
+			wantValid: false,
+		},
+		{
+			name: "an email for a team is accepted",
+			request: V1NotificationRequest{
+				Team:          "pals",
+				Email:         true,
+				EmailTemplate: "added_to_team",
+				Payload:       map[string]interface{}{EmailAddressesKey: addresses},
+			},
+			wantValid: true,
+		},
+		{
+			name: "an email for a team doesn't need any addresses",
+			request: V1NotificationRequest{
+				Team:          "pals",
+				Email:         true,
+				EmailTemplate: "added_to_team",
+			},
+			wantValid: true,
+		},
+		{
+			name: "an email for a team still needs a template",
+			request: V1NotificationRequest{
+				Team:    "pals",
+				Email:   true,
+				Payload: map[string]interface{}{EmailAddressesKey: addresses},
+			},
+			wantValid: false,
+		},
+		{
+			name: "an analysis start date that isn't a timestamp is rejected",
+			request: V1NotificationRequest{
+				User:    "sarahr",
+				Payload: map[string]interface{}{"startdate": true},
+			},
+			wantValid: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.request.Prepare()
+			if tt.wantValid {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
+
+func TestQuietHoursReleaseTime(t *testing.T) {
+	phoenix, err := time.LoadLocation("America/Phoenix")
+	if err != nil {
+		t.Skipf("time zone data is unavailable: %s", err)
+	}
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2026, time.October, day, hour, minute, 0, 0, phoenix)
+	}
+
+	tests := []struct {
+		name        string
+		start, end  string
+		t           time.Time
+		wantQuiet   bool
+		wantRelease time.Time
+	}{
+		{name: "before a daytime window", start: "09:00", end: "17:00", t: at(14, 8, 59)},
+		{name: "inside a daytime window", start: "09:00", end: "17:00", t: at(14, 9, 0), wantQuiet: true, wantRelease: at(14, 17, 0)},
+		{name: "at the end of a daytime window", start: "09:00", end: "17:00", t: at(14, 17, 0)},
+		{name: "late in an overnight window", start: "22:00", end: "07:00", t: at(14, 23, 30), wantQuiet: true, wantRelease: at(15, 7, 0)},
+		{name: "early in an overnight window", start: "22:00", end: "07:00", t: at(15, 3, 0), wantQuiet: true, wantRelease: at(15, 7, 0)},
+		{name: "outside an overnight window", start: "22:00", end: "07:00", t: at(14, 12, 0)},
+		{name: "an empty window", start: "22:00", end: "22:00", t: at(14, 22, 0)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			quietHours := &QuietHours{TimeZone: "America/Phoenix", Start: tt.start, End: tt.end}
+
+			// The time is converted to UTC first to show that the user's time zone is what counts.
+			release, quiet, err := quietHours.ReleaseTime(tt.t.UTC())
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantQuiet, quiet)
+			if tt.wantQuiet {
+				assert.True(t, tt.wantRelease.Equal(release), "released at %s instead of %s", release, tt.wantRelease)
+			}
+		})
+	}
+}
+
+func TestQuietHoursValidation(t *testing.T) {
+	v := validator.New()
+	assert.NoError(t, v.Struct(&QuietHours{TimeZone: "America/Phoenix", Start: "22:00", End: "07:00"}))
+	assert.Error(t, v.Struct(&QuietHours{TimeZone: "Mars/Olympus_Mons", Start: "22:00", End: "07:00"}))
+	assert.Error(t, v.Struct(&QuietHours{TimeZone: "America/Phoenix", Start: "10pm", End: "07:00"}))
+}
@@ -0,0 +1,206 @@
+// Package outbox publishes the email requests and DE UI messages that the recorder stores in the outbox. The recorder
+// stores them in the same transaction as the notifications they belong to, so a message is published if and only if
+// its notification was recorded, and a broker outage delays the message rather than losing it.
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/cyverse-de/messaging/v12"
+	"github.com/cyverse-de/notifications/db"
+	"github.com/sirupsen/logrus"
+)
+
+// log derives from the standard logrus logger, so the formatting and level that main sets up
+// apply here too.
+var log = logrus.WithFields(logrus.Fields{"package": "outbox"})
+
+const (
+	// pollInterval is how often the relay looks for messages to publish. Messages are published within this long of
+	// the notifications being recorded.
+	pollInterval = time.Second
+
+	// batchSize is the maximum number of messages that are claimed in a single transaction.
+	batchSize = 100
+
+	// minRetryDelay is how long the relay waits before trying to publish a message again after the first failure. The
+	// delay doubles with each failure after that, up to maxRetryDelay.
+	minRetryDelay = 5 * time.Second
+
+	// maxRetryDelay is the longest the relay waits between attempts to publish a message.
+	maxRetryDelay = 5 * time.Minute
+)
+
+// MessagingClient is the subset of messaging.Client that the relay uses.
+type MessagingClient interface {
+	PublishEmailRequestContext(context.Context, *messaging.EmailRequest) error
+	PublishNotificationMessageContext(context.Context, *messaging.WrappedNotificationMessage) error
+}
+
+// Relay periodically publishes the messages in the outbox and marks them as sent.
+type Relay struct {
+	db              *sql.DB
+	messagingClient MessagingClient
+	now             func() time.Time
+}
+
+// NewRelay returns a relay that publishes messages with the given messaging client.
+func NewRelay(db *sql.DB, messagingClient MessagingClient) *Relay {
+	return &Relay{
+		db:              db,
+		messagingClient: messagingClient,
+		now:             time.Now,
+	}
+}
+
+// Run publishes the messages in the outbox until the context is canceled.
+func (r *Relay) Run(ctx context.Context) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		r.RelayPending(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RelayPending publishes every message in the outbox that's due. Failures are logged; the messages that couldn't be
+// published stay in the outbox and are tried again later.
+func (r *Relay) RelayPending(ctx context.Context) {
+	now := r.now()
+	for ctx.Err() == nil {
+		published, claimed, err := r.relayBatch(ctx, now)
+		if err != nil {
+			log.Errorf("unable to relay the outbox messages: %s", err)
+			return
+		}
+
+		// Stop once the due messages run out, or if none of the batch could be published; the broker is probably
+		// down.
+		if claimed < batchSize || published == 0 {
+			return
+		}
+	}
+}
+
+// unpublishableError indicates that an outbox message can never be published, such as a message of an unknown kind or
+// one that can't be parsed. Trying again wouldn't help, so the message is marked as failed instead.
+type unpublishableError struct {
+	err error
+}
+
+func (e unpublishableError) Error() string {
+	return e.err.Error()
+}
+
+// retryDelay returns how long to wait before trying to publish a message again after the given number of failed
+// attempts, counting the one that just failed.
+func retryDelay(attempts int) time.Duration {
+	delay := minRetryDelay
+	for i := 1; i < attempts && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, maxRetryDelay)
+}
+
+// publish publishes a single outbox message. An unpublishableError is returned if the message can never be published.
+func (r *Relay) publish(ctx context.Context, message *db.OutboxMessage) error {
+	switch message.Kind {
+	case db.OutboxKindEmail:
+		var emailRequest messaging.EmailRequest
+		if err := json.Unmarshal(message.Message, &emailRequest); err != nil {
+			return unpublishableError{fmt.Errorf("unable to parse the email request: %w", err)}
+		}
+		return r.messagingClient.PublishEmailRequestContext(ctx, &emailRequest)
+	case db.OutboxKindNotification:
+		var notification messaging.WrappedNotificationMessage
+		if err := json.Unmarshal(message.Message, &notification); err != nil {
+			return unpublishableError{fmt.Errorf("unable to parse the notification message: %w", err)}
+		}
+		return r.messagingClient.PublishNotificationMessageContext(ctx, &notification)
+	default:
+		return unpublishableError{fmt.Errorf("unknown outbox message kind: %s", message.Kind)}
+	}
+}
+
+// relayBatch claims and publishes a batch of outbox messages in a single transaction, returning the number of
+// messages that were published and the number that were claimed. The messages that were published are marked as
+// sent, the ones that can never be published are marked as failed, and the others are rescheduled. A replica that
+// finds the messages claimed skips them. The one gap is a replica that dies between publishing and committing, which
+// publishes the messages again later.
+//
+// Messages are published in the order they were recorded, but only as long as they can be published. A rescheduled
+// message doesn't hold back the ones after it, even if they're for the same user: the outbox doesn't record who a
+// message is for, and holding back every later message while one is retried would let a single bad message stall
+// the relay. A DE UI message that's published late carries the unseen count from when it was recorded, so the UI
+// shows a stale count until the next message for that user arrives.
+func (r *Relay) relayBatch(ctx context.Context, now time.Time) (int, int, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	// Claim the messages that are due.
+	messages, err := db.ClaimOutboxMessages(ctx, tx, now, batchSize)
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(messages) == 0 {
+		return 0, 0, nil
+	}
+
+	// Publish them. A message that can't be published is rescheduled with a longer delay each time, unless it can
+	// never be published.
+	sentIDs := make([]string, 0, len(messages))
+	for _, message := range messages {
+		err = r.publish(ctx, message)
+		var unpublishable unpublishableError
+		switch {
+		case errors.As(err, &unpublishable):
+			log.Errorf(
+				"giving up on the %s message for notification %s, which can't be published: %s",
+				message.Kind, message.NotificationID, err,
+			)
+			if err = db.MarkOutboxMessageFailed(ctx, tx, message.ID, now, err.Error()); err != nil {
+				return 0, 0, err
+			}
+		case err != nil:
+			attempts := message.Attempts + 1
+			log.Errorf(
+				"unable to publish the %s message for notification %s (attempt %d): %s",
+				message.Kind, message.NotificationID, attempts, err,
+			)
+			err = db.RescheduleOutboxMessage(ctx, tx, message.ID, now.Add(retryDelay(attempts)), err.Error())
+			if err != nil {
+				return 0, 0, err
+			}
+		default:
+			sentIDs = append(sentIDs, message.ID)
+		}
+	}
+
+	// Mark the messages that were published as sent.
+	if len(sentIDs) > 0 {
+		if err = db.MarkOutboxMessagesSent(ctx, tx, sentIDs, now); err != nil {
+			return 0, 0, err
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return 0, 0, err
+	}
+
+	return len(sentIDs), len(messages), nil
+}
@@ -0,0 +1,147 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/cyverse-de/messaging/v12"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeMessagingClient records the messages it publishes. Publishing email requests fails if failEmails is set.
+type fakeMessagingClient struct {
+	emailRequests []*messaging.EmailRequest
+	notifications []*messaging.WrappedNotificationMessage
+	failEmails    bool
+}
+
+func (f *fakeMessagingClient) PublishEmailRequestContext(_ context.Context, req *messaging.EmailRequest) error {
+	if f.failEmails {
+		return errors.New("the broker is unreachable")
+	}
+	f.emailRequests = append(f.emailRequests, req)
+	return nil
+}
+
+func (f *fakeMessagingClient) PublishNotificationMessageContext(
+	_ context.Context,
+	msg *messaging.WrappedNotificationMessage,
+) error {
+	f.notifications = append(f.notifications, msg)
+	return nil
+}
+
+func TestRelayPendingReschedulesMessagesThatCannotBePublished(t *testing.T) {
+	assert := assert.New(t)
+
+	database, mock, err := sqlmock.New()
+	assert.NoError(err, "unable to open the mock database connection")
+	defer func() { _ = database.Close() }()
+
+	now := time.Date(2026, time.October, 14, 7, 0, 30, 0, time.UTC)
+
+	// The UI message is marked as sent, and the email is tried again after its third failure.
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id, notification_id, kind, message, attempts FROM outbox " +
+		"WHERE sent_at IS NULL AND failed_at IS NULL AND next_attempt_at <= \\$1 " +
+		"ORDER BY time_created, id LIMIT 100 FOR UPDATE SKIP LOCKED").
+		WithArgs(now).
+		WillReturnRows(
+			sqlmock.NewRows([]string{"id", "notification_id", "kind", "message", "attempts"}).
+				AddRow("1", "n1", "email", []byte(`{"template":"analysis_status_change","to":"sarahr@cyverse.org"}`), 2).
+				AddRow("2", "n1", "notification", []byte(`{"message":{"user":"sarahr"},"total":42}`), 0),
+		)
+	mock.ExpectExec("UPDATE outbox SET attempts = attempts \\+ 1, next_attempt_at = \\$1, last_error = \\$2 "+
+		"WHERE id = \\$3").
+		WithArgs(now.Add(20*time.Second), "the broker is unreachable", "1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE outbox SET sent_at = \\$1, attempts = attempts \\+ 1 WHERE id IN \\(\\$2\\)").
+		WithArgs(now, "2").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	messagingClient := &fakeMessagingClient{failEmails: true}
+	relay := NewRelay(database, messagingClient)
+	relay.now = func() time.Time { return now }
+	relay.RelayPending(context.Background())
+
+	assert.NoError(mock.ExpectationsWereMet(), "not all mock expectations were met")
+	assert.Empty(messagingClient.emailRequests)
+	if assert.Len(messagingClient.notifications, 1) {
+		assert.Equal("sarahr", messagingClient.notifications[0].Message.User)
+		assert.Equal(int64(42), messagingClient.notifications[0].Total)
+	}
+}
+
+func TestRelayPendingGivesUpOnMessagesThatCanNeverBePublished(t *testing.T) {
+	assert := assert.New(t)
+
+	database, mock, err := sqlmock.New()
+	assert.NoError(err, "unable to open the mock database connection")
+	defer func() { _ = database.Close() }()
+
+	now := time.Date(2026, time.October, 14, 7, 0, 30, 0, time.UTC)
+
+	// Neither message is rescheduled, because trying again wouldn't help.
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id, notification_id, kind, message, attempts FROM outbox").
+		WithArgs(now).
+		WillReturnRows(
+			sqlmock.NewRows([]string{"id", "notification_id", "kind", "message", "attempts"}).
+				AddRow("1", "n1", "sms", []byte(`{}`), 0).
+				AddRow("2", "n1", "notification", []byte(`{"message":"not an object"}`), 0),
+		)
+	mock.ExpectExec("UPDATE outbox SET attempts = attempts \\+ 1, failed_at = \\$1, last_error = \\$2 WHERE id = \\$3").
+		WithArgs(now, "unknown outbox message kind: sms", "1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE outbox SET attempts = attempts \\+ 1, failed_at = \\$1, last_error = \\$2 WHERE id = \\$3").
+		WithArgs(now, sqlmock.AnyArg(), "2").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	messagingClient := &fakeMessagingClient{}
+	relay := NewRelay(database, messagingClient)
+	relay.now = func() time.Time { return now }
+	relay.RelayPending(context.Background())
+
+	assert.NoError(mock.ExpectationsWereMet(), "not all mock expectations were met")
+	assert.Empty(messagingClient.notifications)
+}
+
+func TestRelayPendingWithNothingPending(t *testing.T) {
+	database, mock, err := sqlmock.New()
+	assert.NoError(t, err, "unable to open the mock database connection")
+	defer func() { _ = database.Close() }()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id, notification_id, kind, message, attempts FROM outbox").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "notification_id", "kind", "message", "attempts"}))
+	mock.ExpectRollback()
+
+	messagingClient := &fakeMessagingClient{}
+	NewRelay(database, messagingClient).RelayPending(context.Background())
+
+	assert.NoError(t, mock.ExpectationsWereMet(), "not all mock expectations were met")
+	assert.Empty(t, messagingClient.emailRequests)
+	assert.Empty(t, messagingClient.notifications)
+}
+
+func TestRetryDelay(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{attempts: 1, want: 5 * time.Second},
+		{attempts: 2, want: 10 * time.Second},
+		{attempts: 3, want: 20 * time.Second},
+		{attempts: 7, want: 5 * time.Minute},
+		{attempts: 1000, want: 5 * time.Minute},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, retryDelay(tt.attempts), "attempts: %d", tt.attempts)
+	}
+}
@@ -0,0 +1,272 @@
+// Package publisher publishes messages to the AMQP exchange: batches of messages whose delivery the broker confirms,
+// and the unseen count updates and broadcast announcements that the API sends to the DE UI.
+package publisher
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/cyverse-de/notifications/common"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// Message is a single message to publish.
+type Message struct {
+	RoutingKey string
+	Body       []byte
+}
+
+// confirmation is a publisher confirmation that can be waited on. It's satisfied by *amqp.DeferredConfirmation.
+type confirmation interface {
+	WaitContext(ctx context.Context) (bool, error)
+}
+
+// channel is the subset of *amqp.Channel that the publisher uses, which allows unit tests to substitute a fake.
+type channel interface {
+	ExchangeDeclare(name, kind string, durable, autoDelete, internal, noWait bool, args amqp.Table) error
+	QueueDeclare(name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error)
+	QueueBind(name, key, exchange string, noWait bool, args amqp.Table) error
+	Confirm(noWait bool) error
+	IsClosed() bool
+	publish(ctx context.Context, exchange, key string, msg amqp.Publishing) (confirmation, error)
+}
+
+// connection is the subset of *amqp.Connection that the publisher uses.
+type connection interface {
+	Close() error
+}
+
+// amqpChannel adapts *amqp.Channel to the channel interface.
+type amqpChannel struct {
+	*amqp.Channel
+}
+
+// publish publishes a message and returns its deferred confirmation.
+func (c amqpChannel) publish(ctx context.Context, exchange, key string, msg amqp.Publishing) (confirmation, error) {
+	return c.PublishWithDeferredConfirmWithContext(ctx, exchange, key, false, false, msg)
+}
+
+// dialAMQP connects to the AMQP broker and opens a channel.
+func dialAMQP(uri string) (connection, channel, error) {
+	conn, err := amqp.Dial(uri)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to connect to the AMQP broker: %w", err)
+	}
+
+	ch, err := conn.Channel()
+	if err != nil {
+		_ = conn.Close()
+		return nil, nil, fmt.Errorf("unable to open an AMQP channel: %w", err)
+	}
+
+	return conn, amqpChannel{ch}, nil
+}
+
+// ConfirmingPublisher publishes messages on a channel in confirm mode, so that callers can tell which of their
+// messages the broker actually accepted. The messaging client that the rest of the service publishes with doesn't
+// support publisher confirms, so this one maintains its own connection. The connection is opened when the first batch
+// is published and reopened whenever it's found to be closed.
+type ConfirmingPublisher struct {
+	settings *common.AMQPSettings
+	dial     func(uri string) (connection, channel, error)
+
+	// mu serializes batches, because the confirmations for a channel arrive in the order that messages were
+	// published on it.
+	mu      sync.Mutex
+	conn    connection
+	channel channel
+}
+
+// New returns a confirming publisher that publishes to the exchange in the given settings. Each publisher has a
+// connection and channel of its own, and publishes one batch or message at a time on it, so callers that shouldn't
+// wait on each other should use separate publishers.
+func New(settings *common.AMQPSettings) *ConfirmingPublisher {
+	return &ConfirmingPublisher{settings: settings, dial: dialAMQP}
+}
+
+// connect opens the connection and the confirming channel if they aren't open already. It must be called with the
+// mutex held.
+func (p *ConfirmingPublisher) connect() error {
+	if p.channel != nil && !p.channel.IsClosed() {
+		return nil
+	}
+	p.closeConnection()
+
+	conn, channel, err := p.dial(p.settings.URI)
+	if err != nil {
+		return err
+	}
+
+	// Declare the exchange the same way the messaging client does, in case nothing else has yet.
+	err = channel.ExchangeDeclare(p.settings.ExchangeName, p.settings.ExchangeType, true, false, false, false, nil)
+	if err != nil {
+		_ = conn.Close()
+		return fmt.Errorf("unable to declare the %s exchange: %w", p.settings.ExchangeName, err)
+	}
+
+	if err = channel.Confirm(false); err != nil {
+		_ = conn.Close()
+		return fmt.Errorf("unable to put the AMQP channel into confirm mode: %w", err)
+	}
+
+	p.conn = conn
+	p.channel = channel
+	return nil
+}
+
+// closeConnection closes the connection if it's open. It must be called with the mutex held.
+func (p *ConfirmingPublisher) closeConnection() {
+	if p.conn != nil {
+		_ = p.conn.Close()
+	}
+	p.conn = nil
+	p.channel = nil
+}
+
+// PublishBatch publishes the messages as persistent JSON messages and waits for the broker to confirm each of them.
+// The returned slice has one entry per message, which is nil if the broker confirmed the message. Messages that
+// weren't confirmed may or may not have been delivered.
+func (p *ConfirmingPublisher) PublishBatch(ctx context.Context, messages []Message) []error {
+	errs := make([]error, len(messages))
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// Every message fails if the broker can't be reached.
+	if err := p.connect(); err != nil {
+		for i := range errs {
+			errs[i] = err
+		}
+		return errs
+	}
+
+	// Publish all of the messages before waiting for any confirmations, so that the broker can confirm them together.
+	confirmations := make([]confirmation, len(messages))
+	for i, message := range messages {
+		msg := amqp.Publishing{
+			DeliveryMode: amqp.Persistent,
+			Timestamp:    time.Now(),
+			ContentType:  "application/json",
+			Body:         message.Body,
+		}
+		confirmations[i], errs[i] = p.channel.publish(ctx, p.settings.ExchangeName, message.RoutingKey, msg)
+	}
+
+	// Wait for the confirmations.
+	for i, confirmation := range confirmations {
+		if errs[i] != nil {
+			continue
+		}
+		acked, err := confirmation.WaitContext(ctx)
+		switch {
+		case err != nil:
+			errs[i] = fmt.Errorf("the broker did not confirm the message: %w", err)
+		case !acked:
+			errs[i] = fmt.Errorf("the broker did not acknowledge the message")
+		}
+	}
+
+	// A closed channel can't be reused, so the next batch will reconnect.
+	if p.channel.IsClosed() {
+		p.closeConnection()
+	}
+
+	return errs
+}
+
+// PublishMessage publishes a single message to the given exchange and waits for the broker to confirm it. Unlike
+// PublishBatch, the message is published exactly as given, so it can go to an exchange other than the configured one,
+// including the default exchange, and carry headers of its own.
+func (p *ConfirmingPublisher) PublishMessage(
+	ctx context.Context,
+	exchange string,
+	routingKey string,
+	msg amqp.Publishing,
+) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := p.connect(); err != nil {
+		return err
+	}
+
+	// Publish the message and wait for the confirmation.
+	confirmation, err := p.channel.publish(ctx, exchange, routingKey, msg)
+	if err == nil {
+		var acked bool
+		acked, err = confirmation.WaitContext(ctx)
+		switch {
+		case err != nil:
+			err = fmt.Errorf("the broker did not confirm the message: %w", err)
+		case !acked:
+			err = fmt.Errorf("the broker did not acknowledge the message")
+		}
+	}
+
+	// A closed channel can't be reused, so the next message will reconnect.
+	if p.channel.IsClosed() {
+		p.closeConnection()
+	}
+
+	return err
+}
+
+// DeclareDeadLetterQueue declares a durable fanout exchange and a durable queue that's bound to it, so that every
+// message published to the exchange is kept in the queue until someone looks into it.
+func (p *ConfirmingPublisher) DeclareDeadLetterQueue(exchange, queue string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := p.connect(); err != nil {
+		return err
+	}
+
+	err := p.channel.ExchangeDeclare(exchange, amqp.ExchangeFanout, true, false, false, false, nil)
+	if err != nil {
+		p.closeConnection()
+		return fmt.Errorf("unable to declare the %s exchange: %w", exchange, err)
+	}
+	if _, err = p.channel.QueueDeclare(queue, true, false, false, false, nil); err != nil {
+		p.closeConnection()
+		return fmt.Errorf("unable to declare the %s queue: %w", queue, err)
+	}
+	if err = p.channel.QueueBind(queue, "", exchange, false, nil); err != nil {
+		p.closeConnection()
+		return fmt.Errorf("unable to bind the %s queue to the %s exchange: %w", queue, exchange, err)
+	}
+
+	return nil
+}
+
+// DeclareRetryQueue declares a durable queue that holds each message for ttl and then dead-letters it through the
+// default exchange to deadLetterQueue. Messages are published to it through the default exchange as well, with the
+// queue's name as the routing key, so that other queues don't see them.
+func (p *ConfirmingPublisher) DeclareRetryQueue(queue, deadLetterQueue string, ttl time.Duration) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := p.connect(); err != nil {
+		return err
+	}
+
+	args := amqp.Table{
+		"x-message-ttl":             ttl.Milliseconds(),
+		"x-dead-letter-exchange":    "",
+		"x-dead-letter-routing-key": deadLetterQueue,
+	}
+	if _, err := p.channel.QueueDeclare(queue, true, false, false, false, args); err != nil {
+		p.closeConnection()
+		return fmt.Errorf("unable to declare the %s queue: %w", queue, err)
+	}
+
+	return nil
+}
+
+// Close closes the connection to the broker.
+func (p *ConfirmingPublisher) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closeConnection()
+}
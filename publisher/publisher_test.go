@@ -0,0 +1,216 @@
+package publisher
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/cyverse-de/notifications/common"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeConfirmation is a confirmation that has already arrived.
+type fakeConfirmation struct {
+	acked bool
+	err   error
+}
+
+func (c fakeConfirmation) WaitContext(context.Context) (bool, error) {
+	return c.acked, c.err
+}
+
+// published is a message that was published on a fake channel.
+type published struct {
+	exchange string
+	key      string
+	msg      amqp.Publishing
+}
+
+// fakeChannel records what's done with it. The broker nacks the messages whose routing keys are in nacked.
+type fakeChannel struct {
+	exchanges map[string]string
+	queues    []string
+	queueArgs map[string]amqp.Table
+	bindings  []string
+	confirm   bool
+	closed    bool
+	nacked    map[string]bool
+	published []published
+}
+
+func newFakeChannel() *fakeChannel {
+	return &fakeChannel{
+		exchanges: make(map[string]string),
+		queueArgs: make(map[string]amqp.Table),
+		nacked:    make(map[string]bool),
+	}
+}
+
+func (c *fakeChannel) ExchangeDeclare(name, kind string, _, _, _, _ bool, _ amqp.Table) error {
+	c.exchanges[name] = kind
+	return nil
+}
+
+func (c *fakeChannel) QueueDeclare(name string, _, _, _, _ bool, args amqp.Table) (amqp.Queue, error) {
+	c.queues = append(c.queues, name)
+	c.queueArgs[name] = args
+	return amqp.Queue{Name: name}, nil
+}
+
+func (c *fakeChannel) QueueBind(name, _, exchange string, _ bool, _ amqp.Table) error {
+	c.bindings = append(c.bindings, name+"->"+exchange)
+	return nil
+}
+
+func (c *fakeChannel) Confirm(bool) error {
+	c.confirm = true
+	return nil
+}
+
+func (c *fakeChannel) IsClosed() bool {
+	return c.closed
+}
+
+func (c *fakeChannel) publish(_ context.Context, exchange, key string, msg amqp.Publishing) (confirmation, error) {
+	c.published = append(c.published, published{exchange: exchange, key: key, msg: msg})
+	return fakeConfirmation{acked: !c.nacked[key]}, nil
+}
+
+// fakeConnection records whether it was closed.
+type fakeConnection struct {
+	closed bool
+}
+
+func (c *fakeConnection) Close() error {
+	c.closed = true
+	return nil
+}
+
+// newFakePublisher returns a publisher whose connections are fakes. The channels it opens are returned in the order
+// that they're opened.
+func newFakePublisher(dialErr error) (*ConfirmingPublisher, *[]*fakeChannel, *[]*fakeConnection) {
+	var channels []*fakeChannel
+	var connections []*fakeConnection
+	p := New(&common.AMQPSettings{URI: "amqp://broker", ExchangeName: "de", ExchangeType: "topic"})
+	p.dial = func(string) (connection, channel, error) {
+		if dialErr != nil {
+			return nil, nil, dialErr
+		}
+		conn, ch := &fakeConnection{}, newFakeChannel()
+		connections = append(connections, conn)
+		channels = append(channels, ch)
+		return conn, ch, nil
+	}
+	return p, &channels, &connections
+}
+
+func TestPublishBatch(t *testing.T) {
+	assert := assert.New(t)
+
+	p, channels, _ := newFakePublisher(nil)
+	p.connectAndNack(t, "notification.ipcdev")
+
+	errs := p.PublishBatch(context.Background(), []Message{
+		{RoutingKey: "notification.sarahr", Body: []byte(`{"n":1}`)},
+		{RoutingKey: "notification.ipcdev", Body: []byte(`{"n":2}`)},
+	})
+
+	if assert.Len(errs, 2) {
+		assert.NoError(errs[0])
+		assert.Error(errs[1], "a message that the broker nacked was reported as published")
+	}
+	ch := (*channels)[0]
+	assert.True(ch.confirm, "the channel wasn't put into confirm mode")
+	assert.Equal("topic", ch.exchanges["de"])
+	if assert.Len(ch.published, 2) {
+		assert.Equal("de", ch.published[0].exchange)
+		assert.Equal("notification.sarahr", ch.published[0].key)
+		assert.Equal(amqp.Persistent, ch.published[0].msg.DeliveryMode)
+		assert.Equal("application/json", ch.published[0].msg.ContentType)
+		assert.Equal([]byte(`{"n":1}`), ch.published[0].msg.Body)
+	}
+}
+
+// connectAndNack connects the publisher and makes the broker nack messages with the given routing key.
+func (p *ConfirmingPublisher) connectAndNack(t *testing.T, key string) {
+	t.Helper()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err := p.connect(); err != nil {
+		t.Fatalf("unable to connect: %s", err)
+	}
+	p.channel.(*fakeChannel).nacked[key] = true
+}
+
+func TestPublishBatchFailsEveryMessageWithoutABroker(t *testing.T) {
+	p, _, _ := newFakePublisher(errors.New("connection refused"))
+
+	errs := p.PublishBatch(context.Background(), []Message{{RoutingKey: "a"}, {RoutingKey: "b"}})
+
+	assert.Len(t, errs, 2)
+	for _, err := range errs {
+		assert.ErrorContains(t, err, "connection refused")
+	}
+}
+
+func TestPublisherReconnectsAfterTheChannelCloses(t *testing.T) {
+	assert := assert.New(t)
+
+	p, channels, connections := newFakePublisher(nil)
+	msg := amqp.Publishing{Body: []byte(`{}`)}
+
+	assert.NoError(p.PublishMessage(context.Background(), "", "event_listener", msg))
+	(*channels)[0].closed = true
+	assert.NoError(p.PublishMessage(context.Background(), "", "event_listener", msg))
+
+	assert.Len(*channels, 2, "the publisher didn't reconnect")
+	assert.True((*connections)[0].closed, "the old connection wasn't closed")
+	assert.Len((*channels)[1].published, 1)
+}
+
+func TestPublishMessagePublishesTheMessageAsGiven(t *testing.T) {
+	assert := assert.New(t)
+
+	p, channels, _ := newFakePublisher(nil)
+	msg := amqp.Publishing{Headers: amqp.Table{"x-retry-count": int32(2)}, Body: []byte(`{}`)}
+
+	assert.NoError(p.PublishMessage(context.Background(), "event_listener.dlx", "events.notification.update.x", msg))
+
+	if ch := (*channels)[0]; assert.Len(ch.published, 1) {
+		assert.Equal("event_listener.dlx", ch.published[0].exchange)
+		assert.Equal("events.notification.update.x", ch.published[0].key)
+		assert.Equal(msg, ch.published[0].msg)
+	}
+}
+
+func TestDeclareDeadLetterQueue(t *testing.T) {
+	assert := assert.New(t)
+
+	p, channels, _ := newFakePublisher(nil)
+
+	assert.NoError(p.DeclareDeadLetterQueue("event_listener.dlx", "event_listener.dead"))
+
+	ch := (*channels)[0]
+	assert.Equal(amqp.ExchangeFanout, ch.exchanges["event_listener.dlx"])
+	assert.Equal([]string{"event_listener.dead"}, ch.queues)
+	assert.Equal([]string{"event_listener.dead->event_listener.dlx"}, ch.bindings)
+}
+
+func TestDeclareRetryQueue(t *testing.T) {
+	assert := assert.New(t)
+
+	p, channels, _ := newFakePublisher(nil)
+
+	assert.NoError(p.DeclareRetryQueue("event_listener.retry.10s", "event_listener", 10*time.Second))
+
+	ch := (*channels)[0]
+	assert.Equal([]string{"event_listener.retry.10s"}, ch.queues)
+	assert.Equal(amqp.Table{
+		"x-message-ttl":             int64(10000),
+		"x-dead-letter-exchange":    "",
+		"x-dead-letter-routing-key": "event_listener",
+	}, ch.queueArgs["event_listener.retry.10s"])
+	assert.Empty(ch.bindings, "the retry queue was bound to an exchange")
+}
@@ -0,0 +1,61 @@
+package publisher
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/cyverse-de/messaging/v12"
+	"github.com/cyverse-de/notifications/common"
+	"github.com/cyverse-de/notifications/model"
+	"github.com/pkg/errors"
+)
+
+// MessagingClient is the subset of messaging.Client that's used to publish messages to the DE UI, which keeps the mock
+// client that's used in unit tests small.
+type MessagingClient interface {
+	PublishContextOpts(context.Context, string, []byte, *messaging.PublishingOpts) error
+}
+
+// PublishUnseenCount sends an unseen count update to the DE UI, so that the badge counts in the user's other browser
+// tabs and devices stay current. It should only be called after the transaction commits. Callers log the error rather
+// than fail the request because the update itself succeeded; the UI catches up the next time a notification arrives.
+func PublishUnseenCount(ctx context.Context, client MessagingClient, user string, total int64) error {
+	wrapMsg := fmt.Sprintf("unable to publish the unseen count update for %s", user)
+
+	body, err := json.Marshal(model.NewUnseenCountUpdate(total))
+	if err != nil {
+		return errors.Wrap(err, wrapMsg)
+	}
+
+	routingKey := fmt.Sprintf("notification.%s", common.BareUsername(user))
+	err = client.PublishContextOpts(ctx, routingKey, body, messaging.JSONPublishingOpts)
+	if err != nil {
+		return errors.Wrap(err, wrapMsg)
+	}
+
+	return nil
+}
+
+// BroadcastRoutingKey is the routing key of the message that's published when a broadcast is sent.
+const BroadcastRoutingKey = "broadcast"
+
+// PublishBroadcast sends a single message announcing a broadcast to the DE UI, no matter how many users it was sent
+// to. The message doesn't carry an unseen count, because it differs from one recipient to the next; clients that
+// receive it refresh the count of any recipient they're showing. It should only be called after the transaction
+// commits, and callers log the error rather than fail the request, for the same reason as PublishUnseenCount.
+func PublishBroadcast(ctx context.Context, client MessagingClient, broadcast *model.Broadcast) error {
+	wrapMsg := fmt.Sprintf("unable to publish broadcast %s", broadcast.ID)
+
+	body, err := json.Marshal(broadcast)
+	if err != nil {
+		return errors.Wrap(err, wrapMsg)
+	}
+
+	err = client.PublishContextOpts(ctx, BroadcastRoutingKey, body, messaging.JSONPublishingOpts)
+	if err != nil {
+		return errors.Wrap(err, wrapMsg)
+	}
+
+	return nil
+}
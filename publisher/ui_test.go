@@ -0,0 +1,51 @@
+package publisher
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/cyverse-de/messaging/v12"
+	"github.com/cyverse-de/notifications/model"
+	"github.com/stretchr/testify/assert"
+)
+
+// mockMessagingClient records the last message that was published.
+type mockMessagingClient struct {
+	routingKey string
+	body       []byte
+}
+
+func (m *mockMessagingClient) PublishContextOpts(_ context.Context, key string, body []byte, _ *messaging.PublishingOpts) error {
+	m.routingKey = key
+	m.body = body
+	return nil
+}
+
+func TestPublishUnseenCount(t *testing.T) {
+	assert := assert.New(t)
+
+	client := &mockMessagingClient{}
+	assert.NoError(PublishUnseenCount(context.Background(), client, "sarahr@iplantcollaborative.org", 3))
+	assert.Equal("notification.sarahr", client.routingKey)
+	assert.JSONEq(`{"type":"unseen_count","total":3}`, string(client.body))
+}
+
+func TestPublishBroadcast(t *testing.T) {
+	assert := assert.New(t)
+
+	client := &mockMessagingClient{}
+	broadcast := &model.Broadcast{
+		ID:           "5d37b6c4-4f4b-11ee-9f9a-62d3d0b6a3a1",
+		Audience:     model.BroadcastAudienceUsers,
+		Users:        []string{"sarahr", "ipcdev"},
+		Notification: &model.Notification{Type: "system", Subject: "maintenance tonight", Broadcast: true},
+	}
+	assert.NoError(PublishBroadcast(context.Background(), client, broadcast))
+	assert.Equal("broadcast", client.routingKey, "a broadcast must be published once rather than once per user")
+
+	var message model.Broadcast
+	assert.NoError(json.Unmarshal(client.body, &message))
+	assert.Equal([]string{"sarahr", "ipcdev"}, message.Users)
+	assert.Equal("maintenance tonight", message.Notification.Subject)
+}
@@ -109,6 +109,12 @@ func ValidateUUIDQueryParam(ctx echo.Context, name string, required bool) (strin
 	return value, nil
 }
 
+// ValidateUUID verifies that a value is an RFC 4122 UUID, for identifiers that arrive somewhere other than a query
+// or path parameter.
+func ValidateUUID(value string) error {
+	return v.Var(value, "uuid_rfc4122")
+}
+
 // ValidateBoolPQueryParam extracts and validates an optional Boolean query parameter.
 func ValidateBoolPQueryParam(ctx echo.Context, name string) (*bool, error) {
 	errMsg := fmt.Sprintf("invalid query parameter: %s", name)
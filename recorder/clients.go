@@ -3,11 +3,13 @@ package recorder
 import (
 	"context"
 	"database/sql"
+	"time"
 
 	"github.com/cyverse-de/notifications/db"
 
 	"github.com/cyverse-de/messaging/v12"
 	"github.com/cyverse-de/notifications/common"
+	"github.com/cyverse-de/notifications/model"
 )
 
 // MessagingClient is a subset of messaging.Client. Its purpose is to limit the number of mock
@@ -28,6 +30,16 @@ type DatabaseClient interface {
 	SaveNotification(context.Context, *sql.Tx, *common.Notification) error
 	SaveOutgoingNotification(context.Context, *sql.Tx, *messaging.NotificationMessage) error
 	CountUnreadNotifications(context.Context, *sql.Tx, string) (int64, error)
+	AnnounceNotification(context.Context, *sql.Tx, string, string) error
+	GetPreference(context.Context, *sql.Tx, string, string) (*model.NotificationPreference, error)
+	SavePendingDigestItem(context.Context, *sql.Tx, string, string, *messaging.EmailRequest) error
+	GetQuietHours(context.Context, *sql.Tx, string) (*model.QuietHours, error)
+	DeferEmail(context.Context, *sql.Tx, string, *messaging.EmailRequest, time.Time) error
+	ClaimIdempotencyKey(context.Context, *sql.Tx, *db.IdempotencyKey, time.Time, time.Time) (bool, []string, error)
+	SaveIdempotencyKeyNotifications(context.Context, *sql.Tx, *db.IdempotencyKey, []string) error
+	QueueEmailRequest(context.Context, *sql.Tx, string, *messaging.EmailRequest) error
+	QueueNotificationMessage(context.Context, *sql.Tx, string, *messaging.WrappedNotificationMessage) error
+	QuarantineEvent(context.Context, *sql.Tx, string, []byte, string) error
 }
 
 // DatabaseClientImpl provides the default implementation of DatabaseClient.
@@ -75,6 +87,104 @@ func (c *DatabaseClientImpl) CountUnreadNotifications(ctx context.Context, tx *s
 	return db.CountUnreadNotifications(ctx, tx, user)
 }
 
+// AnnounceNotification tells every replica of the service that a notification was recorded once the transaction
+// commits.
+func (c *DatabaseClientImpl) AnnounceNotification(ctx context.Context, tx *sql.Tx, id, user string) error {
+	return db.AnnounceNotification(ctx, tx, id, user)
+}
+
+// GetPreference returns the user's preferences for a notification type.
+func (c *DatabaseClientImpl) GetPreference(
+	ctx context.Context,
+	tx *sql.Tx,
+	user string,
+	notificationType string,
+) (*model.NotificationPreference, error) {
+	return db.GetPreference(ctx, tx, user, notificationType)
+}
+
+// SavePendingDigestItem stores an email request so that it can be sent later as part of a digest.
+func (c *DatabaseClientImpl) SavePendingDigestItem(
+	ctx context.Context,
+	tx *sql.Tx,
+	notificationID string,
+	period string,
+	emailRequest *messaging.EmailRequest,
+) error {
+	return db.SavePendingDigestItem(ctx, tx, notificationID, period, emailRequest)
+}
+
+// GetQuietHours returns the user's quiet hours, or nil if the user hasn't set any.
+func (c *DatabaseClientImpl) GetQuietHours(ctx context.Context, tx *sql.Tx, user string) (*model.QuietHours, error) {
+	return db.GetQuietHours(ctx, tx, user)
+}
+
+// DeferEmail stores an email request so that it can be sent at the given time.
+func (c *DatabaseClientImpl) DeferEmail(
+	ctx context.Context,
+	tx *sql.Tx,
+	notificationID string,
+	emailRequest *messaging.EmailRequest,
+	releaseAt time.Time,
+) error {
+	return db.DeferEmail(ctx, tx, notificationID, emailRequest, releaseAt)
+}
+
+// ClaimIdempotencyKey claims the idempotency key of a notification request, and reports false along with the IDs of
+// the notifications that were recorded for the original request if the key was claimed already and hasn't expired.
+func (c *DatabaseClientImpl) ClaimIdempotencyKey(
+	ctx context.Context,
+	tx *sql.Tx,
+	key *db.IdempotencyKey,
+	now time.Time,
+	expiresAt time.Time,
+) (bool, []string, error) {
+	return db.ClaimIdempotencyKey(ctx, tx, key, now, expiresAt)
+}
+
+// SaveIdempotencyKeyNotifications stores the IDs of the notifications that were recorded for the request that
+// claimed an idempotency key.
+func (c *DatabaseClientImpl) SaveIdempotencyKeyNotifications(
+	ctx context.Context,
+	tx *sql.Tx,
+	key *db.IdempotencyKey,
+	ids []string,
+) error {
+	return db.SaveIdempotencyKeyNotifications(ctx, tx, key, ids)
+}
+
+// QueueEmailRequest stores an email request in the outbox so that it's published once the transaction commits.
+func (c *DatabaseClientImpl) QueueEmailRequest(
+	ctx context.Context,
+	tx *sql.Tx,
+	notificationID string,
+	emailRequest *messaging.EmailRequest,
+) error {
+	return db.SaveOutboxMessage(ctx, tx, notificationID, db.OutboxKindEmail, emailRequest)
+}
+
+// QueueNotificationMessage stores a DE UI notification message in the outbox so that it's published once the
+// transaction commits.
+func (c *DatabaseClientImpl) QueueNotificationMessage(
+	ctx context.Context,
+	tx *sql.Tx,
+	notificationID string,
+	notification *messaging.WrappedNotificationMessage,
+) error {
+	return db.SaveOutboxMessage(ctx, tx, notificationID, db.OutboxKindNotification, notification)
+}
+
+// QuarantineEvent stores a notification event that couldn't be recorded so that it can be replayed later.
+func (c *DatabaseClientImpl) QuarantineEvent(
+	ctx context.Context,
+	tx *sql.Tx,
+	routingKey string,
+	body []byte,
+	errorText string,
+) error {
+	return db.QuarantineEvent(ctx, tx, routingKey, body, errorText)
+}
+
 // NewDatabaseClient creates a new default database client implementation.
 func NewDatabaseClient(db *sql.DB) DatabaseClient {
 	return &DatabaseClientImpl{db: db}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"runtime/debug"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -20,49 +21,138 @@ import (
 const QueueName = "event_listener"
 const RoutingKey = "events.*.update.*"
 
-// eventCategory is the routing key category this service records. The binding admits
-// events.*.update.*, but notifications are the only category anything publishes.
-const eventCategory = "notification"
+// DeadLetterExchange and DeadLetterQueue are where deliveries end up once they've failed too many
+// times. The deliveries stay in the queue until someone looks into them.
+const DeadLetterExchange = "event_listener.dlx"
+const DeadLetterQueue = "event_listener.dead"
 
-// prefetchCount is the number of unacknowledged deliveries the broker will hand this consumer.
-const prefetchCount = 100
+// RetryCountHeader is the header that counts the number of times a delivery has been retried.
+const RetryCountHeader = "x-retry-count"
 
-// requeueDelay is how long a delivery is held onto before it's requeued. Requeueing immediately
-// turns a failure that keeps recurring, such as an unreachable database, into a hot loop that pegs
-// a CPU and floods the logs.
-const requeueDelay = 5 * time.Second
+// RoutingKeyHeader is the header that keeps the original routing key of a delivery that was
+// retried. Retries come back to the queue from a retry queue, which replaces the routing key.
+const RoutingKeyHeader = "x-original-routing-key"
 
-// Consumer dispatches incoming AMQP deliveries to a Recorder.
+// HoldHeader is the header that identifies a delivery that's parked in a retry queue, either to be
+// retried or behind another delivery for the same user that is.
+const HoldHeader = "x-hold-id"
+
+// ErrorHeader is the header that describes the last error for a delivery that was dead-lettered.
+const ErrorHeader = "x-last-error"
+
+// RetryQueuePrefix is the prefix of the names of the queues that deliveries wait in before they're
+// retried. There's a queue for each delay, named after the delay, such as event_listener.retry.10s.
+// Each queue holds its deliveries for its delay and then dead-letters them back to QueueName.
+const RetryQueuePrefix = QueueName + ".retry."
+
+// DefaultMaxRetries is the number of times a delivery is retried if no other maximum is configured.
+const DefaultMaxRetries = 10
+
+const (
+	// minRequeueDelay is how long a delivery waits before it's retried the first time. The
+	// delay doubles with each retry after that, up to maxRequeueDelay. Retrying immediately turns a
+	// failure that keeps recurring, such as an unreachable database, into a hot loop that pegs a CPU
+	// and floods the logs.
+	minRequeueDelay = 5 * time.Second
+
+	// maxRequeueDelay is the longest a delivery waits before it's retried.
+	maxRequeueDelay = 5 * time.Minute
+)
+
+// Republisher publishes copies of deliveries and waits for the broker to confirm them, so that the
+// original delivery is only acknowledged once the copy is safe. It's satisfied by
+// *publisher.ConfirmingPublisher.
+type Republisher interface {
+	PublishMessage(ctx context.Context, exchange, routingKey string, msg amqp.Publishing) error
+	DeclareDeadLetterQueue(exchange, queue string) error
+	DeclareRetryQueue(queue, deadLetterQueue string, ttl time.Duration) error
+}
+
+// Consumer dispatches incoming AMQP deliveries to the handlers registered for their categories.
+// Deliveries are handled by a pool of workers, each of which handles the deliveries for its share
+// of the users one at a time, so that each user's events are handled in the order they were
+// delivered while different users' events are handled in parallel.
 type Consumer struct {
-	amqpClient   *messaging.Client
-	publisher    MessagingClient
-	amqpSettings *common.AMQPSettings
-	supportEmail string
-	recorder     *Recorder
-	inFlight     atomic.Int64
+	amqpClient      *messaging.Client
+	publisher       MessagingClient
+	republisher     Republisher
+	amqpSettings    *common.AMQPSettings
+	supportEmail    string
+	recorder        *Recorder
+	handlers        *Handlers
+	maxRetries      int
+	minRequeueDelay time.Duration
+	maxRequeueDelay time.Duration
+	workers         int
+	prefetch        int
+	sequencer       *sequencer
+	shards          []chan *job
+	inFlight        atomic.Int64
+	holdsMu         sync.Mutex
+	holds           map[string]*hold
+
+	// stopping is closed when the consumer starts draining, and ctx is cancelled when the drain
+	// window runs out.
+	stopping chan struct{}
+	stopOnce sync.Once
+	ctx      context.Context
+	cancel   context.CancelFunc
 }
 
-// NewConsumer creates a consumer that records deliveries from the event queue. The AMQP client is
-// supplied by the caller so that the process owns every connection's lifetime. The publisher is a
-// separate client because a failure on the connection used to publish must not disrupt consumption.
+// NewConsumer creates a consumer that dispatches deliveries from the event queue to the handlers
+// for their categories. Deliveries that have to be discarded are quarantined through the recorder.
+// The AMQP client is supplied by the caller so that the process owns every connection's lifetime.
+// The publisher is a separate client because a failure on the connection used to publish must not
+// disrupt consumption. The republisher retries and dead-letters deliveries. Deliveries that fail
+// with recoverable errors are retried up to maxRetries times, or DefaultMaxRetries times if it's
+// zero. Deliveries are handled by the given number of workers, or DefaultWorkers if it's zero, and
+// the broker hands the consumer up to prefetch unacknowledged deliveries at a time, or
+// DefaultPrefetch if it's zero.
 func NewConsumer(
 	amqpClient *messaging.Client,
 	publisher MessagingClient,
+	republisher Republisher,
 	amqpSettings *common.AMQPSettings,
 	supportEmail string,
 	recorder *Recorder,
+	handlers *Handlers,
+	maxRetries int,
+	workers int,
+	prefetch int,
 ) *Consumer {
+	if maxRetries == 0 {
+		maxRetries = DefaultMaxRetries
+	}
+	if workers == 0 {
+		workers = DefaultWorkers
+	}
+	if prefetch == 0 {
+		prefetch = DefaultPrefetch
+	}
+	ctx, cancel := context.WithCancel(context.Background())
 	return &Consumer{
-		amqpClient:   amqpClient,
-		publisher:    publisher,
-		amqpSettings: amqpSettings,
-		supportEmail: supportEmail,
-		recorder:     recorder,
+		amqpClient:      amqpClient,
+		publisher:       publisher,
+		republisher:     republisher,
+		amqpSettings:    amqpSettings,
+		supportEmail:    supportEmail,
+		recorder:        recorder,
+		handlers:        handlers,
+		maxRetries:      maxRetries,
+		minRequeueDelay: minRequeueDelay,
+		maxRequeueDelay: maxRequeueDelay,
+		workers:         workers,
+		prefetch:        prefetch,
+		sequencer:       newSequencer(),
+		holds:           make(map[string]*hold),
+		stopping:        make(chan struct{}),
+		ctx:             ctx,
+		cancel:          cancel,
 	}
 }
 
-// parseRoutingKey extracts the event category and update type from the delivery tag.
-func (c *Consumer) parseRoutingKey(tag string) (string, string, error) {
+// ParseRoutingKey extracts the event category and update type from a routing key.
+func ParseRoutingKey(tag string) (string, string, error) {
 	components := strings.Split(tag, ".")
 	if len(components) < 4 {
 		return "", "", fmt.Errorf("routing key %s has too few components", tag)
@@ -86,13 +176,168 @@ func (c *Consumer) nack(delivery amqp.Delivery, requeue bool) {
 	}
 }
 
-// requeue holds onto a delivery for a while and then returns it to the queue to be retried.
-func (c *Consumer) requeue(ctx context.Context, delivery amqp.Delivery) {
-	select {
-	case <-time.After(requeueDelay):
-	case <-ctx.Done():
+// routingKey returns the routing key that a delivery was originally published with.
+func routingKey(delivery amqp.Delivery) string {
+	if key, ok := delivery.Headers[RoutingKeyHeader].(string); ok && key != "" {
+		return key
+	}
+	return delivery.RoutingKey
+}
+
+// headerInt converts an integer header value to an int. The broker hands integers back in
+// whichever width they were encoded in.
+func headerInt(value interface{}) (int, bool) {
+	switch v := value.(type) {
+	case int:
+		return v, true
+	case int8:
+		return int(v), true
+	case int16:
+		return int(v), true
+	case int32:
+		return int(v), true
+	case int64:
+		return int(v), true
+	case uint8:
+		return int(v), true
+	case uint16:
+		return int(v), true
+	case uint32:
+		return int(v), true
+	default:
+		return 0, false
+	}
+}
+
+// retryCount returns the number of times a delivery has been retried. The count comes from the
+// retry header, or from the x-death header if the broker dead-lettered the delivery back to the
+// queue, such as under a dead-letter policy that an operator added.
+func retryCount(delivery amqp.Delivery) int {
+	count, _ := headerInt(delivery.Headers[RetryCountHeader])
+
+	deaths, _ := delivery.Headers["x-death"].([]interface{})
+	deathCount := 0
+	for _, death := range deaths {
+		table, ok := death.(amqp.Table)
+		if !ok || table["queue"] != QueueName {
+			continue
+		}
+		if n, ok := headerInt(table["count"]); ok {
+			deathCount += n
+		}
+	}
+
+	return max(count, deathCount)
+}
+
+// requeueDelay returns how long a delivery waits before it's retried, given the number of times it
+// has been retried already.
+func (c *Consumer) requeueDelay(retries int) time.Duration {
+	delay := c.minRequeueDelay
+	for i := 0; i < retries && delay < c.maxRequeueDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, c.maxRequeueDelay)
+}
+
+// retryQueue returns the name of the queue that deliveries wait in for the given delay.
+func retryQueue(delay time.Duration) string {
+	return RetryQueuePrefix + delay.String()
+}
+
+// declareRetryQueues declares a retry queue for each of the delays that deliveries can wait for.
+func (c *Consumer) declareRetryQueues() error {
+	for retries := 0; ; retries++ {
+		delay := c.requeueDelay(retries)
+		if err := c.republisher.DeclareRetryQueue(retryQueue(delay), QueueName, delay); err != nil {
+			return err
+		}
+		if delay >= c.maxRequeueDelay {
+			return nil
+		}
+	}
+}
+
+// republishedCopy returns a copy of a delivery that can be published again, with the given
+// headers added to the delivery's own.
+func republishedCopy(delivery amqp.Delivery, headers amqp.Table) amqp.Publishing {
+	copiedHeaders := make(amqp.Table, len(delivery.Headers)+len(headers)+1)
+	for k, v := range delivery.Headers {
+		copiedHeaders[k] = v
 	}
-	c.nack(delivery, true)
+	for k, v := range headers {
+		copiedHeaders[k] = v
+	}
+	copiedHeaders[RoutingKeyHeader] = routingKey(delivery)
+
+	return amqp.Publishing{
+		Headers:         copiedHeaders,
+		ContentType:     delivery.ContentType,
+		ContentEncoding: delivery.ContentEncoding,
+		DeliveryMode:    amqp.Persistent,
+		MessageId:       delivery.MessageId,
+		Timestamp:       delivery.Timestamp,
+		Type:            delivery.Type,
+		AppId:           delivery.AppId,
+		Body:            delivery.Body,
+	}
+}
+
+// park publishes a copy of a delivery with the given headers to the retry queue for the given
+// delay, and acknowledges the original. The broker puts the copy back on the queue once the delay
+// has passed. The copy is published straight to the retry queue rather than to the exchange, so
+// that other queues bound to the exchange don't see the event again. If the copy can't be
+// published, the delivery itself goes back on the queue and false is returned.
+func (c *Consumer) park(ctx context.Context, delivery amqp.Delivery, delay time.Duration, headers amqp.Table) bool {
+	msg := republishedCopy(delivery, headers)
+	if err := c.republisher.PublishMessage(ctx, "", retryQueue(delay), msg); err != nil {
+		log.Errorf("unable to park a delivery in a retry queue; requeuing it instead: %s", err)
+		c.nack(delivery, true)
+		return false
+	}
+	c.ack(delivery)
+	return true
+}
+
+// retry parks a delivery that failed with an error that might go away, with its retry count
+// incremented, for a delay that doubles with each retry. The user's later deliveries are held back
+// until it has been handled. A delivery that has been retried too many times is dead-lettered
+// instead, and the support address is told about it.
+func (c *Consumer) retry(ctx context.Context, key string, delivery amqp.Delivery, cause error) {
+	retries := retryCount(delivery)
+	if retries >= c.maxRetries {
+		unrecoverable := NewUnrecoverableError(
+			"giving up on the delivery after %d retries: %s", retries, cause.Error(),
+		)
+		log.Error(unrecoverable.Error())
+		c.sendUnrecoverableErrorEmail(ctx, delivery, unrecoverable)
+		c.deadLetter(ctx, delivery, unrecoverable)
+		return
+	}
+
+	log.Errorf("retrying message (retry %d of %d) because of a recoverable error: %s", retries+1, c.maxRetries, cause)
+	c.logDelivery("requeued delivery", delivery)
+
+	// If the copy can't be published, the delivery goes back on the queue without counting the retry.
+	delay := c.requeueDelay(retries)
+	token := c.holdFor(key, delay)
+	if !c.park(ctx, delivery, delay, amqp.Table{RetryCountHeader: int32(retries + 1), HoldHeader: token}) {
+		c.unpark(key, token)
+	}
+}
+
+// deadLetter publishes a copy of a delivery to the dead-letter exchange and acknowledges the
+// original. The delivery goes back on the queue if the copy can't be published, so that it's
+// never lost.
+func (c *Consumer) deadLetter(ctx context.Context, delivery amqp.Delivery, cause error) {
+	msg := republishedCopy(delivery, amqp.Table{ErrorHeader: cause.Error()})
+	if err := c.republisher.PublishMessage(ctx, DeadLetterExchange, routingKey(delivery), msg); err != nil {
+		log.Errorf("unable to dead-letter a delivery; requeuing it instead: %s", err)
+		c.nack(delivery, true)
+		return
+	}
+	c.logDelivery("dead-lettered delivery", delivery)
+	c.ack(delivery)
 }
 
 // recoverFromPanic keeps a defect in the recording path from taking down the process, which also
@@ -105,7 +350,19 @@ func (c *Consumer) recoverFromPanic(ctx context.Context, delivery amqp.Delivery)
 
 	cause := NewUnrecoverableError("panic while recording a notification event: %v\n%s", r, debug.Stack())
 	log.Error(cause.Error())
+	c.discard(ctx, delivery, cause)
+}
+
+// discard quarantines a delivery that can't be recorded, so that it can be fixed and replayed
+// later, tells the support address about it, and removes it from the queue. A delivery that can't
+// be quarantined is dead-lettered instead, so that it isn't lost.
+func (c *Consumer) discard(ctx context.Context, delivery amqp.Delivery, cause UnrecoverableError) {
 	c.sendUnrecoverableErrorEmail(ctx, delivery, cause)
+	if err := c.recorder.Quarantine(ctx, routingKey(delivery), delivery.Body, cause); err != nil {
+		log.Errorf("unable to quarantine the discarded delivery; dead-lettering it instead: %s", err.Error())
+		c.deadLetter(ctx, delivery, cause)
+		return
+	}
 	c.logDelivery("discarded delivery", delivery)
 	c.nack(delivery, false)
 }
@@ -122,7 +379,7 @@ func (c *Consumer) sendUnrecoverableErrorEmail(ctx context.Context, delivery amq
 		TemplateName: "notifications_event_discarded",
 		TemplateValues: map[string]interface{}{
 			"error":        cause.Error(),
-			"routing_key":  delivery.RoutingKey,
+			"routing_key":  routingKey(delivery),
 			"message_body": string(delivery.Body),
 		},
 	}
@@ -141,10 +398,15 @@ func (c *Consumer) logDelivery(description string, delivery amqp.Delivery) {
 	log.Debugf("%s: %s; %s", description, delivery.RoutingKey, delivery.Body)
 }
 
-// Drain waits up to timeout for in-flight deliveries to finish. Recorder.Record publishes the
-// email and UI messages after it commits, so closing the connections mid-delivery would leave a
-// recorded notification that the user is never pinged about.
+// Drain stops the consumer from starting deliveries and waits up to timeout for the ones being
+// handled to finish. Deliveries that are waiting for a worker go back on the queue, and the
+// handlers that are still running when the timeout runs out are cancelled. The broker returns the
+// deliveries that are still unacknowledged to the queue when the connections close, so a delivery
+// that was recorded but not acknowledged before then is recorded again.
 func (c *Consumer) Drain(timeout time.Duration) {
+	c.stopOnce.Do(func() { close(c.stopping) })
+	defer c.cancel()
+
 	deadline := time.Now().Add(timeout)
 	for c.inFlight.Load() > 0 && time.Now().Before(deadline) {
 		time.Sleep(100 * time.Millisecond)
@@ -152,7 +414,7 @@ func (c *Consumer) Drain(timeout time.Duration) {
 	if n := c.inFlight.Load(); n > 0 {
 		log.Warnf(
 			"%d notification events still in flight after the %s drain window; "+
-				"any that already committed may not have their email or UI message published",
+				"they will be delivered again",
 			n, timeout,
 		)
 	}
@@ -164,43 +426,52 @@ func (c *Consumer) handleMessage(ctx context.Context, delivery amqp.Delivery) {
 	defer c.inFlight.Add(-1)
 	defer c.recoverFromPanic(ctx, delivery)
 
-	category, updateType, err := c.parseRoutingKey(delivery.RoutingKey)
-	if err != nil {
-		log.Errorf("unable to handle message: %s", err.Error())
-		c.nack(delivery, false)
+	// Deliveries that haven't been started by the time the consumer starts draining go back on the
+	// queue, and the ones that have are cancelled when the drain window runs out.
+	select {
+	case <-c.stopping:
+		c.nack(delivery, true)
 		return
+	default:
 	}
+	ctx, stop := context.WithCancel(ctx)
+	defer stop()
+	defer context.AfterFunc(c.ctx, stop)()
 
-	// The binding admits every event category, but notifications are the only one recorded.
-	if category != eventCategory {
-		log.Infof("no handler for category '%s'; ignoring delivery", category)
-		c.ack(delivery)
+	// Deliveries that their publishers didn't timestamp are timestamped when they first arrive. The
+	// timestamp is kept when a delivery is retried, so that handlers see the same time on every
+	// attempt.
+	if delivery.Timestamp.IsZero() {
+		delivery.Timestamp = time.Now().UTC()
+	}
+	ctx = withDeliveryTime(ctx, delivery.Timestamp)
+
+	// The delivery waits if one of the user's earlier deliveries is waiting to be retried.
+	key := shardKey(delivery.Body)
+	if c.holdBack(ctx, key, delivery) {
 		return
 	}
+	defer c.releaseHold(key)
 
-	// Dispatch the delivery to the recorder.
-	err = c.recorder.Record(ctx, updateType, delivery.Body, delivery.RoutingKey)
+	// Dispatch the delivery to the handler for its category. The binding admits every event
+	// category, so deliveries in categories without handlers are ignored.
+	err := c.handlers.Dispatch(ctx, routingKey(delivery), delivery.Body)
+	if errors.Is(err, ErrNoHandler) {
+		log.Infof("%s; ignoring delivery", err.Error())
+		c.ack(delivery)
+		return
+	}
 	if err != nil {
 		var unrecoverable UnrecoverableError
-		var recoverable RecoverableError
-		switch {
-		case errors.As(err, &unrecoverable):
+		if errors.As(err, &unrecoverable) {
 			log.Errorf("discarding message because of an unrecoverable error: %s", err.Error())
-			c.sendUnrecoverableErrorEmail(ctx, delivery, unrecoverable)
-			c.logDelivery("discarded delivery", delivery)
-			c.nack(delivery, false)
-		case errors.As(err, &recoverable):
-			log.Errorf("requeuing message because of a recoverable error: %s", err.Error())
-			c.logDelivery("requeued delivery", delivery)
-			c.requeue(ctx, delivery)
-		default:
-			log.Errorf(
-				"requeuing message because of an error that is presumed to be recoverable: %s",
-				err.Error(),
-			)
-			c.logDelivery("requeued delivery", delivery)
-			c.requeue(ctx, delivery)
+			c.discard(ctx, delivery, unrecoverable)
+			return
 		}
+
+		// Recoverable errors, along with errors that weren't classified and are presumed to be
+		// recoverable, are retried a limited number of times.
+		c.retry(ctx, key, delivery, err)
 		return
 	}
 
@@ -208,9 +479,19 @@ func (c *Consumer) handleMessage(ctx context.Context, delivery amqp.Delivery) {
 	c.ack(delivery)
 }
 
-// Listen waits for incoming AMQP messages and dispatches any that it receives to the recorder.
+// Listen waits for incoming AMQP messages and dispatches any that it receives to their handlers.
 func (c *Consumer) Listen() error {
-	// Start listening for incoming messages.
+	// Declare the queues that deliveries are retried and dead-lettered through before anything can
+	// fail.
+	if err := c.republisher.DeclareDeadLetterQueue(DeadLetterExchange, DeadLetterQueue); err != nil {
+		return fmt.Errorf("unable to declare the dead-letter queue: %w", err)
+	}
+	if err := c.declareRetryQueues(); err != nil {
+		return fmt.Errorf("unable to declare the retry queues: %w", err)
+	}
+
+	// Start the workers, then listen for incoming messages.
+	c.startWorkers()
 	go c.amqpClient.Listen()
 
 	c.amqpClient.AddConsumer(
@@ -218,8 +499,8 @@ func (c *Consumer) Listen() error {
 		c.amqpSettings.ExchangeType,
 		QueueName,
 		RoutingKey,
-		c.handleMessage,
-		prefetchCount,
+		c.consume,
+		c.prefetch,
 	)
 
 	// AddConsumer reports neither a failed declaration nor a failed registration, so check that the
@@ -1,14 +1,21 @@
 package recorder
 
 import (
+	"context"
+	"errors"
+	"sync"
 	"testing"
 	"time"
+
+	"github.com/cyverse-de/messaging/v12"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/stretchr/testify/assert"
 )
 
 // TestDrainReturnsWhenIdle verifies that shutdown doesn't wait out the full window when nothing
 // is in flight.
 func TestDrainReturnsWhenIdle(t *testing.T) {
-	consumer := &Consumer{}
+	consumer := NewConsumer(nil, nil, nil, nil, "", nil, nil, 0, 0, 0)
 	done := make(chan struct{})
 	go func() {
 		consumer.Drain(30 * time.Second)
@@ -23,9 +30,10 @@ func TestDrainReturnsWhenIdle(t *testing.T) {
 }
 
 // TestDrainWaitsForInFlightDeliveries verifies that shutdown holds the connections open until a
-// delivery that is mid-record finishes, since Recorder.Record publishes after it commits.
+// delivery that is mid-record finishes, since a delivery that's still unacknowledged when the
+// connections close is delivered again.
 func TestDrainWaitsForInFlightDeliveries(t *testing.T) {
-	consumer := &Consumer{}
+	consumer := NewConsumer(nil, nil, nil, nil, "", nil, nil, 0, 0, 0)
 	consumer.inFlight.Add(1)
 
 	done := make(chan struct{})
@@ -48,3 +56,397 @@ func TestDrainWaitsForInFlightDeliveries(t *testing.T) {
 		t.Fatal("Drain did not return after the delivery finished")
 	}
 }
+
+// fakeAcknowledger records how a delivery was acknowledged. Its channel is closed if closed is set.
+type fakeAcknowledger struct {
+	mu      sync.Mutex
+	acked   bool
+	nacked  bool
+	requeue bool
+	closed  bool
+}
+
+func (a *fakeAcknowledger) IsClosed() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.closed
+}
+
+func (a *fakeAcknowledger) Ack(uint64, bool) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.acked = true
+	return nil
+}
+
+func (a *fakeAcknowledger) Nack(_ uint64, _ bool, requeue bool) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.nacked = true
+	a.requeue = requeue
+	return nil
+}
+
+func (a *fakeAcknowledger) Reject(_ uint64, requeue bool) error {
+	return a.Nack(0, false, requeue)
+}
+
+// publishedMessage is a message that was published by the fake republisher.
+type publishedMessage struct {
+	exchange   string
+	routingKey string
+	msg        amqp.Publishing
+}
+
+// fakeRepublisher records the messages it publishes and the retry queues it declares.
+type fakeRepublisher struct {
+	mu           sync.Mutex
+	published    []publishedMessage
+	retryQueues  []string
+	retryDelays  []time.Duration
+	deadLetterTo []string
+}
+
+func (r *fakeRepublisher) PublishMessage(_ context.Context, exchange, routingKey string, msg amqp.Publishing) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.published = append(r.published, publishedMessage{exchange: exchange, routingKey: routingKey, msg: msg})
+	return nil
+}
+
+func (r *fakeRepublisher) DeclareDeadLetterQueue(string, string) error {
+	return nil
+}
+
+func (r *fakeRepublisher) DeclareRetryQueue(queue, deadLetterQueue string, ttl time.Duration) error {
+	r.retryQueues = append(r.retryQueues, queue)
+	r.retryDelays = append(r.retryDelays, ttl)
+	r.deadLetterTo = append(r.deadLetterTo, deadLetterQueue)
+	return nil
+}
+
+// fakeMessagingClient records the email requests it publishes.
+type fakeMessagingClient struct {
+	emailRequests []*messaging.EmailRequest
+}
+
+func (c *fakeMessagingClient) PublishEmailRequestContext(_ context.Context, req *messaging.EmailRequest) error {
+	c.emailRequests = append(c.emailRequests, req)
+	return nil
+}
+
+func (c *fakeMessagingClient) PublishNotificationMessageContext(
+	context.Context,
+	*messaging.WrappedNotificationMessage,
+) error {
+	return nil
+}
+
+// notificationHandlers returns a handler registry with the recorder registered for notifications.
+func notificationHandlers(r *Recorder) *Handlers {
+	handlers := NewHandlers()
+	handlers.Register(NotificationCategory, r)
+	return handlers
+}
+
+// newFailingConsumer returns a consumer whose recorder always fails with a recoverable error, along
+// with the fakes it publishes to.
+func newFailingConsumer(maxRetries int) (*Consumer, *fakeRepublisher, *fakeMessagingClient) {
+	databaseClient := NewMockDatabaseClient(0)
+	databaseClient.BeginErr = errors.New("the database is unreachable")
+	republisher := &fakeRepublisher{}
+	messagingClient := &fakeMessagingClient{}
+	eventRecorder := New(databaseClient, testUserSuffix, nil, 0)
+	consumer := NewConsumer(
+		nil, messagingClient, republisher, nil, "support@example.org",
+		eventRecorder, notificationHandlers(eventRecorder), maxRetries, 0, 0,
+	)
+	consumer.minRequeueDelay = time.Millisecond
+	consumer.maxRequeueDelay = time.Millisecond
+	return consumer, republisher, messagingClient
+}
+
+func TestRecoverableFailuresAreRetriedWithACount(t *testing.T) {
+	assert := assert.New(t)
+
+	consumer, republisher, messagingClient := newFailingConsumer(3)
+	acknowledger := &fakeAcknowledger{}
+	delivery := amqp.Delivery{
+		Acknowledger: acknowledger,
+		RoutingKey:   FakeRoutingKey,
+		Headers:      amqp.Table{RetryCountHeader: int32(1)},
+		Body:         marshalRequest(t, nil),
+	}
+
+	consumer.handleMessage(context.Background(), delivery)
+
+	assert.True(acknowledger.acked, "the original delivery was not acknowledged")
+	assert.Empty(messagingClient.emailRequests, "the support address was told about a delivery that's being retried")
+	if assert.Len(republisher.published, 1) {
+		retried := republisher.published[0]
+		assert.Equal("", retried.exchange, "the retry was not published to the default exchange")
+		assert.Equal(RetryQueuePrefix+"1ms", retried.routingKey)
+		assert.Equal(int32(2), retried.msg.Headers[RetryCountHeader])
+		assert.Equal(FakeRoutingKey, retried.msg.Headers[RoutingKeyHeader])
+		assert.NotEmpty(retried.msg.Headers[HoldHeader])
+		assert.Equal(delivery.Body, retried.msg.Body)
+	}
+
+	// The retry is recorded with the original routing key when the retry queue puts it back.
+	retriedDelivery := amqp.Delivery{
+		Acknowledger: acknowledger,
+		RoutingKey:   QueueName,
+		Headers:      republisher.published[0].msg.Headers,
+		Body:         delivery.Body,
+	}
+	assert.Equal(FakeRoutingKey, routingKey(retriedDelivery))
+}
+
+func TestRetryQueuesAreDeclaredForEachDelay(t *testing.T) {
+	assert := assert.New(t)
+
+	republisher := &fakeRepublisher{}
+	consumer := NewConsumer(nil, nil, republisher, nil, "", nil, nil, 0, 0, 0)
+
+	assert.NoError(consumer.declareRetryQueues())
+
+	assert.Equal([]string{
+		"event_listener.retry.5s",
+		"event_listener.retry.10s",
+		"event_listener.retry.20s",
+		"event_listener.retry.40s",
+		"event_listener.retry.1m20s",
+		"event_listener.retry.2m40s",
+		"event_listener.retry.5m0s",
+	}, republisher.retryQueues)
+	assert.Equal(5*time.Minute, republisher.retryDelays[len(republisher.retryDelays)-1])
+	for _, queue := range republisher.deadLetterTo {
+		assert.Equal(QueueName, queue, "a retry queue doesn't put its deliveries back on the event queue")
+	}
+}
+
+func TestDrainCancelsHandlersThatOutlastTheTimeout(t *testing.T) {
+	cancelled := make(chan struct{})
+	handlers := NewHandlers()
+	handlers.Register(NotificationCategory, HandlerFunc(func(ctx context.Context, _ string, _ []byte, _ string) error {
+		<-ctx.Done()
+		close(cancelled)
+		return nil
+	}))
+	consumer := NewConsumer(nil, &fakeMessagingClient{}, &fakeRepublisher{}, nil, "", nil, handlers, 0, 0, 0)
+
+	delivery := amqp.Delivery{Acknowledger: &fakeAcknowledger{}, RoutingKey: FakeRoutingKey, Body: []byte(`{}`)}
+	go consumer.handleMessage(context.Background(), delivery)
+	assert.Eventually(t, func() bool { return consumer.inFlight.Load() == 1 }, time.Second, 10*time.Millisecond)
+
+	consumer.Drain(100 * time.Millisecond)
+
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("the handler was not cancelled when the drain window ran out")
+	}
+}
+
+func TestDeliveriesAreRequeuedOnceTheConsumerIsDraining(t *testing.T) {
+	assert := assert.New(t)
+
+	handled := false
+	handlers := NewHandlers()
+	handlers.Register(NotificationCategory, HandlerFunc(func(context.Context, string, []byte, string) error {
+		handled = true
+		return nil
+	}))
+	consumer := NewConsumer(nil, &fakeMessagingClient{}, &fakeRepublisher{}, nil, "", nil, handlers, 0, 0, 0)
+	consumer.Drain(0)
+
+	acknowledger := &fakeAcknowledger{}
+	consumer.handleMessage(context.Background(), amqp.Delivery{
+		Acknowledger: acknowledger,
+		RoutingKey:   FakeRoutingKey,
+		Body:         []byte(`{}`),
+	})
+
+	assert.False(handled, "a delivery was handled while the consumer was draining")
+	assert.True(acknowledger.nacked, "the delivery was not put back on the queue")
+	assert.True(acknowledger.requeue, "the delivery was removed from the queue")
+}
+
+func TestDeliveriesAreDeadLetteredAfterTheMaximumRetries(t *testing.T) {
+	assert := assert.New(t)
+
+	consumer, republisher, messagingClient := newFailingConsumer(3)
+	acknowledger := &fakeAcknowledger{}
+	delivery := amqp.Delivery{
+		Acknowledger: acknowledger,
+		RoutingKey:   QueueName,
+		Headers:      amqp.Table{RetryCountHeader: int32(3), RoutingKeyHeader: FakeRoutingKey},
+		Body:         marshalRequest(t, nil),
+	}
+
+	consumer.handleMessage(context.Background(), delivery)
+
+	assert.True(acknowledger.acked, "the original delivery was not acknowledged")
+	if assert.Len(republisher.published, 1) {
+		deadLettered := republisher.published[0]
+		assert.Equal(DeadLetterExchange, deadLettered.exchange)
+		assert.Equal(FakeRoutingKey, deadLettered.routingKey)
+		assert.Equal(int32(3), deadLettered.msg.Headers[RetryCountHeader])
+		assert.Contains(deadLettered.msg.Headers[ErrorHeader], "the database is unreachable")
+	}
+	if assert.Len(messagingClient.emailRequests, 1, "the support address was not told about the delivery") {
+		assert.Equal("support@example.org", messagingClient.emailRequests[0].ToAddress)
+		assert.Equal(FakeRoutingKey, messagingClient.emailRequests[0].TemplateValues["routing_key"])
+	}
+}
+
+func TestRetryCount(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers amqp.Table
+		want    int
+	}{
+		{name: "no headers", want: 0},
+		{name: "retry header", headers: amqp.Table{RetryCountHeader: int32(4)}, want: 4},
+		{name: "64-bit retry header", headers: amqp.Table{RetryCountHeader: int64(2)}, want: 2},
+		{
+			name: "x-death for this queue",
+			headers: amqp.Table{"x-death": []interface{}{
+				amqp.Table{"queue": QueueName, "count": int64(5)},
+				amqp.Table{"queue": "some_other_queue", "count": int64(7)},
+			}},
+			want: 5,
+		},
+		{
+			name: "the larger of the two",
+			headers: amqp.Table{
+				RetryCountHeader: int32(6),
+				"x-death":        []interface{}{amqp.Table{"queue": QueueName, "count": int64(5)}},
+			},
+			want: 6,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, retryCount(amqp.Delivery{Headers: tt.headers}))
+		})
+	}
+}
+
+func TestRequeueDelay(t *testing.T) {
+	consumer := NewConsumer(nil, nil, nil, nil, "", nil, nil, 0, 0, 0)
+
+	assert.Equal(t, DefaultMaxRetries, consumer.maxRetries)
+	assert.Equal(t, 5*time.Second, consumer.requeueDelay(0))
+	assert.Equal(t, 10*time.Second, consumer.requeueDelay(1))
+	assert.Equal(t, 40*time.Second, consumer.requeueDelay(3))
+	assert.Equal(t, 5*time.Minute, consumer.requeueDelay(6))
+	assert.Equal(t, 5*time.Minute, consumer.requeueDelay(100))
+}
+
+func TestUnrecoverableDeliveriesAreQuarantined(t *testing.T) {
+	assert := assert.New(t)
+
+	databaseClient := NewMockDatabaseClient(0)
+	messagingClient := &fakeMessagingClient{}
+	eventRecorder := New(databaseClient, testUserSuffix, nil, 0)
+	consumer := NewConsumer(
+		nil, messagingClient, &fakeRepublisher{}, nil, "support@example.org",
+		eventRecorder, notificationHandlers(eventRecorder), 0, 0, 0,
+	)
+	acknowledger := &fakeAcknowledger{}
+	delivery := amqp.Delivery{
+		Acknowledger: acknowledger,
+		RoutingKey:   FakeRoutingKey,
+		Body:         []byte(`{"type":`),
+	}
+
+	consumer.handleMessage(context.Background(), delivery)
+
+	assert.True(acknowledger.nacked, "the delivery was not removed from the queue")
+	assert.False(acknowledger.requeue, "the delivery was requeued")
+	assert.Equal([]string{FakeRoutingKey}, databaseClient.QuarantinedRoutingKeys)
+	if assert.Len(databaseClient.QuarantinedErrors, 1) {
+		assert.Contains(databaseClient.QuarantinedErrors[0], "unable to parse message body")
+	}
+	assert.True(databaseClient.CommitCalled, "the quarantined delivery was not committed")
+	assert.Len(messagingClient.emailRequests, 1, "the support address was not told about the delivery")
+}
+
+func TestDeliveriesThatCannotBeQuarantinedAreDeadLettered(t *testing.T) {
+	assert := assert.New(t)
+
+	databaseClient := NewMockDatabaseClient(0)
+	databaseClient.BeginErr = errors.New("the database is unreachable")
+	republisher := &fakeRepublisher{}
+	eventRecorder := New(databaseClient, testUserSuffix, nil, 0)
+	consumer := NewConsumer(
+		nil, &fakeMessagingClient{}, republisher, nil, "support@example.org",
+		eventRecorder, notificationHandlers(eventRecorder), 0, 0, 0,
+	)
+	acknowledger := &fakeAcknowledger{}
+	delivery := amqp.Delivery{
+		Acknowledger: acknowledger,
+		RoutingKey:   FakeRoutingKey,
+		Body:         []byte(`{"type":`),
+	}
+
+	consumer.handleMessage(context.Background(), delivery)
+
+	assert.Empty(databaseClient.QuarantinedRoutingKeys)
+	assert.True(acknowledger.acked, "the original delivery was not acknowledged")
+	assert.False(acknowledger.nacked, "the delivery was removed from the queue without being kept anywhere")
+	if assert.Len(republisher.published, 1) {
+		assert.Equal(DeadLetterExchange, republisher.published[0].exchange)
+		assert.Equal(delivery.Body, republisher.published[0].msg.Body)
+	}
+}
+
+func TestDeliveriesWithoutHandlersAreIgnored(t *testing.T) {
+	assert := assert.New(t)
+
+	databaseClient := NewMockDatabaseClient(0)
+	eventRecorder := New(databaseClient, testUserSuffix, nil, 0)
+	consumer := NewConsumer(
+		nil, &fakeMessagingClient{}, &fakeRepublisher{}, nil, "support@example.org",
+		eventRecorder, notificationHandlers(eventRecorder), 0, 0, 0,
+	)
+	acknowledger := &fakeAcknowledger{}
+	delivery := amqp.Delivery{
+		Acknowledger: acknowledger,
+		RoutingKey:   "events.analysis.update.completed",
+		Body:         []byte(`{}`),
+	}
+
+	consumer.handleMessage(context.Background(), delivery)
+
+	assert.True(acknowledger.acked, "the delivery was not acknowledged")
+	assert.Empty(databaseClient.QuarantinedRoutingKeys, "the delivery was quarantined")
+}
+
+func TestHandlerErrorsFollowTheRecorderContract(t *testing.T) {
+	assert := assert.New(t)
+
+	databaseClient := NewMockDatabaseClient(0)
+	handlers := NewHandlers()
+	handlers.Register("analysis", HandlerFunc(func(context.Context, string, []byte, string) error {
+		return NewUnrecoverableError("the analysis doesn't exist")
+	}))
+	consumer := NewConsumer(
+		nil, &fakeMessagingClient{}, &fakeRepublisher{}, nil, "support@example.org",
+		New(databaseClient, testUserSuffix, nil, 0), handlers, 0, 0, 0,
+	)
+	acknowledger := &fakeAcknowledger{}
+	delivery := amqp.Delivery{
+		Acknowledger: acknowledger,
+		RoutingKey:   "events.analysis.update.completed",
+		Body:         []byte(`{}`),
+	}
+
+	consumer.handleMessage(context.Background(), delivery)
+
+	assert.True(acknowledger.nacked, "the delivery was not removed from the queue")
+	assert.False(acknowledger.requeue, "the delivery was requeued")
+	assert.Equal([]string{"events.analysis.update.completed"}, databaseClient.QuarantinedRoutingKeys)
+}
@@ -0,0 +1,85 @@
+package recorder
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// NotificationCategory is the routing key category of notification events, which the Recorder
+// handles.
+const NotificationCategory = "notification"
+
+// ErrNoHandler is returned by Handlers.Dispatch for events in a category that has no handler.
+var ErrNoHandler = errors.New("no handler is registered for the event category")
+
+// Handler handles the events in a single routing key category. Handlers follow the same error
+// contract as the Recorder: an UnrecoverableError discards the event, and any other error means
+// that the event is retried.
+type Handler interface {
+	Handle(ctx context.Context, updateType string, body []byte, routingKey string) error
+}
+
+// deliveryTimeKey is the context key for the time that the event being handled was published.
+type deliveryTimeKey struct{}
+
+// withDeliveryTime returns a copy of the context that carries the time that the event being handled
+// was published.
+func withDeliveryTime(ctx context.Context, t time.Time) context.Context {
+	return context.WithValue(ctx, deliveryTimeKey{}, t)
+}
+
+// deliveryTime returns the time that the event being handled was published, if the context carries
+// it.
+func deliveryTime(ctx context.Context) (time.Time, bool) {
+	t, ok := ctx.Value(deliveryTimeKey{}).(time.Time)
+	return t, ok && !t.IsZero()
+}
+
+// HandlerFunc adapts an ordinary function to the Handler interface.
+type HandlerFunc func(ctx context.Context, updateType string, body []byte, routingKey string) error
+
+// Handle calls f.
+func (f HandlerFunc) Handle(ctx context.Context, updateType string, body []byte, routingKey string) error {
+	return f(ctx, updateType, body, routingKey)
+}
+
+// Handle records a notification event. It makes the Recorder the handler for the notification
+// category.
+func (r *Recorder) Handle(ctx context.Context, updateType string, body []byte, routingKey string) error {
+	return r.Record(ctx, updateType, body, routingKey)
+}
+
+// Handlers is a registry of event handlers keyed by routing key category. Handlers have to be
+// registered before events are dispatched; the registry isn't safe to modify while it's in use.
+type Handlers struct {
+	handlers map[string]Handler
+}
+
+// NewHandlers returns an empty handler registry.
+func NewHandlers() *Handlers {
+	return &Handlers{handlers: make(map[string]Handler)}
+}
+
+// Register registers the handler for a category, replacing any handler that was registered for it
+// before.
+func (h *Handlers) Register(category string, handler Handler) {
+	h.handlers[category] = handler
+}
+
+// Dispatch passes an event to the handler for its category. A routing key that can't be parsed is
+// an UnrecoverableError, and ErrNoHandler is returned if the category has no handler.
+func (h *Handlers) Dispatch(ctx context.Context, routingKey string, body []byte) error {
+	category, updateType, err := ParseRoutingKey(routingKey)
+	if err != nil {
+		return NewUnrecoverableError("%s", err.Error())
+	}
+
+	handler, ok := h.handlers[category]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrNoHandler, category)
+	}
+
+	return handler.Handle(ctx, updateType, body, routingKey)
+}
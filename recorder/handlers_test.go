@@ -0,0 +1,37 @@
+package recorder
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDispatchRoutesEventsByCategory(t *testing.T) {
+	assert := assert.New(t)
+
+	var updateTypes, routingKeys []string
+	handlers := NewHandlers()
+	handlers.Register("analysis", HandlerFunc(func(_ context.Context, updateType string, _ []byte, routingKey string) error {
+		updateTypes = append(updateTypes, updateType)
+		routingKeys = append(routingKeys, routingKey)
+		return nil
+	}))
+
+	assert.NoError(handlers.Dispatch(context.Background(), "events.analysis.update.completed", []byte(`{}`)))
+	assert.Equal([]string{"completed"}, updateTypes)
+	assert.Equal([]string{"events.analysis.update.completed"}, routingKeys)
+
+	err := handlers.Dispatch(context.Background(), "events.data.update.created", []byte(`{}`))
+	assert.True(errors.Is(err, ErrNoHandler), "an event without a handler was dispatched")
+	assert.Len(updateTypes, 1)
+}
+
+func TestDispatchRejectsMalformedRoutingKeys(t *testing.T) {
+	handlers := NewHandlers()
+
+	var unrecoverable UnrecoverableError
+	err := handlers.Dispatch(context.Background(), "events.analysis", []byte(`{}`))
+	assert.True(t, errors.As(err, &unrecoverable), "a malformed routing key was not unrecoverable")
+}
@@ -2,13 +2,19 @@ package recorder
 
 import (
 	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"maps"
+	"slices"
 	"strings"
 	"time"
 
 	"github.com/cyverse-de/messaging/v12"
 	"github.com/cyverse-de/notifications/common"
+	"github.com/cyverse-de/notifications/db"
+	"github.com/cyverse-de/notifications/membership"
+	"github.com/cyverse-de/notifications/model"
 	"github.com/sirupsen/logrus"
 )
 
@@ -18,29 +24,54 @@ var log = logrus.WithFields(logrus.Fields{"package": "recorder"})
 
 // Request represents a deserialized incoming notification request.
 type Request struct {
-	RequestType   string                 `json:"type"`
-	User          string                 `json:"user"`
-	Subject       string                 `json:"subject"`
-	Timestamp     string                 `json:"timestamp"`
-	Email         bool                   `json:"email"`
-	EmailTemplate string                 `json:"email_template"`
-	Payload       map[string]interface{} `json:"payload"`
-	Message       string                 `json:"message"`
+	RequestType    string                 `json:"type"`
+	User           string                 `json:"user,omitempty"`
+	Recipients     []string               `json:"recipients,omitempty"`
+	Team           string                 `json:"team,omitempty"`
+	IdempotencyKey string                 `json:"idempotency_key,omitempty"`
+	Subject        string                 `json:"subject"`
+	Timestamp      string                 `json:"timestamp"`
+	Email          bool                   `json:"email"`
+	EmailTemplate  string                 `json:"email_template"`
+	Payload        map[string]interface{} `json:"payload"`
+	Message        string                 `json:"message"`
 }
 
-// Recorder records incoming notification requests and publishes the outgoing messages.
+// DefaultIdempotencyWindow is how long an idempotency key is remembered if no other window is configured.
+const DefaultIdempotencyWindow = 24 * time.Hour
+
+// ErrDuplicateRequest is returned by RecordNotification when a notification request with the same idempotency key,
+// notification type, and recipient was recorded within the idempotency window. Record treats a duplicate request as
+// having been recorded.
+var ErrDuplicateRequest = errors.New("a notification request with the same idempotency key was already recorded")
+
+// Recorder records incoming notification requests and queues the outgoing messages to be published.
 type Recorder struct {
-	dbc             DatabaseClient
-	messagingClient MessagingClient
-	userSuffix      common.UserSuffix
+	dbc               DatabaseClient
+	userSuffix        common.UserSuffix
+	teams             membership.Resolver
+	idempotencyWindow time.Duration
+	now               func() time.Time
 }
 
-// New returns a new recorder.
-func New(dbc DatabaseClient, messagingClient MessagingClient, userSuffix common.UserSuffix) *Recorder {
+// New returns a new recorder. The team resolver looks up the members of the teams that notifications are addressed
+// to; notifications addressed to teams are discarded if it's nil. Idempotency keys are remembered for the given
+// window, or for the default window if it's zero.
+func New(
+	dbc DatabaseClient,
+	userSuffix common.UserSuffix,
+	teams membership.Resolver,
+	idempotencyWindow time.Duration,
+) *Recorder {
+	if idempotencyWindow == 0 {
+		idempotencyWindow = DefaultIdempotencyWindow
+	}
 	return &Recorder{
-		dbc:             dbc,
-		messagingClient: messagingClient,
-		userSuffix:      userSuffix,
+		dbc:               dbc,
+		userSuffix:        userSuffix,
+		teams:             teams,
+		idempotencyWindow: idempotencyWindow,
+		now:               time.Now,
 	}
 }
 
@@ -137,38 +168,275 @@ func (r *Recorder) buildNotificationMessage(
 	return notificationMessage, nil
 }
 
-// Record stores an incoming notification request and publishes the outgoing email and UI
-// messages. The body and routing key are passed in rather than an AMQP delivery so that the
-// recording logic stays independent of the messaging library.
+// deferEmailDuringQuietHours holds the email for a notification until the end of the recipient's
+// quiet hours, and reports whether it did. The email should be sent right away if it wasn't
+// deferred.
+func (r *Recorder) deferEmailDuringQuietHours(
+	ctx context.Context,
+	tx *sql.Tx,
+	notification *common.Notification,
+	emailRequest *messaging.EmailRequest,
+) (bool, error) {
+	quietHours, err := r.dbc.GetQuietHours(ctx, tx, notification.User)
+	if err != nil {
+		return false, classifyDatabaseError(err, "unable to look up the quiet hours")
+	}
+	if quietHours == nil {
+		return false, nil
+	}
+
+	// Quiet hours that can't be interpreted shouldn't cost the user their email.
+	releaseAt, quiet, err := quietHours.ReleaseTime(r.now())
+	if err != nil {
+		log.Errorf("ignoring the quiet hours for %s: %s", notification.User, err.Error())
+		return false, nil
+	}
+	if !quiet {
+		return false, nil
+	}
+
+	if err = r.dbc.DeferEmail(ctx, tx, notification.ID, emailRequest, releaseAt); err != nil {
+		return false, classifyDatabaseError(err, "unable to defer the email")
+	}
+
+	return true, nil
+}
+
+// Record stores an incoming notification request and queues the outgoing email and UI messages in
+// the outbox, from which they're published once the transaction commits. The body and routing key
+// are passed in rather than an AMQP delivery so that the recording logic stays independent of the
+// messaging library. A request that's addressed to a list of recipients or to a team is recorded
+// as a separate notification for each recipient, all in the same transaction.
 func (r *Recorder) Record(ctx context.Context, updateType string, body []byte, routingKey string) error {
-	updateType = strings.ToLower(updateType)
+	request, timeCreated, err := parseRequest(body)
+	if err != nil {
+		return err
+	}
+
+	// A duplicate request was recorded already, so there's nothing left to do.
+	_, err = r.record(ctx, updateType, request, body, timeCreated, routingKey)
+	if errors.Is(err, ErrDuplicateRequest) {
+		return nil
+	}
+	return err
+}
+
+// RecordNotification works like Record, but also returns the ID of the stored notification so
+// that callers recording notifications synchronously can look it up. The ID is empty if the
+// recipient has opted out of recording notifications of this type. Requests that are addressed
+// to more than one recipient are rejected, because there's no single notification to return, and
+// ErrDuplicateRequest is returned if the request's idempotency key was used already, along with
+// the ID of the notification that was recorded for the original request.
+func (r *Recorder) RecordNotification(ctx context.Context, updateType string, body []byte, routingKey string) (string, error) {
+	request, timeCreated, err := parseRequest(body)
+	if err != nil {
+		return "", err
+	}
+	if request.fansOut() {
+		return "", NewUnrecoverableError("notifications for several recipients can't be recorded one at a time")
+	}
+
+	ids, err := r.record(ctx, updateType, request, body, timeCreated, routingKey)
+	if err != nil && !errors.Is(err, ErrDuplicateRequest) {
+		return "", err
+	}
+
+	// Nothing is recorded if the recipient has opted out, but a duplicate request still has to be reported.
+	if len(ids) == 0 {
+		return "", err
+	}
+	return ids[0], err
+}
+
+// Quarantine stores a notification event that couldn't be recorded, along with the reason why, so
+// that it can be fixed and replayed with Record later.
+func (r *Recorder) Quarantine(ctx context.Context, routingKey string, body []byte, cause error) error {
+	tx, err := r.dbc.Begin()
+	if err != nil {
+		return err
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			if rollbackErr := r.dbc.Rollback(tx); rollbackErr != nil {
+				log.Errorf("unable to roll back the database transaction: %s", rollbackErr.Error())
+			}
+		}
+	}()
 
-	// Parse the message body.
+	if err = r.dbc.QuarantineEvent(ctx, tx, routingKey, body, cause.Error()); err != nil {
+		return err
+	}
+	if err = r.dbc.Commit(tx); err != nil {
+		return err
+	}
+	committed = true
+
+	return nil
+}
+
+// parseRequest parses an incoming notification request and its timestamp.
+func parseRequest(body []byte) (*Request, time.Time, error) {
 	var request Request
 	if err := json.Unmarshal(body, &request); err != nil {
-		return NewUnrecoverableError("unable to parse message body: %s", err.Error())
+		return nil, time.Time{}, NewUnrecoverableError("unable to parse message body: %s", err.Error())
 	}
 
-	// Parse the timestamp.
 	timeCreated, err := time.Parse(time.RFC3339Nano, request.Timestamp)
 	if err != nil {
-		return NewUnrecoverableError("unable to parse timestamp: %s", err.Error())
+		return nil, time.Time{}, NewUnrecoverableError("unable to parse timestamp: %s", err.Error())
 	}
 
-	// Validate the email request before anything is committed, so that a bad address discards
-	// the delivery instead of leaving a recorded notification behind.
-	var emailRequest *messaging.EmailRequest
-	if request.Email {
-		emailRequest, err = r.buildEmailRequest(&request)
+	return &request, timeCreated, nil
+}
+
+// fansOut returns true if the request is addressed to a list of recipients or to a team rather
+// than to a single user.
+func (request *Request) fansOut() bool {
+	return len(request.Recipients) > 0 || request.Team != ""
+}
+
+// recipients returns the usernames of the users that a notification request is addressed to,
+// without duplicates.
+func (r *Recorder) recipients(ctx context.Context, request *Request) ([]string, error) {
+	var usernames []string
+	switch {
+	case request.Team != "":
+		if r.teams == nil {
+			return nil, NewUnrecoverableError("unable to notify team %s: no teams are configured", request.Team)
+		}
+		members, err := r.teams.Members(ctx, request.Team)
+		if errors.Is(err, membership.ErrUnknownGroup) {
+			return nil, NewUnrecoverableError("unable to notify team %s: %s", request.Team, err.Error())
+		}
 		if err != nil {
-			return err
+			return nil, NewRecoverableError("unable to look up the members of team %s: %s", request.Team, err.Error())
+		}
+		usernames = members
+	case len(request.Recipients) > 0:
+		usernames = request.Recipients
+	default:
+		return []string{request.User}, nil
+	}
+
+	// Each recipient gets only one notification, no matter how many times they're listed.
+	seen := make(map[string]bool, len(usernames))
+	recipients := make([]string, 0, len(usernames))
+	for _, username := range usernames {
+		if !seen[username] {
+			seen[username] = true
+			recipients = append(recipients, username)
+		}
+	}
+	return recipients, nil
+}
+
+// withTeamAddresses returns a copy of a request for emails to a team whose email_addresses object
+// includes the addresses that the team resolver knows for the team's members. The caller can't
+// know who the members are, but the addresses that it supplies take precedence over the
+// resolver's.
+func (r *Recorder) withTeamAddresses(ctx context.Context, request *Request) (*Request, error) {
+	resolver, ok := r.teams.(membership.AddressResolver)
+	if !ok {
+		return request, nil
+	}
+
+	// Look up the members' addresses.
+	resolved, err := resolver.Addresses(ctx, request.Team)
+	if errors.Is(err, membership.ErrUnknownGroup) {
+		return nil, NewUnrecoverableError("unable to notify team %s: %s", request.Team, err.Error())
+	}
+	if err != nil {
+		return nil, NewRecoverableError("unable to look up the email addresses of team %s: %s", request.Team, err.Error())
+	}
+
+	// Merge them with the addresses in the request.
+	supplied, _ := request.Payload[model.EmailAddressesKey].(map[string]interface{})
+	addrs := make(map[string]interface{}, len(resolved)+len(supplied))
+	for username, addr := range resolved {
+		addrs[username] = addr
+	}
+	for username, addr := range supplied {
+		addrs[username] = addr
+	}
+
+	teamRequest := *request
+	teamRequest.Payload = maps.Clone(request.Payload)
+	if teamRequest.Payload == nil {
+		teamRequest.Payload = make(map[string]interface{})
+	}
+	teamRequest.Payload[model.EmailAddressesKey] = addrs
+	return &teamRequest, nil
+}
+
+// requestForRecipient returns a copy of a request that's addressed to several recipients, as it
+// would look if it had been addressed to just one of them, along with its serialized form. The
+// recipient's email address is taken from the email_addresses object in the payload, which is
+// left out of the copy so that recipients don't see each other's addresses. No email is sent to
+// a recipient who doesn't have an address.
+func requestForRecipient(request *Request, recipient string) (*Request, []byte, error) {
+	recipientRequest := *request
+	recipientRequest.User = recipient
+	recipientRequest.Recipients = nil
+	recipientRequest.Team = ""
+
+	// The payload is copied because each recipient's copy is modified separately.
+	recipientRequest.Payload = maps.Clone(request.Payload)
+	delete(recipientRequest.Payload, model.EmailAddressesKey)
+	if request.Email {
+		addrs, _ := request.Payload[model.EmailAddressesKey].(map[string]interface{})
+		if addr, ok := addrs[recipient].(string); ok {
+			recipientRequest.Payload["email_address"] = addr
+		} else {
+			log.Warnf("not sending a %s email to %s, who has no email address", request.RequestType, recipient)
+			recipientRequest.Email = false
+		}
+	}
+
+	body, err := json.Marshal(&recipientRequest)
+	if err != nil {
+		return nil, nil, NewUnrecoverableError("unable to serialize the notification for %s: %s", recipient, err.Error())
+	}
+
+	return &recipientRequest, body, nil
+}
+
+// record records a notification for each recipient of a request in a single transaction, along
+// with the outgoing messages for each of them. The IDs of the recorded notifications are returned; recipients
+// who have opted out of recording notifications of this type don't have one. ErrDuplicateRequest is
+// returned along with the IDs of the notifications that were recorded for the original request if the
+// request is a duplicate.
+func (r *Recorder) record(
+	ctx context.Context,
+	updateType string,
+	request *Request,
+	body []byte,
+	timeCreated time.Time,
+	routingKey string,
+) ([]string, error) {
+	updateType = strings.ToLower(updateType)
+
+	// Determine who the notification is for.
+	recipients, err := r.recipients(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	if len(recipients) == 0 {
+		log.Warnf("not recording a %s notification for team %s, which has no members", updateType, request.Team)
+		return nil, nil
+	}
+
+	// The members of a team are emailed at the addresses that the team resolver knows for them.
+	if request.Team != "" && request.Email {
+		if request, err = r.withTeamAddresses(ctx, request); err != nil {
+			return nil, err
 		}
 	}
 
 	// Begin a database transaction.
 	tx, err := r.dbc.Begin()
 	if err != nil {
-		return NewRecoverableError("unable to begin a database transaction: %s", err.Error())
+		return nil, NewRecoverableError("unable to begin a database transaction: %s", err.Error())
 	}
 	committed := false
 	defer func() {
@@ -179,9 +447,132 @@ func (r *Recorder) Record(ctx context.Context, updateType string, body []byte, r
 		}
 	}()
 
+	// Skip requests that were recorded already, such as a redelivered event or a request that a
+	// caller resubmitted after a timeout. The key is claimed in the same transaction that records
+	// the notifications, so it's only remembered if they're recorded.
+	var idempotencyKey *db.IdempotencyKey
+	if request.IdempotencyKey != "" {
+		idempotencyKey = r.idempotencyKey(updateType, request)
+		now := r.now()
+		claimed, ids, err := r.dbc.ClaimIdempotencyKey(ctx, tx, idempotencyKey, now, now.Add(r.idempotencyWindow))
+		if err != nil {
+			return nil, classifyDatabaseError(err, "unable to claim the idempotency key")
+		}
+		if !claimed {
+			log.Infof("ignoring a duplicate %s notification request with idempotency key %s", updateType, request.IdempotencyKey)
+			return ids, ErrDuplicateRequest
+		}
+	}
+
 	// Register the notification type in case it doesn't exist in the database yet.
 	if err = r.dbc.RegisterNotificationType(ctx, tx, updateType); err != nil {
-		return classifyDatabaseError(err, "unable to register the notification type")
+		return nil, classifyDatabaseError(err, "unable to register the notification type")
+	}
+
+	// Record the notification for each recipient. A request addressed to a single user is stored
+	// exactly as it arrived.
+	ids := make([]string, 0, len(recipients))
+	for _, recipient := range recipients {
+		recipientRequest, recipientBody := request, body
+		if request.fansOut() {
+			recipientRequest, recipientBody, err = requestForRecipient(request, recipient)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		id, err := r.recordForRecipient(ctx, tx, updateType, recipientRequest, recipientBody, timeCreated, routingKey)
+		if err != nil {
+			return nil, err
+		}
+		if id != "" {
+			ids = append(ids, id)
+		}
+	}
+
+	// There's nothing to commit if every recipient opted out.
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	// Remember the notifications that were recorded, so that they can be returned for duplicates of the request.
+	if idempotencyKey != nil {
+		if err = r.dbc.SaveIdempotencyKeyNotifications(ctx, tx, idempotencyKey, ids); err != nil {
+			return nil, classifyDatabaseError(err, "unable to save the idempotency key")
+		}
+	}
+
+	// Commit the transaction.
+	if err = r.dbc.Commit(tx); err != nil {
+		return nil, NewRecoverableError("unable to commit the database transaction: %s", err.Error())
+	}
+	committed = true
+
+	return ids, nil
+}
+
+// idempotencyKey returns the idempotency key of a request, scoped to its notification type and
+// recipient so that requests from unrelated callers that happen to choose the same key don't
+// collide.
+func (r *Recorder) idempotencyKey(updateType string, request *Request) *db.IdempotencyKey {
+	var recipient string
+	switch {
+	case request.Team != "":
+		recipient = "team:" + request.Team
+	case len(request.Recipients) > 0:
+		recipients := make([]string, len(request.Recipients))
+		for i, username := range request.Recipients {
+			recipients[i] = r.userSuffix.Qualify(username)
+		}
+		slices.Sort(recipients)
+		recipient = "recipients:" + strings.Join(slices.Compact(recipients), ",")
+	default:
+		recipient = r.userSuffix.Qualify(request.User)
+	}
+
+	return &db.IdempotencyKey{
+		Key:              request.IdempotencyKey,
+		NotificationType: updateType,
+		Recipient:        recipient,
+	}
+}
+
+// recordForRecipient records a notification for a single recipient in an open transaction, queues
+// the messages to publish once the transaction has been committed, and returns the ID of the
+// notification. Nothing is recorded, and the ID is empty, if the recipient has opted out of
+// recording notifications of this type.
+func (r *Recorder) recordForRecipient(
+	ctx context.Context,
+	tx *sql.Tx,
+	updateType string,
+	request *Request,
+	body []byte,
+	timeCreated time.Time,
+	routingKey string,
+) (string, error) {
+	var err error
+
+	// Look up the recipient's preferences for this notification type.
+	preference, err := r.dbc.GetPreference(ctx, tx, r.userSuffix.Qualify(request.User), updateType)
+	if err != nil {
+		return "", classifyDatabaseError(err, "unable to look up the notification preferences")
+	}
+
+	// Drop the notification entirely if the recipient has opted out of recording it.
+	if !preference.Record {
+		log.Debugf("not recording a %s notification for %s, who has opted out of them", updateType, request.User)
+		return "", nil
+	}
+
+	// Validate the email request before anything is committed, so that a bad address discards
+	// the delivery instead of leaving a recorded notification behind. No email is sent if the
+	// recipient has opted out of emails for this notification type.
+	var emailRequest *messaging.EmailRequest
+	if request.Email && preference.Email {
+		emailRequest, err = r.buildEmailRequest(request)
+		if err != nil {
+			return "", err
+		}
 	}
 
 	// Store the message in the database. User is qualified because it resolves to a users row;
@@ -197,57 +588,66 @@ func (r *Recorder) Record(ctx context.Context, updateType string, body []byte, r
 		RoutingKey:       routingKey,
 	}
 	if err = r.dbc.SaveNotification(ctx, tx, storableRequest); err != nil {
-		return classifyDatabaseError(err, "unable to save the notification")
+		return "", classifyDatabaseError(err, "unable to save the notification")
 	}
 
 	// Build the notification message.
-	notificationMessage, err := r.buildNotificationMessage(storableRequest, &request)
+	notificationMessage, err := r.buildNotificationMessage(storableRequest, request)
 	if err != nil {
-		return err
+		return "", err
 	}
 
 	// Save the outgoing notification in the database.
 	if err = r.dbc.SaveOutgoingNotification(ctx, tx, notificationMessage); err != nil {
-		return classifyDatabaseError(err, "unable to save the outgoing notification")
+		return "", classifyDatabaseError(err, "unable to save the outgoing notification")
+	}
+
+	// Hold the email for the recipient's digest instead of sending it now if they've asked for one.
+	if emailRequest != nil && preference.Digest != "" {
+		err = r.dbc.SavePendingDigestItem(ctx, tx, storableRequest.ID, preference.Digest, emailRequest)
+		if err != nil {
+			return "", classifyDatabaseError(err, "unable to save the digest item")
+		}
+		emailRequest = nil
+	}
+
+	// Hold the email until the end of the recipient's quiet hours if it would arrive during them.
+	if emailRequest != nil {
+		deferred, err := r.deferEmailDuringQuietHours(ctx, tx, storableRequest, emailRequest)
+		if err != nil {
+			return "", err
+		}
+		if deferred {
+			emailRequest = nil
+		}
 	}
 
 	// Count the number of unread notifications.
 	unreadNotificationCount, err := r.dbc.CountUnreadNotifications(ctx, tx, r.userSuffix.Qualify(request.User))
 	if err != nil {
-		return classifyDatabaseError(err, "unable to count the unread notifications")
-	}
-
-	// Add the wrapper around the notification message.
-	wrappedNotificationMessage := &messaging.WrappedNotificationMessage{
-		Message: notificationMessage,
-		Total:   unreadNotificationCount,
+		return "", classifyDatabaseError(err, "unable to count the unread notifications")
 	}
 
-	// Commit the transaction.
-	if err = r.dbc.Commit(tx); err != nil {
-		return NewRecoverableError("unable to commit the database transaction: %s", err.Error())
+	// Let every replica know about the notification, so that clients streaming from any of them
+	// receive it. The announcement is only delivered if the transaction commits.
+	if err = r.dbc.AnnounceNotification(ctx, tx, storableRequest.ID, storableRequest.User); err != nil {
+		return "", classifyDatabaseError(err, "unable to announce the notification")
 	}
-	committed = true
 
-	// Publishing after the commit means a publish failure cannot requeue the delivery and write
-	// a duplicate notification. The cost is a recorded notification the user is never pinged
-	// about, which is logged rather than retried.
+	// Queue the email and the notification message, with the wrapper around the notification
+	// message, to be published once the transaction commits.
 	if emailRequest != nil {
-		if err := r.messagingClient.PublishEmailRequestContext(ctx, emailRequest); err != nil {
-			log.Errorf(
-				"notification %s was recorded but its email request could not be published; "+
-					"the AMQP exchange is probably unreachable: %s",
-				storableRequest.ID, err.Error(),
-			)
+		if err = r.dbc.QueueEmailRequest(ctx, tx, storableRequest.ID, emailRequest); err != nil {
+			return "", classifyDatabaseError(err, "unable to queue the email request")
 		}
 	}
-	if err := r.messagingClient.PublishNotificationMessageContext(ctx, wrappedNotificationMessage); err != nil {
-		log.Errorf(
-			"notification %s was recorded but could not be published to the UI; "+
-				"the AMQP exchange is probably unreachable: %s",
-			storableRequest.ID, err.Error(),
-		)
+	notification := &messaging.WrappedNotificationMessage{
+		Message: notificationMessage,
+		Total:   unreadNotificationCount,
+	}
+	if err = r.dbc.QueueNotificationMessage(ctx, tx, storableRequest.ID, notification); err != nil {
+		return "", classifyDatabaseError(err, "unable to queue the notification message")
 	}
 
-	return nil
+	return storableRequest.ID, nil
 }
@@ -8,35 +8,16 @@ import (
 	"os"
 	"regexp"
 	"testing"
+	"time"
 
 	"github.com/cyverse-de/messaging/v12"
 	"github.com/cyverse-de/notifications/common"
+	"github.com/cyverse-de/notifications/db"
+	"github.com/cyverse-de/notifications/membership"
+	"github.com/cyverse-de/notifications/model"
 	"github.com/stretchr/testify/assert"
 )
 
-// MockMessagingClient provides mock implementations of the functions we need from messaging.Client.
-type MockMessagingClient struct {
-	PublishedNotificationMessage *messaging.WrappedNotificationMessage
-	PublishedEmailRequest        *messaging.EmailRequest
-}
-
-// PublishNotificationMessageContext stores a copy of the notification message for later inspection.
-func (c *MockMessagingClient) PublishNotificationMessageContext(_ context.Context, msg *messaging.WrappedNotificationMessage) error {
-	c.PublishedNotificationMessage = msg
-	return nil
-}
-
-// PublishEmailRequestContext stores a copy of the email request for later inspection.
-func (c *MockMessagingClient) PublishEmailRequestContext(_ context.Context, req *messaging.EmailRequest) error {
-	c.PublishedEmailRequest = req
-	return nil
-}
-
-// NewMockMessagingClient creates a new mock messaging client for testing.
-func NewMockMessagingClient() *MockMessagingClient {
-	return &MockMessagingClient{}
-}
-
 // FakeNotificationID is the identifier assigned to notifications by the mock database client.
 const FakeNotificationID = "46ae63be-7030-4cdd-8eb9-66aa49fcf38b"
 
@@ -53,14 +34,52 @@ type MockDatabaseClient struct {
 	savedOutgoingMessage       *messaging.NotificationMessage
 	unreadMessageCount         int64
 
+	// AnnouncedIDs and AnnouncedUsers record the notifications that were announced to other replicas.
+	AnnouncedIDs   []string
+	AnnouncedUsers []string
+
+	// DigestPeriods and DigestEmailRequests record the emails that were held for digests.
+	DigestPeriods       []string
+	DigestEmailRequests []*messaging.EmailRequest
+
+	// QuietHours, when set, is returned as the recipient's quiet hours.
+	QuietHours *model.QuietHours
+
+	// DeferredEmailRequests and ReleaseTimes record the emails that were held until the end of quiet hours.
+	DeferredEmailRequests []*messaging.EmailRequest
+	ReleaseTimes          []time.Time
+
+	// Preference, when set, is returned as the recipient's preferences for every notification type.
+	Preference *model.NotificationPreference
+
+	// BeginErr, when set, makes Begin fail.
+	BeginErr error
+
 	// CommitErr, when set, makes Commit fail so post-commit behavior can be tested.
 	CommitErr error
+
+	// ClaimedKeys records the idempotency keys that have been claimed, along with the IDs of the notifications that
+	// were recorded for them.
+	ClaimedKeys map[db.IdempotencyKey][]string
+
+	// QueuedNotificationMessage and QueuedEmailRequest are the last messages that were queued in the outbox.
+	QueuedNotificationMessage *messaging.WrappedNotificationMessage
+	QueuedEmailRequest        *messaging.EmailRequest
+
+	// QueuedNotificationMessages and QueuedEmailRequests record every message that was queued, for requests that are
+	// addressed to several recipients.
+	QueuedNotificationMessages []*messaging.WrappedNotificationMessage
+	QueuedEmailRequests        []*messaging.EmailRequest
+
+	// QuarantinedRoutingKeys and QuarantinedErrors record the events that were quarantined.
+	QuarantinedRoutingKeys []string
+	QuarantinedErrors      []string
 }
 
-// Begin records the fact that it was called.
+// Begin records the fact that it was called and reports BeginErr.
 func (c *MockDatabaseClient) Begin() (*sql.Tx, error) {
 	c.BeginCalled = true
-	return nil, nil
+	return nil, c.BeginErr
 }
 
 // Commit records the fact that it was called and reports CommitErr.
@@ -103,6 +122,106 @@ func (c *MockDatabaseClient) CountUnreadNotifications(_ context.Context, _ *sql.
 	return c.unreadMessageCount, nil
 }
 
+// AnnounceNotification records the notification that was announced.
+func (c *MockDatabaseClient) AnnounceNotification(_ context.Context, _ *sql.Tx, id, user string) error {
+	c.AnnouncedIDs = append(c.AnnouncedIDs, id)
+	c.AnnouncedUsers = append(c.AnnouncedUsers, user)
+	return nil
+}
+
+// GetPreference returns Preference, or the defaults if Preference isn't set.
+func (c *MockDatabaseClient) GetPreference(_ context.Context, _ *sql.Tx, _, notificationType string) (*model.NotificationPreference, error) {
+	if c.Preference != nil {
+		return c.Preference, nil
+	}
+	return model.DefaultNotificationPreference(notificationType), nil
+}
+
+// SavePendingDigestItem records the email request that was held for a digest.
+func (c *MockDatabaseClient) SavePendingDigestItem(
+	_ context.Context,
+	_ *sql.Tx,
+	_ string,
+	period string,
+	emailRequest *messaging.EmailRequest,
+) error {
+	c.DigestPeriods = append(c.DigestPeriods, period)
+	c.DigestEmailRequests = append(c.DigestEmailRequests, emailRequest)
+	return nil
+}
+
+// GetQuietHours returns QuietHours.
+func (c *MockDatabaseClient) GetQuietHours(context.Context, *sql.Tx, string) (*model.QuietHours, error) {
+	return c.QuietHours, nil
+}
+
+// DeferEmail records the email request that was deferred and when it's to be released.
+func (c *MockDatabaseClient) DeferEmail(
+	_ context.Context,
+	_ *sql.Tx,
+	_ string,
+	emailRequest *messaging.EmailRequest,
+	releaseAt time.Time,
+) error {
+	c.DeferredEmailRequests = append(c.DeferredEmailRequests, emailRequest)
+	c.ReleaseTimes = append(c.ReleaseTimes, releaseAt)
+	return nil
+}
+
+// ClaimIdempotencyKey claims a key unless it's in ClaimedKeys, and adds it to ClaimedKeys.
+func (c *MockDatabaseClient) ClaimIdempotencyKey(
+	_ context.Context,
+	_ *sql.Tx,
+	key *db.IdempotencyKey,
+	_, _ time.Time,
+) (bool, []string, error) {
+	if c.ClaimedKeys == nil {
+		c.ClaimedKeys = make(map[db.IdempotencyKey][]string)
+	}
+	if ids, ok := c.ClaimedKeys[*key]; ok {
+		return false, ids, nil
+	}
+	c.ClaimedKeys[*key] = nil
+	return true, nil, nil
+}
+
+// SaveIdempotencyKeyNotifications records the notification IDs for a key in ClaimedKeys.
+func (c *MockDatabaseClient) SaveIdempotencyKeyNotifications(
+	_ context.Context,
+	_ *sql.Tx,
+	key *db.IdempotencyKey,
+	ids []string,
+) error {
+	c.ClaimedKeys[*key] = ids
+	return nil
+}
+
+// QueueEmailRequest records the email request that was queued in the outbox.
+func (c *MockDatabaseClient) QueueEmailRequest(_ context.Context, _ *sql.Tx, _ string, req *messaging.EmailRequest) error {
+	c.QueuedEmailRequest = req
+	c.QueuedEmailRequests = append(c.QueuedEmailRequests, req)
+	return nil
+}
+
+// QueueNotificationMessage records the notification message that was queued in the outbox.
+func (c *MockDatabaseClient) QueueNotificationMessage(
+	_ context.Context,
+	_ *sql.Tx,
+	_ string,
+	msg *messaging.WrappedNotificationMessage,
+) error {
+	c.QueuedNotificationMessage = msg
+	c.QueuedNotificationMessages = append(c.QueuedNotificationMessages, msg)
+	return nil
+}
+
+// QuarantineEvent records the event that was quarantined.
+func (c *MockDatabaseClient) QuarantineEvent(_ context.Context, _ *sql.Tx, routingKey string, _ []byte, errorText string) error {
+	c.QuarantinedRoutingKeys = append(c.QuarantinedRoutingKeys, routingKey)
+	c.QuarantinedErrors = append(c.QuarantinedErrors, errorText)
+	return nil
+}
+
 // NewMockDatabaseClient creates a new mock database client for testing.
 func NewMockDatabaseClient(unreadMessageCount int64) *MockDatabaseClient {
 	return &MockDatabaseClient{unreadMessageCount: unreadMessageCount}
@@ -207,8 +326,7 @@ func TestRecord(t *testing.T) {
 
 			body := marshalRequest(t, tt.mutate)
 			databaseClient := NewMockDatabaseClient(tt.wantUnread)
-			messagingClient := NewMockMessagingClient()
-			r := New(databaseClient, messagingClient, testUserSuffix)
+			r := New(databaseClient, testUserSuffix, nil, 0)
 
 			err := r.Record(context.Background(), tt.updateType, body, FakeRoutingKey)
 			assert.NoError(err)
@@ -244,10 +362,10 @@ func TestRecord(t *testing.T) {
 			)
 
 			// The email request was published only when one was requested.
-			emailRequest := messagingClient.PublishedEmailRequest
+			emailRequest := databaseClient.QueuedEmailRequest
 			if tt.wantEmail {
 				if emailRequest == nil {
-					t.Fatal("no email request was published")
+					t.Fatal("no email request was queued")
 				}
 				assert.Equal("some job status changed", emailRequest.Subject, "incorrect email subject")
 				assert.Equal("sarahr@cyverse.org", emailRequest.ToAddress, "incorrect email address")
@@ -256,7 +374,7 @@ func TestRecord(t *testing.T) {
 			}
 
 			// The UI notification was published.
-			notification := messagingClient.PublishedNotificationMessage
+			notification := databaseClient.QueuedNotificationMessage
 			if notification == nil {
 				t.Fatal("no notification was published")
 			}
@@ -322,35 +440,34 @@ func TestRecordRejectsBadInput(t *testing.T) {
 			}
 
 			databaseClient := NewMockDatabaseClient(42)
-			messagingClient := NewMockMessagingClient()
-			r := New(databaseClient, messagingClient, testUserSuffix)
+			r := New(databaseClient, testUserSuffix, nil, 0)
 
 			err := r.Record(context.Background(), "analysis", body, FakeRoutingKey)
 
 			var unrecoverable UnrecoverableError
 			assert.ErrorAs(err, &unrecoverable, "bad input must be an unrecoverable error so the delivery is discarded")
 			assert.False(databaseClient.CommitCalled, "nothing may be committed for a rejected request")
-			assert.Nil(messagingClient.PublishedEmailRequest, "no email may be published for a rejected request")
-			assert.Nil(messagingClient.PublishedNotificationMessage, "no notification may be published for a rejected request")
+			assert.Nil(databaseClient.QueuedEmailRequest, "no email may be queued for a rejected request")
+			assert.Nil(databaseClient.QueuedNotificationMessage, "no notification may be queued for a rejected request")
 		})
 	}
 }
 
-func TestPublishesHappenAfterCommit(t *testing.T) {
+func TestMessagesAreQueuedInTheTransaction(t *testing.T) {
 	assert := assert.New(t)
 
 	databaseClient := NewMockDatabaseClient(42)
 	databaseClient.CommitErr = errors.New("commit failed")
-	messagingClient := NewMockMessagingClient()
-	r := New(databaseClient, messagingClient, testUserSuffix)
+	r := New(databaseClient, testUserSuffix, nil, 0)
 
 	err := r.Record(context.Background(), "analysis", marshalRequest(t, nil), FakeRoutingKey)
 
+	// The messages are queued in the transaction that failed to commit, so they're discarded along with the
+	// notification instead of being published for a notification that was never recorded.
 	assert.Error(err, "a failed commit must be reported")
-	assert.Nil(messagingClient.PublishedEmailRequest,
-		"no email may be published when the transaction did not commit")
-	assert.Nil(messagingClient.PublishedNotificationMessage,
-		"no UI notification may be published when the transaction did not commit")
+	assert.NotNil(databaseClient.QueuedEmailRequest, "the email was not queued in the transaction")
+	assert.NotNil(databaseClient.QueuedNotificationMessage, "the UI notification was not queued in the transaction")
+	assert.True(databaseClient.RollbackCalled, "the transaction was not rolled back")
 
 	var recoverable RecoverableError
 	assert.ErrorAs(err, &recoverable, "a failed commit is recoverable so the delivery is requeued")
@@ -359,15 +476,15 @@ func TestPublishesHappenAfterCommit(t *testing.T) {
 func TestEmailPayloadIsNotRewritten(t *testing.T) {
 	assert := assert.New(t)
 
-	messagingClient := NewMockMessagingClient()
-	r := New(NewMockDatabaseClient(42), messagingClient, testUserSuffix)
+	databaseClient := NewMockDatabaseClient(42)
+	r := New(databaseClient, testUserSuffix, nil, 0)
 
 	err := r.Record(context.Background(), "analysis", marshalRequest(t, nil), FakeRoutingKey)
 	assert.NoError(err)
 
-	emailRequest := messagingClient.PublishedEmailRequest
+	emailRequest := databaseClient.QueuedEmailRequest
 	if emailRequest == nil {
-		t.Fatal("no email request was published")
+		t.Fatal("no email request was queued")
 	}
 	assert.Equal("2020-07-07T17:59:59-07:00", emailRequest.TemplateValues["startdate"],
 		"the email templates expect the timestamps exactly as they arrived")
@@ -375,7 +492,7 @@ func TestEmailPayloadIsNotRewritten(t *testing.T) {
 
 func TestOutgoingJSONShapeIsUnchanged(t *testing.T) {
 	databaseClient := NewMockDatabaseClient(42)
-	r := New(databaseClient, NewMockMessagingClient(), testUserSuffix)
+	r := New(databaseClient, testUserSuffix, nil, 0)
 
 	if err := r.Record(context.Background(), "analysis", marshalRequest(t, nil), FakeRoutingKey); err != nil {
 		t.Fatalf("unexpected error: %s", err.Error())
@@ -402,8 +519,7 @@ func TestUsernameIsQualifiedForStorageOnly(t *testing.T) {
 	assert := assert.New(t)
 
 	databaseClient := NewMockDatabaseClient(42)
-	messagingClient := NewMockMessagingClient()
-	r := New(databaseClient, messagingClient, testUserSuffix)
+	r := New(databaseClient, testUserSuffix, nil, 0)
 
 	err := r.Record(context.Background(), "analysis", marshalRequest(t, nil), FakeRoutingKey)
 	assert.NoError(err)
@@ -416,7 +532,7 @@ func TestUsernameIsQualifiedForStorageOnly(t *testing.T) {
 	// The outgoing message is served back to clients verbatim out of outgoing_json, and every
 	// caller sends and expects the bare username. Qualifying it here would change the wire
 	// contract.
-	assert.Equal("sarahr", messagingClient.PublishedNotificationMessage.Message.User,
+	assert.Equal("sarahr", databaseClient.QueuedNotificationMessage.Message.User,
 		"the published notification must keep the bare username")
 }
 
@@ -424,7 +540,7 @@ func TestUsernameAlreadyAnAddressResolvesToTheDEAccount(t *testing.T) {
 	assert := assert.New(t)
 
 	databaseClient := NewMockDatabaseClient(42)
-	r := New(databaseClient, NewMockMessagingClient(), testUserSuffix)
+	r := New(databaseClient, testUserSuffix, nil, 0)
 
 	body := marshalRequest(t, func(req map[string]any) {
 		req["user"] = "stephen.wright@utoronto.ca"
@@ -437,3 +553,327 @@ func TestUsernameAlreadyAnAddressResolvesToTheDEAccount(t *testing.T) {
 	assert.Equal("stephen.wright@iplantcollaborative.org", databaseClient.SavedNotification.User,
 		"a username that is already an address must resolve to its DE account")
 }
+
+func TestRecordedNotificationsAreAnnounced(t *testing.T) {
+	assert := assert.New(t)
+
+	databaseClient := NewMockDatabaseClient(42)
+	r := New(databaseClient, testUserSuffix, nil, 0)
+
+	err := r.Record(context.Background(), "analysis", marshalRequest(t, nil), FakeRoutingKey)
+	assert.NoError(err)
+
+	// Streams are keyed by the qualified username.
+	assert.Equal([]string{FakeNotificationID}, databaseClient.AnnouncedIDs)
+	assert.Equal([]string{"sarahr@iplantcollaborative.org"}, databaseClient.AnnouncedUsers)
+}
+
+func TestEmailOptOutSkipsOnlyTheEmail(t *testing.T) {
+	assert := assert.New(t)
+
+	databaseClient := NewMockDatabaseClient(42)
+	databaseClient.Preference = &model.NotificationPreference{Type: "analysis", Email: false, Record: true}
+	r := New(databaseClient, testUserSuffix, nil, 0)
+
+	// The email address is invalid too, but it doesn't matter because no email is going out.
+	body := marshalRequest(t, func(m map[string]any) {
+		m["payload"].(map[string]any)["email_address"] = "not-an-address"
+	})
+	err := r.Record(context.Background(), "analysis", body, FakeRoutingKey)
+
+	assert.NoError(err)
+	assert.True(databaseClient.CommitCalled, "the notification was not recorded")
+	assert.NotNil(databaseClient.QueuedNotificationMessage, "the notification was not sent to the UI")
+	assert.Nil(databaseClient.QueuedEmailRequest, "an email was sent to a user who opted out of them")
+}
+
+func TestRecordOptOutDropsTheNotification(t *testing.T) {
+	assert := assert.New(t)
+
+	databaseClient := NewMockDatabaseClient(42)
+	databaseClient.Preference = &model.NotificationPreference{Type: "analysis", Email: true, Record: false}
+	r := New(databaseClient, testUserSuffix, nil, 0)
+
+	err := r.Record(context.Background(), "analysis", marshalRequest(t, nil), FakeRoutingKey)
+
+	// The delivery is acknowledged rather than retried; there's nothing wrong with it.
+	assert.NoError(err)
+	assert.Nil(databaseClient.SavedNotification, "a notification that the user opted out of was saved")
+	assert.False(databaseClient.CommitCalled, "nothing may be committed for a notification that isn't recorded")
+	assert.Nil(databaseClient.QueuedNotificationMessage, "a notification that the user opted out of was sent to the UI")
+	assert.Nil(databaseClient.QueuedEmailRequest, "a notification that the user opted out of was emailed")
+}
+
+func TestRecordNotificationReturnsTheID(t *testing.T) {
+	assert := assert.New(t)
+
+	databaseClient := NewMockDatabaseClient(42)
+	r := New(databaseClient, testUserSuffix, nil, 0)
+
+	id, err := r.RecordNotification(context.Background(), "analysis", marshalRequest(t, nil), FakeRoutingKey)
+	assert.NoError(err)
+	assert.Equal(FakeNotificationID, id)
+
+	// No ID is returned for a notification that the user opted out of.
+	databaseClient = NewMockDatabaseClient(42)
+	databaseClient.Preference = &model.NotificationPreference{Type: "analysis", Email: true, Record: false}
+	r = New(databaseClient, testUserSuffix, nil, 0)
+
+	id, err = r.RecordNotification(context.Background(), "analysis", marshalRequest(t, nil), FakeRoutingKey)
+	assert.NoError(err)
+	assert.Empty(id)
+}
+
+func TestDigestPreferenceHoldsTheEmail(t *testing.T) {
+	assert := assert.New(t)
+
+	databaseClient := NewMockDatabaseClient(42)
+	databaseClient.Preference = &model.NotificationPreference{
+		Type:   "analysis",
+		Email:  true,
+		Record: true,
+		Digest: model.DigestDaily,
+	}
+	r := New(databaseClient, testUserSuffix, nil, 0)
+
+	err := r.Record(context.Background(), "analysis", marshalRequest(t, nil), FakeRoutingKey)
+
+	assert.NoError(err)
+	assert.True(databaseClient.CommitCalled, "the notification was not recorded")
+	assert.NotNil(databaseClient.QueuedNotificationMessage, "the notification was not sent to the UI")
+	assert.Nil(databaseClient.QueuedEmailRequest, "an email was sent for a notification that belongs in a digest")
+	assert.Equal([]string{model.DigestDaily}, databaseClient.DigestPeriods)
+	if assert.Len(databaseClient.DigestEmailRequests, 1) {
+		assert.Equal("sarahr@cyverse.org", databaseClient.DigestEmailRequests[0].ToAddress)
+	}
+}
+
+func TestEmailsAreHeldDuringQuietHours(t *testing.T) {
+	tests := []struct {
+		name      string
+		now       time.Time
+		wantHeld  bool
+		wantUntil time.Time
+	}{
+		{
+			name:      "an email recorded during quiet hours is held until they end",
+			now:       time.Date(2026, time.October, 14, 5, 30, 0, 0, time.UTC),
+			wantHeld:  true,
+			wantUntil: time.Date(2026, time.October, 14, 7, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "an email recorded outside quiet hours is sent right away",
+			now:  time.Date(2026, time.October, 14, 12, 0, 0, 0, time.UTC),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			databaseClient := NewMockDatabaseClient(42)
+			databaseClient.QuietHours = &model.QuietHours{TimeZone: "UTC", Start: "22:00", End: "07:00"}
+			r := New(databaseClient, testUserSuffix, nil, 0)
+			r.now = func() time.Time { return tt.now }
+
+			err := r.Record(context.Background(), "analysis", marshalRequest(t, nil), FakeRoutingKey)
+
+			assert.NoError(err)
+			assert.NotNil(databaseClient.QueuedNotificationMessage, "the UI is notified regardless of quiet hours")
+			if tt.wantHeld {
+				assert.Nil(databaseClient.QueuedEmailRequest, "an email was sent during quiet hours")
+				assert.Len(databaseClient.DeferredEmailRequests, 1)
+				if assert.Len(databaseClient.ReleaseTimes, 1) {
+					assert.True(tt.wantUntil.Equal(databaseClient.ReleaseTimes[0]))
+				}
+			} else {
+				assert.NotNil(databaseClient.QueuedEmailRequest, "an email was held outside quiet hours")
+				assert.Empty(databaseClient.DeferredEmailRequests)
+			}
+		})
+	}
+}
+
+func TestTeamNotificationsAreRecordedForEachMember(t *testing.T) {
+	assert := assert.New(t)
+
+	databaseClient := NewMockDatabaseClient(42)
+	teams := membership.NewStaticResolver(map[string][]string{"pals": {"sarahr", "ipcdev"}})
+	r := New(databaseClient, testUserSuffix, teams, 0)
+
+	body := marshalRequest(t, func(m map[string]any) {
+		delete(m, "user")
+		m["type"] = "team"
+		m["team"] = "pals"
+		m["email"] = false
+	})
+	err := r.Record(context.Background(), "added_to_team", body, FakeRoutingKey)
+	assert.NoError(err)
+
+	// Both members were recorded in the same transaction, and each of them was published on their own.
+	assert.True(databaseClient.CommitCalled)
+	assert.Equal([]string{"ipcdev@iplantcollaborative.org", "sarahr@iplantcollaborative.org"}, databaseClient.AnnouncedUsers)
+	if assert.Len(databaseClient.QueuedNotificationMessages, 2) {
+		assert.Equal("ipcdev", databaseClient.QueuedNotificationMessages[0].Message.User)
+		assert.Equal("sarahr", databaseClient.QueuedNotificationMessages[1].Message.User)
+	}
+	assert.Empty(databaseClient.QueuedEmailRequests)
+}
+
+// addressResolver is a team resolver that knows the email addresses of the members of its teams.
+type addressResolver struct {
+	*membership.StaticResolver
+	addresses map[string]string
+}
+
+func (r addressResolver) Addresses(ctx context.Context, team string) (map[string]string, error) {
+	members, err := r.Members(ctx, team)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]string)
+	for _, member := range members {
+		if addr, ok := r.addresses[member]; ok {
+			result[member] = addr
+		}
+	}
+	return result, nil
+}
+
+func TestTeamNotificationsEmailMembers(t *testing.T) {
+	assert := assert.New(t)
+
+	databaseClient := NewMockDatabaseClient(42)
+	teams := addressResolver{
+		StaticResolver: membership.NewStaticResolver(map[string][]string{"pals": {"sarahr", "ipcdev", "wregglej"}}),
+		addresses:      map[string]string{"sarahr": "sarahr@example.org", "ipcdev": "ipcdev@example.org"},
+	}
+	r := New(databaseClient, testUserSuffix, teams, 0)
+
+	// The addresses in the request take precedence over the resolver's.
+	body := marshalRequest(t, func(m map[string]any) {
+		delete(m, "user")
+		m["team"] = "pals"
+		m["payload"].(map[string]any)["email_addresses"] = map[string]any{"sarahr": "sarahr@cyverse.org"}
+	})
+	err := r.Record(context.Background(), "added_to_team", body, FakeRoutingKey)
+	assert.NoError(err)
+
+	// Every member is notified, but only the members who have an address are emailed.
+	assert.True(databaseClient.CommitCalled)
+	assert.Len(databaseClient.QueuedNotificationMessages, 3)
+	addresses := make([]string, len(databaseClient.QueuedEmailRequests))
+	for i, request := range databaseClient.QueuedEmailRequests {
+		addresses[i] = request.ToAddress
+	}
+	assert.ElementsMatch([]string{"ipcdev@example.org", "sarahr@cyverse.org"}, addresses)
+}
+
+func TestTeamNotificationsWithoutAddressesAreStillRecorded(t *testing.T) {
+	assert := assert.New(t)
+
+	databaseClient := NewMockDatabaseClient(42)
+	teams := membership.NewStaticResolver(map[string][]string{"pals": {"sarahr", "ipcdev"}})
+	r := New(databaseClient, testUserSuffix, teams, 0)
+
+	// The resolver doesn't know any addresses, so only the member whose address was supplied is emailed.
+	body := marshalRequest(t, func(m map[string]any) {
+		delete(m, "user")
+		m["team"] = "pals"
+		m["payload"].(map[string]any)["email_addresses"] = map[string]any{"sarahr": "sarahr@cyverse.org"}
+	})
+	err := r.Record(context.Background(), "added_to_team", body, FakeRoutingKey)
+	assert.NoError(err)
+
+	assert.True(databaseClient.CommitCalled)
+	assert.Len(databaseClient.QueuedNotificationMessages, 2)
+	if assert.Len(databaseClient.QueuedEmailRequests, 1) {
+		assert.Equal("sarahr@cyverse.org", databaseClient.QueuedEmailRequests[0].ToAddress)
+	}
+}
+
+func TestTeamNotificationsRequireAKnownTeam(t *testing.T) {
+	teams := membership.NewStaticResolver(map[string][]string{"pals": {"sarahr"}})
+	for _, resolver := range []membership.Resolver{nil, teams} {
+		databaseClient := NewMockDatabaseClient(42)
+		r := New(databaseClient, testUserSuffix, resolver, 0)
+
+		body := marshalRequest(t, func(m map[string]any) {
+			delete(m, "user")
+			m["email"] = false
+			m["team"] = "rivals"
+		})
+		err := r.Record(context.Background(), "added_to_team", body, FakeRoutingKey)
+
+		var unrecoverable UnrecoverableError
+		assert.ErrorAs(t, err, &unrecoverable)
+		assert.False(t, databaseClient.BeginCalled)
+	}
+}
+
+func TestRecordNotificationRejectsSeveralRecipients(t *testing.T) {
+	databaseClient := NewMockDatabaseClient(42)
+	r := New(databaseClient, testUserSuffix, nil, 0)
+
+	body := marshalRequest(t, func(m map[string]any) {
+		delete(m, "user")
+		m["email"] = false
+		m["recipients"] = []string{"sarahr", "ipcdev"}
+	})
+	_, err := r.RecordNotification(context.Background(), "analysis", body, FakeRoutingKey)
+
+	var unrecoverable UnrecoverableError
+	assert.ErrorAs(t, err, &unrecoverable)
+	assert.False(t, databaseClient.BeginCalled)
+}
+
+func TestDuplicateRequestsAreRecordedOnce(t *testing.T) {
+	assert := assert.New(t)
+
+	databaseClient := NewMockDatabaseClient(42)
+	r := New(databaseClient, testUserSuffix, nil, 0)
+
+	body := marshalRequest(t, func(m map[string]any) { m["idempotency_key"] = "job-1234-completed" })
+	assert.NoError(r.Record(context.Background(), "analysis", body, FakeRoutingKey))
+	assert.Len(databaseClient.QueuedEmailRequests, 1)
+
+	// A redelivery is acknowledged without recording or sending anything.
+	databaseClient.CommitCalled = false
+	assert.NoError(r.Record(context.Background(), "analysis", body, FakeRoutingKey))
+	assert.False(databaseClient.CommitCalled, "nothing may be committed for a duplicate request")
+	assert.Len(databaseClient.QueuedEmailRequests, 1, "the email was sent again")
+	assert.Len(databaseClient.QueuedNotificationMessages, 1, "the notification was queued again")
+
+	// Callers waiting for the notification to be recorded are told that it's a duplicate, and which notification was
+	// recorded for the original request.
+	id, err := r.RecordNotification(context.Background(), "analysis", body, FakeRoutingKey)
+	assert.ErrorIs(err, ErrDuplicateRequest)
+	assert.Equal(FakeNotificationID, id)
+}
+
+func TestIdempotencyKeysAreScopedToTheTypeAndRecipient(t *testing.T) {
+	assert := assert.New(t)
+
+	databaseClient := NewMockDatabaseClient(42)
+	r := New(databaseClient, testUserSuffix, nil, 0)
+
+	// Requests from unrelated callers that happen to choose the same key are each recorded.
+	tests := []struct {
+		updateType string
+		user       string
+	}{
+		{updateType: "analysis", user: "sarahr"},
+		{updateType: "analysis", user: "ipcdev"},
+		{updateType: "data", user: "sarahr"},
+	}
+	for _, tt := range tests {
+		body := marshalRequest(t, func(m map[string]any) {
+			m["idempotency_key"] = "job-1234-completed"
+			m["user"] = tt.user
+		})
+		assert.NoError(r.Record(context.Background(), tt.updateType, body, FakeRoutingKey))
+	}
+
+	assert.Len(databaseClient.QueuedNotificationMessages, len(tests))
+	assert.Len(databaseClient.ClaimedKeys, len(tests))
+}
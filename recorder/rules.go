@@ -0,0 +1,322 @@
+package recorder
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Rule describes how events that aren't notification requests are turned into notifications. An event matches a rule
+// if its routing key matches one of the rule's routing key patterns and every field listed in Match has the given
+// value. The type, user, subject, message, email address, timestamp and idempotency key are text/template templates
+// that are executed with the routing key, the update type and the event itself, for example {{.Event.name}}.
+type Rule struct {
+	Name           string            `json:"name"`
+	RoutingKeys    []string          `json:"routing_keys"`
+	Match          map[string]string `json:"match,omitempty"`
+	Type           string            `json:"type"`
+	User           string            `json:"user"`
+	Subject        string            `json:"subject"`
+	Message        string            `json:"message,omitempty"`
+	Email          bool              `json:"email,omitempty"`
+	EmailTemplate  string            `json:"email_template,omitempty"`
+	EmailAddress   string            `json:"email_address,omitempty"`
+	Timestamp      string            `json:"timestamp,omitempty"`
+	IdempotencyKey string            `json:"idempotency_key,omitempty"`
+}
+
+// ruleData is the value that rule templates are executed with.
+type ruleData struct {
+	RoutingKey string
+	UpdateType string
+	Event      map[string]interface{}
+}
+
+// compiledRule is a rule with its templates parsed.
+type compiledRule struct {
+	Rule
+	notificationType *template.Template
+	user             *template.Template
+	subject          *template.Template
+	message          *template.Template
+	emailAddress     *template.Template
+	timestamp        *template.Template
+	idempotencyKey   *template.Template
+}
+
+// RuleHandler turns events into notification requests according to a list of rules, and records them with the
+// Recorder. It's registered as the handler for each category that its rules match.
+type RuleHandler struct {
+	recorder *Recorder
+	rules    []*compiledRule
+	now      func() time.Time
+}
+
+// parseRuleTemplate parses one of a rule's templates. Templates fail if they refer to event fields that don't exist, so
+// that a notification isn't recorded with missing text.
+func parseRuleTemplate(rule *Rule, field, text string) (*template.Template, error) {
+	tmpl, err := template.New(field).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s template in rule %s: %w", field, rule.Name, err)
+	}
+	return tmpl, nil
+}
+
+// NewRuleHandler validates the rules and returns a handler that records the notifications they describe with the
+// recorder. Each routing key pattern has to name a single category other than the notification category, such as
+// events.analysis.update.*; the remaining words may use the * and # wildcards of AMQP topic exchanges.
+func NewRuleHandler(recorder *Recorder, rules []Rule) (*RuleHandler, error) {
+	compiled := make([]*compiledRule, 0, len(rules))
+	for i := range rules {
+		rule := rules[i]
+		if rule.Name == "" {
+			return nil, fmt.Errorf("rule %d has no name", i+1)
+		}
+		if len(rule.RoutingKeys) == 0 {
+			return nil, fmt.Errorf("rule %s has no routing keys", rule.Name)
+		}
+		for _, pattern := range rule.RoutingKeys {
+			category, _, err := ParseRoutingKey(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("invalid routing key in rule %s: %w", rule.Name, err)
+			}
+			if category == "*" || category == "#" || category == NotificationCategory {
+				return nil, fmt.Errorf("rule %s can't match events in category %s", rule.Name, category)
+			}
+		}
+		if rule.Type == "" || rule.User == "" || rule.Subject == "" {
+			return nil, fmt.Errorf("rule %s needs a type, a user and a subject", rule.Name)
+		}
+		if rule.Email && rule.EmailTemplate == "" {
+			return nil, fmt.Errorf("rule %s sends email but has no email template", rule.Name)
+		}
+
+		c := &compiledRule{Rule: rule}
+		var err error
+		templates := []struct {
+			dest  **template.Template
+			field string
+			text  string
+		}{
+			{&c.notificationType, "type", rule.Type},
+			{&c.user, "user", rule.User},
+			{&c.subject, "subject", rule.Subject},
+			{&c.message, "message", rule.Message},
+			{&c.emailAddress, "email_address", rule.EmailAddress},
+			{&c.timestamp, "timestamp", rule.Timestamp},
+			{&c.idempotencyKey, "idempotency_key", rule.IdempotencyKey},
+		}
+		for _, t := range templates {
+			if *t.dest, err = parseRuleTemplate(&rule, t.field, t.text); err != nil {
+				return nil, err
+			}
+		}
+		compiled = append(compiled, c)
+	}
+
+	return &RuleHandler{recorder: recorder, rules: compiled, now: time.Now}, nil
+}
+
+// LoadRuleHandler reads rules from a JSON file containing a list of rules, and returns a handler for them.
+func LoadRuleHandler(path string, recorder *Recorder) (*RuleHandler, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the notification rules: %w", err)
+	}
+
+	var rules []Rule
+	if err = json.Unmarshal(contents, &rules); err != nil {
+		return nil, fmt.Errorf("unable to parse the notification rules in %s: %w", path, err)
+	}
+
+	return NewRuleHandler(recorder, rules)
+}
+
+// Categories returns the routing key categories that the rules match, sorted and without duplicates.
+func (h *RuleHandler) Categories() []string {
+	seen := make(map[string]bool)
+	categories := make([]string, 0)
+	for _, rule := range h.rules {
+		for _, pattern := range rule.RoutingKeys {
+			category, _, _ := ParseRoutingKey(pattern)
+			if !seen[category] {
+				seen[category] = true
+				categories = append(categories, category)
+			}
+		}
+	}
+	sort.Strings(categories)
+	return categories
+}
+
+// Register registers the handler for each of the categories that its rules match.
+func (h *RuleHandler) Register(handlers *Handlers) {
+	for _, category := range h.Categories() {
+		handlers.Register(category, h)
+	}
+}
+
+// matchesTopic returns true if a routing key matches a pattern in which * matches exactly one word and # matches zero
+// or more words.
+func matchesTopic(pattern, routingKey []string) bool {
+	switch {
+	case len(pattern) == 0:
+		return len(routingKey) == 0
+	case pattern[0] == "#":
+		for i := 0; i <= len(routingKey); i++ {
+			if matchesTopic(pattern[1:], routingKey[i:]) {
+				return true
+			}
+		}
+		return false
+	case len(routingKey) == 0:
+		return false
+	case pattern[0] == "*" || pattern[0] == routingKey[0]:
+		return matchesTopic(pattern[1:], routingKey[1:])
+	default:
+		return false
+	}
+}
+
+// lookupField returns the value of a field in an event. Fields in nested objects are named with dotted paths, such as
+// analysis.status.
+func lookupField(event map[string]interface{}, path string) (interface{}, bool) {
+	var value interface{} = event
+	for _, name := range strings.Split(path, ".") {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if value, ok = object[name]; !ok {
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// matches returns true if an event matches the rule.
+func (rule *compiledRule) matches(routingKey string, event map[string]interface{}) bool {
+	words := strings.Split(routingKey, ".")
+	matched := false
+	for _, pattern := range rule.RoutingKeys {
+		if matchesTopic(strings.Split(pattern, "."), words) {
+			matched = true
+			break
+		}
+	}
+	if !matched {
+		return false
+	}
+
+	for path, want := range rule.Match {
+		value, ok := lookupField(event, path)
+		if !ok || fmt.Sprint(value) != want {
+			return false
+		}
+	}
+	return true
+}
+
+// execute executes one of the rule's templates.
+func (rule *compiledRule) execute(tmpl *template.Template, data *ruleData) (string, error) {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", NewUnrecoverableError("unable to build the %s for rule %s: %s", tmpl.Name(), rule.Name, err.Error())
+	}
+	return strings.TrimSpace(buf.String()), nil
+}
+
+// request builds the notification request that the rule describes for an event. The event becomes the payload of the
+// notification. The notification is timestamped with the time given by the rule's timestamp template, or with the
+// given time if the rule doesn't have one.
+func (rule *compiledRule) request(data *ruleData, timestamp time.Time) (*Request, error) {
+	var err error
+	request := &Request{
+		Timestamp:     timestamp.Format(time.RFC3339Nano),
+		Email:         rule.Email,
+		EmailTemplate: rule.EmailTemplate,
+		Payload:       maps.Clone(data.Event),
+	}
+
+	fields := []struct {
+		dest *string
+		tmpl *template.Template
+	}{
+		{&request.RequestType, rule.notificationType},
+		{&request.User, rule.user},
+		{&request.Subject, rule.subject},
+		{&request.Message, rule.message},
+		{&request.IdempotencyKey, rule.idempotencyKey},
+	}
+	for _, f := range fields {
+		if *f.dest, err = rule.execute(f.tmpl, data); err != nil {
+			return nil, err
+		}
+	}
+	if request.RequestType == "" || request.User == "" {
+		return nil, NewUnrecoverableError("rule %s produced a notification without a type or user", rule.Name)
+	}
+
+	if rule.Timestamp != "" {
+		text, err := rule.execute(rule.timestamp, data)
+		if err != nil {
+			return nil, err
+		}
+		eventTime, err := time.Parse(time.RFC3339Nano, text)
+		if err != nil {
+			return nil, NewUnrecoverableError("rule %s produced an invalid timestamp: %s", rule.Name, err.Error())
+		}
+		request.Timestamp = eventTime.Format(time.RFC3339Nano)
+	}
+
+	// The email address is only set if the rule provides one, so that one in the event itself is used otherwise.
+	if rule.EmailAddress != "" {
+		if request.Payload["email_address"], err = rule.execute(rule.emailAddress, data); err != nil {
+			return nil, err
+		}
+	}
+
+	return request, nil
+}
+
+// Handle records the notification described by the first rule that an event matches. Events that don't match any rule
+// are ignored, and events that aren't JSON objects or that a rule's templates can't be executed with are
+// unrecoverable. Unless the rule says where the event's time is, the notification is timestamped with the time that
+// the event was published, so that a redelivered event isn't given a later time.
+func (h *RuleHandler) Handle(ctx context.Context, updateType string, body []byte, routingKey string) error {
+	var event map[string]interface{}
+	if err := json.Unmarshal(body, &event); err != nil {
+		return NewUnrecoverableError("unable to parse message body: %s", err.Error())
+	}
+
+	for _, rule := range h.rules {
+		if !rule.matches(routingKey, event) {
+			continue
+		}
+
+		timestamp, ok := deliveryTime(ctx)
+		if !ok {
+			timestamp = h.now()
+		}
+		request, err := rule.request(&ruleData{RoutingKey: routingKey, UpdateType: updateType, Event: event}, timestamp)
+		if err != nil {
+			return err
+		}
+		requestBody, err := json.Marshal(request)
+		if err != nil {
+			return NewUnrecoverableError("unable to serialize the notification for rule %s: %s", rule.Name, err.Error())
+		}
+
+		return h.recorder.Record(ctx, request.RequestType, requestBody, routingKey)
+	}
+
+	log.Debugf("no notification rule matches the event with routing key %s", routingKey)
+	return nil
+}
@@ -0,0 +1,225 @@
+package recorder
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cyverse-de/notifications/db"
+	"github.com/stretchr/testify/assert"
+)
+
+// analysisEvent returns a serialized analysis status event.
+func analysisEvent(status string) []byte {
+	body, _ := json.Marshal(map[string]any{
+		"user":          "sarahr",
+		"name":          "some job",
+		"status":        status,
+		"email_address": "sarahr@cyverse.org",
+		"id":            "b6a1e9f5-4b0f-4c2e-9f1e-2a3d6c1f7e08",
+		"timestamp":     "2020-07-07T17:58:00Z",
+	})
+	return body
+}
+
+// loadTestRules loads the rules in testdata/rules.json, recording with the given database client.
+func loadTestRules(t *testing.T, databaseClient *MockDatabaseClient) *RuleHandler {
+	t.Helper()
+	rules, err := LoadRuleHandler("testdata/rules.json", New(databaseClient, testUserSuffix, nil, 0))
+	if err != nil {
+		t.Fatalf("unable to load the rules: %s", err.Error())
+	}
+	rules.now = func() time.Time { return time.Date(2020, 7, 7, 17, 59, 59, 0, time.UTC) }
+	return rules
+}
+
+func TestRulesTurnEventsIntoNotifications(t *testing.T) {
+	assert := assert.New(t)
+
+	databaseClient := NewMockDatabaseClient(0)
+	rules := loadTestRules(t, databaseClient)
+	routingKey := "events.analysis.update.completed"
+
+	assert.NoError(rules.Handle(context.Background(), "completed", analysisEvent("Completed"), routingKey))
+
+	saved := databaseClient.SavedNotification
+	if assert.NotNil(saved, "no notification was saved") {
+		assert.Equal("analysis", saved.NotificationType)
+		assert.Equal("sarahr@iplantcollaborative.org", saved.User)
+		assert.Equal("some job Completed", saved.Subject)
+		assert.Equal(routingKey, saved.RoutingKey)
+		assert.True(saved.TimeCreated.Equal(time.Date(2020, 7, 7, 17, 58, 0, 0, time.UTC)),
+			"the notification wasn't given the time of the event")
+	}
+	assert.Contains(databaseClient.ClaimedKeys, db.IdempotencyKey{
+		Key:              "b6a1e9f5-4b0f-4c2e-9f1e-2a3d6c1f7e08-" + routingKey,
+		NotificationType: "analysis",
+		Recipient:        "sarahr@iplantcollaborative.org",
+	})
+	if assert.NotNil(databaseClient.QueuedEmailRequest, "no email was queued") {
+		assert.Equal("analysis_status_change", databaseClient.QueuedEmailRequest.TemplateName)
+		assert.Equal("sarahr@cyverse.org", databaseClient.QueuedEmailRequest.ToAddress)
+	}
+	if assert.NotNil(databaseClient.QueuedNotificationMessage, "no notification message was queued") {
+		assert.Equal("Your analysis some job has finished.",
+			databaseClient.QueuedNotificationMessage.Message.Message["text"])
+	}
+}
+
+func TestTheFirstMatchingRuleIsUsed(t *testing.T) {
+	assert := assert.New(t)
+
+	databaseClient := NewMockDatabaseClient(0)
+	rules := loadTestRules(t, databaseClient)
+
+	routingKey := "events.analysis.update.running"
+	assert.NoError(rules.Handle(context.Background(), "running", analysisEvent("Running"), routingKey))
+
+	assert.NotNil(databaseClient.SavedNotification, "no notification was saved")
+	assert.Nil(databaseClient.QueuedEmailRequest, "the rule for completed analyses was used")
+}
+
+func TestRedeliveredEventsAreRecordedOnce(t *testing.T) {
+	assert := assert.New(t)
+
+	databaseClient := NewMockDatabaseClient(0)
+	rules := loadTestRules(t, databaseClient)
+	routingKey := "events.analysis.update.completed"
+
+	assert.NoError(rules.Handle(context.Background(), "completed", analysisEvent("Completed"), routingKey))
+	databaseClient.SavedNotification = nil
+	assert.NoError(rules.Handle(context.Background(), "completed", analysisEvent("Completed"), routingKey))
+
+	assert.Nil(databaseClient.SavedNotification, "the redelivered event was recorded again")
+}
+
+func TestNotificationsAreGivenTheTimeOfTheDelivery(t *testing.T) {
+	assert := assert.New(t)
+
+	databaseClient := NewMockDatabaseClient(0)
+	rules := loadTestRules(t, databaseClient)
+	published := time.Date(2020, 7, 7, 17, 0, 0, 0, time.UTC)
+	ctx := withDeliveryTime(context.Background(), published)
+
+	// The rule for running analyses doesn't say where the event's time is.
+	assert.NoError(rules.Handle(ctx, "running", analysisEvent("Running"), "events.analysis.update.running"))
+
+	if saved := databaseClient.SavedNotification; assert.NotNil(saved, "no notification was saved") {
+		assert.True(saved.TimeCreated.Equal(published), "the notification wasn't given the time of the delivery")
+	}
+}
+
+func TestEventsThatMatchNoRuleAreIgnored(t *testing.T) {
+	databaseClient := NewMockDatabaseClient(0)
+	rules, err := NewRuleHandler(New(databaseClient, testUserSuffix, nil, 0), []Rule{{
+		Name:        "completed",
+		RoutingKeys: []string{"events.analysis.update.completed"},
+		Match:       map[string]string{"status": "Completed"},
+		Type:        "analysis",
+		User:        "{{.Event.user}}",
+		Subject:     "{{.Event.name}}",
+	}})
+	assert.NoError(t, err)
+
+	ctx := context.Background()
+	assert.NoError(t, rules.Handle(ctx, "completed", analysisEvent("Failed"), "events.analysis.update.completed"))
+	assert.NoError(t, rules.Handle(ctx, "failed", analysisEvent("Completed"), "events.analysis.update.failed"))
+	assert.False(t, databaseClient.BeginCalled, "an event that matches no rule was recorded")
+}
+
+func TestEventsThatRulesCantHandleAreUnrecoverable(t *testing.T) {
+	tests := []struct {
+		name string
+		body []byte
+	}{
+		{name: "the event isn't JSON", body: []byte(`{"user":`)},
+		{name: "the event is missing a field", body: []byte(`{"user":"sarahr","status":"Running"}`)},
+		{
+			name: "the event has an invalid timestamp",
+			body: []byte(`{"user":"sarahr","name":"some job","status":"Completed","id":"1","timestamp":"yesterday"}`),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules := loadTestRules(t, NewMockDatabaseClient(0))
+
+			var unrecoverable UnrecoverableError
+			err := rules.Handle(context.Background(), "running", tt.body, "events.analysis.update.running")
+			assert.True(t, errors.As(err, &unrecoverable), "the error was not unrecoverable: %v", err)
+		})
+	}
+}
+
+func TestInvalidRulesAreRejected(t *testing.T) {
+	valid := Rule{
+		Name:        "valid",
+		RoutingKeys: []string{"events.analysis.update.*"},
+		Type:        "analysis",
+		User:        "{{.Event.user}}",
+		Subject:     "{{.Event.name}}",
+	}
+
+	tests := []struct {
+		name   string
+		mutate func(*Rule)
+	}{
+		{name: "no name", mutate: func(r *Rule) { r.Name = "" }},
+		{name: "no routing keys", mutate: func(r *Rule) { r.RoutingKeys = nil }},
+		{name: "short routing key", mutate: func(r *Rule) { r.RoutingKeys = []string{"events.analysis"} }},
+		{name: "wildcard category", mutate: func(r *Rule) { r.RoutingKeys = []string{"events.*.update.*"} }},
+		{name: "notification category", mutate: func(r *Rule) { r.RoutingKeys = []string{"events.notification.update.*"} }},
+		{name: "no subject", mutate: func(r *Rule) { r.Subject = "" }},
+		{name: "email without a template", mutate: func(r *Rule) { r.Email = true }},
+		{name: "bad template", mutate: func(r *Rule) { r.User = "{{.Event.user" }},
+		{name: "bad idempotency key template", mutate: func(r *Rule) { r.IdempotencyKey = "{{.Event.id" }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule := valid
+			tt.mutate(&rule)
+			_, err := NewRuleHandler(nil, []Rule{rule})
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestRuleCategories(t *testing.T) {
+	rules, err := NewRuleHandler(nil, []Rule{
+		{
+			Name:        "a",
+			RoutingKeys: []string{"events.data.update.*", "events.analysis.update.*"},
+			Type:        "t",
+			User:        "u",
+			Subject:     "s",
+		},
+		{Name: "b", RoutingKeys: []string{"events.analysis.update.#"}, Type: "t", User: "u", Subject: "s"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"analysis", "data"}, rules.Categories())
+}
+
+func TestMatchesTopic(t *testing.T) {
+	tests := []struct {
+		pattern    string
+		routingKey string
+		want       bool
+	}{
+		{"events.analysis.update.completed", "events.analysis.update.completed", true},
+		{"events.analysis.update.*", "events.analysis.update.completed", true},
+		{"events.analysis.update.*", "events.analysis.update", false},
+		{"events.analysis.#", "events.analysis.update.completed", true},
+		{"events.analysis.update.#", "events.analysis.update", true},
+		{"events.#.completed", "events.analysis.update.completed", true},
+		{"events.analysis.update.failed", "events.analysis.update.completed", false},
+	}
+
+	for _, tt := range tests {
+		got := matchesTopic(strings.Split(tt.pattern, "."), strings.Split(tt.routingKey, "."))
+		assert.Equal(t, tt.want, got, "%s matching %s", tt.pattern, tt.routingKey)
+	}
+}
@@ -0,0 +1,283 @@
+package recorder
+
+import (
+	"context"
+	"encoding/json"
+	"hash/fnv"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// DefaultWorkers is the number of workers that handle deliveries if no other number is configured.
+const DefaultWorkers = 16
+
+// DefaultPrefetch is the number of unacknowledged deliveries the broker hands the consumer if no
+// other number is configured.
+const DefaultPrefetch = 100
+
+// job is a delivery that's waiting to be handled by a worker.
+type job struct {
+	ctx      context.Context
+	delivery amqp.Delivery
+	done     chan struct{}
+}
+
+// channelSequence tracks the deliveries from a single AMQP channel, whose delivery tags count up
+// from one.
+type channelSequence struct {
+	next    uint64
+	pending map[uint64]*job
+}
+
+// closer is implemented by acknowledgers that can tell whether their channel has closed, such as
+// *amqp.Channel.
+type closer interface {
+	IsClosed() bool
+}
+
+// channelClosed returns true if the channel that a delivery came from is known to have closed. The
+// broker puts the channel's unacknowledged deliveries back on the queue when it closes, so they
+// can't be acknowledged any longer.
+func channelClosed(acknowledger amqp.Acknowledger) bool {
+	c, ok := acknowledger.(closer)
+	return ok && c.IsClosed()
+}
+
+// sequencer puts deliveries back in the order that the broker delivered them in. The messaging
+// library hands each delivery to the consumer in a goroutine of its own, so they can arrive in any
+// order. A new sequence starts with each channel, such as after a reconnection, and the sequence of
+// a channel that has closed is dropped.
+type sequencer struct {
+	mu          sync.Mutex
+	channels    map[amqp.Acknowledger]*channelSequence
+	ready       []*job
+	dispatching bool
+}
+
+// newSequencer returns a sequencer that hasn't seen any deliveries yet.
+func newSequencer() *sequencer {
+	return &sequencer{channels: make(map[amqp.Acknowledger]*channelSequence)}
+}
+
+// prune drops the sequences of the channels that have closed. Their deliveries that are still
+// waiting for the ones ahead of them are finished without being handled, because the ones ahead of
+// them will never arrive and the broker delivers them all again anyway. The sequencer has to be
+// locked.
+func (s *sequencer) prune() {
+	for acknowledger, seq := range s.channels {
+		if !channelClosed(acknowledger) {
+			continue
+		}
+		for _, j := range seq.pending {
+			close(j.done)
+		}
+		delete(s.channels, acknowledger)
+	}
+}
+
+// add adds a job and passes every job that's now in order to dispatch, oldest first. Dispatching
+// can block while a worker's queue is full, so it's done after the sequencer is unlocked. Only one
+// caller dispatches at a time, and it dispatches the jobs that other callers make ready while it's
+// busy as well, so that no job can overtake another.
+func (s *sequencer) add(j *job, dispatch func(*job)) {
+	s.mu.Lock()
+	s.prune()
+
+	seq, ok := s.channels[j.delivery.Acknowledger]
+	if !ok {
+		seq = &channelSequence{next: 1, pending: make(map[uint64]*job)}
+		s.channels[j.delivery.Acknowledger] = seq
+	}
+
+	// A delivery from before the start of the sequence can't be put in order, and waiting for its
+	// predecessors would hold it forever.
+	if j.delivery.DeliveryTag < seq.next {
+		s.ready = append(s.ready, j)
+	} else {
+		seq.pending[j.delivery.DeliveryTag] = j
+		for {
+			next, ok := seq.pending[seq.next]
+			if !ok {
+				break
+			}
+			delete(seq.pending, seq.next)
+			seq.next++
+			s.ready = append(s.ready, next)
+		}
+	}
+
+	if s.dispatching {
+		s.mu.Unlock()
+		return
+	}
+	s.dispatching = true
+	for len(s.ready) > 0 {
+		ready := s.ready
+		s.ready = nil
+		s.mu.Unlock()
+		for _, r := range ready {
+			dispatch(r)
+		}
+		s.mu.Lock()
+	}
+	s.dispatching = false
+	s.mu.Unlock()
+}
+
+// shardKey returns the key that a delivery is assigned to a worker by, which is the user or team
+// that the event is for. Deliveries that aren't for a single user or team all share a worker.
+func shardKey(body []byte) string {
+	var addressee struct {
+		User string `json:"user"`
+		Team string `json:"team"`
+	}
+	if err := json.Unmarshal(body, &addressee); err != nil {
+		return ""
+	}
+	if addressee.User != "" {
+		return addressee.User
+	}
+	return addressee.Team
+}
+
+// hold keeps a user's deliveries in order while one of them is waiting in a retry queue. The user's
+// later deliveries are parked in the same retry queue behind it, and they're only handled once the
+// ones ahead of them have been. The parked deliveries are identified by the tokens in their
+// HoldHeader, oldest first.
+type hold struct {
+	parked  []string
+	delay   time.Duration
+	expires time.Time
+}
+
+// holdBack parks a delivery behind the user's earlier deliveries if any of them are waiting to be
+// retried, and returns true if it did. The hold is released if the oldest delivery doesn't come
+// back in time, such as when another replica picked it up, so that a lost delivery doesn't hold up
+// the user's events for good. Deliveries that aren't for a user or team are never held back.
+func (c *Consumer) holdBack(ctx context.Context, key string, delivery amqp.Delivery) bool {
+	if key == "" {
+		return false
+	}
+	token, _ := delivery.Headers[HoldHeader].(string)
+
+	c.holdsMu.Lock()
+	h := c.holds[key]
+	if h != nil && (len(h.parked) == 0 || time.Now().After(h.expires)) {
+		delete(c.holds, key)
+		h = nil
+	}
+	if h == nil {
+		c.holdsMu.Unlock()
+		return false
+	}
+
+	// The oldest parked delivery is handled now.
+	if token != "" && token == h.parked[0] {
+		h.parked = h.parked[1:]
+		c.holdsMu.Unlock()
+		return false
+	}
+
+	// Deliveries that arrive while the user is held are parked behind the ones that are already
+	// parked, and parked deliveries that come back before the ones ahead of them keep their places.
+	if token == "" || !slices.Contains(h.parked, token) {
+		token = uuid.NewString()
+		h.parked = append(h.parked, token)
+	}
+	delay := h.delay
+	c.holdsMu.Unlock()
+
+	c.logDelivery("parked delivery", delivery)
+	if !c.park(ctx, delivery, delay, amqp.Table{HoldHeader: token}) {
+		c.unpark(key, token)
+	}
+	return true
+}
+
+// holdFor holds the user's deliveries behind one that's about to be parked for the given delay, and
+// returns the token that identifies it.
+func (c *Consumer) holdFor(key string, delay time.Duration) string {
+	token := uuid.NewString()
+	if key == "" {
+		return token
+	}
+
+	c.holdsMu.Lock()
+	defer c.holdsMu.Unlock()
+	h := c.holds[key]
+	if h == nil {
+		h = &hold{}
+		c.holds[key] = h
+	}
+	h.parked = append([]string{token}, h.parked...)
+	h.delay = delay
+	h.expires = time.Now().Add(delay + c.maxRequeueDelay)
+	return token
+}
+
+// unpark forgets a delivery that couldn't be parked, which goes back on the queue instead.
+func (c *Consumer) unpark(key, token string) {
+	c.holdsMu.Lock()
+	defer c.holdsMu.Unlock()
+	if h := c.holds[key]; h != nil {
+		h.parked = slices.DeleteFunc(h.parked, func(t string) bool { return t == token })
+	}
+}
+
+// releaseHold releases a user's hold once none of their deliveries are parked.
+func (c *Consumer) releaseHold(key string) {
+	c.holdsMu.Lock()
+	defer c.holdsMu.Unlock()
+	if h := c.holds[key]; h != nil && len(h.parked) == 0 {
+		delete(c.holds, key)
+	}
+}
+
+// shard returns the queue of the worker that handles a delivery.
+func (c *Consumer) shard(delivery amqp.Delivery) chan<- *job {
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(shardKey(delivery.Body)))
+	return c.shards[hash.Sum32()%uint32(len(c.shards))]
+}
+
+// startWorkers starts the workers that handle deliveries. Each worker has a queue that's large
+// enough to hold every delivery the broker will hand the consumer, so adding to it doesn't block
+// unless deliveries from a channel that has closed are still waiting in it.
+func (c *Consumer) startWorkers() {
+	c.shards = make([]chan *job, c.workers)
+	for i := range c.shards {
+		c.shards[i] = make(chan *job, c.prefetch)
+		go c.work(c.shards[i])
+	}
+}
+
+// work handles the deliveries in a worker's queue one at a time, in the order they were delivered.
+// Deliveries from channels that have closed since are skipped, because the broker delivers them
+// again.
+func (c *Consumer) work(jobs <-chan *job) {
+	for j := range jobs {
+		if !channelClosed(j.delivery.Acknowledger) {
+			c.handleMessage(j.ctx, j.delivery)
+		}
+		close(j.done)
+	}
+}
+
+// consume passes a delivery to the worker for its user, in the order the broker delivered it, and
+// waits for the delivery to be handled so that the span the messaging library traces it with
+// covers the handling. The delivery counts as in flight while it's waiting for the worker.
+func (c *Consumer) consume(ctx context.Context, delivery amqp.Delivery) {
+	c.inFlight.Add(1)
+	defer c.inFlight.Add(-1)
+
+	j := &job{ctx: ctx, delivery: delivery, done: make(chan struct{})}
+	c.sequencer.add(j, func(j *job) {
+		c.shard(j.delivery) <- j
+	})
+	<-j.done
+}
@@ -0,0 +1,349 @@
+package recorder
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSequencerRestoresDeliveryOrder(t *testing.T) {
+	assert := assert.New(t)
+
+	first, second := &fakeAcknowledger{}, &fakeAcknowledger{}
+	s := newSequencer()
+	var dispatched []uint64
+	add := func(acknowledger amqp.Acknowledger, tag uint64) {
+		s.add(&job{delivery: amqp.Delivery{Acknowledger: acknowledger, DeliveryTag: tag}}, func(j *job) {
+			dispatched = append(dispatched, j.delivery.DeliveryTag)
+		})
+	}
+
+	add(first, 3)
+	add(first, 2)
+	assert.Empty(dispatched, "deliveries were dispatched before the ones ahead of them")
+
+	// Each channel has a sequence of its own.
+	add(second, 1)
+	assert.Equal([]uint64{1}, dispatched)
+
+	add(first, 1)
+	assert.Equal([]uint64{1, 1, 2, 3}, dispatched)
+}
+
+func TestSequencerDropsTheSequencesOfClosedChannels(t *testing.T) {
+	assert := assert.New(t)
+
+	first, second := &fakeAcknowledger{}, &fakeAcknowledger{}
+	s := newSequencer()
+	var dispatched []uint64
+	dispatch := func(j *job) { dispatched = append(dispatched, j.delivery.DeliveryTag) }
+
+	// The second delivery from the first channel waits for the first one, which never arrives.
+	waiting := &job{delivery: amqp.Delivery{Acknowledger: first, DeliveryTag: 2}, done: make(chan struct{})}
+	s.add(waiting, dispatch)
+	first.closed = true
+
+	s.add(&job{delivery: amqp.Delivery{Acknowledger: second, DeliveryTag: 1}}, dispatch)
+
+	assert.Equal([]uint64{1}, dispatched)
+	assert.NotContains(s.channels, amqp.Acknowledger(first), "the closed channel's sequence was kept")
+	select {
+	case <-waiting.done:
+	default:
+		t.Fatal("the closed channel's waiting delivery was never finished")
+	}
+}
+
+func TestSequencerDispatchesWithoutHoldingTheLock(t *testing.T) {
+	first, second := &fakeAcknowledger{}, &fakeAcknowledger{}
+	s := newSequencer()
+
+	// Dispatching the first job blocks, as it would while a worker's queue is full.
+	var mu sync.Mutex
+	var dispatched []amqp.Acknowledger
+	blocked, release := make(chan struct{}), make(chan struct{})
+	dispatch := func(j *job) {
+		if j.delivery.Acknowledger == first {
+			close(blocked)
+			<-release
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		dispatched = append(dispatched, j.delivery.Acknowledger)
+	}
+
+	go s.add(&job{delivery: amqp.Delivery{Acknowledger: first, DeliveryTag: 1}}, dispatch)
+	<-blocked
+
+	added := make(chan struct{})
+	go func() {
+		s.add(&job{delivery: amqp.Delivery{Acknowledger: second, DeliveryTag: 1}}, dispatch)
+		close(added)
+	}()
+	select {
+	case <-added:
+	case <-time.After(time.Second):
+		t.Fatal("adding a job waited for another job to be dispatched")
+	}
+
+	close(release)
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(dispatched) == 2
+	}, time.Second, 10*time.Millisecond, "the second job was never dispatched")
+	assert.Equal(t, []amqp.Acknowledger{first, second}, dispatched, "the jobs were dispatched out of order")
+}
+
+func TestShardKey(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{name: "user", body: `{"user":"sarahr","team":"staff"}`, want: "sarahr"},
+		{name: "team", body: `{"team":"staff","recipients":["sarahr"]}`, want: "staff"},
+		{name: "recipients", body: `{"recipients":["sarahr"]}`, want: ""},
+		{name: "not JSON", body: `{"user":`, want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, shardKey([]byte(tt.body)))
+		})
+	}
+}
+
+func TestEachUsersDeliveriesAreHandledInOrder(t *testing.T) {
+	assert := assert.New(t)
+
+	const users = 5
+	const deliveriesPerUser = 20
+
+	// Record the order that each user's events are handled in.
+	var mu sync.Mutex
+	handled := make(map[string][]int)
+	handlers := NewHandlers()
+	handlers.Register(NotificationCategory, HandlerFunc(func(_ context.Context, _ string, body []byte, _ string) error {
+		var event struct {
+			User     string `json:"user"`
+			Sequence int    `json:"sequence"`
+		}
+		if err := json.Unmarshal(body, &event); err != nil {
+			return NewUnrecoverableError("%s", err.Error())
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		handled[event.User] = append(handled[event.User], event.Sequence)
+		return nil
+	}))
+
+	consumer := NewConsumer(nil, &fakeMessagingClient{}, &fakeRepublisher{}, nil, "", nil, handlers, 0, 3, 0)
+	consumer.startWorkers()
+
+	// The messaging library hands each delivery over in a goroutine of its own, so they arrive in
+	// no particular order.
+	acknowledger := &fakeAcknowledger{}
+	var wg sync.WaitGroup
+	for i := 0; i < users*deliveriesPerUser; i++ {
+		body := fmt.Sprintf(`{"user":"user%d","sequence":%d}`, i%users, i/users)
+		delivery := amqp.Delivery{
+			Acknowledger: acknowledger,
+			DeliveryTag:  uint64(i + 1),
+			RoutingKey:   FakeRoutingKey,
+			Body:         []byte(body),
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			consumer.consume(context.Background(), delivery)
+		}()
+	}
+	wg.Wait()
+	consumer.Drain(time.Second)
+
+	assert.Len(handled, users)
+	for user, sequences := range handled {
+		if assert.Len(sequences, deliveriesPerUser, "not all of %s's deliveries were handled", user) {
+			for i, sequence := range sequences {
+				assert.Equal(i, sequence, "%s's deliveries were handled out of order", user)
+			}
+		}
+	}
+	assert.Zero(consumer.inFlight.Load(), "deliveries are still counted as in flight")
+}
+
+// TestRetriesKeepEachUsersDeliveriesInOrder verifies that a user's later deliveries are parked
+// behind an earlier one that's waiting to be retried, and that other users' deliveries aren't.
+func TestRetriesKeepEachUsersDeliveriesInOrder(t *testing.T) {
+	tests := []struct {
+		name     string
+		failures int
+	}{
+		{name: "one failure", failures: 1},
+		{name: "the later delivery comes back before the retry", failures: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			// The first event fails the given number of times.
+			var mu sync.Mutex
+			var handled []string
+			failures := 0
+			handlers := NewHandlers()
+			handlers.Register(NotificationCategory, HandlerFunc(func(_ context.Context, _ string, body []byte, _ string) error {
+				var event struct {
+					Subject string `json:"subject"`
+				}
+				if err := json.Unmarshal(body, &event); err != nil {
+					return NewUnrecoverableError("%s", err.Error())
+				}
+				mu.Lock()
+				defer mu.Unlock()
+				if event.Subject == "first" && failures < tt.failures {
+					failures++
+					return NewRecoverableError("the database is unreachable")
+				}
+				handled = append(handled, event.Subject)
+				return nil
+			}))
+
+			republisher := &fakeRepublisher{}
+			consumer := NewConsumer(nil, &fakeMessagingClient{}, republisher, nil, "", nil, handlers, 3, 2, 0)
+			consumer.minRequeueDelay = time.Millisecond
+			consumer.maxRequeueDelay = 2 * time.Millisecond
+			consumer.startWorkers()
+
+			acknowledger := &fakeAcknowledger{}
+			tag := uint64(0)
+			deliver := func(delivery amqp.Delivery) {
+				tag++
+				delivery.Acknowledger = acknowledger
+				delivery.DeliveryTag = tag
+				consumer.consume(context.Background(), delivery)
+			}
+
+			for _, event := range []string{`{"user":"sarahr","subject":"first"}`,
+				`{"user":"sarahr","subject":"second"}`, `{"user":"ipcdev","subject":"other"}`} {
+				deliver(amqp.Delivery{RoutingKey: FakeRoutingKey, Body: []byte(event)})
+			}
+			assert.Equal([]string{"other"}, handled, "a held user's delivery was handled")
+
+			// The retry queues put the parked deliveries back on the queue in the order they were
+			// parked in.
+			for i := 0; ; i++ {
+				republisher.mu.Lock()
+				if i == len(republisher.published) {
+					republisher.mu.Unlock()
+					break
+				}
+				parked := republisher.published[i]
+				republisher.mu.Unlock()
+
+				assert.True(strings.HasPrefix(parked.routingKey, RetryQueuePrefix), "a delivery wasn't parked")
+				deliver(amqp.Delivery{RoutingKey: QueueName, Headers: parked.msg.Headers, Body: parked.msg.Body})
+			}
+
+			assert.Equal([]string{"other", "first", "second"}, handled, "the user's events were recorded out of order")
+			assert.Empty(consumer.holds, "the user is still held")
+		})
+	}
+}
+
+// TestRetriesDontHoldBackDeliveriesWithoutAnAddressee verifies that deliveries that aren't for a user
+// or a team aren't parked behind each other.
+func TestRetriesDontHoldBackDeliveriesWithoutAnAddressee(t *testing.T) {
+	assert := assert.New(t)
+
+	var handled []string
+	handlers := NewHandlers()
+	handlers.Register(NotificationCategory, HandlerFunc(func(_ context.Context, _ string, body []byte, _ string) error {
+		if string(body) == `{"subject":"first"}` {
+			return NewRecoverableError("the database is unreachable")
+		}
+		handled = append(handled, string(body))
+		return nil
+	}))
+
+	republisher := &fakeRepublisher{}
+	consumer := NewConsumer(nil, &fakeMessagingClient{}, republisher, nil, "", nil, handlers, 3, 1, 0)
+	consumer.minRequeueDelay = time.Millisecond
+
+	for _, body := range []string{`{"subject":"first"}`, `{"subject":"second"}`} {
+		consumer.handleMessage(context.Background(), amqp.Delivery{
+			Acknowledger: &fakeAcknowledger{},
+			RoutingKey:   FakeRoutingKey,
+			Body:         []byte(body),
+		})
+	}
+
+	assert.Equal([]string{`{"subject":"second"}`}, handled, "a delivery without an addressee was held back")
+	assert.Len(republisher.published, 1)
+	assert.Empty(consumer.holds)
+}
+
+// TestDrainWaitsForQueuedDeliveries verifies that a delivery that's waiting for a busy worker
+// counts as in flight.
+func TestDrainWaitsForQueuedDeliveries(t *testing.T) {
+	started, release := make(chan struct{}, 2), make(chan struct{})
+	handlers := NewHandlers()
+	handlers.Register(NotificationCategory, HandlerFunc(func(context.Context, string, []byte, string) error {
+		started <- struct{}{}
+		<-release
+		return nil
+	}))
+
+	consumer := NewConsumer(nil, &fakeMessagingClient{}, &fakeRepublisher{}, nil, "", nil, handlers, 0, 1, 0)
+	consumer.startWorkers()
+
+	acknowledger := &fakeAcknowledger{}
+	for tag := uint64(1); tag <= 2; tag++ {
+		delivery := amqp.Delivery{
+			Acknowledger: acknowledger,
+			DeliveryTag:  tag,
+			RoutingKey:   FakeRoutingKey,
+			Body:         []byte(`{"user":"sarahr"}`),
+		}
+		go consumer.consume(context.Background(), delivery)
+	}
+
+	assert.Eventually(t, func() bool { return consumer.inFlight.Load() >= 2 }, time.Second, 10*time.Millisecond,
+		"the deliveries were not counted as in flight")
+
+	// Deliveries that no worker has started on go back on the queue once the consumer is draining, so
+	// wait until the first one is being handled.
+	select {
+	case <-started:
+	case <-time.After(time.Second):
+		t.Fatal("the first delivery was never handled")
+	}
+
+	done := make(chan struct{})
+	go func() {
+		consumer.Drain(5 * time.Second)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("Drain returned while deliveries were still waiting for a worker")
+	case <-time.After(300 * time.Millisecond):
+	}
+
+	close(release)
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Drain did not return after the deliveries were handled")
+	}
+}
@@ -0,0 +1,333 @@
+// Package retention permanently removes old notifications that users have already dealt with. Notifications are only
+// ever marked as deleted by the API, so without this the notifications table would grow forever.
+package retention
+
+import (
+	"context"
+	"database/sql"
+	"expvar"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/cyverse-de/notifications/db"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// log derives from the standard logrus logger, so the formatting and level that main sets up
+// apply here too.
+var log = logrus.WithFields(logrus.Fields{"package": "retention"})
+
+const (
+	// checkInterval is how often the purge runs.
+	checkInterval = time.Hour
+
+	// defaultBatchSize is the number of notifications deleted in a single transaction if the configuration doesn't
+	// say otherwise. Small batches keep each transaction's locks short-lived.
+	defaultBatchSize = 1000
+
+	// batchPause is how long the purge waits between batches, so that a large backlog doesn't monopolize the
+	// database.
+	batchPause = 100 * time.Millisecond
+
+	// sentOutboxRetention is how long outbox messages are kept after they've been published.
+	sentOutboxRetention = 24 * time.Hour
+)
+
+// MetricPrefix is the prefix of the names of the metrics exported by the purge.
+const MetricPrefix = "retention_"
+
+// The metrics exported by the purge, which are published at /debug/vars.
+var (
+	purgedDeleted    = expvar.NewInt("retention_purged_deleted_notifications")
+	purgedSeen       = expvar.NewInt("retention_purged_seen_notifications")
+	purgedBroadcasts = expvar.NewInt("retention_purged_broadcasts")
+	purgeRuns        = expvar.NewInt("retention_purge_runs")
+	purgeFailures    = expvar.NewInt("retention_purge_failures")
+	lastPurge        = expvar.NewString("retention_last_purge_completed")
+)
+
+// Rule says how long notifications are kept once they've been dealt with. Ages are measured from the time that each
+// notification was first marked as deleted or as seen, or from the time that it was created if it was marked before
+// those times were recorded. A zero number of days means that the notifications are kept forever.
+type Rule struct {
+
+	// The number of days that notifications that have been marked as deleted are kept.
+	DeletedDays int
+
+	// The number of days that notifications that have been marked as seen are kept.
+	SeenDays int
+}
+
+// Policy is the retention policy: a default rule along with overrides for individual notification types.
+type Policy struct {
+	Default Rule
+	Types   map[string]Rule
+
+	// The number of days that broadcasts are kept after they're created. A broadcast is shared by all of its
+	// recipients, so it can't be aged by the time that any one of them dealt with it. Zero means that broadcasts are
+	// kept forever.
+	BroadcastDays int
+}
+
+// ruleFromConfig reads a single rule from the configuration. Settings that are absent fall back to the given rule.
+func ruleFromConfig(cfg *viper.Viper, prefix string, fallback Rule) (Rule, error) {
+	rule := fallback
+	if cfg.IsSet(prefix + "deleted_days") {
+		rule.DeletedDays = cfg.GetInt(prefix + "deleted_days")
+	}
+	if cfg.IsSet(prefix + "seen_days") {
+		rule.SeenDays = cfg.GetInt(prefix + "seen_days")
+	}
+	if rule.DeletedDays < 0 || rule.SeenDays < 0 {
+		return rule, fmt.Errorf("%sdeleted_days and %sseen_days may not be negative", prefix, prefix)
+	}
+	return rule, nil
+}
+
+// PolicyFromConfig reads the retention policy from the notifications.retention section of the configuration. Nothing
+// is purged unless the section says otherwise. Notification types that don't have a setting of their own use the
+// default setting.
+func PolicyFromConfig(cfg *viper.Viper) (*Policy, error) {
+	const prefix = "notifications.retention."
+
+	// Read the default rule.
+	defaultRule, err := ruleFromConfig(cfg, prefix, Rule{})
+	if err != nil {
+		return nil, err
+	}
+
+	// Read the overrides.
+	types := make(map[string]Rule)
+	for notificationType := range cfg.GetStringMap(prefix + "types") {
+		types[notificationType], err = ruleFromConfig(cfg, prefix+"types."+notificationType+".", defaultRule)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Read the broadcast retention period.
+	broadcastDays := cfg.GetInt(prefix + "broadcast_days")
+	if broadcastDays < 0 {
+		return nil, fmt.Errorf("%sbroadcast_days may not be negative", prefix)
+	}
+
+	return &Policy{Default: defaultRule, Types: types, BroadcastDays: broadcastDays}, nil
+}
+
+// Purger periodically hard-deletes the notifications that the retention policy says are no longer needed.
+type Purger struct {
+	db        *sql.DB
+	policy    *Policy
+	batchSize uint64
+	now       func() time.Time
+}
+
+// NewPurger returns a purger that applies the given policy. The default batch size is used if batchSize is zero.
+func NewPurger(db *sql.DB, policy *Policy, batchSize uint64) *Purger {
+	if batchSize == 0 {
+		batchSize = defaultBatchSize
+	}
+	return &Purger{
+		db:        db,
+		policy:    policy,
+		batchSize: batchSize,
+		now:       time.Now,
+	}
+}
+
+// Run purges notifications until the context is canceled.
+func (p *Purger) Run(ctx context.Context) {
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+
+	for {
+		p.PurgeOnce(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// purge describes one of the purges that the policy calls for. The batch function purges a single batch of records.
+type purge struct {
+	description string
+	batch       func(context.Context, *sql.Tx) (int64, error)
+	counter     *expvar.Int
+}
+
+// notificationBatch returns a function that purges a single batch of the notifications that the given parameters
+// describe.
+func notificationBatch(params *db.PurgeNotificationsParameters) func(context.Context, *sql.Tx) (int64, error) {
+	return func(ctx context.Context, tx *sql.Tx) (int64, error) {
+		return db.PurgeNotifications(ctx, tx, params)
+	}
+}
+
+// purges lists the purges that the policy calls for at the given time.
+func (p *Purger) purges(now time.Time) []purge {
+	var purges []purge
+
+	// add adds the purges for a single rule.
+	add := func(description string, rule Rule, types, excludedTypes []string) {
+		if rule.DeletedDays > 0 {
+			purges = append(purges, purge{
+				description: fmt.Sprintf("%snotifications deleted more than %d days ago", description, rule.DeletedDays),
+				batch: notificationBatch(&db.PurgeNotificationsParameters{
+					Deleted:       true,
+					Before:        now.AddDate(0, 0, -rule.DeletedDays),
+					Types:         types,
+					ExcludedTypes: excludedTypes,
+					Limit:         p.batchSize,
+				}),
+				counter: purgedDeleted,
+			})
+		}
+		if rule.SeenDays > 0 {
+			purges = append(purges, purge{
+				description: fmt.Sprintf("%snotifications seen more than %d days ago", description, rule.SeenDays),
+				batch: notificationBatch(&db.PurgeNotificationsParameters{
+					Deleted:       false,
+					Before:        now.AddDate(0, 0, -rule.SeenDays),
+					Types:         types,
+					ExcludedTypes: excludedTypes,
+					Limit:         p.batchSize,
+				}),
+				counter: purgedSeen,
+			})
+		}
+	}
+
+	// The overrides are sorted so that the purges always run in the same order.
+	overriddenTypes := make([]string, 0, len(p.policy.Types))
+	for notificationType := range p.policy.Types {
+		overriddenTypes = append(overriddenTypes, notificationType)
+	}
+	sort.Strings(overriddenTypes)
+
+	add("", p.policy.Default, nil, overriddenTypes)
+	for _, notificationType := range overriddenTypes {
+		add(notificationType+" ", p.policy.Types[notificationType], []string{notificationType}, nil)
+	}
+
+	if p.policy.BroadcastDays > 0 {
+		before := now.AddDate(0, 0, -p.policy.BroadcastDays)
+		purges = append(purges, purge{
+			description: fmt.Sprintf("broadcasts created more than %d days ago", p.policy.BroadcastDays),
+			batch: func(ctx context.Context, tx *sql.Tx) (int64, error) {
+				return db.PurgeBroadcasts(ctx, tx, before, p.batchSize)
+			},
+			counter: purgedBroadcasts,
+		})
+	}
+
+	return purges
+}
+
+// PurgeOnce runs every purge that the policy calls for, one batch at a time, until there's nothing left to purge.
+// Failures are logged, and the remaining notifications are purged the next time around.
+func (p *Purger) PurgeOnce(ctx context.Context) {
+	purgeRuns.Add(1)
+	now := p.now()
+
+	// Expired idempotency keys are no longer needed to detect duplicate notification requests, and
+	// sent outbox messages are only kept for a while in case a delivery problem has to be looked into.
+	p.purgeRecords(ctx, "expired idempotency keys", func(ctx context.Context, tx *sql.Tx) (int64, error) {
+		return db.PurgeExpiredIdempotencyKeys(ctx, tx, now)
+	})
+	p.purgeRecords(ctx, "sent outbox messages", func(ctx context.Context, tx *sql.Tx) (int64, error) {
+		return db.PurgeSentOutboxMessages(ctx, tx, now.Add(-sentOutboxRetention))
+	})
+
+	for _, job := range p.purges(now) {
+		var total int64
+		for ctx.Err() == nil {
+			count, err := p.purgeBatch(ctx, job.batch)
+			if err != nil {
+				purgeFailures.Add(1)
+				log.Errorf("unable to purge %s: %s", job.description, err)
+				break
+			}
+			total += count
+			job.counter.Add(count)
+
+			// A short batch means that there's nothing left to purge, at least nothing that isn't locked.
+			if count < int64(p.batchSize) {
+				break
+			}
+			log.Debugf("purged %d %s so far", total, job.description)
+
+			select {
+			case <-ctx.Done():
+			case <-time.After(batchPause):
+			}
+		}
+
+		if total > 0 {
+			log.Infof("purged %d %s", total, job.description)
+		}
+		if ctx.Err() != nil {
+			return
+		}
+	}
+
+	lastPurge.Set(now.UTC().Format(time.RFC3339))
+}
+
+// purgeRecords runs a single purge of records that the service no longer needs in its own transaction. Failures are
+// logged, and the records are purged the next time around.
+func (p *Purger) purgeRecords(
+	ctx context.Context,
+	description string,
+	purge func(context.Context, *sql.Tx) (int64, error),
+) {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		log.Errorf("unable to purge %s: %s", description, err)
+		return
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	count, err := purge(ctx, tx)
+	if err != nil {
+		log.Errorf("unable to purge %s: %s", description, err)
+		return
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Errorf("unable to purge %s: %s", description, err)
+		return
+	}
+
+	if count > 0 {
+		log.Infof("purged %d %s", count, description)
+	}
+}
+
+// purgeBatch purges a single batch of records in its own transaction.
+func (p *Purger) purgeBatch(ctx context.Context, batch func(context.Context, *sql.Tx) (int64, error)) (int64, error) {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	count, err := batch(ctx, tx)
+	if err != nil {
+		return 0, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
@@ -0,0 +1,185 @@
+package retention
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func loadConfig(t *testing.T, yaml string) *viper.Viper {
+	t.Helper()
+	cfg := viper.New()
+	cfg.SetConfigType("yaml")
+	if err := cfg.ReadConfig(strings.NewReader(yaml)); err != nil {
+		t.Fatalf("unable to read the configuration: %s", err)
+	}
+	return cfg
+}
+
+func TestPolicyFromConfig(t *testing.T) {
+	cfg := loadConfig(t, `
+notifications:
+  retention:
+    deleted_days: 30
+    seen_days: 365
+    broadcast_days: 90
+    types:
+      analysis_periodic_notification:
+        seen_days: 7
+`)
+
+	policy, err := PolicyFromConfig(cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, &Policy{
+		Default:       Rule{DeletedDays: 30, SeenDays: 365},
+		BroadcastDays: 90,
+		Types: map[string]Rule{
+			// Settings that an override leaves out are inherited from the default.
+			"analysis_periodic_notification": {DeletedDays: 30, SeenDays: 7},
+		},
+	}, policy)
+}
+
+func TestPolicyFromConfigKeepsEverythingByDefault(t *testing.T) {
+	policy, err := PolicyFromConfig(viper.New())
+	assert.NoError(t, err)
+	assert.Empty(t, NewPurger(nil, policy, 0).purges(time.Now()), "nothing may be purged unless the policy says so")
+}
+
+func TestPolicyFromConfigRejectsNegativeSettings(t *testing.T) {
+	_, err := PolicyFromConfig(loadConfig(t, "notifications: {retention: {seen_days: -1}}"))
+	assert.Error(t, err)
+}
+
+func TestPurgeOnce(t *testing.T) {
+	assert := assert.New(t)
+
+	database, mock, err := sqlmock.New()
+	assert.NoError(err, "unable to open the mock database connection")
+	defer func() { _ = database.Close() }()
+
+	now := time.Date(2026, time.October, 14, 3, 0, 0, 0, time.UTC)
+	policy := &Policy{
+		Default: Rule{DeletedDays: 30},
+		Types:   map[string]Rule{"analysis_periodic_notification": {DeletedDays: 7}},
+	}
+
+	// Expired idempotency keys are purged first.
+	mock.ExpectBegin()
+	mock.ExpectExec(`DELETE FROM idempotency_keys WHERE expires_at <= \$1`).
+		WithArgs(now).
+		WillReturnResult(sqlmock.NewResult(0, 5))
+	mock.ExpectCommit()
+
+	// So are the outbox messages that were sent more than a day ago.
+	mock.ExpectBegin()
+	mock.ExpectExec(`DELETE FROM outbox WHERE sent_at < \$1`).
+		WithArgs(now.Add(-24 * time.Hour)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	// The default rule leaves the overridden type alone, and purging continues in batches until a short batch.
+	defaultPurge := `DELETE FROM notifications WHERE id IN \( SELECT n.id FROM notifications n ` +
+		`JOIN notification_types nt ON n.notification_type_id = nt.id ` +
+		`WHERE COALESCE\(n.deleted_at, n.time_created\) < \$1 AND n.deleted = \$2 AND nt.name NOT IN \(\$3\) ` +
+		`LIMIT 2 FOR UPDATE OF n SKIP LOCKED \)`
+	for _, count := range []int64{2, 1} {
+		mock.ExpectBegin()
+		mock.ExpectExec(defaultPurge).
+			WithArgs(now.AddDate(0, 0, -30), true, "analysis_periodic_notification").
+			WillReturnResult(sqlmock.NewResult(0, count))
+		mock.ExpectCommit()
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`WHERE COALESCE\(n.deleted_at, n.time_created\) < \$1 AND n.deleted = \$2 AND nt.name IN \(\$3\)`).
+		WithArgs(now.AddDate(0, 0, -7), true, "analysis_periodic_notification").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	purger := NewPurger(database, policy, 2)
+	purger.now = func() time.Time { return now }
+
+	before := purgedDeleted.Value()
+	purger.PurgeOnce(context.Background())
+
+	assert.NoError(mock.ExpectationsWereMet(), "not all mock expectations were met")
+	assert.Equal(int64(3), purgedDeleted.Value()-before)
+}
+
+// TestSeenNotificationsAreAgedFromWhenTheyWereSeen verifies that a notification that was created long ago but seen
+// recently isn't purged.
+func TestSeenNotificationsAreAgedFromWhenTheyWereSeen(t *testing.T) {
+	assert := assert.New(t)
+
+	database, mock, err := sqlmock.New()
+	assert.NoError(err, "unable to open the mock database connection")
+	defer func() { _ = database.Close() }()
+
+	now := time.Date(2026, time.October, 14, 3, 0, 0, 0, time.UTC)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`DELETE FROM idempotency_keys`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectExec(`DELETE FROM outbox`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	seenPurge := `DELETE FROM notifications WHERE id IN \( SELECT n.id FROM notifications n ` +
+		`WHERE COALESCE\(n.seen_at, n.time_created\) < \$1 AND n.seen = \$2 ` +
+		`LIMIT 1000 FOR UPDATE OF n SKIP LOCKED \)`
+	mock.ExpectBegin()
+	mock.ExpectExec(seenPurge).
+		WithArgs(now.AddDate(0, 0, -365), true).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	purger := NewPurger(database, &Policy{Default: Rule{SeenDays: 365}}, 0)
+	purger.now = func() time.Time { return now }
+	purger.PurgeOnce(context.Background())
+
+	assert.NoError(mock.ExpectationsWereMet(), "not all mock expectations were met")
+}
+
+// TestPurgeOnceDeletesOldBroadcasts verifies that broadcasts are purged by age once a broadcast retention period is
+// configured.
+func TestPurgeOnceDeletesOldBroadcasts(t *testing.T) {
+	assert := assert.New(t)
+
+	database, mock, err := sqlmock.New()
+	assert.NoError(err, "unable to open the mock database connection")
+	defer func() { _ = database.Close() }()
+
+	now := time.Date(2026, time.October, 14, 3, 0, 0, 0, time.UTC)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`DELETE FROM idempotency_keys`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectExec(`DELETE FROM outbox`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	broadcastPurge := `DELETE FROM broadcasts WHERE id IN \( SELECT b.id FROM broadcasts b ` +
+		`WHERE b.time_created < \$1 LIMIT 2 FOR UPDATE OF b SKIP LOCKED \)`
+	for _, count := range []int64{2, 0} {
+		mock.ExpectBegin()
+		mock.ExpectExec(broadcastPurge).
+			WithArgs(now.AddDate(0, 0, -90)).
+			WillReturnResult(sqlmock.NewResult(0, count))
+		mock.ExpectCommit()
+	}
+
+	purger := NewPurger(database, &Policy{BroadcastDays: 90}, 2)
+	purger.now = func() time.Time { return now }
+
+	before := purgedBroadcasts.Value()
+	purger.PurgeOnce(context.Background())
+
+	assert.NoError(mock.ExpectationsWereMet(), "not all mock expectations were met")
+	assert.Equal(int64(2), purgedBroadcasts.Value()-before)
+}
@@ -0,0 +1,91 @@
+package stream
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/cyverse-de/notifications/model"
+)
+
+// EventNotification is the name of the event sent for each newly recorded notification.
+const EventNotification = "notification"
+
+// EventUnseenCount is the name of the event sent when a user's unseen notification count changes without a new
+// notification having been recorded.
+const EventUnseenCount = model.UnseenCountUpdateType
+
+// EventReload is the name of the event sent to a resuming client that missed too many notifications to be caught up
+// one at a time. The client should reload the user's notifications instead.
+const EventReload = "reload"
+
+// Event is a single server-sent event.
+type Event struct {
+
+	// The event ID. Notification events use the notification ID, so that a client that reconnects
+	// can name the last notification it received in the Last-Event-ID header.
+	ID string
+
+	// The event name.
+	Name string
+
+	// The event data, which is sent as JSON.
+	Data any
+}
+
+// WriteTo writes the event to w in the text/event-stream format. The data is marshaled without
+// indentation, so it always fits on a single data line.
+func (e Event) WriteTo(w io.Writer) (int64, error) {
+	data, err := json.Marshal(e.Data)
+	if err != nil {
+		return 0, fmt.Errorf("unable to marshal the %s event: %w", e.Name, err)
+	}
+
+	var buf bytes.Buffer
+	if e.ID != "" {
+		fmt.Fprintf(&buf, "id: %s\n", e.ID)
+	}
+	if e.Name != "" {
+		fmt.Fprintf(&buf, "event: %s\n", e.Name)
+	}
+	fmt.Fprintf(&buf, "data: %s\n\n", data)
+
+	return buf.WriteTo(w)
+}
+
+// NewNotificationEvent returns the event for a notification. The data has the same shape as the message that the DE
+// UI receives over AMQP: the notification along with the recipient's unseen total.
+func NewNotificationEvent(total int64, notification *model.Notification) Event {
+	id, _ := notification.Message["id"].(string)
+	return Event{
+		ID:   id,
+		Name: EventNotification,
+		Data: &model.WrappedNotification{Total: total, Message: notification},
+	}
+}
+
+// NewUnseenCountEvent returns the event for an unseen count update. It has no ID, because there's nothing to catch up
+// on: the next event a resuming client receives carries the current total anyway.
+func NewUnseenCountEvent(total int64) Event {
+	return Event{
+		Name: EventUnseenCount,
+		Data: model.NewUnseenCountUpdate(total),
+	}
+}
+
+// reload is the data of a reload event.
+type reload struct {
+
+	// The number of notifications that the user hasn't marked as seen yet.
+	Total int64 `json:"total"`
+}
+
+// NewReloadEvent returns the event that tells a resuming client to reload the user's notifications. It has no ID, so
+// the client's last event ID stays the same until the next notification arrives.
+func NewReloadEvent(total int64) Event {
+	return Event{
+		Name: EventReload,
+		Data: &reload{Total: total},
+	}
+}
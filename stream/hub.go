@@ -0,0 +1,142 @@
+// Package stream fans recorded notifications out to the clients that hold a message stream open,
+// so that a client doesn't have to be wired to the AMQP broker to learn about new notifications.
+package stream
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// log derives from the standard logrus logger, so the formatting and level that main sets up
+// apply here too.
+var log = logrus.WithFields(logrus.Fields{"package": "stream"})
+
+// subscriptionBufferSize is the number of events a subscription can fall behind by before it's
+// dropped. A dropped client reconnects with the ID of the last event it saw and is caught up from
+// the database, so dropping it is safer than letting it block delivery to everyone else.
+const subscriptionBufferSize = 64
+
+// Hub delivers events to the subscriptions of the users they're directed to. Users are identified
+// by their qualified usernames.
+type Hub struct {
+	mu            sync.Mutex
+	subscriptions map[string]map[*Subscription]struct{}
+	closed        bool
+}
+
+// NewHub returns a new, empty hub.
+func NewHub() *Hub {
+	return &Hub{subscriptions: make(map[string]map[*Subscription]struct{})}
+}
+
+// Subscription receives the events directed to a single user.
+type Subscription struct {
+	hub    *Hub
+	user   string
+	events chan Event
+	done   bool
+}
+
+// Events returns the channel that events for the subscription arrive on. The channel is closed
+// when the subscription is closed, either by the subscriber or because it fell too far behind.
+func (s *Subscription) Events() <-chan Event {
+	return s.events
+}
+
+// Close ends the subscription. It's safe to call more than once.
+func (s *Subscription) Close() {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+	s.hub.remove(s)
+}
+
+// Subscribe registers a new subscription for a user. If the hub has already been closed, the
+// returned subscription is closed as well.
+func (h *Hub) Subscribe(user string) *Subscription {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	sub := &Subscription{
+		hub:    h,
+		user:   user,
+		events: make(chan Event, subscriptionBufferSize),
+	}
+	if h.closed {
+		sub.done = true
+		close(sub.events)
+		return sub
+	}
+
+	if h.subscriptions[user] == nil {
+		h.subscriptions[user] = make(map[*Subscription]struct{})
+	}
+	h.subscriptions[user][sub] = struct{}{}
+
+	return sub
+}
+
+// remove closes a subscription and forgets about it. The caller must hold the lock.
+func (h *Hub) remove(sub *Subscription) {
+	if sub.done {
+		return
+	}
+	sub.done = true
+	close(sub.events)
+
+	delete(h.subscriptions[sub.user], sub)
+	if len(h.subscriptions[sub.user]) == 0 {
+		delete(h.subscriptions, sub.user)
+	}
+}
+
+// Publish delivers an event to every subscription for a user without blocking. A subscription
+// whose buffer is full is closed rather than waited on.
+func (h *Hub) Publish(user string, event Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for sub := range h.subscriptions[user] {
+		select {
+		case sub.events <- event:
+		default:
+			log.Warnf("dropping a notification stream for %s because it fell too far behind", user)
+			h.remove(sub)
+		}
+	}
+}
+
+// HasSubscribers returns true if the user has at least one open subscription.
+func (h *Hub) HasSubscribers(user string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.subscriptions[user]) > 0
+}
+
+// Users returns the users who have at least one open subscription, in sorted order.
+func (h *Hub) Users() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	users := make([]string, 0, len(h.subscriptions))
+	for user := range h.subscriptions {
+		users = append(users, user)
+	}
+	sort.Strings(users)
+	return users
+}
+
+// Close closes every subscription and causes subsequent subscriptions to be closed immediately.
+// It's called during shutdown so that open streams don't hold up the HTTP server's drain.
+func (h *Hub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.closed = true
+	for _, subs := range h.subscriptions {
+		for sub := range subs {
+			h.remove(sub)
+		}
+	}
+}
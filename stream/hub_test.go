@@ -0,0 +1,93 @@
+package stream
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/cyverse-de/notifications/model"
+	"github.com/stretchr/testify/assert"
+)
+
+// receive returns the next event on a subscription without blocking.
+func receive(t *testing.T, sub *Subscription) (Event, bool) {
+	t.Helper()
+	select {
+	case event, ok := <-sub.Events():
+		return event, ok
+	default:
+		t.Fatal("no event was available")
+		return Event{}, false
+	}
+}
+
+func TestEventsOnlyReachTheirUser(t *testing.T) {
+	assert := assert.New(t)
+
+	hub := NewHub()
+	sarah := hub.Subscribe("sarahr@example.org")
+	defer sarah.Close()
+	ipc := hub.Subscribe("ipcdev@example.org")
+	defer ipc.Close()
+
+	notification := &model.Notification{
+		Message: map[string]interface{}{"id": "46ae63be-7030-4cdd-8eb9-66aa49fcf38b"},
+	}
+	hub.Publish("sarahr@example.org", NewNotificationEvent(3, notification))
+
+	event, ok := receive(t, sarah)
+	assert.True(ok)
+	assert.Equal("46ae63be-7030-4cdd-8eb9-66aa49fcf38b", event.ID)
+	assert.Equal(EventNotification, event.Name)
+	assert.Equal(&model.WrappedNotification{Total: 3, Message: notification}, event.Data)
+
+	assert.Empty(ipc.Events(), "an event may only be delivered to the user it's directed to")
+
+	assert.True(hub.HasSubscribers("sarahr@example.org"))
+	sarah.Close()
+	assert.False(hub.HasSubscribers("sarahr@example.org"), "a closed subscription still counts")
+}
+
+func TestASlowSubscriptionIsDropped(t *testing.T) {
+	hub := NewHub()
+	sub := hub.Subscribe("sarahr@example.org")
+
+	// Fill the buffer and then overflow it; publishing must never block.
+	for i := 0; i <= subscriptionBufferSize; i++ {
+		hub.Publish("sarahr@example.org", Event{Name: "test"})
+	}
+
+	// The buffered events are still delivered, and then the channel is closed so that the client
+	// reconnects and is caught up from the database.
+	for i := 0; i < subscriptionBufferSize; i++ {
+		_, ok := receive(t, sub)
+		assert.True(t, ok, "a buffered event was lost")
+	}
+	_, ok := receive(t, sub)
+	assert.False(t, ok, "a subscription that fell behind was not closed")
+
+	// Closing an already dropped subscription is harmless.
+	sub.Close()
+}
+
+func TestCloseEndsEverySubscription(t *testing.T) {
+	hub := NewHub()
+	before := hub.Subscribe("sarahr@example.org")
+
+	hub.Close()
+
+	_, ok := <-before.Events()
+	assert.False(t, ok, "an open subscription was not closed")
+
+	after := hub.Subscribe("sarahr@example.org")
+	_, ok = <-after.Events()
+	assert.False(t, ok, "a subscription made after shutdown began was left open")
+}
+
+func TestEventFormat(t *testing.T) {
+	var buf bytes.Buffer
+	event := Event{ID: "1", Name: EventNotification, Data: map[string]any{"total": 2}}
+
+	_, err := event.WriteTo(&buf)
+	assert.NoError(t, err)
+	assert.Equal(t, "id: 1\nevent: notification\ndata: {\"total\":2}\n\n", buf.String())
+}
@@ -0,0 +1,187 @@
+package stream
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/cyverse-de/notifications/db"
+	"github.com/lib/pq"
+)
+
+const (
+	// listenerMinReconnectInterval and listenerMaxReconnectInterval bound the backoff between attempts to
+	// re-establish a lost listener connection.
+	listenerMinReconnectInterval = time.Second
+	listenerMaxReconnectInterval = time.Minute
+
+	// listenerPingInterval is how often the listener connection is checked. A connection that died quietly would
+	// otherwise go unnoticed, because a listening connection never sends anything on its own.
+	listenerPingInterval = 90 * time.Second
+)
+
+// Listener turns the notification events that every replica sends through PostgreSQL into events on a hub. This
+// is what gives a client streaming from one replica the notifications recorded by the other replicas.
+type Listener struct {
+	db          *sql.DB
+	databaseURI string
+	hub         *Hub
+}
+
+// NewListener returns a listener that publishes to the given hub. The database URI is needed because the listener
+// holds a dedicated connection of its own rather than borrowing one from the pool.
+func NewListener(db *sql.DB, databaseURI string, hub *Hub) *Listener {
+	return &Listener{
+		db:          db,
+		databaseURI: databaseURI,
+		hub:         hub,
+	}
+}
+
+// logConnectionEvent logs changes in the state of the listener connection.
+func logConnectionEvent(event pq.ListenerEventType, err error) {
+	switch event {
+	case pq.ListenerEventDisconnected:
+		log.Warnf("lost the notification event connection; streams will miss notifications until it's restored: %s", err)
+	case pq.ListenerEventConnectionAttemptFailed:
+		log.Errorf("unable to re-establish the notification event connection: %s", err)
+	case pq.ListenerEventReconnected:
+		log.Info("re-established the notification event connection")
+	}
+}
+
+// Run listens for notification events until the context is canceled. The connection is re-established
+// automatically if it's lost.
+func (l *Listener) Run(ctx context.Context) {
+	listener := pq.NewListener(l.databaseURI, listenerMinReconnectInterval, listenerMaxReconnectInterval, logConnectionEvent)
+
+	// Closing the listener is also what releases a Listen call that's still waiting for a connection.
+	go func() {
+		<-ctx.Done()
+		_ = listener.Close()
+	}()
+
+	if err := listener.Listen(db.NotificationEventChannel); err != nil {
+		if ctx.Err() == nil {
+			log.Errorf("unable to listen for notification events; streams will only carry local notifications: %s", err)
+		}
+		return
+	}
+	log.Infof("listening for notification events on %s", db.NotificationEventChannel)
+
+	ping := time.NewTicker(listenerPingInterval)
+	defer ping.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case notification, ok := <-listener.Notify:
+			if !ok {
+				return
+			}
+
+			// A nil notification means that the connection was re-established. Anything sent in the meantime is
+			// gone, but clients that reconnect with a Last-Event-ID are caught up from the database.
+			if notification == nil {
+				log.Warn("notification events sent while the listener was disconnected were missed")
+				continue
+			}
+			l.handle(ctx, notification.Extra)
+
+		case <-ping.C:
+			if err := listener.Ping(); err != nil {
+				log.Warnf("the notification event connection failed a health check: %s", err)
+			}
+		}
+	}
+}
+
+// handle turns the payload of a single notification event into a hub event.
+func (l *Listener) handle(ctx context.Context, payload string) {
+	event, err := db.ParseNotificationEvent(payload)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+
+	// A broadcast isn't addressed to a single user, so it's offered to everyone who's streaming from this replica.
+	if event.Kind == db.NotificationEventBroadcast {
+		l.handleBroadcast(ctx, event.ID)
+		return
+	}
+
+	// There's no point in loading anything for a user who isn't streaming from this replica.
+	if !l.hub.HasSubscribers(event.User) {
+		return
+	}
+
+	switch event.Kind {
+	case db.NotificationEventRecorded:
+		hubEvent, err := l.loadNotification(ctx, event)
+		if err != nil {
+			log.Errorf("unable to load notification %s for streaming: %s", event.ID, err)
+			return
+		}
+		if hubEvent != nil {
+			l.hub.Publish(event.User, *hubEvent)
+		}
+
+	case db.NotificationEventUnseenCount:
+		if event.Total != nil {
+			l.hub.Publish(event.User, NewUnseenCountEvent(*event.Total))
+		}
+
+	default:
+		log.Debugf("ignoring notification event of unknown kind %q", event.Kind)
+	}
+}
+
+// handleBroadcast delivers a broadcast notification to each of the users who are streaming from this replica and
+// were sent the broadcast.
+func (l *Listener) handleBroadcast(ctx context.Context, id string) {
+	for _, user := range l.hub.Users() {
+		event := &db.NotificationEvent{Kind: db.NotificationEventBroadcast, ID: id, User: user}
+		hubEvent, err := l.loadNotification(ctx, event)
+		if err != nil {
+			log.Errorf("unable to load broadcast %s for streaming to %s: %s", id, user, err)
+			continue
+		}
+
+		// The broadcast can't be loaded for users it wasn't sent to.
+		if hubEvent != nil {
+			l.hub.Publish(user, *hubEvent)
+		}
+	}
+}
+
+// loadNotification loads a newly recorded notification along with the recipient's unseen count. Nil is returned
+// if the notification no longer exists.
+func (l *Listener) loadNotification(ctx context.Context, event *db.NotificationEvent) (*Event, error) {
+	tx, err := l.db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	// Load the notification.
+	notification, err := db.GetNotification(ctx, tx, event.User, event.ID)
+	if err != nil {
+		return nil, err
+	}
+	if notification == nil {
+		return nil, nil
+	}
+
+	// Count the unseen notifications.
+	total, err := db.CountUnreadNotifications(ctx, tx, event.User)
+	if err != nil {
+		return nil, err
+	}
+
+	hubEvent := NewNotificationEvent(total, notification)
+	return &hubEvent, nil
+}
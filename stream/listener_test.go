@@ -0,0 +1,122 @@
+package stream
+
+import (
+	"context"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/cyverse-de/notifications/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestListenerRelaysRecordedNotifications(t *testing.T) {
+	assert := assert.New(t)
+
+	db, mock, err := sqlmock.New()
+	assert.NoError(err, "unable to open the mock database connection")
+	defer func() { _ = db.Close() }()
+
+	const id = "46ae63be-7030-4cdd-8eb9-66aa49fcf38b"
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT \\* FROM \\(\\( SELECT nt.name AS type, n.seen, n.deleted, n.outgoing_json AS message, n.seen_at, n.deleted_at FROM \\(SELECT un.id").
+		WithArgs("sarahr@example.org", id, "sarahr@example.org", "sarahr@example.org", id).
+		WillReturnRows(
+			sqlmock.NewRows([]string{"type", "seen", "deleted", "message", "seen_at", "deleted_at"}).
+				AddRow("analysis", false, false, []byte(`{"message":{"id":"`+id+`"},"subject":"some job status changed"}`), nil, nil),
+		)
+	mock.ExpectQuery("SELECT \\(SELECT count\\(\\*\\) FROM \\(SELECT un.id").
+		WithArgs("sarahr@example.org", false, false, "sarahr@example.org", "sarahr@example.org", false, false).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(7))
+	mock.ExpectRollback()
+
+	hub := NewHub()
+	sub := hub.Subscribe("sarahr@example.org")
+	defer sub.Close()
+
+	listener := NewListener(db, "", hub)
+	listener.handle(context.Background(), `{"kind":"recorded","id":"`+id+`","user":"sarahr@example.org"}`)
+
+	event, ok := receive(t, sub)
+	assert.True(ok)
+	assert.Equal(id, event.ID)
+	data, ok := event.Data.(*model.WrappedNotification)
+	if !ok {
+		t.Fatalf("unexpected event data: %#v", event.Data)
+	}
+	assert.Equal(int64(7), data.Total)
+	assert.Equal("some job status changed", data.Message.Subject)
+
+	assert.NoError(mock.ExpectationsWereMet(), "not all mock expectations were met")
+}
+
+func TestListenerRelaysBroadcastsToTheirRecipients(t *testing.T) {
+	assert := assert.New(t)
+
+	db, mock, err := sqlmock.New()
+	assert.NoError(err, "unable to open the mock database connection")
+	defer func() { _ = db.Close() }()
+
+	// The broadcast is looked up for each streaming user, and it can't be found for users it wasn't sent to.
+	const id = "46ae63be-7030-4cdd-8eb9-66aa49fcf38b"
+	columns := []string{"type", "seen", "deleted", "message", "seen_at", "deleted_at"}
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT nt.name AS type").
+		WithArgs("ipcdev@example.org", id, "ipcdev@example.org", "ipcdev@example.org", id).
+		WillReturnRows(sqlmock.NewRows(columns))
+	mock.ExpectRollback()
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT nt.name AS type").
+		WithArgs("sarahr@example.org", id, "sarahr@example.org", "sarahr@example.org", id).
+		WillReturnRows(
+			sqlmock.NewRows(columns).
+				AddRow("system", false, false, []byte(`{"message":{"id":"`+id+`"},"subject":"maintenance tonight"}`), nil, nil),
+		)
+	mock.ExpectQuery("SELECT count\\(\\*\\)").
+		WithArgs("sarahr@example.org", false, false, "sarahr@example.org", "sarahr@example.org", false, false).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectRollback()
+
+	hub := NewHub()
+	sarah := hub.Subscribe("sarahr@example.org")
+	defer sarah.Close()
+	ipc := hub.Subscribe("ipcdev@example.org")
+	defer ipc.Close()
+
+	listener := NewListener(db, "", hub)
+	listener.handle(context.Background(), `{"kind":"broadcast","id":"`+id+`"}`)
+
+	event, ok := receive(t, sarah)
+	assert.True(ok)
+	assert.Equal(id, event.ID)
+	assert.Empty(ipc.Events(), "a broadcast must not reach users it wasn't sent to")
+
+	assert.NoError(mock.ExpectationsWereMet(), "not all mock expectations were met")
+}
+
+func TestListenerSkipsUsersWithoutStreams(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err, "unable to open the mock database connection")
+	defer func() { _ = db.Close() }()
+
+	// No expectations are set: nothing may be loaded for a user who isn't streaming from this replica.
+	listener := NewListener(db, "", NewHub())
+	listener.handle(context.Background(), `{"kind":"recorded","id":"1","user":"sarahr@example.org"}`)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestListenerRelaysUnseenCounts(t *testing.T) {
+	hub := NewHub()
+	sub := hub.Subscribe("sarahr@example.org")
+	defer sub.Close()
+
+	// Unseen counts travel in the event itself, so nothing is loaded from the database.
+	listener := NewListener(nil, "", hub)
+	listener.handle(context.Background(), `{"kind":"unseen_count","user":"sarahr@example.org","total":0}`)
+
+	event, ok := receive(t, sub)
+	assert.True(t, ok)
+	assert.Equal(t, EventUnseenCount, event.Name)
+	assert.Empty(t, event.ID, "an unseen count event must not move the client's resume position")
+	assert.Equal(t, model.NewUnseenCountUpdate(0), event.Data)
+}